@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/iiivan-lemon/technopark_proxy/config"
+	"github.com/iiivan-lemon/technopark_proxy/internal/storage"
+	"github.com/iiivan-lemon/technopark_proxy/internal/utils/seal"
+	"github.com/pkg/errors"
+)
+
+const rotateKeyUsage = "usage: main rotate-key (file PATH | env NAME)"
+
+// enableEncryption makes store seal the captured traffic if conf says so.
+func enableEncryption(store storage.Storage, conf *config.StorageConfig) error {
+	if !conf.Encryption.Enabled {
+		return nil
+	}
+	e, ok := store.(storage.Encryptable)
+	if !ok {
+		return errors.Errorf("%s storage keeps nothing at rest to encrypt", conf.Backend)
+	}
+	key, err := storage.LoadMasterKey(&conf.Encryption)
+	if err != nil {
+		return err
+	}
+	return e.EnableEncryption(key)
+}
+
+// runRotateKey implements the rotate-key subcommand. The proxy should be
+// stopped: what it records meanwhile may be sealed by a dropped data key.
+func runRotateKey(conf *config.Config, args []string) error {
+	if len(args) != 2 || args[0] != "file" && args[0] != "env" {
+		return errors.New(rotateKeyUsage)
+	}
+	if !conf.Storage.Encryption.Enabled {
+		return errors.New("storage.encryption is not enabled")
+	}
+	var (
+		newKey []byte
+		err    error
+	)
+	if args[0] == "file" {
+		newKey, err = seal.LoadKey(args[1], "")
+	} else {
+		newKey, err = seal.LoadKey("", args[1])
+	}
+	if err != nil {
+		return errors.Wrap(err, "loading new master key")
+	}
+
+	store, err := newStorage(conf)
+	if err != nil {
+		return errors.Wrap(err, "error creating storage")
+	}
+	defer store.Close()
+	if err = migrateUp(store); err != nil {
+		return errors.Wrap(err, "error migrating storage schema")
+	}
+	if err = enableEncryption(store, &conf.Storage); err != nil {
+		return err
+	}
+
+	n, err := store.(storage.Encryptable).RotateKey(newKey)
+	if err != nil {
+		return errors.Wrapf(err, "rotation stopped after resealing %d values", n)
+	}
+	fmt.Printf("values resealed: %d, storage.encryption has to point to the new master key now\n", n)
+	return nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/iiivan-lemon/technopark_proxy/config"
+	"github.com/iiivan-lemon/technopark_proxy/internal/repeater"
+	"github.com/iiivan-lemon/technopark_proxy/internal/storage"
+	"github.com/iiivan-lemon/technopark_proxy/internal/utils/redact"
+	"github.com/pkg/errors"
+)
+
+const importUsage = "usage: main import [-session ID] [-format har|curl|http] [-var NAME=VALUE]... FILE..."
+
+// importVariables are the repeated -var flags.
+type importVariables map[string]string
+
+func (v importVariables) String() string {
+	return fmt.Sprint(map[string]string(v))
+}
+
+func (v importVariables) Set(value string) error {
+	i := strings.IndexByte(value, '=')
+	if i <= 0 {
+		return errors.New("-var should be NAME=VALUE")
+	}
+	v[value[:i]] = value[i+1:]
+	return nil
+}
+
+// importFormat returns the format of a file by its extension, curl
+// commands when it is none of the others.
+func importFormat(name string) string {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".har":
+		return "har"
+	case ".http", ".rest":
+		return "http"
+	}
+	return "curl"
+}
+
+// runImport implements the import subcommand: the requests of the files,
+// - for stdin, are stored in the session as requests with source=import.
+// The files the requests refer to are read relative to the file.
+func runImport(conf *config.Config, args []string) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	sessionID := flags.Int64("session", 0, "")
+	format := flags.String("format", "", "")
+	vars := importVariables{}
+	flags.Var(vars, "var", "")
+	if err := flags.Parse(args); err != nil || flags.NArg() == 0 || *sessionID < 0 ||
+		*format != "" && !repeater.IsImportFormat(*format) {
+		return errors.New(importUsage)
+	}
+	if conf.Storage.Backend == storage.Memory {
+		return errors.New("memory storage keeps nothing to import into")
+	}
+
+	store, err := newStorage(conf)
+	if err != nil {
+		return errors.Wrap(err, "error creating storage")
+	}
+	defer store.Close()
+	if err = migrateUp(store); err != nil {
+		return errors.Wrap(err, "error migrating storage schema")
+	}
+	if err = enableEncryption(store, &conf.Storage); err != nil {
+		return err
+	}
+	redactor, err := redact.NewRedactor(&conf.Proxy.Redact)
+	if err != nil {
+		return errors.Wrap(err, "error creating redactor")
+	}
+
+	var session *repeater.Session
+	if *sessionID > 0 {
+		session, err = store.GetSession(*sessionID)
+	} else {
+		session, err = store.ActiveSession()
+	}
+	if err != nil {
+		return errors.Wrap(err, "error loading session")
+	}
+	if session == nil {
+		return errors.Errorf("no session %d", *sessionID)
+	}
+	if session.ArchivedAt != nil {
+		return errors.Errorf("session %d is archived", session.ID)
+	}
+
+	for _, name := range flags.Args() {
+		var data []byte
+		dir := "."
+		if name == "-" {
+			data, err = io.ReadAll(os.Stdin)
+		} else {
+			data, err = os.ReadFile(name)
+			dir = filepath.Dir(name)
+		}
+		if err != nil {
+			return err
+		}
+		fileFormat := *format
+		if fileFormat == "" {
+			fileFormat = importFormat(name)
+		}
+		opts := &repeater.ImportOptions{
+			Variables: vars,
+			ReadFile: func(file string) ([]byte, error) {
+				if !filepath.IsAbs(file) {
+					file = filepath.Join(dir, file)
+				}
+				return os.ReadFile(file)
+			},
+		}
+
+		items, err := repeater.ParseImport(fileFormat, data, opts)
+		if err != nil {
+			return errors.Wrapf(err, "reading %s", name)
+		}
+		res, err := repeater.StoreImported(store, redactor, session.ID, items)
+		if err != nil {
+			return errors.Wrapf(err, "importing %s", name)
+		}
+		fmt.Printf("%s: imported %d requests into session %d %v, skipped %d\n", name, res.Imported, session.ID, res.IDs, len(res.Skipped))
+		for _, skipped := range res.Skipped {
+			fmt.Printf("  request %d: %s\n", skipped.Entry+1, skipped.Error)
+		}
+	}
+	return nil
+}
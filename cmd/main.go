@@ -1,21 +1,43 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
 	"github.com/iiivan-lemon/technopark_proxy/config"
 	proxyserver "github.com/iiivan-lemon/technopark_proxy/internal/proxyServer"
 	"github.com/iiivan-lemon/technopark_proxy/internal/repeater"
+	"github.com/iiivan-lemon/technopark_proxy/internal/storage"
+	"github.com/iiivan-lemon/technopark_proxy/internal/storage/memory"
+	"github.com/iiivan-lemon/technopark_proxy/internal/storage/postgres"
+	"github.com/iiivan-lemon/technopark_proxy/internal/storage/queue"
+	"github.com/iiivan-lemon/technopark_proxy/internal/storage/sqlite"
 	"github.com/iiivan-lemon/technopark_proxy/internal/tools/logger/zaplogger"
 	"github.com/iiivan-lemon/technopark_proxy/internal/tools/postgresql"
 	"github.com/iiivan-lemon/technopark_proxy/internal/utils/cert"
+	"github.com/iiivan-lemon/technopark_proxy/internal/utils/forward"
 	"github.com/iiivan-lemon/technopark_proxy/internal/utils/middleware"
+	"github.com/iiivan-lemon/technopark_proxy/internal/utils/redact"
+	"github.com/iiivan-lemon/technopark_proxy/internal/utils/resolver"
 	"github.com/pkg/errors"
-	"log"
 
 	servLog "github.com/iiivan-lemon/technopark_proxy/internal/tools/logger"
 	"github.com/spf13/viper"
 )
 
+// given to the servers and the recording queue when shutdownTimeout is not set
+const defaultShutdownTimeout = 15 * time.Second
+
+type shutdowner interface {
+	Shutdown(ctx context.Context) error
+}
+
 func main() {
 	viper.AddConfigPath("./config/")
 	viper.SetConfigName("config")
@@ -27,6 +49,26 @@ func main() {
 	if err := viper.Unmarshal(&servConf); err != nil {
 		log.Fatal(err)
 	}
+
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(&servConf, os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "rotate-key" {
+		if err := runRotateKey(&servConf, os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		if err := runImport(&servConf, os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	caCert, err := cert.LoadCA(servConf.Proxy.CaCrt, servConf.Proxy.CaKey, servConf.Proxy.CommonName)
 	if err != nil {
 		log.Fatal(err)
@@ -36,33 +78,129 @@ func main() {
 	if err != nil {
 		log.Fatal(errors.Wrap(err, "error creating logger object"))
 	}
-	defer func() {
-		err := logger.Sync()
-		if err != nil {
-			log.Fatal("Error occurred in logger sync")
-		}
-	}()
 
 	servLogger := servLog.NewServLogger(logger)
 
-	pgxManager, err := postgresql.NewDBConn(&servConf.DB)
+	store, err := newStorage(&servConf)
+	if err != nil {
+		log.Fatal(errors.Wrap(err, "error creating storage"))
+	}
+	if err = migrateUp(store); err != nil {
+		log.Fatal(errors.Wrap(err, "error migrating storage schema"))
+	}
+	if err = enableEncryption(store, &servConf.Storage); err != nil {
+		log.Fatal(errors.Wrap(err, "error enabling storage encryption"))
+	}
+
+	activeSession, err := store.ActiveSession()
 	if err != nil {
-		log.Fatal(errors.Wrap(err, "error creating postgres agent"))
+		log.Fatal(errors.Wrap(err, "error loading active session"))
+	}
+	sessions := repeater.NewActiveSession(activeSession)
+
+	janitor := storage.NewJanitor(store, &servConf.Storage.Retention, servLogger)
+
+	recorder, err := queue.NewQueue(store, &servConf.Storage.Queue, servLogger)
+	if err != nil {
+		log.Fatal(errors.Wrap(err, "error creating recording queue"))
 	}
-	defer pgxManager.Close()
 
 	comonMw := middleware.NewCommonMiddleware(servLogger)
 
-	repeaterRepo := repeater.NewRepeaterRepository(pgxManager)
-	repeaterServer := repeater.NewRepeaterServer(repeaterRepo, caCert, &tls.Config{MinVersion: tls.VersionTLS12}, nil)
+	upstreamResolver, err := resolver.NewResolver(&servConf.Resolver)
+	if err != nil {
+		log.Fatal(errors.Wrap(err, "error creating resolver"))
+	}
 
+	redactor, err := redact.NewRedactor(&servConf.Proxy.Redact)
+	if err != nil {
+		log.Fatal(errors.Wrap(err, "error creating redactor"))
+	}
+
+	differ, err := repeater.NewDiffer(&servConf.Repeater.Diff)
+	if err != nil {
+		log.Fatal(errors.Wrap(err, "error creating differ"))
+	}
+
+	repeaterServer := repeater.NewRepeaterServer(store, sessions, redactor, differ, caCert, &tls.Config{MinVersion: tls.VersionTLS12}, nil, upstreamResolver, recorder)
+
+	forwarder, err := forward.NewForwarder(&servConf.Proxy.Forward)
+	if err != nil {
+		log.Fatal(errors.Wrap(err, "error creating forwarder"))
+	}
+
+	proxyServ := proxyserver.NewProxyServer(recorder, sessions, redactor, caCert, &tls.Config{MinVersion: tls.VersionTLS12}, nil, forwarder, upstreamResolver)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	servErrs := make(chan error, 2)
 	go func() {
-		repeaterServer.ListenAndServe(&servConf.Repeater, comonMw)
+		servErrs <- repeaterServer.ListenAndServe(&servConf.Repeater, comonMw)
 	}()
+	go func() {
+		servErrs <- proxyServ.ListenAndServe(&servConf.Proxy, comonMw)
+	}()
+
+	select {
+	case <-ctx.Done():
+		log.Println("shutting down")
+	case err = <-servErrs:
+		log.Println(err)
+	}
+	stop()
+
+	shutdownTimeout := time.Duration(servConf.ShutdownTimeout) * time.Second
+	if shutdownTimeout <= 0 {
+		shutdownTimeout = defaultShutdownTimeout
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
 
-	proxyRepo := proxyserver.NewProxyRepository(pgxManager)
+	// both servers are drained in parallel and share the deadline
+	// with the recording queue flushed after them.
+	var wg sync.WaitGroup
+	for _, srv := range []shutdowner{proxyServ, repeaterServer} {
+		wg.Add(1)
+		go func(srv shutdowner) {
+			defer wg.Done()
+			if err := srv.Shutdown(shutdownCtx); err != nil {
+				log.Println(errors.Wrap(err, "server shutdown"))
+			}
+		}(srv)
+	}
+	wg.Wait()
 
-	proxyServ := proxyserver.NewProxyServer(proxyRepo, caCert, &tls.Config{MinVersion: tls.VersionTLS12}, nil)
-	proxyServ.ListenAndServe(&servConf.Proxy, comonMw)
+	if err := recorder.Close(shutdownCtx); err != nil {
+		log.Println(errors.Wrap(err, "flushing recorded traffic"))
+	}
+	janitor.Close()
+
+	if err := logger.Sync(); err != nil {
+		log.Println("Error occurred in logger sync")
+	}
+	if err := store.Close(); err != nil {
+		log.Println(errors.Wrap(err, "closing storage"))
+	}
+}
 
+func newStorage(conf *config.Config) (storage.Storage, error) {
+	blobs, err := storage.NewBlobs(&conf.Storage.Blobs)
+	if err != nil {
+		return nil, err
+	}
+	switch conf.Storage.Backend {
+	case storage.Postgres, "":
+		pgxManager, err := postgresql.NewDBConn(&conf.DB)
+		if err != nil {
+			return nil, errors.Wrap(err, "error creating postgres agent")
+		}
+		return postgres.NewStorage(pgxManager, blobs), nil
+	case storage.SQLite:
+		return sqlite.NewStorage(conf.Storage.SQLitePath, blobs)
+	case storage.Memory:
+		return memory.NewStorage(blobs.Threshold), nil
+	default:
+		return nil, errors.Errorf("unknown storage backend %q", conf.Storage.Backend)
+	}
 }
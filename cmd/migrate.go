@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/iiivan-lemon/technopark_proxy/config"
+	"github.com/iiivan-lemon/technopark_proxy/internal/storage"
+	"github.com/pkg/errors"
+)
+
+const migrateUsage = "usage: main migrate [up | down [N] | to VERSION | version]"
+
+// migrateUp brings the schema of store to the latest version.
+func migrateUp(store storage.Storage) error {
+	m, ok := store.(storage.Migratable)
+	if !ok {
+		return nil
+	}
+	runner, err := m.Migrations()
+	if err != nil {
+		return err
+	}
+	_, err = runner.Up()
+	return err
+}
+
+// runMigrate implements the migrate subcommand.
+func runMigrate(conf *config.Config, args []string) error {
+	store, err := newStorage(conf)
+	if err != nil {
+		return errors.Wrap(err, "error creating storage")
+	}
+	defer store.Close()
+
+	m, ok := store.(storage.Migratable)
+	if !ok {
+		return errors.Errorf("%s storage has no schema to migrate", conf.Storage.Backend)
+	}
+	runner, err := m.Migrations()
+	if err != nil {
+		return err
+	}
+
+	cmd := "up"
+	if len(args) > 0 {
+		cmd = args[0]
+	}
+	var done int
+	switch {
+	case cmd == "up" && len(args) <= 1:
+		done, err = runner.Up()
+	case cmd == "down" && len(args) <= 2:
+		steps := 1
+		if len(args) == 2 {
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				return errors.New(migrateUsage)
+			}
+		}
+		done, err = runner.Down(steps)
+	case cmd == "to" && len(args) == 2:
+		version, convErr := strconv.Atoi(args[1])
+		if convErr != nil || version < 0 {
+			return errors.New(migrateUsage)
+		}
+		done, err = runner.To(version)
+	case cmd == "version" && len(args) == 1:
+	default:
+		return errors.New(migrateUsage)
+	}
+	if err != nil {
+		return err
+	}
+
+	version, err := runner.Version()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("migrations run: %d, schema version: %d (latest %d)\n", done, version, runner.Latest())
+	return nil
+}
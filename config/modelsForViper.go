@@ -8,12 +8,142 @@ type ServerConfig struct {
 	CaCrt        string
 	CaKey        string
 	CommonName   string
+	Forward      ForwardConfig
+	Tunnel       TunnelConfig
+	Redact       RedactConfig
+	Diff         DiffConfig
+	Attack       AttackConfig
+}
+
+// TunnelConfig controls CONNECT tunnels that carry neither TLS nor HTTP.
+type TunnelConfig struct {
+	// milliseconds to wait for the client to speak first before relaying raw bytes
+	SniffTimeout int
+	// seconds without traffic in both directions after which a raw tunnel is closed
+	IdleTimeout int
+	// bytes of each direction stored with the tunnel record
+	CaptureLimit int
+}
+
+// ForwardConfig controls the headers the proxy adds to forwarded messages.
+// Loop detection relies on Via, so it works only while Via is enabled.
+type ForwardConfig struct {
+	Via           bool
+	Forwarded     bool
+	XForwardedFor bool
+	Pseudonym     string
+}
+
+// RedactConfig lists the secrets replaced by Mask before traffic is stored.
+type RedactConfig struct {
+	// header names, in any case
+	Headers []string
+	Cookies []string
+	// query parameters and form fields
+	Fields []string
+	// paths in JSON bodies: $.user.password, $.items[*].token, $..secret
+	JSONPaths []string
+	// replaced everywhere, a regex with groups has its first group replaced
+	Regexes []string
+	Mask    string
+	// keep the requests as they were, encrypted with the key in KeyFile,
+	// or in the KeyEnv variable, so that the repeater can still send them
+	KeepOriginals bool
+	KeyFile       string
+	KeyEnv        string
+}
+
+// DiffConfig controls how the repeater compares responses.
+type DiffConfig struct {
+	// header names left out of the comparison, in any case
+	IgnoreHeaders []string
+	// masked in both responses before they are compared, as in RedactConfig
+	Regexes   []string
+	JSONPaths []string
+}
+
+// AttackConfig limits the attacks the repeater runs.
+type AttackConfig struct {
+	// requests of an attack in flight at once, by default and at most
+	Concurrency    int
+	MaxConcurrency int
+	// requests an attack may send at most
+	MaxRequests int64
+	// the wordlists payload sets refer to by file name
+	WordlistDir string
 }
 
 func (srv ServerConfig) Addr() string {
 	return srv.Host + ":" + srv.Port
 }
 
+// ResolverConfig describes how upstream host names are resolved.
+type ResolverConfig struct {
+	// lines in /etc/hosts format: "10.0.0.5 example.com www.example.com"
+	Hosts     []string
+	HostsFile string
+	// DNS server address, the system resolver is used when empty
+	Server string
+	// DNS-over-HTTPS endpoint, takes precedence over Server
+	DoH string
+}
+
+// StorageConfig selects where the traffic is kept:
+// "postgres" (configured by DBConfig), "sqlite" or "memory".
+type StorageConfig struct {
+	Backend    string
+	SQLitePath string
+	Queue      QueueConfig
+	Blobs      BlobConfig
+	Retention  RetentionConfig
+	Encryption EncryptionConfig
+}
+
+// EncryptionConfig keeps raw requests, headers, cookies, bodies and the
+// payloads of tunnels encrypted at rest. The master key is 32 bytes in
+// hex or base64, read from KeyFile or, when it is empty, from the KeyEnv
+// variable. Search then decrypts and scans the session instead of using
+// the full-text indexes of postgres, which keep indexing the sealed text.
+type EncryptionConfig struct {
+	Enabled bool
+	KeyFile string
+	KeyEnv  string
+}
+
+// RetentionConfig limits the stored history, zero means no limit.
+type RetentionConfig struct {
+	// seconds between janitor runs, 0 disables pruning
+	Interval int
+	// hours
+	MaxAge             int
+	MaxRequests        int
+	MaxRequestsPerHost int
+	MaxBodyBytes       int64
+}
+
+// BlobConfig controls where large bodies are kept. A body larger than
+// Threshold bytes is stored once per content, the rows keep its hash.
+type BlobConfig struct {
+	// 0 keeps every body inline
+	Threshold int
+	// "db" keeps the data in the blobs table, "fs" in files under Dir
+	Backend string
+	Dir     string
+}
+
+// QueueConfig controls the queue the proxy records traffic through.
+type QueueConfig struct {
+	// records waiting to be written
+	Size      int
+	BatchSize int
+	// milliseconds a partial batch may wait before it is written
+	FlushInterval int
+	// what happens to a record that does not fit into the queue or
+	// that the storage refused: "drop", "block" or "spill" (to SpillDir)
+	Overflow string
+	SpillDir string
+}
+
 type DBConfig struct {
 	Host           string
 	Port           string
@@ -40,6 +170,10 @@ type LogConfig struct {
 type Config struct {
 	Proxy    ServerConfig
 	Repeater ServerConfig
+	Storage  StorageConfig
 	DB       DBConfig
 	Logger   LogConfig
+	Resolver ResolverConfig
+	// seconds given to active tunnels and repeats to finish on SIGINT/SIGTERM
+	ShutdownTimeout int
 }
@@ -1,36 +1,123 @@
 package proxyserver
 
 import (
+	"bytes"
+	"io"
+	"net"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type Map map[string]interface{}
 
 type Request struct {
-	Method     string `json:"method"`
-	Path       string `json:"path"`
-	GetParams  Map    `json:"get_params"`
-	Headers    Map    `json:"headers"`
-	Cookies    Map    `json:"cookies"`
-	PostParams Map    `json:"post_params"`
-	Raw        string `json:"raw"`
-	IsHTTPS    bool   `json:"is_https"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	GetParams  Map       `json:"get_params"`
+	Headers    Map       `json:"headers"`
+	Cookies    Map       `json:"cookies"`
+	PostParams Map       `json:"post_params"`
+	Raw        string    `json:"raw"`
+	IsHTTPS    bool      `json:"is_https"`
+	StartedAt  time.Time `json:"started_at"`
+	// body size in bytes
+	Size int64 `json:"size"`
+
+	// where the request was sent: URL is rebuilt from Scheme, Host, Port,
+	// Path and Query, the default port is left out of it
+	Scheme     string `json:"scheme"`
+	Host       string `json:"host"`
+	Port       int    `json:"port"`
+	URL        string `json:"url"`
+	Query      string `json:"query"`
+	ClientAddr string `json:"client_addr"`
+	// HTTP version, e.g. HTTP/1.1
+	Proto string `json:"proto"`
+	// capture session the request was recorded in
+	SessionID int64 `json:"session_id"`
+	// Raw before redaction, sealed with the redaction key
+	Original string `json:"original,omitempty"`
+	// what sent the request, empty is SourceProxy
+	Source string `json:"source,omitempty"`
+	// request a repeat was made from, zero for the others
+	ParentID int64 `json:"parent_id,omitempty"`
 }
+
+// Sources of the recorded requests.
+const (
+	SourceProxy    = "proxy"
+	SourceRepeater = "repeater"
+	SourceScanner  = "scanner"
+	// HAR files, curl commands and .http files
+	SourceImport = "import"
+)
+
 type Response struct {
 	Code    int    `json:"code"`
 	Message string `json:"message"`
 	Headers Map    `json:"headers"`
 	Body    string `json:"body"`
-	Raw     string `json:"raw"`
 	IsHTTPS bool   `json:"is_https"`
+	Timing  Timing `json:"timing"`
+	// body size in bytes
+	Size int64 `json:"size"`
+	// address the upstream was reached at
+	RemoteIP string `json:"remote_ip"`
+}
+
+// Tunnel is a CONNECT tunnel carrying neither TLS nor HTTP,
+// relayed byte by byte.
+type Tunnel struct {
+	SessionID     int64         `json:"session_id"`
+	Host          string        `json:"host"`
+	ClientAddr    string        `json:"client_addr"`
+	BytesSent     int64         `json:"bytes_sent"`
+	BytesReceived int64         `json:"bytes_received"`
+	ClientPayload []byte        `json:"client_payload"`
+	ServerPayload []byte        `json:"server_payload"`
+	StartedAt     time.Time     `json:"started_at"`
+	Duration      time.Duration `json:"duration"`
+}
+
+// Exchange is a request together with the response it got,
+// Response is nil when the upstream never answered.
+type Exchange struct {
+	Request  *Request  `json:"request"`
+	Response *Response `json:"response,omitempty"`
 }
 
+// FormRequestData expects r.URL to be absolute, as it is in requests
+// sent to a proxy.
 func FormRequestData(r *http.Request, dump []byte) *Request {
 	req := &Request{
-		Method: r.Method,
-		Path:   r.URL.Path,
-		Raw:    string(dump),
+		Method:     r.Method,
+		Path:       r.URL.Path,
+		Raw:        string(dump),
+		Query:      r.URL.RawQuery,
+		ClientAddr: r.RemoteAddr,
+		Proto:      r.Proto,
 	}
+	req.Scheme = strings.ToLower(r.URL.Scheme)
+	if req.Scheme == "" {
+		req.Scheme = "http"
+	}
+	req.IsHTTPS = req.Scheme == "https"
+	authority := r.URL.Host
+	if authority == "" {
+		authority = r.Host
+	}
+	req.Host, req.Port = SplitAuthority(authority, req.Scheme)
+	target := url.URL{
+		Scheme:   req.Scheme,
+		Host:     JoinAuthority(req.Host, req.Port, req.Scheme),
+		Path:     r.URL.Path,
+		RawPath:  r.URL.RawPath,
+		RawQuery: r.URL.RawQuery,
+	}
+	req.URL = target.String()
 	getParams := Map{}
 	for key, value := range r.URL.Query() {
 		getParams[key] = getValue(value)
@@ -56,12 +143,25 @@ func FormRequestData(r *http.Request, dump []byte) *Request {
 	}
 	req.Cookies = cookies
 
+	var body []byte
+	if r.Body != nil {
+		body, _ = io.ReadAll(r.Body)
+		r.Body.Close()
+		req.Size = int64(len(body))
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
 	postParams := Map{}
 	_ = r.ParseForm()
 	for key, value := range r.PostForm {
 		postParams[key] = getValue(value)
 	}
 	req.PostParams = postParams
+
+	// ParseForm drains the body, restore it for the upstream
+	if r.Body != nil {
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
 	return req
 }
 func FormResponseData(response *http.Response, body string) *Response {
@@ -83,11 +183,67 @@ func FormResponseData(response *http.Response, body string) *Response {
 	}
 	res.Headers = headers
 	res.Body = body
+	res.Size = int64(len(body))
 
 	return res
 
 }
 
+// ContentType returns the lower-cased media type of the response
+// without parameters, empty when there is none.
+func (r *Response) ContentType() string {
+	var value string
+	switch v := r.Headers["Content-Type"].(type) {
+	case string:
+		value = v
+	case []string:
+		if len(v) > 0 {
+			value = v[0]
+		}
+	case []interface{}:
+		// a response read back from JSON
+		if len(v) > 0 {
+			value, _ = v[0].(string)
+		}
+	}
+	if i := strings.IndexByte(value, ';'); i >= 0 {
+		value = value[:i]
+	}
+	return strings.ToLower(strings.TrimSpace(value))
+}
+
+// DefaultPort of the scheme, 80 unless it is https.
+func DefaultPort(scheme string) int {
+	if scheme == "https" {
+		return 443
+	}
+	return 80
+}
+
+// SplitAuthority splits host[:port], the port defaults to the scheme's.
+func SplitAuthority(authority, scheme string) (string, int) {
+	host, portStr, err := net.SplitHostPort(authority)
+	if err != nil {
+		return strings.Trim(authority, "[]"), DefaultPort(scheme)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port <= 0 {
+		port = DefaultPort(scheme)
+	}
+	return host, port
+}
+
+// JoinAuthority is the inverse of SplitAuthority.
+func JoinAuthority(host string, port int, scheme string) string {
+	if port == DefaultPort(scheme) {
+		if strings.Contains(host, ":") {
+			return "[" + host + "]"
+		}
+		return host
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
+
 func getValue(value []string) interface{} {
 	if len(value) == 1 {
 		return value[0]
@@ -0,0 +1,79 @@
+package proxyserver
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/iiivan-lemon/technopark_proxy/internal/utils/redact"
+)
+
+// RedactExchange masks the secrets of an exchange before it is recorded.
+// The original request is sealed for the repeater when the config keeps it,
+// if sealing fails only the redacted copy is stored.
+func RedactExchange(r *redact.Redactor, exchange *Exchange) {
+	if r == nil {
+		return
+	}
+	req := exchange.Request
+	raw := r.Raw(req.Raw)
+	if raw != req.Raw && r.KeepsOriginals() {
+		if sealed, err := r.Seal(req.Raw); err == nil {
+			req.Original = sealed
+		}
+	}
+	req.Raw = raw
+	req.Path = r.Text(req.Path)
+	req.Query = r.Query(req.Query)
+	req.URL = r.URL(req.URL)
+	r.Headers(req.Headers)
+	r.Cookies(req.Cookies)
+	// parsed again from the redacted text, so that the regexes matching
+	// a name together with its value apply
+	if getParams, ok := parseParams(req.Query); ok {
+		req.GetParams = getParams
+	} else {
+		r.Fields(req.GetParams)
+	}
+	body := ""
+	if i := strings.Index(req.Raw, "\r\n\r\n"); i >= 0 {
+		body = req.Raw[i+4:]
+	}
+	postParams, ok := parseParams(body)
+	if ok && strings.HasPrefix(strings.ToLower(headerValue(req.Headers, "Content-Type")), "application/x-www-form-urlencoded") {
+		req.PostParams = postParams
+	} else {
+		r.Fields(req.PostParams)
+	}
+
+	if resp := exchange.Response; resp != nil {
+		contentType := headerValue(resp.Headers, "Content-Type")
+		r.Headers(resp.Headers)
+		resp.Body = r.Body(resp.Body, contentType)
+	}
+}
+
+func parseParams(query string) (Map, bool) {
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return nil, false
+	}
+	params := Map{}
+	for key, value := range values {
+		params[key] = getValue(value)
+	}
+	return params, true
+}
+
+// headerValue is the first value of a header in a map made by getValue.
+func headerValue(headers Map, name string) string {
+	switch v := headers[http.CanonicalHeaderKey(name)].(type) {
+	case string:
+		return v
+	case []string:
+		if len(v) > 0 {
+			return v[0]
+		}
+	}
+	return ""
+}
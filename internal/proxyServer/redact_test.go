@@ -0,0 +1,93 @@
+package proxyserver
+
+import (
+	"bufio"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/iiivan-lemon/technopark_proxy/config"
+	"github.com/iiivan-lemon/technopark_proxy/internal/utils/redact"
+)
+
+func recorded(t *testing.T, raw string) *Exchange {
+	r, err := http.ReadRequest(bufio.NewReader(strings.NewReader(raw)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Exchange{
+		Request: FormRequestData(r, []byte(raw)),
+		Response: &Response{
+			Code:    200,
+			Headers: Map{"Content-Type": "application/json", "Set-Cookie": "sid=new; Path=/"},
+			Body:    `{"token": "t", "user": "ann"}`,
+		},
+	}
+}
+
+func TestRedactExchange(t *testing.T) {
+	t.Setenv("REDACT_TEST_KEY", strings.Repeat("ab", 32))
+	r, err := redact.NewRedactor(&config.RedactConfig{
+		Headers:       []string{"Authorization"},
+		Cookies:       []string{"sid"},
+		Fields:        []string{"password"},
+		JSONPaths:     []string{"$.token"},
+		KeepOriginals: true,
+		KeyEnv:        "REDACT_TEST_KEY",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw := "POST http://example.com/login?password=1&next=%2F HTTP/1.1\r\nHost: example.com\r\nAuthorization: Basic abc\r\n" +
+		"Cookie: sid=abc; theme=dark\r\nContent-Type: application/x-www-form-urlencoded\r\nContent-Length: 19\r\n\r\nuser=ann&password=p"
+	exchange := recorded(t, raw)
+	RedactExchange(r, exchange)
+	req := exchange.Request
+	for _, secret := range []string{"Basic abc", "sid=abc", "password=1", "password=p"} {
+		if strings.Contains(req.Raw, secret) {
+			t.Errorf("redacted dump shows %s:\n%s", secret, req.Raw)
+		}
+	}
+	if req.Headers["Authorization"] != "[REDACTED]" || req.Cookies["sid"] != "[REDACTED]" || req.Cookies["theme"] != "dark" ||
+		req.GetParams["password"] != "[REDACTED]" || req.GetParams["next"] != "/" ||
+		req.PostParams["password"] != "[REDACTED]" || req.PostParams["user"] != "ann" {
+		t.Errorf("redacted request = %+v", req)
+	}
+	if strings.Contains(req.URL, "password=1") || strings.Contains(req.Query, "password=1") {
+		t.Errorf("redacted URL %s, query %s", req.URL, req.Query)
+	}
+	if original, err := r.Open(req.Original); err != nil || original != raw {
+		t.Errorf("Original opens to %q, %v", original, err)
+	}
+	resp := exchange.Response
+	if resp.Body != `{"token":"[REDACTED]","user":"ann"}` || resp.Headers["Set-Cookie"] != "sid=[REDACTED]; Path=/" {
+		t.Errorf("redacted response = %+v", resp)
+	}
+
+	// nothing to redact, nothing to keep
+	raw = "GET http://example.com/page?x=1 HTTP/1.1\r\nHost: example.com\r\nX-Odd:  spaced\r\nCookie: theme=dark\r\n\r\n"
+	exchange = recorded(t, raw)
+	RedactExchange(r, exchange)
+	if exchange.Request.Raw != raw || exchange.Request.Original != "" {
+		t.Errorf("request without secrets came out as %q with original %q", exchange.Request.Raw, exchange.Request.Original)
+	}
+
+	// without a key only the redacted copy is stored
+	r, err = redact.NewRedactor(&config.RedactConfig{Headers: []string{"Authorization"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	exchange = recorded(t, "GET http://example.com/ HTTP/1.1\r\nHost: example.com\r\nAuthorization: Basic abc\r\n\r\n")
+	RedactExchange(r, exchange)
+	if exchange.Request.Original != "" || strings.Contains(exchange.Request.Raw, "Basic abc") {
+		t.Errorf("request redacted without a key = %+v", exchange.Request)
+	}
+
+	// a nil Redactor records the exchange as it is
+	exchange = recorded(t, raw)
+	RedactExchange(nil, exchange)
+	if exchange.Request.Raw != raw || exchange.Response.Body != `{"token": "t", "user": "ann"}` {
+		t.Errorf("exchange recorded without a Redactor = %+v", exchange)
+	}
+}
@@ -0,0 +1,192 @@
+package proxyserver
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+type tunnelProtocol int
+
+const (
+	tunnelRaw tunnelProtocol = iota
+	tunnelTLS
+	tunnelHTTP
+)
+
+const (
+	defaultSniffTimeout = 500 * time.Millisecond
+	// first byte of a TLS record carrying a handshake message (ClientHello)
+	tlsHandshakeRecord = 0x16
+	relayBufferSize    = 32 * 1024
+)
+
+var httpMethods = []string{
+	"GET", "HEAD", "POST", "PUT", "DELETE", "OPTIONS", "PATCH", "TRACE", "CONNECT",
+}
+
+// maxMethodLength is the length of the longest of httpMethods.
+const maxMethodLength = len("OPTIONS")
+
+// bufferedConn is a net.Conn whose first bytes have already been peeked.
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+func (c *bufferedConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
+
+// sniffTunnel guesses the protocol spoken inside a CONNECT tunnel by its first bytes.
+// Protocols where the server speaks first (SMTP, SSH, ...) send nothing
+// from the client side, so a silent client is relayed as raw TCP.
+func (ps *ProxyServer) sniffTunnel(conn net.Conn) (*bufferedConn, tunnelProtocol) {
+	bc := &bufferedConn{Conn: conn, reader: bufio.NewReader(conn)}
+
+	sniffTimeout := time.Duration(ps.tunnelConf.SniffTimeout) * time.Millisecond
+	if sniffTimeout <= 0 {
+		sniffTimeout = defaultSniffTimeout
+	}
+	// the request line may come in several packets, the deadline covers
+	// the whole of the peeking
+	_ = conn.SetReadDeadline(time.Now().Add(sniffTimeout))
+	protocol := sniffProtocol(bc.reader)
+	_ = conn.SetDeadline(time.Time{})
+	if protocol != tunnelRaw && ps.readTimeout > 0 {
+		_ = conn.SetReadDeadline(time.Now().Add(ps.readTimeout))
+	}
+	return bc, protocol
+}
+
+// sniffProtocol tells the protocol by the first bytes of reader, raw
+// when they do not come in time.
+func sniffProtocol(reader *bufio.Reader) tunnelProtocol {
+	first, err := reader.Peek(1)
+	switch {
+	case err != nil:
+		return tunnelRaw
+	case first[0] == tlsHandshakeRecord:
+		return tunnelTLS
+	case looksLikeHTTP(reader):
+		return tunnelHTTP
+	}
+	return tunnelRaw
+}
+
+// looksLikeHTTP reports whether reader starts with an HTTP method and a
+// space. It peeks a byte more at a time, up to the longest method and
+// the space, until the bytes are one of the methods or cannot become one.
+func looksLikeHTTP(reader *bufio.Reader) bool {
+	for n := 1; n <= maxMethodLength+1; n++ {
+		prefix, err := reader.Peek(n)
+		if err != nil {
+			return false
+		}
+		possible := false
+		for _, method := range httpMethods {
+			request := method + " "
+			if string(prefix) == request {
+				return true
+			}
+			if strings.HasPrefix(request, string(prefix)) {
+				possible = true
+			}
+		}
+		if !possible {
+			return false
+		}
+	}
+	return false
+}
+
+// relay copies data between client and upstream until both directions are closed
+// or stay idle longer than idleTimeout. It returns the amount of bytes sent
+// by each side together with up to captureLimit first bytes of each stream.
+func relay(client, upstream net.Conn, idleTimeout time.Duration, captureLimit int) (sent, received int64, clientPayload, serverPayload []byte) {
+	toUpstream := &capture{limit: captureLimit}
+	toClient := &capture{limit: captureLimit}
+	activity := &lastActivity{}
+	activity.touch()
+
+	done := make(chan struct{})
+	go func() {
+		received = copyIdle(client, upstream, toClient, activity, idleTimeout)
+		close(done)
+	}()
+	sent = copyIdle(upstream, client, toUpstream, activity, idleTimeout)
+	<-done
+
+	return sent, received, toUpstream.data, toClient.data
+}
+
+// copyIdle copies src to dst and half-closes dst when src is exhausted,
+// so that the other direction of the relay can finish on its own.
+// A direction waiting for data is not idle while the opposite one is busy.
+func copyIdle(dst, src net.Conn, capt *capture, activity *lastActivity, idleTimeout time.Duration) int64 {
+	var written int64
+	buf := make([]byte, relayBufferSize)
+	for {
+		if idleTimeout > 0 {
+			_ = src.SetReadDeadline(activity.get().Add(idleTimeout))
+		}
+		n, err := src.Read(buf)
+		if n > 0 {
+			activity.touch()
+			capt.add(buf[:n])
+			if _, werr := dst.Write(buf[:n]); werr != nil {
+				break
+			}
+			written += int64(n)
+		}
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() && time.Since(activity.get()) < idleTimeout {
+			continue
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+		_ = cw.CloseWrite()
+	} else {
+		_ = dst.Close()
+	}
+	return written
+}
+
+type lastActivity struct {
+	unixNano int64
+}
+
+func (a *lastActivity) touch() {
+	atomic.StoreInt64(&a.unixNano, time.Now().UnixNano())
+}
+
+func (a *lastActivity) get() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&a.unixNano))
+}
+
+// capture keeps the first limit bytes written to it.
+type capture struct {
+	limit int
+	data  []byte
+}
+
+func (c *capture) add(p []byte) {
+	if rest := c.limit - len(c.data); rest > 0 {
+		if len(p) > rest {
+			p = p[:rest]
+		}
+		c.data = append(c.data, p...)
+	}
+}
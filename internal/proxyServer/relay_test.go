@@ -0,0 +1,91 @@
+package proxyserver
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/iiivan-lemon/technopark_proxy/config"
+)
+
+func TestLooksLikeHTTP(t *testing.T) {
+	tests := []struct {
+		data string
+		want bool
+	}{
+		{"GET / HTTP/1.1\r\n", true},
+		{"OPTIONS * HTTP/1.1\r\n", true},
+		{"CONNECT example.com:443 HTTP/1.1\r\n", true},
+		{"DELETE /a", true},
+		{"GET ", true},
+		{"GET", false},
+		{"GETS / HTTP/1.1", false},
+		{"get / HTTP/1.1", false},
+		{"OPTIONSX", false},
+		{"SSH-2.0-OpenSSH_9.0\r\n", false},
+		{"\x00\x01\x02", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		// the request line arrives a byte at a time
+		reader := bufio.NewReader(iotest.OneByteReader(strings.NewReader(tt.data)))
+		if got := looksLikeHTTP(reader); got != tt.want {
+			t.Errorf("looksLikeHTTP(%q) = %v, want %v", tt.data, got, tt.want)
+		}
+		// nothing is consumed
+		if rest, _ := io.ReadAll(reader); string(rest) != tt.data {
+			t.Errorf("looksLikeHTTP(%q) left %q", tt.data, rest)
+		}
+	}
+}
+
+func TestSniffTunnel(t *testing.T) {
+	tests := []struct {
+		name string
+		// written one after the other, the client stays silent after them
+		writes []string
+		want   tunnelProtocol
+	}{
+		{"tls", []string{"\x16\x03\x01\x02\x00"}, tunnelTLS},
+		{"http", []string{"GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"}, tunnelHTTP},
+		{"http in pieces", []string{"P", "OS", "T /upload HTTP/1.1\r\n"}, tunnelHTTP},
+		{"raw", []string{"SSH-2.0-OpenSSH_9.0\r\n"}, tunnelRaw},
+		{"raw starting like a method", []string{"GETTING"}, tunnelRaw},
+		{"silent client", nil, tunnelRaw},
+		{"cut short", []string{"GE"}, tunnelRaw},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ps := &ProxyServer{tunnelConf: config.TunnelConfig{SniffTimeout: 100}}
+			client, conn := net.Pipe()
+			defer client.Close()
+			defer conn.Close()
+			go func(writes []string) {
+				for _, w := range writes {
+					if _, err := client.Write([]byte(w)); err != nil {
+						return
+					}
+					time.Sleep(10 * time.Millisecond)
+				}
+			}(tt.writes)
+
+			bc, protocol := ps.sniffTunnel(conn)
+			if protocol != tt.want {
+				t.Errorf("sniffTunnel = %v, want %v", protocol, tt.want)
+			}
+			// the peeked bytes are still there for the relay
+			want := strings.Join(tt.writes, "")
+			if want == "" {
+				return
+			}
+			got := make([]byte, len(want))
+			if _, err := io.ReadFull(bc, got); err != nil || string(got) != want {
+				t.Errorf("read %q, %v after sniffing, want %q", got, err, want)
+			}
+		})
+	}
+}
@@ -2,17 +2,26 @@ package proxyserver
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"crypto/tls"
 	"io"
 	"net"
 	"net/http"
+	"net/http/httptrace"
 	"net/http/httputil"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/iiivan-lemon/technopark_proxy/config"
+	log "github.com/iiivan-lemon/technopark_proxy/internal/tools/logger"
 	"github.com/iiivan-lemon/technopark_proxy/internal/utils/cert"
+	"github.com/iiivan-lemon/technopark_proxy/internal/utils/forward"
 	httperrors "github.com/iiivan-lemon/technopark_proxy/internal/utils/httpErrors"
 	"github.com/iiivan-lemon/technopark_proxy/internal/utils/middleware"
+	"github.com/iiivan-lemon/technopark_proxy/internal/utils/redact"
+	"github.com/iiivan-lemon/technopark_proxy/internal/utils/resolver"
 	"github.com/labstack/echo/v4"
 	echomw "github.com/labstack/echo/v4/middleware"
 	"github.com/pkg/errors"
@@ -20,37 +29,85 @@ import (
 
 var okHeader = []byte("HTTP/1.1 200 OK\r\n\r\n")
 
+const upstreamDialTimeout = 10 * time.Second
+
 type ProxyServer struct {
-	repo ProxyRepository
-	CA   *tls.Certificate
+	recorder Recorder
+	sessions Sessions
+	redactor *redact.Redactor
+	CA       *tls.Certificate
 	// proxy server's tls-config for connecting to client as server
 	ProxyAsServerTLSConfig *tls.Config
 
 	// proxy server's tls-config for connecting to upstream-server as client
 	ProxyAsClientTLSConfig *tls.Config
+
+	forwarder   *forward.Forwarder
+	resolver    *resolver.Resolver
+	transport   *http.Transport
+	tunnelConf  config.TunnelConfig
+	readTimeout time.Duration
+
+	mu       sync.Mutex
+	httpServ *http.Server
+	tunnels  tunnelTracker
 }
 
-func NewProxyServer(repo *ProxyRepository, caCert *tls.Certificate, servConf, clientConf *tls.Config) *ProxyServer {
+func NewProxyServer(recorder Recorder, sessions Sessions, redactor *redact.Redactor, caCert *tls.Certificate, servConf, clientConf *tls.Config, forwarder *forward.Forwarder, res *resolver.Resolver) *ProxyServer {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = res.DialContext
 	return &ProxyServer{
-		repo:                   *repo,
+		recorder:               recorder,
+		sessions:               sessions,
+		redactor:               redactor,
 		CA:                     caCert,
 		ProxyAsServerTLSConfig: servConf,
 		ProxyAsClientTLSConfig: clientConf,
+		forwarder:              forwarder,
+		resolver:               res,
+		transport:              transport,
 	}
 }
 
-func (ps *ProxyServer) ListenAndServe(proxyConf *config.ServerConfig, mw *middleware.CommonMiddleware) {
+// ListenAndServe blocks until the server fails or Shutdown is called.
+// A graceful stop is not reported as an error.
+func (ps *ProxyServer) ListenAndServe(proxyConf *config.ServerConfig, mw *middleware.CommonMiddleware) error {
 	e := echo.New()
 	e.Use(echomw.Recover(), mw.RequestIdMiddleware, mw.AccessLogMiddleware, mw.PanicMiddleware, ps.proxyDefineProtocol)
 
-	httpServ := http.Server{
+	httpServ := &http.Server{
 		Addr:         proxyConf.Addr(),
 		ReadTimeout:  time.Duration(proxyConf.ReadTimeout) * time.Second,
 		WriteTimeout: time.Duration(proxyConf.WriteTimeout) * time.Second,
 		Handler:      e,
 	}
+	ps.mu.Lock()
+	ps.httpServ = httpServ
+	ps.tunnelConf = proxyConf.Tunnel
+	ps.readTimeout = httpServ.ReadTimeout
+	ps.mu.Unlock()
+
+	if err := e.StartServer(httpServ); err != nil && err != http.ErrServerClosed {
+		return errors.Wrap(err, "proxy server error")
+	}
+	return nil
+}
 
-	e.Logger.Fatal(e.StartServer(&httpServ))
+// Shutdown stops accepting connections and waits for active requests and
+// CONNECT tunnels to finish. Tunnels still open when ctx expires are closed.
+func (ps *ProxyServer) Shutdown(ctx context.Context) error {
+	ps.mu.Lock()
+	httpServ := ps.httpServ
+	ps.mu.Unlock()
+	if httpServ == nil {
+		return nil
+	}
+
+	err := httpServ.Shutdown(ctx)
+	if tunnelsErr := ps.tunnels.wait(ctx); err == nil {
+		err = tunnelsErr
+	}
+	return err
 }
 
 func (ps *ProxyServer) proxyDefineProtocol(_ echo.HandlerFunc) echo.HandlerFunc {
@@ -65,7 +122,12 @@ func (ps *ProxyServer) proxyDefineProtocol(_ echo.HandlerFunc) echo.HandlerFunc
 func (ps *ProxyServer) proxyHTTPHandler(ctx echo.Context) error {
 	logger := middleware.GetLoggerFromCtx(ctx)
 	requestId := middleware.GetRequestIdFromCtx(ctx)
-	ctx.Request().Header.Del("Proxy-Connection")
+	timing := NewTiming()
+	if ps.forwarder.IsLoop(ctx.Request().Header) {
+		logger.Warn(requestId, "loop detected for "+ctx.Request().Host)
+		return echo.NewHTTPError(http.StatusLoopDetected, httperrors.LOOP_DETECTED)
+	}
+	forward.RemoveHopByHop(ctx.Request().Header)
 
 	reqDump, err := httputil.DumpRequest(ctx.Request(), true)
 	if err != nil {
@@ -74,19 +136,25 @@ func (ps *ProxyServer) proxyHTTPHandler(ctx echo.Context) error {
 	}
 
 	repoReq := FormRequestData(ctx.Request(), reqDump)
-	repoReq.IsHTTPS = false
-	repoReqID, err := ps.repo.InsertRequest(repoReq)
-	if err != nil {
-		logger.Error(requestId, errors.Wrap(err, "http inserting request to db error").Error())
-		return echo.NewHTTPError(http.StatusServiceUnavailable, httperrors.INTERNAL_SERVER_ERR)
+	repoReq.StartedAt = timing.StartedAt
+	exchange := &Exchange{Request: repoReq}
+	// the request is recorded even when the upstream never answers
+	defer ps.record(exchange)
+
+	ps.forwarder.PrepareRequest(ctx.Request(), ctx.Request().RemoteAddr, false)
+	var remoteIP string
+	trace := timing.Trace()
+	trace.GotConn = func(info httptrace.GotConnInfo) {
+		remoteIP = resolver.RemoteIP(info.Conn)
 	}
-
-	upstreamResp, err := http.DefaultTransport.RoundTrip(ctx.Request())
+	tracedReq := ctx.Request().WithContext(httptrace.WithClientTrace(ctx.Request().Context(), trace))
+	upstreamResp, err := ps.transport.RoundTrip(tracedReq)
 	if err != nil {
 		logger.Error(requestId, errors.Wrap(err, "round trip").Error())
 		return echo.NewHTTPError(http.StatusServiceUnavailable, httperrors.INTERNAL_SERVER_ERR)
 	}
 	defer upstreamResp.Body.Close()
+	ps.forwarder.PrepareResponse(upstreamResp)
 
 	for key, values := range upstreamResp.Header {
 		for _, value := range values {
@@ -95,26 +163,21 @@ func (ps *ProxyServer) proxyHTTPHandler(ctx echo.Context) error {
 	}
 
 	ctx.Response().Status = upstreamResp.StatusCode
-	if _, err = io.Copy(ctx.Response(), upstreamResp.Body); err != nil {
+	var upstreamBody bytes.Buffer
+	if _, err = io.Copy(ctx.Response(), io.TeeReader(upstreamResp.Body, &upstreamBody)); err != nil {
 		logger.Error(requestId, errors.Wrap(err, "copy upstream's response to client").Error())
 		return echo.NewHTTPError(http.StatusInternalServerError, httperrors.INTERNAL_SERVER_ERR)
 	}
-	var upsreamBody string
-	if b, err := io.ReadAll(upstreamResp.Body); err == nil {
-		upsreamBody = string(b)
-	}
+	timing.Finish()
 
-	upstreamRepoResp := FormResponseData(upstreamResp, upsreamBody)
-	if upstreamResp == nil {
+	upstreamRepoResp := FormResponseData(upstreamResp, upstreamBody.String())
+	if upstreamRepoResp == nil {
 		logger.Error(requestId, errors.Wrap(err, "form response error").Error())
 		return echo.NewHTTPError(http.StatusInternalServerError, httperrors.INTERNAL_SERVER_ERR)
 	}
-
-	err = ps.repo.InsertResponse(repoReqID, upstreamRepoResp)
-	if err != nil {
-		logger.Error(requestId, errors.Wrap(err, "http inserting response to db error").Error())
-		return echo.NewHTTPError(http.StatusInternalServerError, httperrors.INTERNAL_SERVER_ERR)
-	}
+	upstreamRepoResp.Timing = *timing
+	upstreamRepoResp.RemoteIP = remoteIP
+	exchange.Response = upstreamRepoResp
 
 	return nil
 }
@@ -122,32 +185,63 @@ func (ps *ProxyServer) proxyHTTPHandler(ctx echo.Context) error {
 func (ps *ProxyServer) proxyHTTPSHandler(ctx echo.Context) error {
 	logger := middleware.GetLoggerFromCtx(ctx)
 	requestId := middleware.GetRequestIdFromCtx(ctx)
-	name, _, _ := net.SplitHostPort(ctx.Request().Host)
+	ps.tunnels.begin()
+	defer ps.tunnels.end()
+	target := ctx.Request().Host
+	name, _, _ := net.SplitHostPort(target)
 
 	if name == "" {
-		logger.Warn(requestId, "cannot determine cert name for"+ctx.Request().Host)
+		logger.Warn(requestId, "cannot determine cert name for"+target)
 		return echo.NewHTTPError(http.StatusServiceUnavailable, httperrors.NO_UPSTREAM_ERR)
 	}
 
+	hijackedConnToClient, _, err := ctx.Response().Hijack()
+	if err != nil {
+		logger.Error(requestId, errors.Wrap(err, "hijacking error:").Error())
+		return nil
+	}
+	defer hijackedConnToClient.Close()
+	ps.tunnels.track(hijackedConnToClient)
+	defer ps.tunnels.untrack(hijackedConnToClient)
+
+	if _, err = hijackedConnToClient.Write(okHeader); err != nil {
+		logger.Error(requestId, errors.Wrap(err, "writing ok-header error").Error())
+		return nil
+	}
+
+	connToClient, protocol := ps.sniffTunnel(hijackedConnToClient)
+	switch protocol {
+	case tunnelTLS:
+		ps.serveTLSTunnel(logger, requestId, connToClient, target, name)
+	case tunnelHTTP:
+		ps.serveHTTPTunnel(logger, requestId, connToClient, target)
+	default:
+		ps.serveRawTunnel(logger, requestId, connToClient, target)
+	}
+	return nil
+}
+
+func (ps *ProxyServer) serveTLSTunnel(logger *log.ServLogger, requestId uint64, hijackedConnToClient net.Conn, target, name string) {
+	timing := NewTiming()
 	provisionalCert, err := cert.GenCert(ps.CA, name)
 	if err != nil {
 		logger.Error(requestId, errors.Wrap(err, "generating leaf provisional cert").Error())
-		return echo.NewHTTPError(http.StatusInternalServerError, httperrors.INTERNAL_SERVER_ERR)
+		return
 	}
 
-	serverConfig := tls.Config{}
+	serverConfig := &tls.Config{}
 	if ps.ProxyAsServerTLSConfig != nil {
-		serverConfig = *ps.ProxyAsServerTLSConfig
+		serverConfig = ps.ProxyAsServerTLSConfig.Clone()
 	}
 	serverConfig.Certificates = []tls.Certificate{*provisionalCert}
 	var connToUpstream *tls.Conn
 	serverConfig.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
-		clientConfig := tls.Config{}
+		clientConfig := &tls.Config{}
 		if ps.ProxyAsClientTLSConfig != nil {
-			clientConfig = *ps.ProxyAsClientTLSConfig
+			clientConfig = ps.ProxyAsClientTLSConfig.Clone()
 		}
 		clientConfig.ServerName = hello.ServerName
-		connToUpstream, err = tls.Dial("tcp", ctx.Request().Host, &clientConfig)
+		connToUpstream, err = ps.dialTLSTimed(hello.Context(), target, clientConfig, timing)
 		if err != nil {
 			logger.Error(requestId, errors.Wrap(err, "dial error").Error())
 			return nil, err
@@ -155,83 +249,113 @@ func (ps *ProxyServer) proxyHTTPSHandler(ctx echo.Context) error {
 		return cert.GenCert(ps.CA, hello.ServerName)
 	}
 
-	hijackedConnToClient, _, err := ctx.Response().Hijack()
-	if err != nil {
-		logger.Error(requestId, errors.Wrap(err, "hijacking error:").Error())
-		return nil
-	}
-	defer hijackedConnToClient.Close()
-
-	if _, err = hijackedConnToClient.Write(okHeader); err != nil {
-		logger.Error(requestId, errors.Wrap(err, "writing ok-header error").Error())
-		return nil
-	}
-
-	connToClient := tls.Server(hijackedConnToClient, &serverConfig)
+	connToClient := tls.Server(hijackedConnToClient, serverConfig)
 	if connToClient == nil {
 		logger.Error(requestId, errors.Wrap(err, "tls-server error:").Error())
-		return nil
+		return
 	}
 	defer connToClient.Close()
 
 	err = connToClient.Handshake()
 	if err != nil {
 		logger.Error(requestId, errors.Wrap(err, "tls-server error:").Error())
-		return nil
+		return
 	}
 
 	if connToUpstream == nil {
 		logger.Warn(requestId, "connection to upstrean error")
-		return nil
+		return
+	}
+	defer connToUpstream.Close()
+
+	ps.tunnelExchange(logger, requestId, connToClient, connToUpstream, target, hijackedConnToClient.RemoteAddr().String(), true, timing)
+}
+
+// serveHTTPTunnel handles plain HTTP sent through a CONNECT tunnel.
+func (ps *ProxyServer) serveHTTPTunnel(logger *log.ServLogger, requestId uint64, connToClient net.Conn, target string) {
+	timing := NewTiming()
+	connToUpstream, err := ps.dialTimed(context.Background(), target, timing)
+	if err != nil {
+		logger.Error(requestId, errors.Wrap(err, "dial error").Error())
+		return
 	}
 	defer connToUpstream.Close()
 
+	ps.tunnelExchange(logger, requestId, connToClient, connToUpstream, target, connToClient.RemoteAddr().String(), false, timing)
+}
+
+// tunnelExchange reads a single request from the client side of a tunnel,
+// forwards it upstream and records both the request and the response.
+func (ps *ProxyServer) tunnelExchange(logger *log.ServLogger, requestId uint64, connToClient, connToUpstream net.Conn, target, clientAddr string, isHTTPS bool, timing *Timing) {
 	reader := bufio.NewReader(connToClient)
 	request, err := http.ReadRequest(reader)
 	if err != nil {
 		logger.Error(requestId, errors.Wrap(err, "getting request error").Error())
-		return nil
+		return
 	}
+	// the request line inside a tunnel holds only the path,
+	// the upstream is the one asked for by CONNECT
+	request.URL.Scheme = "http"
+	if isHTTPS {
+		request.URL.Scheme = "https"
+	}
+	request.URL.Host = target
+	request.RemoteAddr = clientAddr
+
+	if ps.forwarder.IsLoop(request.Header) {
+		logger.Warn(requestId, "loop detected for "+request.Host)
+		writeTunnelError(connToClient, request, http.StatusLoopDetected, httperrors.LOOP_DETECTED)
+		return
+	}
+	forward.RemoveHopByHop(request.Header)
 
 	requestByte, err := httputil.DumpRequest(request, true)
 	if err != nil {
 		logger.Error(requestId, errors.Wrap(err, "dump request error").Error())
-		return nil
+		return
 	}
 
 	repoReq := FormRequestData(request, requestByte)
-	repoReq.IsHTTPS = true
-	repoReqID, err := ps.repo.InsertRequest(repoReq)
+	repoReq.StartedAt = timing.StartedAt
+	exchange := &Exchange{Request: repoReq}
+	defer ps.record(exchange)
 
+	ps.forwarder.PrepareRequest(request, clientAddr, isHTTPS)
+	upstreamRequestByte, err := httputil.DumpRequest(request, true)
 	if err != nil {
-		logger.Error(requestId, errors.Wrap(err, "https inserting request to db error").Error())
-		return nil
+		logger.Error(requestId, errors.Wrap(err, "dump request error").Error())
+		return
 	}
 
-	_, err = connToUpstream.Write(requestByte)
+	_, err = connToUpstream.Write(upstreamRequestByte)
 	if err != nil {
 		logger.Error(requestId, errors.Wrap(err, "write request error").Error())
-		return nil
+		return
 	}
 
-	serverReader := bufio.NewReader(connToUpstream)
+	serverReader := bufio.NewReader(&firstByteReader{Reader: connToUpstream, timing: timing})
 	response, err := http.ReadResponse(serverReader, request)
 	if err != nil {
 		logger.Error(requestId, errors.Wrap(err, "read response error").Error())
-		return nil
+		return
 	}
 
+	ps.forwarder.PrepareResponse(response)
+	// the tunnel serves a single request, let the client know it is closing
+	response.Close = true
+
 	rawResponse, err := httputil.DumpResponse(response, true)
 	if err != nil {
 		logger.Error(requestId, errors.Wrap(err, "dump response error").Error())
-		return nil
+		return
 	}
 
 	_, err = connToClient.Write(rawResponse)
 	if err != nil {
 		logger.Error(requestId, errors.Wrap(err, "write response error").Error())
-		return nil
+		return
 	}
+	timing.Finish()
 
 	var upsreamRespBody string
 	if b, err := io.ReadAll(response.Body); err == nil {
@@ -240,14 +364,72 @@ func (ps *ProxyServer) proxyHTTPSHandler(ctx echo.Context) error {
 	upstreamRepoResp := FormResponseData(response, upsreamRespBody)
 	if upstreamRepoResp == nil {
 		logger.Error(requestId, errors.Wrap(err, "form response error").Error())
-		return nil
+		return
 	}
+	upstreamRepoResp.Timing = *timing
+	upstreamRepoResp.RemoteIP = resolver.RemoteIP(connToUpstream)
+	exchange.Response = upstreamRepoResp
+}
 
-	err = ps.repo.InsertResponse(repoReqID, upstreamRepoResp)
+// serveRawTunnel relays bytes of a non-HTTP protocol in both directions
+// and records the byte counts and the beginning of each stream.
+func (ps *ProxyServer) serveRawTunnel(logger *log.ServLogger, requestId uint64, connToClient net.Conn, target string) {
+	started := time.Now()
+	dialCtx, cancel := context.WithTimeout(context.Background(), upstreamDialTimeout)
+	connToUpstream, err := ps.resolver.DialContext(dialCtx, "tcp", target)
+	cancel()
 	if err != nil {
-		logger.Error(requestId, errors.Wrap(err, "https inserting response to db error").Error())
-		return nil
+		logger.Error(requestId, errors.Wrap(err, "dial error").Error())
+		return
 	}
+	defer connToUpstream.Close()
+	ps.tunnels.track(connToUpstream)
+	defer ps.tunnels.untrack(connToUpstream)
 
-	return nil
+	tunnel := &Tunnel{
+		Host:       target,
+		ClientAddr: connToClient.RemoteAddr().String(),
+		StartedAt:  started,
+	}
+	idleTimeout := time.Duration(ps.tunnelConf.IdleTimeout) * time.Second
+	tunnel.BytesSent, tunnel.BytesReceived, tunnel.ClientPayload, tunnel.ServerPayload =
+		relay(connToClient, connToUpstream, idleTimeout, ps.tunnelConf.CaptureLimit)
+	tunnel.Duration = time.Since(started)
+
+	host, _, err := net.SplitHostPort(target)
+	if err != nil {
+		host = target
+	}
+	if sessionID, ok := ps.sessions.Capture(host); ok {
+		tunnel.SessionID = sessionID
+		ps.recorder.RecordTunnel(tunnel)
+	}
+}
+
+// record stamps the exchange with the active session and hands it to
+// the recorder, unless its host is out of the session's scope.
+func (ps *ProxyServer) record(exchange *Exchange) {
+	sessionID, ok := ps.sessions.Capture(exchange.Request.Host)
+	if !ok {
+		return
+	}
+	exchange.Request.SessionID = sessionID
+	RedactExchange(ps.redactor, exchange)
+	ps.recorder.RecordExchange(exchange)
+}
+
+// writeTunnelError answers a request read from inside a CONNECT tunnel,
+// where echo's error handler cannot reach the client.
+func writeTunnelError(conn net.Conn, request *http.Request, code int, msg string) {
+	resp := &http.Response{
+		StatusCode:    code,
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Request:       request,
+		Header:        http.Header{"Content-Type": {"text/plain; charset=utf-8"}},
+		Body:          io.NopCloser(strings.NewReader(msg)),
+		ContentLength: int64(len(msg)),
+		Close:         true,
+	}
+	_ = resp.Write(conn)
 }
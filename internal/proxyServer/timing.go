@@ -0,0 +1,109 @@
+package proxyserver
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http/httptrace"
+	"time"
+)
+
+// Timing holds the phases of a single exchange. Upstream phases stay zero
+// when they did not happen, e.g. DNS, Connect and TLS for a reused connection.
+// TTFB and Total are counted from StartedAt.
+type Timing struct {
+	StartedAt time.Time     `json:"started_at"`
+	DNS       time.Duration `json:"dns"`
+	Connect   time.Duration `json:"connect"`
+	TLS       time.Duration `json:"tls"`
+	TTFB      time.Duration `json:"ttfb"`
+	Total     time.Duration `json:"total"`
+}
+
+// NewTiming starts timing an exchange now.
+func NewTiming() *Timing {
+	return &Timing{StartedAt: time.Now()}
+}
+
+// Finish fixes the total duration of the exchange.
+func (t *Timing) Finish() {
+	t.Total = time.Since(t.StartedAt)
+}
+
+// Trace returns an httptrace.ClientTrace filling the upstream phases of t.
+func (t *Timing) Trace() *httptrace.ClientTrace {
+	var dnsStart, connStart, tlsStart time.Time
+	return &httptrace.ClientTrace{
+		DNSStart:             func(httptrace.DNSStartInfo) { dnsStart = time.Now() },
+		DNSDone:              func(httptrace.DNSDoneInfo) { t.DNS = time.Since(dnsStart) },
+		ConnectStart:         func(string, string) { connStart = time.Now() },
+		ConnectDone:          func(string, string, error) { t.Connect = time.Since(connStart) },
+		TLSHandshakeStart:    func() { tlsStart = time.Now() },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { t.TLS = time.Since(tlsStart) },
+		GotFirstResponseByte: func() { t.TTFB = time.Since(t.StartedAt) },
+	}
+}
+
+// dialTimed connects to addr recording the DNS and TCP connect phases.
+func (ps *ProxyServer) dialTimed(ctx context.Context, addr string, timing *Timing) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	dnsStart := time.Now()
+	ips, err := ps.resolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	timing.DNS = time.Since(dnsStart)
+
+	dialer := net.Dialer{Timeout: upstreamDialTimeout}
+	connectStart := time.Now()
+	for _, ip := range ips {
+		var conn net.Conn
+		conn, err = dialer.DialContext(ctx, "tcp", net.JoinHostPort(ip.String(), port))
+		if err == nil {
+			timing.Connect = time.Since(connectStart)
+			return conn, nil
+		}
+	}
+	return nil, err
+}
+
+// dialTLSTimed is dialTimed followed by a TLS handshake.
+func (ps *ProxyServer) dialTLSTimed(ctx context.Context, addr string, conf *tls.Config, timing *Timing) (*tls.Conn, error) {
+	rawConn, err := ps.dialTimed(ctx, addr, timing)
+	if err != nil {
+		return nil, err
+	}
+	if conf.ServerName == "" {
+		conf.ServerName, _, _ = net.SplitHostPort(addr)
+	}
+
+	tlsStart := time.Now()
+	conn := tls.Client(rawConn, conf)
+	if err = conn.HandshakeContext(ctx); err != nil {
+		rawConn.Close()
+		return nil, err
+	}
+	timing.TLS = time.Since(tlsStart)
+	return conn, nil
+}
+
+// firstByteReader sets TTFB of timing on the first successful read.
+type firstByteReader struct {
+	io.Reader
+	timing *Timing
+	seen   bool
+}
+
+func (r *firstByteReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if n > 0 && !r.seen {
+		r.seen = true
+		r.timing.TTFB = time.Since(r.timing.StartedAt)
+	}
+	return n, err
+}
@@ -0,0 +1,64 @@
+package proxyserver
+
+import (
+	"context"
+	"net"
+	"sync"
+)
+
+// tunnelTracker keeps track of hijacked CONNECT connections.
+// http.Server.Shutdown forgets about a connection as soon as it is hijacked,
+// so the proxy has to wait for (and, past the deadline, close) them itself.
+type tunnelTracker struct {
+	mu    sync.Mutex
+	wg    sync.WaitGroup
+	conns map[net.Conn]struct{}
+}
+
+// begin must be called before the connection is hijacked,
+// otherwise Shutdown may return before the tunnel is registered.
+func (t *tunnelTracker) begin() {
+	t.wg.Add(1)
+}
+
+func (t *tunnelTracker) end() {
+	t.wg.Done()
+}
+
+func (t *tunnelTracker) track(conn net.Conn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.conns == nil {
+		t.conns = make(map[net.Conn]struct{})
+	}
+	t.conns[conn] = struct{}{}
+}
+
+func (t *tunnelTracker) untrack(conn net.Conn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.conns, conn)
+}
+
+// wait blocks until every tunnel is finished. When ctx expires first,
+// the remaining connections are closed and ctx.Err() is returned.
+func (t *tunnelTracker) wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		t.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+
+	t.mu.Lock()
+	for conn := range t.conns {
+		_ = conn.Close()
+	}
+	t.mu.Unlock()
+	return ctx.Err()
+}
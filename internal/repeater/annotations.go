@@ -0,0 +1,58 @@
+package repeater
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Annotation is what a user marked a request with while triaging.
+type Annotation struct {
+	Note  string   `json:"note"`
+	Color string   `json:"color"`
+	Tags  []string `json:"tags"`
+}
+
+// TagCount is a tag with the number of requests carrying it.
+type TagCount struct {
+	Name     string `json:"name"`
+	Requests int    `json:"requests"`
+}
+
+// Colors are the named highlights, a color may also be #rgb or #rrggbb.
+var Colors = []string{"red", "orange", "yellow", "green", "cyan", "blue", "pink", "magenta", "gray"}
+
+var hexColor = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+func IsColor(color string) bool {
+	for _, c := range Colors {
+		if c == color {
+			return true
+		}
+	}
+	return hexColor.MatchString(color)
+}
+
+const (
+	maxTagLen  = 64
+	maxNoteLen = 64 << 10
+)
+
+// IsTag reports whether name can be a tag. Commas separate tags in
+// the query of GET /requests.
+func IsTag(name string) bool {
+	return name != "" && len(name) <= maxTagLen && strings.TrimSpace(name) == name && !strings.ContainsAny(name, ",\r\n")
+}
+
+// SortTags sorts tags and drops the repeated ones.
+func SortTags(tags []string) []string {
+	sorted := append([]string{}, tags...)
+	sort.Strings(sorted)
+	res := sorted[:0]
+	for _, tag := range sorted {
+		if len(res) == 0 || tag != res[len(res)-1] {
+			res = append(res, tag)
+		}
+	}
+	return res
+}
@@ -0,0 +1,659 @@
+package repeater
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/iiivan-lemon/technopark_proxy/config"
+	proxyserver "github.com/iiivan-lemon/technopark_proxy/internal/proxyServer"
+	"github.com/pkg/errors"
+)
+
+// AttackTypes are how the payloads go into the positions: sniper puts
+// each payload of its set into one position at a time, the others keep
+// their value; battering-ram puts it into every position at once;
+// pitchfork takes the n-th payload of every set, a set per position;
+// cluster-bomb tries every combination of the sets.
+var AttackTypes = []string{"sniper", "battering-ram", "pitchfork", "cluster-bomb"}
+
+func IsAttackType(t string) bool {
+	for _, at := range AttackTypes {
+		if at == t {
+			return true
+		}
+	}
+	return false
+}
+
+// PositionMarker encloses the positions of the template of an attack,
+// the text between a pair of markers is the value the position keeps.
+const PositionMarker = "§"
+
+// The statuses of an attack. An attack left running or paused by a
+// previous run of the server is interrupted.
+const (
+	AttackRunning     = "running"
+	AttackPaused      = "paused"
+	AttackFinished    = "finished"
+	AttackCancelled   = "cancelled"
+	AttackFailed      = "failed"
+	AttackInterrupted = "interrupted"
+)
+
+// Attack sends the request of Template with its positions filled with
+// payloads to the upstream at Scheme, Host and Port, the target of the
+// request it is built on, unless the template is in the absolute form.
+type Attack struct {
+	ID        int64 `json:"id"`
+	SessionID int64 `json:"session_id"`
+	// null once the request is deleted
+	RequestID   *int64       `json:"request_id"`
+	Type        string       `json:"type"`
+	Template    string       `json:"template"`
+	Payloads    []PayloadSet `json:"payloads"`
+	Concurrency int          `json:"concurrency"`
+	// milliseconds each worker waits between its requests
+	Delay int `json:"delay"`
+	// regular expressions looked for in the responses, see AttackResult.Grep
+	Grep   []string `json:"grep"`
+	Scheme string   `json:"scheme"`
+	Host   string   `json:"host"`
+	Port   int      `json:"port"`
+	Status string   `json:"status"`
+	// the number of requests to send, and sent so far
+	Total      int64      `json:"total"`
+	Done       int64      `json:"done"`
+	Error      string     `json:"error,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+}
+
+// AttackResult is a request of an attack: the payloads it was sent with,
+// in the order of the positions, or the payload and its position for a
+// sniper, and what came back.
+type AttackResult struct {
+	AttackID int64    `json:"attack_id"`
+	Index    int64    `json:"index"`
+	Payloads []string `json:"payloads"`
+	Position *int     `json:"position,omitempty"`
+	// the request stored, null when it could not be or it is deleted
+	RequestID *int64 `json:"request_id"`
+	// zero when there is no response
+	Status int `json:"status"`
+	// bytes of the response, head and body as received
+	Length int64 `json:"length"`
+	// microseconds, as Timing.Total
+	Duration int64 `json:"duration_us"`
+	// whether each regular expression of Attack.Grep matches the response
+	Grep      []bool    `json:"grep"`
+	Error     string    `json:"error,omitempty"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+// AttackResultSortKeys are the values accepted by AttackResultsFilter.SortBy.
+var AttackResultSortKeys = []string{"index", "status", "length", "duration"}
+
+func IsAttackResultSortKey(key string) bool {
+	for _, k := range AttackResultSortKeys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// AttackResultsFilter selects the results of an attack, index order by
+// default.
+type AttackResultsFilter struct {
+	AttackID int64
+	SortBy   string
+	Desc     bool
+	// any of the ranges, 0 is no response
+	Statuses []StatusRange
+	// the results with a match of Attack.Grep
+	Matched bool
+	Offset  int
+	// 0 means no limit
+	Limit int
+}
+
+// template is the template of an attack split at its positions: the
+// text around the positions and the values the positions keep.
+type template struct {
+	parts    []string
+	defaults []string
+}
+
+func parseTemplate(text string) (*template, error) {
+	split := strings.Split(text, PositionMarker)
+	if len(split)%2 == 0 {
+		return nil, errors.New("template should have pairs of " + PositionMarker)
+	}
+	t := &template{}
+	for i, s := range split {
+		if i%2 == 0 {
+			t.parts = append(t.parts, s)
+		} else {
+			t.defaults = append(t.defaults, s)
+		}
+	}
+	if len(t.defaults) == 0 {
+		return nil, errors.New("template should mark a position with a pair of " + PositionMarker)
+	}
+	return t, nil
+}
+
+// fill returns the template with the values in the positions.
+func (t *template) fill(values []string) string {
+	var b strings.Builder
+	for i, part := range t.parts {
+		b.WriteString(part)
+		if i < len(values) {
+			b.WriteString(values[i])
+		}
+	}
+	return b.String()
+}
+
+// attackPlan is the order an attack goes through the payloads in.
+type attackPlan struct {
+	kind     string
+	template *template
+	sets     []payloads
+	total    int64
+}
+
+// newAttackPlan checks the attack and counts its requests, at most
+// conf.MaxRequests of them.
+func newAttackPlan(attack *Attack, conf *config.AttackConfig) (*attackPlan, error) {
+	if !IsAttackType(attack.Type) {
+		return nil, errors.New("type should be sniper, battering-ram, pitchfork or cluster-bomb")
+	}
+	t, err := parseTemplate(attack.Template)
+	if err != nil {
+		return nil, err
+	}
+	positions := len(t.defaults)
+	switch {
+	case (attack.Type == "sniper" || attack.Type == "battering-ram") && len(attack.Payloads) != 1:
+		return nil, errors.Errorf("%s takes a single payload set", attack.Type)
+	case (attack.Type == "pitchfork" || attack.Type == "cluster-bomb") && len(attack.Payloads) != positions:
+		return nil, errors.Errorf("%s takes a payload set per position, %d of them", attack.Type, positions)
+	}
+
+	limit := conf.MaxRequests
+	p := &attackPlan{kind: attack.Type, template: t}
+	for i := range attack.Payloads {
+		set, err := attack.Payloads[i].payloads(conf.WordlistDir, limit)
+		if err != nil {
+			return nil, errors.Wrapf(err, "payload set %d", i+1)
+		}
+		p.sets = append(p.sets, set)
+	}
+	switch attack.Type {
+	case "sniper":
+		p.total = int64(positions) * p.sets[0].Len()
+	case "battering-ram":
+		p.total = p.sets[0].Len()
+	case "pitchfork":
+		p.total = p.sets[0].Len()
+		for _, set := range p.sets[1:] {
+			if set.Len() < p.total {
+				p.total = set.Len()
+			}
+		}
+	case "cluster-bomb":
+		p.total = 1
+		for _, set := range p.sets {
+			if p.total > limit/set.Len() {
+				return nil, errors.Errorf("attack has more than %d requests", limit)
+			}
+			p.total *= set.Len()
+		}
+	}
+	if p.total > limit {
+		return nil, errors.Errorf("attack has more than %d requests", limit)
+	}
+	return p, nil
+}
+
+// payloads returns the values of the positions for the request i, the
+// payloads it is sent with and, for a sniper, the position it fills.
+func (p *attackPlan) payloads(i int64) (values, sent []string, position *int) {
+	values = make([]string, len(p.template.defaults))
+	switch p.kind {
+	case "sniper":
+		n := p.sets[0].Len()
+		pos := int(i / n)
+		copy(values, p.template.defaults)
+		values[pos] = p.sets[0].At(i % n)
+		return values, []string{values[pos]}, &pos
+	case "battering-ram":
+		v := p.sets[0].At(i)
+		for j := range values {
+			values[j] = v
+		}
+		return values, []string{v}, nil
+	case "pitchfork":
+		for j, set := range p.sets {
+			values[j] = set.At(i)
+		}
+	case "cluster-bomb":
+		// the last set changes fastest
+		for j := len(p.sets) - 1; j >= 0; j-- {
+			n := p.sets[j].Len()
+			values[j] = p.sets[j].At(i % n)
+			i /= n
+		}
+	}
+	return values, append([]string(nil), values...), nil
+}
+
+// compileGrep compiles the regular expressions of an attack.
+func compileGrep(patterns []string) ([]*regexp.Regexp, error) {
+	res := make([]*regexp.Regexp, len(patterns))
+	for i, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, errors.Errorf("grep %q is not a valid regular expression", pattern)
+		}
+		res[i] = re
+	}
+	return res, nil
+}
+
+// attackSender sends the requests of the attacks and stores them, see
+// RepeaterServer.sendRaw and RepeaterServer.store.
+type attackSender interface {
+	sendRaw(ctx context.Context, raw string, to *target) (*proxyserver.Exchange, error)
+	store(exchange *proxyserver.Exchange) (int64, error)
+}
+
+// attacker runs the attacks, each one as a job that can be paused,
+// resumed and cancelled. The jobs live as long as the server does.
+type attacker struct {
+	conf   config.AttackConfig
+	repo   Repository
+	sender attackSender
+
+	mu   sync.Mutex
+	jobs map[int64]*attackJob
+	wg   sync.WaitGroup
+	// set by Close, no job starts after it
+	closed bool
+}
+
+// attackJob is a running attack. The producer hands out the indexes of
+// the requests while the job is not paused.
+type attackJob struct {
+	attack  *Attack
+	plan    *attackPlan
+	grep    []*regexp.Regexp
+	parent  *RequestResponse
+	to      target
+	ctx     context.Context
+	cancel  context.CancelFunc
+	stopped chan struct{}
+
+	mu sync.Mutex
+	// closed when the job is resumed
+	resumed chan struct{}
+	paused  bool
+	status  string
+	done    int64
+	err     string
+}
+
+func newAttacker(conf *config.AttackConfig, repo Repository, sender attackSender) *attacker {
+	c := *conf
+	if c.MaxConcurrency <= 0 {
+		c.MaxConcurrency = 1
+	}
+	if c.Concurrency <= 0 || c.Concurrency > c.MaxConcurrency {
+		c.Concurrency = c.MaxConcurrency
+	}
+	if c.MaxRequests <= 0 {
+		c.MaxRequests = 1
+	}
+	return &attacker{conf: c, repo: repo, sender: sender, jobs: make(map[int64]*attackJob)}
+}
+
+// errAttack is a mistake of the caller in an attack.
+type errAttack struct {
+	msg string
+}
+
+func (e *errAttack) Error() string {
+	return e.msg
+}
+
+// IsAttackError reports whether err is a mistake in the attack.
+func IsAttackError(err error) bool {
+	_, ok := errors.Cause(err).(*errAttack)
+	return ok
+}
+
+// start checks the attack, stores it and starts sending its requests to
+// the target of parent. The errors IsAttackError reports are the caller's.
+func (a *attacker) start(attack *Attack, parent *RequestResponse) error {
+	if attack.Concurrency == 0 {
+		attack.Concurrency = a.conf.Concurrency
+	}
+	if attack.Concurrency < 0 || attack.Concurrency > a.conf.MaxConcurrency {
+		return &errAttack{errors.Errorf("concurrency should be from 1 to %d", a.conf.MaxConcurrency).Error()}
+	}
+	if attack.Delay < 0 {
+		return &errAttack{"delay should not be negative"}
+	}
+	plan, err := newAttackPlan(attack, &a.conf)
+	if err != nil {
+		return &errAttack{err.Error()}
+	}
+	grep, err := compileGrep(attack.Grep)
+	if err != nil {
+		return &errAttack{err.Error()}
+	}
+	scheme, host, port, ok := parent.Target()
+	if !ok {
+		return &errAttack{"request has no upstream"}
+	}
+	if attack.Grep == nil {
+		attack.Grep = []string{}
+	}
+	attack.SessionID = parent.SessionID
+	id := parent.ID
+	attack.RequestID = &id
+	attack.Scheme, attack.Host, attack.Port = scheme, host, port
+	attack.Status = AttackRunning
+	attack.Total, attack.Done = plan.total, 0
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.closed {
+		return errors.New("attacker is closed")
+	}
+	if err = a.repo.CreateAttack(attack); err != nil {
+		return errors.Wrap(err, "CreateAttack error")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &attackJob{
+		attack:  attack,
+		plan:    plan,
+		grep:    grep,
+		parent:  parent,
+		to:      target{scheme: scheme, host: host, port: port},
+		ctx:     ctx,
+		cancel:  cancel,
+		stopped: make(chan struct{}),
+		status:  AttackRunning,
+	}
+	a.jobs[attack.ID] = job
+	a.wg.Add(1)
+	go a.run(job)
+	return nil
+}
+
+// view sets the status and the progress of a stored attack from its
+// job: an attack running or paused without a job was interrupted.
+func (a *attacker) view(attack *Attack) {
+	a.mu.Lock()
+	job := a.jobs[attack.ID]
+	a.mu.Unlock()
+	if job == nil {
+		if attack.Status == AttackRunning || attack.Status == AttackPaused {
+			attack.Status = AttackInterrupted
+		}
+		return
+	}
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	attack.Status, attack.Done, attack.Error = job.status, job.done, job.err
+}
+
+// pause stops handing out the requests of a running attack, the ones in
+// flight complete. It returns false when the attack is not running.
+func (a *attacker) pause(id int64) bool {
+	return a.control(id, func(job *attackJob) bool {
+		if job.status != AttackRunning {
+			return false
+		}
+		job.status, job.paused = AttackPaused, true
+		job.resumed = make(chan struct{})
+		return true
+	})
+}
+
+// resume continues a paused attack, false when it is not paused.
+func (a *attacker) resume(id int64) bool {
+	return a.control(id, func(job *attackJob) bool {
+		if job.status != AttackPaused {
+			return false
+		}
+		job.status, job.paused = AttackRunning, false
+		close(job.resumed)
+		return true
+	})
+}
+
+// cancel stops a running or paused attack, the requests in flight are
+// aborted. It returns false when the attack is over.
+func (a *attacker) cancel(id int64) bool {
+	a.mu.Lock()
+	job := a.jobs[id]
+	a.mu.Unlock()
+	ok := a.control(id, func(job *attackJob) bool {
+		if job.status != AttackRunning && job.status != AttackPaused {
+			return false
+		}
+		job.status = AttackCancelled
+		job.cancel()
+		return true
+	})
+	if ok {
+		<-job.stopped
+	}
+	return ok
+}
+
+// cancelSession cancels the running and paused attacks of the session.
+func (a *attacker) cancelSession(sessionID int64) {
+	a.mu.Lock()
+	var ids []int64
+	for id, job := range a.jobs {
+		if job.attack.SessionID == sessionID {
+			ids = append(ids, id)
+		}
+	}
+	a.mu.Unlock()
+	for _, id := range ids {
+		a.cancel(id)
+	}
+}
+
+// running reports whether the attack has a job, which has to be
+// cancelled before the attack is deleted.
+func (a *attacker) running(id int64) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.jobs[id] != nil
+}
+
+// control changes the job of the attack by change, false when there is
+// no job or change refuses. The status is saved by the job once it stops.
+func (a *attacker) control(id int64, change func(job *attackJob) bool) bool {
+	a.mu.Lock()
+	job := a.jobs[id]
+	a.mu.Unlock()
+	if job == nil {
+		return false
+	}
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	return change(job)
+}
+
+// close cancels the jobs and waits for them until ctx expires, their
+// attacks are interrupted.
+func (a *attacker) close(ctx context.Context) error {
+	a.mu.Lock()
+	a.closed = true
+	for _, job := range a.jobs {
+		job.cancel()
+	}
+	a.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		a.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// run sends the requests of the job with its concurrency and saves the
+// status the attack ends in.
+func (a *attacker) run(job *attackJob) {
+	defer a.wg.Done()
+	defer close(job.stopped)
+
+	indexes := make(chan int64)
+	var workers sync.WaitGroup
+	var failure error
+	var failureOnce sync.Once
+	for w := 0; w < job.attack.Concurrency; w++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for i := range indexes {
+				if err := a.attackOne(job, i); err != nil {
+					failureOnce.Do(func() {
+						failure = err
+						job.cancel()
+					})
+				}
+				if job.attack.Delay > 0 {
+					select {
+					case <-time.After(time.Duration(job.attack.Delay) * time.Millisecond):
+					case <-job.ctx.Done():
+					}
+				}
+			}
+		}()
+	}
+
+produce:
+	for i := int64(0); i < job.plan.total; i++ {
+		job.mu.Lock()
+		paused, resumed := job.paused, job.resumed
+		job.mu.Unlock()
+		if paused {
+			select {
+			case <-resumed:
+			case <-job.ctx.Done():
+				break produce
+			}
+		}
+		select {
+		case indexes <- i:
+		case <-job.ctx.Done():
+			break produce
+		}
+	}
+	close(indexes)
+	workers.Wait()
+
+	job.mu.Lock()
+	status := job.status
+	switch {
+	case failure != nil:
+		status, job.err = AttackFailed, failure.Error()
+	case status == AttackCancelled:
+	case job.ctx.Err() != nil:
+		// closed with the server, running or paused as it was
+		status = ""
+	default:
+		status = AttackFinished
+	}
+	if status != "" {
+		job.status = status
+	}
+	errText := job.err
+	job.mu.Unlock()
+	job.cancel()
+
+	if status != "" {
+		finishedAt := time.Now()
+		// the job is dropped even when the status is not saved, its
+		// attack shows as interrupted then
+		_ = a.repo.UpdateAttack(job.attack.ID, status, errText, &finishedAt)
+	}
+	a.mu.Lock()
+	delete(a.jobs, job.attack.ID)
+	a.mu.Unlock()
+}
+
+// attackOne sends the request i of the job, stores it and its result.
+// The error is the storage's, which stops the attack.
+func (a *attacker) attackOne(job *attackJob, i int64) error {
+	values, sent, position := job.plan.payloads(i)
+	res := &AttackResult{
+		AttackID:  job.attack.ID,
+		Index:     i,
+		Payloads:  sent,
+		Position:  position,
+		Grep:      make([]bool, len(job.grep)),
+		StartedAt: time.Now(),
+	}
+
+	to := job.to
+	exchange, err := a.sender.sendRaw(job.ctx, job.plan.template.fill(values), &to)
+	if job.ctx.Err() != nil {
+		// cancelled in flight, the request is not counted
+		return nil
+	}
+	if err != nil {
+		res.Error = err.Error()
+	}
+	if exchange != nil {
+		if resp := exchange.Response; resp != nil {
+			r := &Response{Code: resp.Code, Message: resp.Message, Headers: Map(resp.Headers)}
+			head := r.head()
+			res.Status = resp.Code
+			res.Length = int64(len(head) + len(resp.Body))
+			res.Duration = resp.Timing.Total.Microseconds()
+			body, err := decodeBody([]byte(resp.Body), r.header("Content-Encoding"))
+			if err != nil {
+				body = []byte(resp.Body)
+			}
+			text := head + string(body)
+			for j, re := range job.grep {
+				res.Grep[j] = re.MatchString(text)
+			}
+		}
+		exchange.Request.SessionID = job.attack.SessionID
+		exchange.Request.ParentID = job.parent.ID
+		exchange.Request.Source = proxyserver.SourceScanner
+		id, err := a.sender.store(exchange)
+		if id > 0 {
+			res.RequestID = &id
+		}
+		if err != nil && id == 0 {
+			return err
+		}
+	}
+	if err = a.repo.InsertAttackResult(res); err != nil {
+		return errors.Wrap(err, "InsertAttackResult error")
+	}
+	job.mu.Lock()
+	job.done++
+	job.mu.Unlock()
+	return nil
+}
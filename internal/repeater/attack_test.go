@@ -0,0 +1,443 @@
+package repeater
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/iiivan-lemon/technopark_proxy/config"
+	proxyserver "github.com/iiivan-lemon/technopark_proxy/internal/proxyServer"
+	"github.com/pkg/errors"
+)
+
+func TestParseTemplate(t *testing.T) {
+	tmpl, err := parseTemplate("GET /§a§?x=§§ HTTP/1.1\r\nX: §b§\r\n\r\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equalStrings(tmpl.parts, []string{"GET /", "?x=", " HTTP/1.1\r\nX: ", "\r\n\r\n"}) ||
+		!equalStrings(tmpl.defaults, []string{"a", "", "b"}) {
+		t.Errorf("parseTemplate = %q, %q", tmpl.parts, tmpl.defaults)
+	}
+	if got := tmpl.fill([]string{"1", "2", "3"}); got != "GET /1?x=2 HTTP/1.1\r\nX: 3\r\n\r\n" {
+		t.Errorf("fill = %q", got)
+	}
+	if got := tmpl.fill(tmpl.defaults); got != "GET /a?x= HTTP/1.1\r\nX: b\r\n\r\n" {
+		t.Errorf("fill with the defaults = %q", got)
+	}
+
+	for _, text := range []string{"", "GET / HTTP/1.1", "GET /§a HTTP/1.1", "§a§b§"} {
+		if _, err := parseTemplate(text); err == nil {
+			t.Errorf("parseTemplate(%q) returned no error", text)
+		}
+	}
+}
+
+func list(values ...string) PayloadSet {
+	return PayloadSet{Type: "list", Values: values}
+}
+
+func TestAttackPlan(t *testing.T) {
+	type request struct {
+		values, sent []string
+		position     int
+	}
+	tests := []struct {
+		kind     string
+		template string
+		sets     []PayloadSet
+		want     []request
+	}{
+		{"sniper", "§a§-§b§", []PayloadSet{list("1", "2")}, []request{
+			{[]string{"1", "b"}, []string{"1"}, 0},
+			{[]string{"2", "b"}, []string{"2"}, 0},
+			{[]string{"a", "1"}, []string{"1"}, 1},
+			{[]string{"a", "2"}, []string{"2"}, 1},
+		}},
+		{"battering-ram", "§a§-§b§", []PayloadSet{list("1", "2")}, []request{
+			{[]string{"1", "1"}, []string{"1"}, -1},
+			{[]string{"2", "2"}, []string{"2"}, -1},
+		}},
+		{"pitchfork", "§a§-§b§", []PayloadSet{list("1", "2", "3"), list("x", "y")}, []request{
+			{[]string{"1", "x"}, []string{"1", "x"}, -1},
+			{[]string{"2", "y"}, []string{"2", "y"}, -1},
+		}},
+		{"cluster-bomb", "§a§-§b§", []PayloadSet{list("1", "2"), {Type: "numbers", From: 7, To: 9}}, []request{
+			{[]string{"1", "7"}, []string{"1", "7"}, -1},
+			{[]string{"1", "8"}, []string{"1", "8"}, -1},
+			{[]string{"1", "9"}, []string{"1", "9"}, -1},
+			{[]string{"2", "7"}, []string{"2", "7"}, -1},
+			{[]string{"2", "8"}, []string{"2", "8"}, -1},
+			{[]string{"2", "9"}, []string{"2", "9"}, -1},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.kind, func(t *testing.T) {
+			plan, err := newAttackPlan(&Attack{Type: tt.kind, Template: tt.template, Payloads: tt.sets}, &config.AttackConfig{MaxRequests: 100})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if plan.total != int64(len(tt.want)) {
+				t.Fatalf("total = %d, want %d", plan.total, len(tt.want))
+			}
+			for i, want := range tt.want {
+				values, sent, position := plan.payloads(int64(i))
+				got := -1
+				if position != nil {
+					got = *position
+				}
+				if !equalStrings(values, want.values) || !equalStrings(sent, want.sent) || got != want.position {
+					t.Errorf("request %d: payloads = %q, %q, %d, want %q, %q, %d", i, values, sent, got, want.values, want.sent, want.position)
+				}
+			}
+		})
+	}
+}
+
+func TestAttackPlanErrors(t *testing.T) {
+	three := list("1", "2", "3")
+	tests := []struct {
+		name   string
+		attack Attack
+	}{
+		{"unknown type", Attack{Type: "shotgun", Template: "§a§", Payloads: []PayloadSet{three}}},
+		{"no positions", Attack{Type: "sniper", Template: "GET /", Payloads: []PayloadSet{three}}},
+		{"sniper with two sets", Attack{Type: "sniper", Template: "§a§", Payloads: []PayloadSet{three, three}}},
+		{"battering-ram without a set", Attack{Type: "battering-ram", Template: "§a§"}},
+		{"pitchfork short of a set", Attack{Type: "pitchfork", Template: "§a§§b§", Payloads: []PayloadSet{three}}},
+		{"cluster-bomb with a set too many", Attack{Type: "cluster-bomb", Template: "§a§", Payloads: []PayloadSet{three, three}}},
+		{"bad set", Attack{Type: "sniper", Template: "§a§", Payloads: []PayloadSet{list()}}},
+		// 3 positions of 3 payloads
+		{"sniper over the limit", Attack{Type: "sniper", Template: "§a§§b§§c§", Payloads: []PayloadSet{three}}},
+		{"cluster-bomb over the limit", Attack{Type: "cluster-bomb", Template: "§a§§b§", Payloads: []PayloadSet{three, three}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := newAttackPlan(&tt.attack, &config.AttackConfig{MaxRequests: 8}); err == nil {
+				t.Error("newAttackPlan returned no error")
+			}
+		})
+	}
+}
+
+// attackRepository keeps the attacks and their results, the rest of
+// Repository is not used by the attacker.
+type attackRepository struct {
+	Repository
+
+	mu       sync.Mutex
+	lastID   int64
+	statuses map[int64]string
+	errors   map[int64]string
+	results  map[int64][]AttackResult
+	// returned by InsertAttackResult
+	failure error
+}
+
+func newAttackRepository() *attackRepository {
+	return &attackRepository{
+		statuses: make(map[int64]string),
+		errors:   make(map[int64]string),
+		results:  make(map[int64][]AttackResult),
+	}
+}
+
+func (r *attackRepository) CreateAttack(attack *Attack) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lastID++
+	attack.ID, attack.CreatedAt = r.lastID, time.Now()
+	r.statuses[attack.ID] = attack.Status
+	return nil
+}
+
+func (r *attackRepository) UpdateAttack(id int64, status, errText string, finishedAt *time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.statuses[id], r.errors[id] = status, errText
+	return nil
+}
+
+func (r *attackRepository) InsertAttackResult(res *AttackResult) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.failure != nil {
+		return r.failure
+	}
+	r.results[res.AttackID] = append(r.results[res.AttackID], *res)
+	return nil
+}
+
+func (r *attackRepository) status(id int64) (string, string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.statuses[id], r.errors[id]
+}
+
+func (r *attackRepository) resultsOf(id int64) []AttackResult {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]AttackResult(nil), r.results[id]...)
+}
+
+// stubSender answers every request with 200 and its own text. When
+// gated, a request waits for a value of the gate or its cancellation.
+type stubSender struct {
+	gate chan struct{}
+
+	mu     sync.Mutex
+	sent   []string
+	stored int64
+}
+
+func (s *stubSender) sendRaw(ctx context.Context, raw string, to *target) (*proxyserver.Exchange, error) {
+	s.mu.Lock()
+	s.sent = append(s.sent, raw)
+	s.mu.Unlock()
+	if s.gate != nil {
+		select {
+		case <-s.gate:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return &proxyserver.Exchange{
+		Request: &proxyserver.Request{Raw: raw, Host: to.host},
+		Response: &proxyserver.Response{
+			Code:    200,
+			Message: "200 OK",
+			Headers: proxyserver.Map{"Content-Type": "text/plain"},
+			Body:    "echo " + raw,
+			Timing:  proxyserver.Timing{Total: time.Millisecond},
+		},
+	}, nil
+}
+
+func (s *stubSender) store(exchange *proxyserver.Exchange) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stored++
+	return s.stored, nil
+}
+
+func (s *stubSender) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.sent)
+}
+
+func newTestAttacker(sender *stubSender) (*attacker, *attackRepository) {
+	repo := newAttackRepository()
+	return newAttacker(&config.AttackConfig{MaxConcurrency: 4, MaxRequests: 100}, repo, sender), repo
+}
+
+var attackParent = &RequestResponse{ID: 5, SessionID: 2, Request: Request{Scheme: "http", Host: "example.com", Port: 80}}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// viewed returns the status and the progress of the attack as the API
+// shows them.
+func viewed(a *attacker, attack *Attack) (string, int64) {
+	view := *attack
+	a.view(&view)
+	return view.Status, view.Done
+}
+
+func TestAttackRun(t *testing.T) {
+	sender := &stubSender{}
+	a, repo := newTestAttacker(sender)
+	attack := &Attack{Type: "sniper", Template: "GET /§a§?q=§b§", Payloads: []PayloadSet{list("1", "22")}, Concurrency: 3, Grep: []string{"q=22", "nowhere"}}
+	if err := a.start(attack, attackParent); err != nil {
+		t.Fatal(err)
+	}
+	if attack.Total != 4 || attack.SessionID != 2 || *attack.RequestID != 5 || attack.Host != "example.com" {
+		t.Errorf("started attack = %+v", attack)
+	}
+	waitFor(t, "the attack", func() bool { return !a.running(attack.ID) })
+	if status, errText := repo.status(attack.ID); status != AttackFinished || errText != "" {
+		t.Errorf("attack ended %s %q, want finished", status, errText)
+	}
+	stored := *attack
+	stored.Status, _ = repo.status(attack.ID)
+	if status, _ := viewed(a, &stored); status != AttackFinished {
+		t.Errorf("finished attack shows as %s", status)
+	}
+
+	results := repo.resultsOf(attack.ID)
+	if len(results) != 4 {
+		t.Fatalf("%d results, want 4", len(results))
+	}
+	byIndex := make(map[int64]AttackResult)
+	for _, res := range results {
+		byIndex[res.Index] = res
+	}
+	want := []struct {
+		payload  string
+		position int
+		grep     bool
+	}{{"1", 0, false}, {"22", 0, false}, {"1", 1, false}, {"22", 1, true}}
+	for i, w := range want {
+		res, ok := byIndex[int64(i)]
+		if !ok {
+			t.Fatalf("no result %d", i)
+		}
+		if !equalStrings(res.Payloads, []string{w.payload}) || res.Position == nil || *res.Position != w.position ||
+			res.Status != 200 || res.Duration != 1000 || res.RequestID == nil || res.Grep[0] != w.grep || res.Grep[1] {
+			t.Errorf("result %d = %+v", i, res)
+		}
+	}
+	sender.mu.Lock()
+	defer sender.mu.Unlock()
+	if got := strings.Join(sender.sent, "\n"); !strings.Contains(got, "GET /22?q=b") || !strings.Contains(got, "GET /a?q=1") {
+		t.Errorf("sent %q", sender.sent)
+	}
+}
+
+func TestAttackControl(t *testing.T) {
+	sender := &stubSender{gate: make(chan struct{})}
+	a, repo := newTestAttacker(sender)
+	attack := &Attack{Type: "battering-ram", Template: "GET /§a§", Payloads: []PayloadSet{{Type: "numbers", From: 1, To: 6}}, Concurrency: 1}
+	if err := a.start(attack, attackParent); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the first request", func() bool { return sender.count() == 1 })
+
+	if a.resume(attack.ID) {
+		t.Error("resumed a running attack")
+	}
+	if !a.pause(attack.ID) {
+		t.Fatal("could not pause a running attack")
+	}
+	if a.pause(attack.ID) {
+		t.Error("paused a paused attack")
+	}
+	if status, _ := viewed(a, attack); status != AttackPaused {
+		t.Errorf("paused attack shows as %s", status)
+	}
+	// the request in flight completes, and the one handed out before the
+	// pause; no other is sent
+	sender.gate <- struct{}{}
+	sender.gate <- struct{}{}
+	select {
+	case sender.gate <- struct{}{}:
+		t.Fatal("a paused attack sent a request")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if status, done := viewed(a, attack); status != AttackPaused || done != 2 {
+		t.Errorf("paused attack shows as %s with %d done, want 2", status, done)
+	}
+
+	if !a.resume(attack.ID) {
+		t.Fatal("could not resume a paused attack")
+	}
+	for i := 0; i < 4; i++ {
+		sender.gate <- struct{}{}
+	}
+	waitFor(t, "the attack", func() bool { return !a.running(attack.ID) })
+	if status, _ := repo.status(attack.ID); status != AttackFinished || len(repo.resultsOf(attack.ID)) != 6 {
+		t.Errorf("attack ended %s with %d results, want finished with 6", status, len(repo.resultsOf(attack.ID)))
+	}
+	if a.pause(attack.ID) || a.resume(attack.ID) || a.cancel(attack.ID) {
+		t.Error("controlled a finished attack")
+	}
+}
+
+func TestAttackCancel(t *testing.T) {
+	for _, paused := range []bool{false, true} {
+		sender := &stubSender{gate: make(chan struct{})}
+		a, repo := newTestAttacker(sender)
+		attack := &Attack{Type: "battering-ram", Template: "§a§", Payloads: []PayloadSet{list("1", "2", "3")}, Concurrency: 2}
+		if err := a.start(attack, attackParent); err != nil {
+			t.Fatal(err)
+		}
+		waitFor(t, "the requests", func() bool { return sender.count() == 2 })
+		if paused && !a.pause(attack.ID) {
+			t.Fatal("could not pause a running attack")
+		}
+		// cancel returns once the job is over, the requests in flight are
+		// aborted and not counted
+		if !a.cancel(attack.ID) {
+			t.Fatal("could not cancel the attack")
+		}
+		if a.running(attack.ID) {
+			t.Error("cancelled attack still has a job")
+		}
+		if status, _ := repo.status(attack.ID); status != AttackCancelled || len(repo.resultsOf(attack.ID)) != 0 {
+			t.Errorf("attack paused %v ended %s with %d results, want cancelled with none", paused, status, len(repo.resultsOf(attack.ID)))
+		}
+		if a.cancel(attack.ID) || a.resume(attack.ID) {
+			t.Error("controlled a cancelled attack")
+		}
+	}
+}
+
+func TestAttackStorageFailure(t *testing.T) {
+	a, repo := newTestAttacker(&stubSender{})
+	repo.failure = errors.New("disk full")
+	attack := &Attack{Type: "battering-ram", Template: "§a§", Payloads: []PayloadSet{list("1", "2", "3")}, Concurrency: 2}
+	if err := a.start(attack, attackParent); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the attack", func() bool { return !a.running(attack.ID) })
+	if status, errText := repo.status(attack.ID); status != AttackFailed || !strings.Contains(errText, "disk full") {
+		t.Errorf("attack ended %s %q, want failed", status, errText)
+	}
+}
+
+func TestAttackerClose(t *testing.T) {
+	sender := &stubSender{gate: make(chan struct{})}
+	a, repo := newTestAttacker(sender)
+	attack := &Attack{Type: "battering-ram", Template: "§a§", Payloads: []PayloadSet{list("1", "2")}}
+	if err := a.start(attack, attackParent); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the request", func() bool { return sender.count() > 0 })
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := a.close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// the status stays running, the attack shows as interrupted
+	if status, _ := repo.status(attack.ID); status != AttackRunning {
+		t.Errorf("closed attack saved as %s", status)
+	}
+	if status, _ := viewed(a, attack); status != AttackInterrupted {
+		t.Errorf("closed attack shows as %s", status)
+	}
+	if err := a.start(&Attack{Type: "battering-ram", Template: "§a§", Payloads: []PayloadSet{list("1")}}, attackParent); err == nil || IsAttackError(err) {
+		t.Errorf("start after close = %v", err)
+	}
+}
+
+func TestAttackStartErrors(t *testing.T) {
+	a, _ := newTestAttacker(&stubSender{})
+	set := []PayloadSet{list("1")}
+	tests := []struct {
+		name   string
+		attack Attack
+		parent *RequestResponse
+	}{
+		{"concurrency over the limit", Attack{Type: "sniper", Template: "§a§", Payloads: set, Concurrency: 5}, attackParent},
+		{"negative delay", Attack{Type: "sniper", Template: "§a§", Payloads: set, Delay: -1}, attackParent},
+		{"bad plan", Attack{Type: "sniper", Template: "a", Payloads: set}, attackParent},
+		{"bad grep", Attack{Type: "sniper", Template: "§a§", Payloads: set, Grep: []string{"("}}, attackParent},
+		{"no upstream", Attack{Type: "sniper", Template: "§a§", Payloads: set}, &RequestResponse{ID: 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := a.start(&tt.attack, tt.parent); !IsAttackError(err) {
+				t.Errorf("start = %v, want a mistake in the attack", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,800 @@
+package repeater
+
+import (
+	"bytes"
+	"encoding/base64"
+	"mime/multipart"
+	"net/textproto"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	proxyserver "github.com/iiivan-lemon/technopark_proxy/internal/proxyServer"
+	"github.com/pkg/errors"
+)
+
+// curlShort maps the short options of curl to the long ones.
+var curlShort = map[byte]string{
+	'X': "request", 'H': "header", 'd': "data", 'F': "form", 'b': "cookie", 'A': "user-agent",
+	'e': "referer", 'u': "user", 'T': "upload-file", 'r': "range", 'G': "get", 'I': "head",
+	'o': "output", 'm': "max-time", 'x': "proxy", 'w': "write-out", 'c': "cookie-jar",
+	'D': "dump-header", 'E': "cert", 'U': "proxy-user", 'K': "config", 'Y': "speed-limit",
+	'y': "speed-time", 'z': "time-cond", 'C': "continue-at",
+	's': "silent", 'S': "show-error", 'L': "location", 'k': "insecure", 'v': "verbose",
+	'i': "include", 'f': "fail", 'N': "no-buffer", 'O': "remote-name", 'J': "remote-header-name",
+	'n': "netrc", 'q': "disable", 'g': "globoff", 'Z': "parallel", '#': "progress-bar",
+	'4': "ipv4", '6': "ipv6", '0': "http1.0", 'j': "junk-session-cookies", 'R': "remote-time",
+	'p': "proxytunnel",
+}
+
+// curlWithArg are the long options taking an argument, the ones that
+// make no difference to the request are ignored.
+var curlWithArg = map[string]bool{
+	"request": true, "header": true, "data": true, "data-ascii": true, "data-binary": true,
+	"data-raw": true, "data-urlencode": true, "json": true, "form": true, "form-string": true,
+	"cookie": true, "user-agent": true, "referer": true, "user": true, "url": true,
+	"upload-file": true, "range": true, "oauth2-bearer": true, "url-query": true,
+
+	"output": true, "max-time": true, "connect-timeout": true, "retry": true, "retry-delay": true,
+	"retry-max-time": true, "proxy": true, "proxy-user": true, "proxy-header": true, "write-out": true,
+	"resolve": true, "connect-to": true, "cacert": true, "capath": true, "cert": true, "key": true,
+	"cert-type": true, "key-type": true, "pass": true, "cookie-jar": true, "dump-header": true,
+	"limit-rate": true, "max-redirs": true, "max-filesize": true, "interface": true,
+	"local-port": true, "speed-limit": true, "speed-time": true, "expect100-timeout": true,
+	"keepalive-time": true, "dns-servers": true, "trace": true, "trace-ascii": true,
+	"stderr": true, "config": true, "ciphers": true, "tls-max": true, "time-cond": true,
+	"continue-at": true, "unix-socket": true, "abstract-unix-socket": true, "output-dir": true,
+	"happy-eyeballs-timeout-ms": true, "pinnedpubkey": true, "crlfile": true,
+}
+
+// curlFlags are the options without an argument making no difference
+// to the request, or handled apart: get, head and compressed.
+var curlFlags = map[string]bool{
+	"get": true, "head": true, "compressed": true,
+
+	"silent": true, "show-error": true, "location": true, "location-trusted": true,
+	"insecure": true, "verbose": true, "include": true, "fail": true, "fail-with-body": true,
+	"fail-early": true, "no-buffer": true, "remote-name": true, "remote-header-name": true,
+	"remote-name-all": true, "netrc": true, "netrc-optional": true, "globoff": true,
+	"parallel": true, "progress-bar": true, "no-progress-meter": true, "ipv4": true, "ipv6": true,
+	"http0.9": true, "http1.0": true, "http1.1": true, "http2": true, "http2-prior-knowledge": true,
+	"http3": true, "http3-only": true, "tlsv1": true, "tlsv1.0": true, "tlsv1.1": true,
+	"tlsv1.2": true, "tlsv1.3": true, "sslv2": true, "sslv3": true, "ssl": true, "ssl-reqd": true,
+	"ssl-no-revoke": true, "ssl-revoke-best-effort": true, "path-as-is": true, "raw": true,
+	"tr-encoding": true, "create-dirs": true, "disable": true, "keepalive": true, "sessionid": true,
+	"alpn": true, "npn": true, "tcp-nodelay": true, "tcp-fastopen": true, "styled-output": true,
+	"remote-time": true, "junk-session-cookies": true, "proxytunnel": true, "digest": true,
+	"basic": true, "ntlm": true, "negotiate": true, "anyauth": true, "post301": true,
+	"post302": true, "post303": true, "false-start": true, "xattr": true, "buffer": true,
+	"progress-meter": true, "cert-status": true, "proxy-insecure": true, "suppress-connect-headers": true,
+}
+
+// curlCommand is what a curl command line says about the requests it sends.
+type curlCommand struct {
+	method string
+	urls   []string
+	// the -H headers in their order, nil value for the removed ones
+	headers   [][2]*string
+	data      []string
+	json      bool
+	form      []curlFormPart
+	upload    []byte
+	hasUpload bool
+	get, head bool
+	userAgent *string
+	referer   *string
+	user      *string
+	bearer    *string
+	byteRange *string
+	cookies   []string
+	compress  bool
+	query     []string
+}
+
+type curlFormPart struct {
+	name, value     string
+	filename, ctype string
+	file            bool
+}
+
+// ParseCurl reads the curl commands of a shell script, a request for
+// every URL of each of them. The commands other than curl are left out,
+// except printf and echo piped into curl, which give it its stdin.
+func ParseCurl(script string, opts *ImportOptions) []Imported {
+	var items []Imported
+	for _, cmd := range splitShell(script) {
+		words := cmd.words
+		for len(words) > 0 && (words[0] == "$" || strings.Contains(words[0], "=") && !strings.HasPrefix(words[0], "-")) {
+			// a prompt and the variables of the environment
+			words = words[1:]
+		}
+		if len(words) == 0 || path.Base(words[0]) != "curl" && path.Base(words[0]) != "curl.exe" {
+			if cmd.err != nil {
+				items = append(items, Imported{Err: cmd.err})
+			}
+			continue
+		}
+		if cmd.err != nil {
+			items = append(items, Imported{Err: cmd.err})
+			continue
+		}
+		reader := &curlReader{opts: opts, stdin: cmd.stdin}
+		c, err := reader.parse(words[1:])
+		if err != nil {
+			items = append(items, Imported{Err: err})
+			continue
+		}
+		for _, rawURL := range c.urls {
+			req, err := c.request(rawURL)
+			if err != nil {
+				items = append(items, Imported{Err: err})
+				continue
+			}
+			items = append(items, Imported{Exchange: &proxyserver.Exchange{Request: req}})
+		}
+	}
+	return items
+}
+
+// curlReader reads the options of a command and the files they refer to.
+type curlReader struct {
+	opts  *ImportOptions
+	stdin *[]byte
+}
+
+func (r *curlReader) readFile(name string) ([]byte, error) {
+	if name == "-" {
+		if r.stdin == nil {
+			return nil, errors.New("stdin is read only from printf or echo piped into curl")
+		}
+		return *r.stdin, nil
+	}
+	return r.opts.readFile(name)
+}
+
+func (r *curlReader) parse(args []string) (*curlCommand, error) {
+	c := &curlCommand{}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			c.urls = append(c.urls, args[i+1:]...)
+			break
+		}
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			c.urls = append(c.urls, arg)
+			continue
+		}
+
+		var options []string
+		value, hasValue := "", false
+		if strings.HasPrefix(arg, "--") {
+			options = []string{strings.TrimPrefix(arg, "--")}
+		} else {
+			// -sSL, -XPOST and -H'...' in one word
+			for j := 1; j < len(arg); j++ {
+				long, ok := curlShort[arg[j]]
+				if !ok {
+					return nil, errors.Errorf("unsupported curl option -%c", arg[j])
+				}
+				options = append(options, long)
+				if curlWithArg[long] && j+1 < len(arg) {
+					value, hasValue = arg[j+1:], true
+					break
+				}
+			}
+		}
+		for _, name := range options {
+			if !curlWithArg[name] {
+				if err := c.flag(name); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			if !hasValue {
+				if i+1 >= len(args) {
+					return nil, errors.Errorf("curl option --%s needs a value", name)
+				}
+				i++
+				value = args[i]
+			}
+			if err := r.option(c, name, value); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if len(c.urls) == 0 {
+		return nil, errors.New("curl command has no URL")
+	}
+	if len(c.form) > 0 && (len(c.data) > 0 || c.hasUpload) || len(c.data) > 0 && c.hasUpload && !c.get {
+		return nil, errors.New("curl command combines bodies of -d, -F and -T")
+	}
+	return c, nil
+}
+
+func (c *curlCommand) flag(name string) error {
+	negated := strings.TrimPrefix(name, "no-")
+	switch {
+	case name == "get":
+		c.get = true
+	case name == "head":
+		c.head = true
+	case name == "compressed":
+		c.compress = true
+	case curlFlags[name], negated != name && curlFlags[negated]:
+	default:
+		return errors.Errorf("unsupported curl option --%s", name)
+	}
+	return nil
+}
+
+func (r *curlReader) option(c *curlCommand, name, value string) error {
+	switch name {
+	case "request":
+		c.method = value
+	case "header":
+		if strings.HasPrefix(value, "@") {
+			data, err := r.readFile(value[1:])
+			if err != nil {
+				return err
+			}
+			for _, line := range strings.Split(string(data), "\n") {
+				if line = strings.TrimSpace(line); line != "" {
+					c.header(line)
+				}
+			}
+			return nil
+		}
+		c.header(value)
+	case "data", "data-ascii", "data-binary", "data-raw", "json":
+		if name != "data-raw" && strings.HasPrefix(value, "@") {
+			data, err := r.readFile(value[1:])
+			if err != nil {
+				return err
+			}
+			if name == "data" || name == "data-ascii" {
+				data = bytes.ReplaceAll(bytes.ReplaceAll(data, []byte("\r"), nil), []byte("\n"), nil)
+			}
+			value = string(data)
+		}
+		c.data = append(c.data, value)
+		c.json = c.json || name == "json"
+	case "data-urlencode", "url-query":
+		encoded, err := r.urlencode(value)
+		if err != nil {
+			return err
+		}
+		if name == "url-query" {
+			c.query = append(c.query, encoded)
+		} else {
+			c.data = append(c.data, encoded)
+		}
+	case "form", "form-string":
+		part, err := r.formPart(value, name == "form")
+		if err != nil {
+			return err
+		}
+		c.form = append(c.form, part)
+	case "cookie":
+		// a cookie jar is left out, curl reads it only when there is no =
+		if strings.Contains(value, "=") {
+			c.cookies = append(c.cookies, value)
+		}
+	case "user-agent":
+		c.userAgent = &value
+	case "referer":
+		value = strings.TrimSuffix(value, ";auto")
+		c.referer = &value
+	case "user":
+		c.user = &value
+	case "oauth2-bearer":
+		c.bearer = &value
+	case "range":
+		c.byteRange = &value
+	case "url":
+		c.urls = append(c.urls, value)
+	case "upload-file":
+		data, err := r.readFile(value)
+		if err != nil {
+			return err
+		}
+		c.upload, c.hasUpload = data, true
+	}
+	return nil
+}
+
+// header adds -H "Name: value"; "Name;" is an empty header and "Name:"
+// removes the header curl would send.
+func (c *curlCommand) header(line string) {
+	if i := strings.IndexByte(line, ':'); i >= 0 {
+		name, value := strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:])
+		if value == "" {
+			c.headers = append(c.headers, [2]*string{&name, nil})
+			return
+		}
+		c.headers = append(c.headers, [2]*string{&name, &value})
+		return
+	}
+	if name := strings.TrimSuffix(line, ";"); name != line {
+		empty := ""
+		c.headers = append(c.headers, [2]*string{&name, &empty})
+	}
+}
+
+// urlencode reads --data-urlencode: content, =content, name=content,
+// @file or name@file, with the content percent-encoded.
+func (r *curlReader) urlencode(value string) (string, error) {
+	sep := strings.IndexByte(value, '=')
+	if sep < 0 {
+		sep = strings.IndexByte(value, '@')
+	}
+	if sep < 0 {
+		return curlEscape(value), nil
+	}
+	name, content := value[:sep], value[sep+1:]
+	if value[sep] == '@' {
+		data, err := r.readFile(content)
+		if err != nil {
+			return "", err
+		}
+		content = string(data)
+	}
+	if name == "" {
+		return curlEscape(content), nil
+	}
+	return name + "=" + curlEscape(content), nil
+}
+
+// formPart reads -F name=value, name=@file and name=<file, each with
+// ;type= and ;filename=, --form-string takes the value as it is.
+func (r *curlReader) formPart(value string, files bool) (curlFormPart, error) {
+	i := strings.IndexByte(value, '=')
+	if i <= 0 {
+		return curlFormPart{}, errors.Errorf("curl form part %q should be name=value", value)
+	}
+	part := curlFormPart{name: value[:i], value: value[i+1:]}
+	if !files {
+		return part, nil
+	}
+	if strings.HasPrefix(part.value, "@") || strings.HasPrefix(part.value, "<") {
+		params := strings.Split(part.value[1:], ";")
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			switch {
+			case strings.HasPrefix(param, "type="):
+				part.ctype = strings.TrimPrefix(param, "type=")
+			case strings.HasPrefix(param, "filename="):
+				part.filename = strings.Trim(strings.TrimPrefix(param, "filename="), `"`)
+			}
+		}
+		data, err := r.readFile(params[0])
+		if err != nil {
+			return curlFormPart{}, err
+		}
+		if part.value[0] == '@' {
+			part.file = true
+			if part.filename == "" {
+				part.filename = path.Base(params[0])
+			}
+		}
+		part.value = string(data)
+	} else if j := strings.Index(part.value, ";type="); j >= 0 {
+		part.value, part.ctype = part.value[:j], part.value[j+len(";type="):]
+	}
+	return part, nil
+}
+
+// request returns the request the command sends to rawURL, with the
+// headers curl sends by itself and the body of its data or form.
+func (c *curlCommand) request(rawURL string) (*proxyserver.Request, error) {
+	if !strings.Contains(rawURL, "://") {
+		rawURL = "http://" + rawURL
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, errors.Errorf("bad curl URL %q", rawURL)
+	}
+	query := append([]string{}, c.query...)
+	var body []byte
+	var contentType string
+	switch {
+	case len(c.form) > 0:
+		if body, contentType, err = c.multipart(); err != nil {
+			return nil, err
+		}
+	case len(c.data) > 0 && c.get:
+		query = append(query, c.data...)
+	case len(c.data) > 0:
+		sep := "&"
+		if c.json {
+			sep = ""
+		}
+		body = []byte(strings.Join(c.data, sep))
+		contentType = "application/x-www-form-urlencoded"
+		if c.json {
+			contentType = "application/json"
+		}
+	case c.hasUpload:
+		body = c.upload
+	}
+	if len(query) > 0 {
+		if u.RawQuery != "" {
+			u.RawQuery += "&"
+		}
+		u.RawQuery += strings.Join(query, "&")
+	}
+
+	method := "GET"
+	switch {
+	case c.method != "":
+		method = c.method
+	case c.head:
+		method = "HEAD"
+	case c.hasUpload:
+		method = "PUT"
+	case body != nil && !c.get:
+		method = "POST"
+	}
+
+	var headers [][2]string
+	if c.user != nil {
+		headers = append(headers, [2]string{"Authorization", "Basic " + base64.StdEncoding.EncodeToString([]byte(*c.user))})
+	} else if c.bearer != nil {
+		headers = append(headers, [2]string{"Authorization", "Bearer " + *c.bearer})
+	}
+	if c.byteRange != nil {
+		headers = append(headers, [2]string{"Range", "bytes=" + *c.byteRange})
+	}
+	if c.userAgent != nil {
+		headers = append(headers, [2]string{"User-Agent", *c.userAgent})
+	}
+	headers = append(headers, [2]string{"Accept", "*/*"})
+	if c.json {
+		headers = setHeader(headers, "Accept", "application/json")
+	}
+	if c.referer != nil {
+		headers = append(headers, [2]string{"Referer", *c.referer})
+	}
+	if len(c.cookies) > 0 {
+		headers = append(headers, [2]string{"Cookie", strings.Join(c.cookies, "; ")})
+	}
+	if c.compress {
+		headers = append(headers, [2]string{"Accept-Encoding", "deflate, gzip"})
+	}
+	if contentType != "" {
+		headers = append(headers, [2]string{"Content-Type", contentType})
+	}
+
+	// -H replaces what curl would send and adds the rest after it
+	var custom [][2]string
+	for _, h := range c.headers {
+		name := *h[0]
+		if _, ok := lastHeader(headers, name); ok {
+			kept := headers[:0]
+			for _, sent := range headers {
+				if !strings.EqualFold(sent[0], name) {
+					kept = append(kept, sent)
+				}
+			}
+			headers = kept
+		}
+		if h[1] != nil {
+			custom = append(custom, [2]string{name, *h[1]})
+		}
+	}
+	return importedRequest(method, u, append(headers, custom...), body)
+}
+
+func (c *curlCommand) multipart() ([]byte, string, error) {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	for _, part := range c.form {
+		h := textproto.MIMEHeader{}
+		disposition := `form-data; name="` + escapeQuotes(part.name) + `"`
+		if part.file {
+			disposition += `; filename="` + escapeQuotes(part.filename) + `"`
+			if part.ctype == "" {
+				part.ctype = "application/octet-stream"
+			}
+		}
+		h.Set("Content-Disposition", disposition)
+		if part.ctype != "" {
+			h.Set("Content-Type", part.ctype)
+		}
+		pw, err := w.CreatePart(h)
+		if err != nil {
+			return nil, "", err
+		}
+		if _, err = pw.Write([]byte(part.value)); err != nil {
+			return nil, "", err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return nil, "", err
+	}
+	return body.Bytes(), w.FormDataContentType(), nil
+}
+
+func escapeQuotes(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s)
+}
+
+// curlEscape percent-encodes everything but the unreserved characters,
+// as --data-urlencode does.
+func curlEscape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || strings.IndexByte("-._~", c) >= 0 {
+			b.WriteByte(c)
+			continue
+		}
+		b.WriteString("%" + strings.ToUpper(strconv.FormatUint(uint64(c)|0x100, 16)[1:]))
+	}
+	return b.String()
+}
+
+// shellCommand is a command of a script split into words, stdin is the
+// output of printf or echo piped into it.
+type shellCommand struct {
+	words []string
+	stdin *[]byte
+	err   error
+}
+
+// splitShell splits a POSIX shell script into commands at newlines, ;,
+// &&, || and |. It knows quotes, $'...' and backslashes, not expansions.
+// A script that cannot be split ends with a command carrying the error.
+func splitShell(s string) []shellCommand {
+	var (
+		cmds  []shellCommand
+		cur   shellCommand
+		word  strings.Builder
+		piped *shellCommand
+	)
+	inWord := false
+	flush := func() {
+		if inWord {
+			cur.words = append(cur.words, word.String())
+		}
+		word.Reset()
+		inWord = false
+	}
+	end := func(pipe bool) {
+		flush()
+		if len(cur.words) > 0 {
+			if piped != nil {
+				cur.stdin = pipedOutput(piped.words)
+			}
+			cmds = append(cmds, cur)
+		}
+		piped = nil
+		if pipe && len(cur.words) > 0 {
+			last := cmds[len(cmds)-1]
+			piped = &last
+		}
+		cur = shellCommand{}
+	}
+
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == '\\':
+			switch {
+			case strings.HasPrefix(s[i+1:], "\n"):
+				i += 2
+			case strings.HasPrefix(s[i+1:], "\r\n"):
+				i += 3
+			case i+1 < len(s):
+				word.WriteByte(s[i+1])
+				inWord = true
+				i += 2
+			default:
+				i++
+			}
+		case c == '\'':
+			j := strings.IndexByte(s[i+1:], '\'')
+			if j < 0 {
+				cur.err = errors.New("unterminated ' in the command")
+				return append(cmds, cur)
+			}
+			word.WriteString(s[i+1 : i+1+j])
+			inWord = true
+			i += j + 2
+		case c == '$' && strings.HasPrefix(s[i+1:], "'"):
+			n, err := ansiQuoted(s[i+2:], &word)
+			if err != nil {
+				cur.err = err
+				return append(cmds, cur)
+			}
+			inWord = true
+			i += n + 2
+		case c == '"':
+			n, err := doubleQuoted(s[i+1:], &word)
+			if err != nil {
+				cur.err = err
+				return append(cmds, cur)
+			}
+			inWord = true
+			i += n + 1
+		case c == ' ' || c == '\t' || c == '\r':
+			flush()
+			i++
+		case c == '\n' || c == ';':
+			end(false)
+			i++
+		case strings.HasPrefix(s[i:], "&&") || strings.HasPrefix(s[i:], "||"):
+			end(false)
+			i += 2
+		case c == '|':
+			end(true)
+			i++
+		case c == '#' && !inWord:
+			if j := strings.IndexByte(s[i:], '\n'); j >= 0 {
+				i += j
+			} else {
+				i = len(s)
+			}
+		default:
+			word.WriteByte(c)
+			inWord = true
+			i++
+		}
+	}
+	end(false)
+	return cmds
+}
+
+// doubleQuoted reads a "..." string up to its closing quote, which is
+// counted in the returned length.
+func doubleQuoted(s string, word *strings.Builder) (int, error) {
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"':
+			return i + 1, nil
+		case c == '\\' && i+1 < len(s) && strings.IndexByte("\\\"$`\n", s[i+1]) >= 0:
+			if s[i+1] != '\n' {
+				word.WriteByte(s[i+1])
+			}
+			i++
+		default:
+			word.WriteByte(c)
+		}
+	}
+	return 0, errors.New(`unterminated " in the command`)
+}
+
+// ansiQuoted reads a $'...' string after $', as doubleQuoted does.
+func ansiQuoted(s string, word *strings.Builder) (int, error) {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == '\'' {
+			return i + 1, nil
+		}
+		if c != '\\' || i+1 >= len(s) {
+			word.WriteByte(c)
+			continue
+		}
+		i++
+		n := unescapeC(s[i:], word, false)
+		i += n - 1
+	}
+	return 0, errors.New("unterminated $' in the command")
+}
+
+// unescapeC writes the character of the C escape sequence s starts with,
+// after its backslash, and returns the length of the sequence. Octal
+// sequences of echo start with 0, the ones of printf and $'...' do not.
+func unescapeC(s string, word *strings.Builder, echo bool) int {
+	simple := map[byte]byte{'a': 7, 'b': 8, 'e': 27, 'E': 27, 'f': 12, 'n': '\n', 'r': '\r', 't': '\t', 'v': 11, '\\': '\\', '\'': '\'', '"': '"', '?': '?'}
+	c := s[0]
+	if b, ok := simple[c]; ok {
+		word.WriteByte(b)
+		return 1
+	}
+	switch {
+	case c >= '0' && c <= '7':
+		from := 0
+		if echo && c == '0' {
+			from = 1
+		}
+		end := escapeDigits(s, from, 3, "01234567")
+		if end == from {
+			// \0 of echo alone
+			word.WriteByte(0)
+			return end
+		}
+		v, _ := strconv.ParseUint(s[from:end], 8, 32)
+		word.WriteByte(byte(v))
+		return end
+	case c == 'x':
+		if end := escapeDigits(s, 1, 2, hexDigits); end > 1 {
+			v, _ := strconv.ParseUint(s[1:end], 16, 8)
+			word.WriteByte(byte(v))
+			return end
+		}
+	case c == 'u' || c == 'U':
+		max := 4
+		if c == 'U' {
+			max = 8
+		}
+		if end := escapeDigits(s, 1, max, hexDigits); end > 1 {
+			v, _ := strconv.ParseUint(s[1:end], 16, 32)
+			var buf [utf8.UTFMax]byte
+			word.Write(buf[:utf8.EncodeRune(buf[:], rune(v))])
+			return end
+		}
+	}
+	word.WriteByte('\\')
+	word.WriteByte(c)
+	return 1
+}
+
+const hexDigits = "0123456789abcdefABCDEF"
+
+// escapeDigits returns the end of the at most max digits of s from from.
+func escapeDigits(s string, from, max int, digits string) int {
+	end := from
+	for end < len(s) && end-from < max && strings.IndexByte(digits, s[end]) >= 0 {
+		end++
+	}
+	return end
+}
+
+// pipedOutput returns what printf FORMAT or echo [-n] [-e] ARGS writes,
+// nil for the other commands.
+func pipedOutput(words []string) *[]byte {
+	if len(words) < 2 {
+		return nil
+	}
+	var out strings.Builder
+	switch path.Base(words[0]) {
+	case "printf":
+		args := words[1:]
+		if args[0] == "--" && len(args) > 1 {
+			args = args[1:]
+		}
+		format := args[0]
+		for i := 0; i < len(format); i++ {
+			switch c := format[i]; {
+			case c == '\\' && i+1 < len(format):
+				i += unescapeC(format[i+1:], &out, false)
+			case c == '%' && i+1 < len(format):
+				// no arguments are substituted, %% is %
+				i++
+				if format[i] == '%' {
+					out.WriteByte('%')
+				}
+			default:
+				out.WriteByte(c)
+			}
+		}
+	case "echo":
+		args, newline, escapes := words[1:], true, false
+		for len(args) > 0 && (args[0] == "-n" || args[0] == "-e" || args[0] == "-ne" || args[0] == "-en") {
+			newline = newline && !strings.Contains(args[0], "n")
+			escapes = escapes || strings.Contains(args[0], "e")
+			args = args[1:]
+		}
+		text := strings.Join(args, " ")
+		if escapes {
+			for i := 0; i < len(text); i++ {
+				if text[i] == '\\' && i+1 < len(text) {
+					i += unescapeC(text[i+1:], &out, true)
+					continue
+				}
+				out.WriteByte(text[i])
+			}
+		} else {
+			out.WriteString(text)
+		}
+		if newline {
+			out.WriteByte('\n')
+		}
+	default:
+		return nil
+	}
+	data := []byte(out.String())
+	return &data
+}
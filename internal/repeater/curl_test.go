@@ -0,0 +1,280 @@
+package repeater
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+// rawParts splits a raw request into its header lines, without the
+// request line, and its body.
+func rawParts(raw string) ([]string, string) {
+	head, body := raw, ""
+	if i := strings.Index(raw, "\r\n\r\n"); i >= 0 {
+		head, body = raw[:i], raw[i+4:]
+	}
+	return strings.Split(head, "\r\n")[1:], body
+}
+
+func hasHeader(lines []string, header string) bool {
+	for _, line := range lines {
+		if line == header {
+			return true
+		}
+	}
+	return false
+}
+
+func hasHeaderName(lines []string, name string) bool {
+	for _, line := range lines {
+		if strings.HasPrefix(strings.ToLower(line), strings.ToLower(name)+":") {
+			return true
+		}
+	}
+	return false
+}
+
+// readFiles stands in for the files an imported file refers to.
+func readFiles(files map[string]string) *ImportOptions {
+	return &ImportOptions{ReadFile: func(name string) ([]byte, error) {
+		data, ok := files[name]
+		if !ok {
+			return nil, os.ErrNotExist
+		}
+		return []byte(data), nil
+	}}
+}
+
+func TestSplitShell(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		want   [][]string
+		err    bool
+	}{
+		{"words", "curl  -s\texample.com", [][]string{{"curl", "-s", "example.com"}}, false},
+		{"single quotes", `curl 'a "b" \n $c'`, [][]string{{"curl", `a "b" \n $c`}}, false},
+		{"double quotes", `curl "a \"b\" \\ \$c \n" "x"y`, [][]string{{"curl", `a "b" \ $c \n`, "xy"}}, false},
+		{"ansi quotes", `curl $'a\nb\t\x41\101é\'c\\'`, [][]string{{"curl", "a\nb\tAAé'c\\"}}, false},
+		{"escaped space", `curl a\ b \'c`, [][]string{{"curl", "a b", "'c"}}, false},
+		{"backslash newline", "curl \\\n  -H x \\\r\n  example.com", [][]string{{"curl", "-H", "x", "example.com"}}, false},
+		{"backslash newline in double quotes", "curl \"a\\\nb\"", [][]string{{"curl", "ab"}}, false},
+		{"trailing backslash", `curl a\`, [][]string{{"curl", "a"}}, false},
+		{"empty quotes", `curl '' ""`, [][]string{{"curl", "", ""}}, false},
+		{"separators", "a 1; b && c || d\ne", [][]string{{"a", "1"}, {"b"}, {"c"}, {"d"}, {"e"}}, false},
+		{"comments", "# curl a\ncurl b # c\ncurl d#e", [][]string{{"curl", "b"}, {"curl", "d#e"}}, false},
+		{"unterminated single quote", "curl 'a", nil, true},
+		{"unterminated double quote", `curl "a\"`, nil, true},
+		{"unterminated ansi quote", `curl $'a\'`, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmds := splitShell(tt.script)
+			var got [][]string
+			var err error
+			for _, cmd := range cmds {
+				if cmd.err != nil {
+					err = cmd.err
+					continue
+				}
+				got = append(got, cmd.words)
+			}
+			if tt.err {
+				if err == nil {
+					t.Errorf("splitShell(%q) = %q, want an error", tt.script, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("splitShell(%q): %v", tt.script, err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("splitShell(%q) = %q, want %q", tt.script, got, tt.want)
+			}
+			for i := range got {
+				if !equalStrings(got[i], tt.want[i]) {
+					t.Errorf("splitShell(%q) = %q, want %q", tt.script, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestPipedOutput(t *testing.T) {
+	tests := []struct {
+		words []string
+		want  string
+	}{
+		{[]string{"printf", `a\tb%%\101\n`}, "a\tb%A\n"},
+		{[]string{"printf", "--", "%s"}, ""},
+		{[]string{"echo", "a", "b"}, "a b\n"},
+		{[]string{"echo", "-n", `a\n`}, `a\n`},
+		{[]string{"echo", "-ne", `a\n\0101`}, "a\nA"},
+	}
+	for _, tt := range tests {
+		got := pipedOutput(tt.words)
+		if got == nil || string(*got) != tt.want {
+			t.Errorf("pipedOutput(%q) = %v, want %q", tt.words, got, tt.want)
+		}
+	}
+	if got := pipedOutput([]string{"cat", "file"}); got != nil {
+		t.Errorf("pipedOutput of cat = %q, want nil", *got)
+	}
+}
+
+// curlWant is a request a curl command should send.
+type curlWant struct {
+	method, url string
+	headers     []string
+	// headers curl should not send
+	absent []string
+	body   string
+}
+
+func TestParseCurl(t *testing.T) {
+	files := readFiles(map[string]string{"body.txt": "a=1\r\n&b=2\n", "headers.txt": "X-A: 1\n\nX-B: 2\n"})
+	tests := []struct {
+		name   string
+		script string
+		want   []curlWant
+	}{
+		{"get", "curl https://example.com/a?x=1", []curlWant{
+			{method: "GET", url: "https://example.com/a?x=1", headers: []string{"Host: example.com", "Accept: */*"}, absent: []string{"Content-Type"}},
+		}},
+		{"no scheme", "$ curl example.com", []curlWant{{method: "GET", url: "http://example.com/"}}},
+		{"data", "curl -d a=1 --data 'b=2 3' example.com", []curlWant{
+			{method: "POST", url: "http://example.com/", headers: []string{"Content-Type: application/x-www-form-urlencoded", "Content-Length: 9"}, body: "a=1&b=2 3"},
+		}},
+		{"data from a file drops newlines", "curl -d @body.txt example.com", []curlWant{{method: "POST", url: "http://example.com/", body: "a=1&b=2"}}},
+		{"data binary", "curl --data-binary $'a\\nb' --data-binary @body.txt example.com", []curlWant{
+			{method: "POST", url: "http://example.com/", body: "a\nb&a=1\r\n&b=2\n"},
+		}},
+		{"data raw keeps @", "curl --data-raw @body.txt example.com", []curlWant{{method: "POST", url: "http://example.com/", body: "@body.txt"}}},
+		{"data urlencode", "curl --data-urlencode 'q=a b&c' --data-urlencode =é example.com", []curlWant{
+			{method: "POST", url: "http://example.com/", body: "q=a%20b%26c&%C3%A9"},
+		}},
+		{"json", `curl --json '{"a":1}' example.com`, []curlWant{
+			{method: "POST", url: "http://example.com/", headers: []string{"Accept: application/json", "Content-Type: application/json"}, body: `{"a":1}`},
+		}},
+		{"get with data", "curl -G -d q=1 -d r=2 'example.com/s?x=0'", []curlWant{{method: "GET", url: "http://example.com/s?x=0&q=1&r=2"}}},
+		{"method", "curl -X PUT -d a example.com", []curlWant{{method: "PUT", url: "http://example.com/", body: "a"}}},
+		{"method in one word", "curl -XDELETE -sSL example.com", []curlWant{{method: "DELETE", url: "http://example.com/"}}},
+		{"head", "curl -I example.com", []curlWant{{method: "HEAD", url: "http://example.com/"}}},
+		{"headers", "curl -H 'X-A: 1' -H'X-B:2' -H 'Accept:' -H 'X-Empty;' example.com", []curlWant{
+			{method: "GET", url: "http://example.com/", headers: []string{"X-A: 1", "X-B: 2", "X-Empty: "}, absent: []string{"Accept"}},
+		}},
+		{"headers from a file", "curl -H @headers.txt example.com", []curlWant{{method: "GET", url: "http://example.com/", headers: []string{"X-A: 1", "X-B: 2"}}}},
+		{"host header", "curl -H 'Host: other.example' example.com", []curlWant{{method: "GET", url: "http://example.com/", headers: []string{"Host: other.example"}}}},
+		{"cookies", "curl -b 'a=1' --cookie 'b=2; c=3' -b jar.txt example.com", []curlWant{
+			{method: "GET", url: "http://example.com/", headers: []string{"Cookie: a=1; b=2; c=3"}},
+		}},
+		{"user and agent", "curl -u user:pass -A agent/1 -e 'https://ref.example/;auto' --compressed example.com", []curlWant{
+			{method: "GET", url: "http://example.com/", headers: []string{"Authorization: Basic dXNlcjpwYXNz", "User-Agent: agent/1", "Referer: https://ref.example/", "Accept-Encoding: deflate, gzip"}},
+		}},
+		{"urls", "curl --url https://example.com/1 example.com/2 -- -odd", []curlWant{
+			{method: "GET", url: "https://example.com/1"},
+			{method: "GET", url: "http://example.com/2"},
+			{method: "GET", url: "http://-odd/"},
+		}},
+		{"stdin", "printf 'a\\tb' | curl --data-binary @- example.com", []curlWant{{method: "POST", url: "http://example.com/", body: "a\tb"}}},
+		{"upload", "echo -n hi | curl -T - example.com/f", []curlWant{{method: "PUT", url: "http://example.com/f", body: "hi"}}},
+		{"other commands", "ls -la; export A=1 && A=2 curl example.com | jq .", []curlWant{{method: "GET", url: "http://example.com/"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			items := ParseCurl(tt.script, files)
+			if len(items) != len(tt.want) {
+				t.Fatalf("ParseCurl(%q) returned %d requests, want %d", tt.script, len(items), len(tt.want))
+			}
+			for i, item := range items {
+				if item.Err != nil {
+					t.Fatalf("request %d: %v", i, item.Err)
+				}
+				checkImported(t, item.Exchange.Request.Method, item.Exchange.Request.URL, item.Exchange.Request.Raw, tt.want[i])
+			}
+		})
+	}
+}
+
+func checkImported(t *testing.T, method, url, raw string, want curlWant) {
+	t.Helper()
+	if method != want.method || url != want.url {
+		t.Errorf("request = %s %s, want %s %s", method, url, want.method, want.url)
+	}
+	headers, body := rawParts(raw)
+	for _, header := range want.headers {
+		if !hasHeader(headers, header) {
+			t.Errorf("header %q is missing from %q", header, headers)
+		}
+	}
+	for _, name := range want.absent {
+		if hasHeaderName(headers, name) {
+			t.Errorf("header %s is sent: %q", name, headers)
+		}
+	}
+	if body != want.body {
+		t.Errorf("body = %q, want %q", body, want.body)
+	}
+}
+
+func TestParseCurlErrors(t *testing.T) {
+	tests := []struct {
+		name, script string
+	}{
+		{"no url", "curl -s"},
+		{"unsupported option", "curl -Q example.com"},
+		{"unsupported long option", "curl --frobnicate example.com"},
+		{"missing value", "curl example.com -H"},
+		{"unterminated quote", "curl 'example.com"},
+		{"data and form", "curl -d a -F b=c example.com"},
+		{"bad form part", "curl -F novalue example.com"},
+		{"bad method", "curl -X 'GET /' example.com"},
+		{"bad header", "curl -H 'Bad Name: 1' example.com"},
+		{"bad scheme", "curl ftp://example.com"},
+		{"missing file", "curl -d @missing.txt example.com"},
+		{"stdin without a pipe", "curl -T - example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			items := ParseCurl(tt.script, readFiles(nil))
+			if len(items) != 1 || items[0].Err == nil {
+				t.Errorf("ParseCurl(%q) = %+v, want one error", tt.script, items)
+			}
+		})
+	}
+
+	// files are refused without ReadFile
+	items := ParseCurl("curl -d @body.txt example.com", nil)
+	if len(items) != 1 || items[0].Err == nil {
+		t.Errorf("ParseCurl read a file without ReadFile: %+v", items)
+	}
+}
+
+// TestParseCurlTruncated cuts a script at every byte: the parser may
+// fail but must not panic.
+func TestParseCurlTruncated(t *testing.T) {
+	script := "printf 'x' | curl -sSLX POST -H'A: 1' -b c=1 -F 'f=@body.txt;type=text/plain' --data-urlencode q@body.txt \\\n" +
+		"  $'https://example.com/\\x41?a=1' \"--url\" 'e\\'x' -G -d \"a\\\"b\" -u u:p --json {} -T - -- x"
+	files := readFiles(map[string]string{"body.txt": "b"})
+	for i := 0; i <= len(script); i++ {
+		ParseCurl(script[:i], files)
+	}
+}
+
+func TestCurlEscape(t *testing.T) {
+	if got, want := curlEscape("a-._~ /?=&é"), "a-._~%20%2F%3F%3D%26%C3%A9"; got != want {
+		t.Errorf("curlEscape = %q, want %q", got, want)
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,478 @@
+package repeater
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/iiivan-lemon/technopark_proxy/config"
+	"github.com/iiivan-lemon/technopark_proxy/internal/utils/redact"
+)
+
+const (
+	// what the masked parts of both responses are replaced with
+	diffMask = "***"
+	// lines of context around a change in the unified diff
+	diffContext = 3
+	// past it the changed lines are not matched, all of them are replaced
+	maxDiffCells = 4 << 20
+)
+
+// Differ compares responses, leaving out the ignored headers and the
+// masked parts of both. A nil Differ ignores and masks nothing.
+type Differ struct {
+	conf config.DiffConfig
+	// lower-cased
+	ignore map[string]bool
+	masker *redact.Redactor
+}
+
+func NewDiffer(conf *config.DiffConfig) (*Differ, error) {
+	d := &Differ{conf: *conf, ignore: make(map[string]bool)}
+	for _, name := range conf.IgnoreHeaders {
+		d.ignore[strings.ToLower(name)] = true
+	}
+	masker, err := redact.NewRedactor(&config.RedactConfig{JSONPaths: conf.JSONPaths, Regexes: conf.Regexes, Mask: diffMask})
+	if err != nil {
+		return nil, err
+	}
+	d.masker = masker
+	return d, nil
+}
+
+// With returns a Differ ignoring the headers and masking the regexes
+// on top of what d does.
+func (d *Differ) With(ignore, regexes []string) (*Differ, error) {
+	var conf config.DiffConfig
+	if d != nil {
+		conf = d.conf
+	}
+	conf.IgnoreHeaders = append(append([]string(nil), conf.IgnoreHeaders...), ignore...)
+	conf.Regexes = append(append([]string(nil), conf.Regexes...), regexes...)
+	return NewDiffer(&conf)
+}
+
+// Diff of two responses, A is the old one.
+type Diff struct {
+	A     DiffSide `json:"a"`
+	B     DiffSide `json:"b"`
+	Equal bool     `json:"equal"`
+	// status lines
+	Status  ValueChange    `json:"status"`
+	Headers []HeaderChange `json:"headers"`
+	Body    BodyDiff       `json:"body"`
+	// status line, headers and body in the unified format, empty when equal
+	Unified string `json:"unified"`
+}
+
+type DiffSide struct {
+	RequestID  int64 `json:"request_id"`
+	ResponseID int64 `json:"response_id"`
+}
+
+// ValueChange holds both values, they are left out when they are equal.
+type ValueChange struct {
+	Equal bool        `json:"equal"`
+	A     interface{} `json:"a,omitempty"`
+	B     interface{} `json:"b,omitempty"`
+}
+
+// Kinds of a change.
+const (
+	Added   = "added"
+	Removed = "removed"
+	Changed = "changed"
+)
+
+type HeaderChange struct {
+	Name string `json:"name"`
+	// added, removed or changed
+	Op string   `json:"op"`
+	A  []string `json:"a,omitempty"`
+	B  []string `json:"b,omitempty"`
+}
+
+type BodyDiff struct {
+	Equal bool `json:"equal"`
+	// set when both bodies are JSON, Changes are then by path
+	JSON    bool         `json:"json"`
+	Changes []JSONChange `json:"changes,omitempty"`
+	// set when a body is not text, only the equality is known
+	Binary bool `json:"binary,omitempty"`
+}
+
+// JSONChange is a difference between JSON bodies at Path, e.g. $.items[0].id.
+type JSONChange struct {
+	Path string `json:"path"`
+	// added, removed or changed
+	Op string      `json:"op"`
+	A  interface{} `json:"a,omitempty"`
+	B  interface{} `json:"b,omitempty"`
+}
+
+// Diff compares two decoded responses, see Response.Decode.
+func (d *Differ) Diff(a, b *Response) *Diff {
+	res := &Diff{
+		A: DiffSide{RequestID: a.RequestID, ResponseID: a.ID},
+		B: DiffSide{RequestID: b.RequestID, ResponseID: b.ID},
+	}
+	statusA, statusB := a.status(), b.status()
+	res.Status = ValueChange{Equal: statusA == statusB}
+	if !res.Status.Equal {
+		res.Status.A, res.Status.B = statusA, statusB
+	}
+
+	headersA, headersB := d.headers(a), d.headers(b)
+	res.Headers = make([]HeaderChange, 0)
+	for _, name := range unionKeys(headersA, headersB) {
+		valuesA, okA := headersA[name]
+		valuesB, okB := headersB[name]
+		switch {
+		case !okA:
+			res.Headers = append(res.Headers, HeaderChange{Name: name, Op: Added, B: valuesB})
+		case !okB:
+			res.Headers = append(res.Headers, HeaderChange{Name: name, Op: Removed, A: valuesA})
+		case !reflect.DeepEqual(valuesA, valuesB):
+			res.Headers = append(res.Headers, HeaderChange{Name: name, Op: Changed, A: valuesA, B: valuesB})
+		}
+	}
+
+	bodyA, bodyB := d.body(a), d.body(b)
+	res.Body.Equal = bodyA == bodyB
+	textA, textB := bodyA, bodyB
+	if a.BodyBase64 || b.BodyBase64 {
+		res.Body.Binary = true
+		textA, textB = binaryBody(a, bodyA), binaryBody(b, bodyB)
+	} else if jsonA, ok := parseJSON(bodyA); ok {
+		if jsonB, ok := parseJSON(bodyB); ok {
+			res.Body.JSON = true
+			res.Body.Changes = diffJSON("$", jsonA, jsonB, nil)
+			res.Body.Equal = len(res.Body.Changes) == 0
+			textA, textB = indentJSON(jsonA), indentJSON(jsonB)
+		}
+	}
+
+	res.Equal = res.Status.Equal && len(res.Headers) == 0 && res.Body.Equal
+	if !res.Equal {
+		res.Unified = unified(
+			diffText(statusA, headersA, textA), diffText(statusB, headersB, textB),
+			fmt.Sprintf("a/requests/%d/response/%d", a.RequestID, a.ID),
+			fmt.Sprintf("b/requests/%d/response/%d", b.RequestID, b.ID))
+	}
+	return res
+}
+
+func (r *Response) status() string {
+	if r.Message != "" {
+		return r.Message
+	}
+	return strconv.Itoa(r.Code)
+}
+
+// headers returns the masked values of the headers of r that are not
+// ignored, by canonical name.
+func (d *Differ) headers(r *Response) map[string][]string {
+	masked := make(map[string]interface{}, len(r.Headers))
+	for name, value := range r.Headers {
+		if d == nil || !d.ignore[strings.ToLower(name)] {
+			masked[name] = headerValues(value)
+		}
+	}
+	if d != nil {
+		d.masker.Headers(masked)
+	}
+	res := make(map[string][]string, len(masked))
+	for name, value := range masked {
+		key := http.CanonicalHeaderKey(name)
+		res[key] = append(res[key], headerValues(value)...)
+	}
+	return res
+}
+
+func (d *Differ) body(r *Response) string {
+	if d == nil || r.BodyBase64 {
+		return r.Body
+	}
+	return d.masker.Body(r.Body, r.header("Content-Type"))
+}
+
+// diffText is what the unified diff compares, headers sorted by name.
+func diffText(status string, headers map[string][]string, body string) string {
+	var b strings.Builder
+	b.WriteString("HTTP/1.1 " + status + "\n")
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range headers[name] {
+			b.WriteString(name + ": " + value + "\n")
+		}
+	}
+	b.WriteString("\n" + body)
+	return b.String()
+}
+
+// binaryBody stands in for a binary body in the unified diff, the digest
+// tells apart bodies of the same length.
+func binaryBody(r *Response, body string) string {
+	if !r.BodyBase64 {
+		return body
+	}
+	sum := sha256.Sum256([]byte(body))
+	return fmt.Sprintf("[binary body, %d base64 characters, sha256 %x]", len(body), sum[:8])
+}
+
+func unionKeys(a, b map[string][]string) []string {
+	keys := make([]string, 0, len(a)+len(b))
+	for key := range a {
+		keys = append(keys, key)
+	}
+	for key := range b {
+		if _, ok := a[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func parseJSON(s string) (interface{}, bool) {
+	if strings.TrimSpace(s) == "" {
+		return nil, false
+	}
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil || dec.More() {
+		return nil, false
+	}
+	return v, true
+}
+
+// indentJSON prints v one value per line with sorted keys, so that
+// the line diff follows the values.
+func indentJSON(v interface{}) string {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return ""
+	}
+	return b.String()
+}
+
+var identifier = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*$`)
+
+// diffJSON appends the changes from a to b under path to changes.
+func diffJSON(path string, a, b interface{}, changes []JSONChange) []JSONChange {
+	switch a := a.(type) {
+	case map[string]interface{}:
+		if b, ok := b.(map[string]interface{}); ok {
+			keys := make([]string, 0, len(a)+len(b))
+			for key := range a {
+				keys = append(keys, key)
+			}
+			for key := range b {
+				if _, ok := a[key]; !ok {
+					keys = append(keys, key)
+				}
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				child := path + "." + key
+				if !identifier.MatchString(key) {
+					child = path + "[" + strconv.Quote(key) + "]"
+				}
+				changes = diffMember(child, a, b, key, changes)
+			}
+			return changes
+		}
+	case []interface{}:
+		if b, ok := b.([]interface{}); ok {
+			for i := 0; i < len(a) || i < len(b); i++ {
+				child := path + "[" + strconv.Itoa(i) + "]"
+				switch {
+				case i >= len(b):
+					changes = append(changes, JSONChange{Path: child, Op: Removed, A: a[i]})
+				case i >= len(a):
+					changes = append(changes, JSONChange{Path: child, Op: Added, B: b[i]})
+				default:
+					changes = diffJSON(child, a[i], b[i], changes)
+				}
+			}
+			return changes
+		}
+	}
+	if !reflect.DeepEqual(a, b) {
+		changes = append(changes, JSONChange{Path: path, Op: Changed, A: a, B: b})
+	}
+	return changes
+}
+
+func diffMember(path string, a, b map[string]interface{}, key string, changes []JSONChange) []JSONChange {
+	valueA, okA := a[key]
+	valueB, okB := b[key]
+	switch {
+	case !okA:
+		return append(changes, JSONChange{Path: path, Op: Added, B: valueB})
+	case !okB:
+		return append(changes, JSONChange{Path: path, Op: Removed, A: valueA})
+	}
+	return diffJSON(path, valueA, valueB, changes)
+}
+
+// lineEdit is a line kept (' '), removed ('-') or added ('+').
+type lineEdit struct {
+	op   byte
+	line string
+}
+
+// diffLines returns the edits turning a into b, the lines between the
+// common prefix and suffix are matched by their longest common subsequence.
+func diffLines(a, b []string) []lineEdit {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	edits := make([]lineEdit, 0, len(a)+len(b))
+	for _, line := range a[:prefix] {
+		edits = append(edits, lineEdit{' ', line})
+	}
+	midA, midB := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	if len(midA)*len(midB) > maxDiffCells {
+		for _, line := range midA {
+			edits = append(edits, lineEdit{'-', line})
+		}
+		for _, line := range midB {
+			edits = append(edits, lineEdit{'+', line})
+		}
+	} else {
+		edits = append(edits, lcsEdits(midA, midB)...)
+	}
+	for _, line := range a[len(a)-suffix:] {
+		edits = append(edits, lineEdit{' ', line})
+	}
+	return edits
+}
+
+func lcsEdits(a, b []string) []lineEdit {
+	// lcs[i][j] is the length of the LCS of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	edits := make([]lineEdit, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			edits = append(edits, lineEdit{' ', a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			edits = append(edits, lineEdit{'-', a[i]})
+			i++
+		default:
+			edits = append(edits, lineEdit{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		edits = append(edits, lineEdit{'-', a[i]})
+	}
+	for ; j < len(b); j++ {
+		edits = append(edits, lineEdit{'+', b[j]})
+	}
+	return edits
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// unified returns the diff of a and b in the unified format.
+func unified(a, b, nameA, nameB string) string {
+	edits := diffLines(splitLines(a), splitLines(b))
+	// lines of a and b before each edit
+	posA, posB := make([]int, len(edits)+1), make([]int, len(edits)+1)
+	for k, e := range edits {
+		posA[k+1], posB[k+1] = posA[k], posB[k]
+		if e.op != '+' {
+			posA[k+1]++
+		}
+		if e.op != '-' {
+			posB[k+1]++
+		}
+	}
+
+	var out strings.Builder
+	out.WriteString("--- " + nameA + "\n+++ " + nameB + "\n")
+	for k := 0; k < len(edits); {
+		for k < len(edits) && edits[k].op == ' ' {
+			k++
+		}
+		if k == len(edits) {
+			break
+		}
+		start := k - diffContext
+		if start < 0 {
+			start = 0
+		}
+		// a hunk goes on while the next change is close enough to share context
+		last := k
+		for j := k; j < len(edits) && j-last <= 2*diffContext; j++ {
+			if edits[j].op != ' ' {
+				last = j
+			}
+		}
+		stop := last + diffContext + 1
+		if stop > len(edits) {
+			stop = len(edits)
+		}
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n", hunkRange(posA[start], posA[stop]-posA[start]), hunkRange(posB[start], posB[stop]-posB[start]))
+		for _, e := range edits[start:stop] {
+			out.WriteByte(e.op)
+			out.WriteString(e.line + "\n")
+		}
+		k = stop
+	}
+	return out.String()
+}
+
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return strconv.Itoa(start) + ",0"
+	}
+	if count == 1 {
+		return strconv.Itoa(start + 1)
+	}
+	return strconv.Itoa(start+1) + "," + strconv.Itoa(count)
+}
@@ -0,0 +1,196 @@
+package repeater
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/iiivan-lemon/technopark_proxy/config"
+)
+
+func diffResponse(id int64, message string, headers Map, body string) *Response {
+	return &Response{ID: id, RequestID: id, Message: message, Headers: headers, Body: body}
+}
+
+func newDiffer(t *testing.T, conf config.DiffConfig) *Differ {
+	d, err := NewDiffer(&conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+// number is a JSON number as the JSON changes hold it.
+func number(s string) json.Number {
+	return json.Number(s)
+}
+
+func TestDiffIdentical(t *testing.T) {
+	for _, body := range []string{"", "plain text\n", `{"a": [1, {"b": null}]}`} {
+		headers := Map{"Content-Type": "application/json", "X-Multi": []string{"1", "2"}}
+		diff := (*Differ)(nil).Diff(diffResponse(1, "200 OK", headers, body), diffResponse(2, "200 OK", headers, body))
+		if !diff.Equal || !diff.Status.Equal || len(diff.Headers) != 0 || !diff.Body.Equal || diff.Unified != "" {
+			t.Errorf("Diff of identical responses with body %q = %+v", body, diff)
+		}
+		if diff.A.RequestID != 1 || diff.B.ResponseID != 2 {
+			t.Errorf("Diff sides = %+v, %+v", diff.A, diff.B)
+		}
+	}
+}
+
+func TestDiffJSON(t *testing.T) {
+	headers := Map{"Content-Type": "application/json"}
+	a := diffResponse(1, "200 OK", headers, `{"a": 1, "b": {"c": 2}, "d": [1, 2], "weird key": "x", "n": 1.0}`)
+	reordered := diffResponse(2, "200 OK", headers, "{\n\"n\": 1.0, \"weird key\": \"x\", \"d\": [1, 2],\n\"b\": {\"c\": 2}, \"a\": 1}")
+	if diff := (*Differ)(nil).Diff(a, reordered); !diff.Equal || !diff.Body.JSON || len(diff.Body.Changes) != 0 {
+		t.Errorf("Diff of reordered keys = %+v", diff)
+	}
+
+	b := diffResponse(2, "200 OK", headers, `{"a": 2, "b": {"c": 2, "e": true}, "d": [1], "weird key": "y", "n": 1.0}`)
+	diff := (*Differ)(nil).Diff(a, b)
+	want := []JSONChange{
+		{Path: "$.a", Op: Changed, A: number("1"), B: number("2")},
+		{Path: "$.b.e", Op: Added, B: true},
+		{Path: "$.d[1]", Op: Removed, A: number("2")},
+		{Path: `$["weird key"]`, Op: Changed, A: "x", B: "y"},
+	}
+	if diff.Equal || diff.Body.Equal || !diff.Body.JSON || !reflect.DeepEqual(diff.Body.Changes, want) {
+		t.Errorf("Diff.Body = %+v, want changes %+v", diff.Body, want)
+	}
+	// the unified diff compares the indented JSON with sorted keys
+	if !strings.Contains(diff.Unified, "-  \"a\": 1,\n+  \"a\": 2,\n") {
+		t.Errorf("Unified =\n%s", diff.Unified)
+	}
+
+	// a JSON body against one that is not JSON is compared as text
+	diff = (*Differ)(nil).Diff(a, diffResponse(2, "200 OK", headers, "not json"))
+	if diff.Body.Equal || diff.Body.JSON || len(diff.Body.Changes) != 0 {
+		t.Errorf("Diff of JSON and text = %+v", diff.Body)
+	}
+}
+
+func TestDiffHeaders(t *testing.T) {
+	a := diffResponse(1, "200 OK", Map{"date": "Mon", "X-Kept": "1", "X-Gone": "1", "X-Changed": []string{"1", "2"}}, "")
+	b := diffResponse(2, "404 Not Found", Map{"Date": "Tue", "x-kept": "1", "X-New": "1", "X-Changed": []string{"1", "3"}}, "")
+
+	diff := newDiffer(t, config.DiffConfig{IgnoreHeaders: []string{"DATE"}}).Diff(a, b)
+	want := []HeaderChange{
+		{Name: "X-Changed", Op: Changed, A: []string{"1", "2"}, B: []string{"1", "3"}},
+		{Name: "X-Gone", Op: Removed, A: []string{"1"}},
+		{Name: "X-New", Op: Added, B: []string{"1"}},
+	}
+	if !reflect.DeepEqual(diff.Headers, want) {
+		t.Errorf("Diff.Headers = %+v, want %+v", diff.Headers, want)
+	}
+	if diff.Status.Equal || diff.Status.A != "200 OK" || diff.Status.B != "404 Not Found" {
+		t.Errorf("Diff.Status = %+v", diff.Status)
+	}
+	if !strings.Contains(diff.Unified, "-HTTP/1.1 200 OK\n+HTTP/1.1 404 Not Found\n") || strings.Contains(diff.Unified, "Date") {
+		t.Errorf("Unified =\n%s", diff.Unified)
+	}
+
+	// only the ignored headers differ
+	a = diffResponse(1, "200 OK", Map{"Date": "Mon", "Server": "a"}, "body")
+	b = diffResponse(2, "200 OK", Map{"Date": "Tue", "Server": "b"}, "body")
+	d := newDiffer(t, config.DiffConfig{IgnoreHeaders: []string{"Date"}})
+	if diff = d.Diff(a, b); diff.Equal {
+		t.Error("Server is not ignored but the responses are equal")
+	}
+	with, err := d.With([]string{"server"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff = with.Diff(a, b); !diff.Equal {
+		t.Errorf("Diff ignoring Date and Server = %+v", diff)
+	}
+	// With does not change the Differ it is made from
+	if diff = d.Diff(a, b); diff.Equal {
+		t.Error("With changed the headers its Differ ignores")
+	}
+}
+
+func TestDiffMasks(t *testing.T) {
+	headers := Map{"Content-Type": "application/json", "X-Request-Id": "req-1"}
+	a := diffResponse(1, "200 OK", headers, `{"token": "abc", "user": {"id": 1, "seen": "10:00"}, "nonce=aaa": 1}`)
+	b := diffResponse(2, "200 OK", Map{"Content-Type": "application/json", "X-Request-Id": "req-2"},
+		`{"token": "def", "user": {"id": 1, "seen": "11:30"}, "nonce=bbb": 1}`)
+
+	if diff := (*Differ)(nil).Diff(a, b); diff.Equal {
+		t.Fatal("responses are equal without masks")
+	}
+	d := newDiffer(t, config.DiffConfig{JSONPaths: []string{"$.token", "$..seen"}, Regexes: []string{`req-\d+`}})
+	diff := d.Diff(a, b)
+	if len(diff.Headers) != 0 {
+		t.Errorf("masked headers differ: %+v", diff.Headers)
+	}
+	want := []JSONChange{
+		{Path: `$["nonce=aaa"]`, Op: Removed, A: number("1")},
+		{Path: `$["nonce=bbb"]`, Op: Added, B: number("1")},
+	}
+	if !reflect.DeepEqual(diff.Body.Changes, want) {
+		t.Errorf("Diff.Body.Changes = %+v, want %+v", diff.Body.Changes, want)
+	}
+
+	// a group masks only what it matches
+	with, err := d.With(nil, []string{`nonce=(\w+)`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff = with.Diff(a, b); !diff.Equal {
+		t.Errorf("Diff with every mask = %+v", diff)
+	}
+	if _, err = d.With(nil, []string{"("}); err == nil {
+		t.Error("With accepted a bad regex")
+	}
+	if _, err = NewDiffer(&config.DiffConfig{JSONPaths: []string{"token"}}); err == nil {
+		t.Error("NewDiffer accepted a bad JSON path")
+	}
+}
+
+func TestDiffBinary(t *testing.T) {
+	binary := func(id int64, body string) *Response {
+		r := diffResponse(id, "200 OK", Map{"Content-Type": "image/png"}, body)
+		r.BodyBase64 = true
+		return r
+	}
+	d := newDiffer(t, config.DiffConfig{Regexes: []string{"A+"}})
+	if diff := d.Diff(binary(1, "AAAA"), binary(2, "AAAA")); !diff.Equal || !diff.Body.Binary {
+		t.Errorf("Diff of equal binary bodies = %+v", diff)
+	}
+	// binary bodies are not masked
+	diff := d.Diff(binary(1, "AAAA"), binary(2, "AAAB"))
+	if diff.Equal || diff.Body.Equal || !diff.Body.Binary || diff.Body.JSON {
+		t.Errorf("Diff of binary bodies = %+v", diff.Body)
+	}
+	if !strings.Contains(diff.Unified, "-[binary body, 4 base64 characters, sha256 ") ||
+		!strings.Contains(diff.Unified, "+[binary body, 4 base64 characters, sha256 ") || strings.Contains(diff.Unified, "AAAB") {
+		t.Errorf("Unified =\n%s", diff.Unified)
+	}
+
+	text := diffResponse(2, "200 OK", Map{"Content-Type": "image/png"}, "AAAA")
+	if diff = d.Diff(binary(1, "AAAA"), text); !diff.Body.Binary {
+		t.Errorf("Diff of a binary and a text body = %+v", diff.Body)
+	}
+}
+
+func TestUnified(t *testing.T) {
+	lines := make([]string, 20)
+	for i := range lines {
+		lines[i] = string(rune('a' + i))
+	}
+	a := strings.Join(lines, "\n") + "\n"
+	lines[1], lines[17] = "B", "R"
+	lines = append(lines, "u")
+	b := strings.Join(lines, "\n") + "\n"
+
+	want := "--- a\n+++ b\n" +
+		"@@ -1,5 +1,5 @@\n a\n-b\n+B\n c\n d\n e\n" +
+		"@@ -15,6 +15,7 @@\n o\n p\n q\n-r\n+R\n s\n t\n+u\n"
+	if got := unified(a, b, "a", "b"); got != want {
+		t.Errorf("unified =\n%s\nwant\n%s", got, want)
+	}
+	if got := unified("", "x\n", "a", "b"); got != "--- a\n+++ b\n@@ -0,0 +1 @@\n+x\n" {
+		t.Errorf("unified of an added line =\n%s", got)
+	}
+}
@@ -0,0 +1,477 @@
+package repeater
+
+import (
+	"fmt"
+	"go/format"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	proxyserver "github.com/iiivan-lemon/technopark_proxy/internal/proxyServer"
+	"github.com/iiivan-lemon/technopark_proxy/internal/utils/forward"
+	"github.com/pkg/errors"
+)
+
+// ExportFormats are the values accepted by format of GET /requests/:id/export.
+var ExportFormats = []string{"curl", "httpie", "python-requests", "go-nethttp", "raw", "powershell"}
+
+// IsExportFormat reports whether format is one of ExportFormats.
+func IsExportFormat(format string) bool {
+	for _, f := range ExportFormats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+// exported is a stored request reduced to what the formats need.
+type exported struct {
+	method string
+	url    string
+	// the Host header when it is not the authority of url
+	host string
+	// sorted by name, without Host, Cookie and the headers the clients set
+	headers [][2]string
+	// the Cookie header and the cookies in it
+	cookie  string
+	cookies [][2]string
+	body    []byte
+}
+
+// Export returns the request as a command or a program in format, see
+// ExportFormats. It is built from the stored, redacted, raw request.
+func Export(req *RequestResponse, format string) (string, error) {
+	e, err := newExported(req)
+	if err != nil {
+		return "", err
+	}
+	switch format {
+	case "curl":
+		return e.curl(), nil
+	case "httpie":
+		return e.httpie(), nil
+	case "python-requests":
+		return e.python(), nil
+	case "go-nethttp":
+		return e.goNetHTTP()
+	case "raw":
+		return e.raw(), nil
+	case "powershell":
+		return e.powershell(), nil
+	}
+	return "", errors.Errorf("unknown export format %q", format)
+}
+
+func newExported(req *RequestResponse) (*exported, error) {
+	scheme, host, port, ok := req.Target()
+	if !ok {
+		return nil, errors.New("request has no upstream")
+	}
+	if scheme == "" {
+		scheme = "http"
+		if req.IsHTTPS {
+			scheme = "https"
+		}
+	}
+	httpReq, body, err := parseRaw(req.Raw)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing raw request")
+	}
+	authority := proxyserver.JoinAuthority(host, port, scheme)
+	e := &exported{
+		method: httpReq.Method,
+		url:    scheme + "://" + authority + httpReq.URL.RequestURI(),
+		body:   body,
+	}
+	if httpReq.Host != "" && !strings.EqualFold(httpReq.Host, authority) {
+		e.host = httpReq.Host
+	}
+
+	forward.RemoveHopByHop(httpReq.Header)
+	for _, name := range []string{"Content-Length", "Transfer-Encoding"} {
+		httpReq.Header.Del(name)
+	}
+	e.cookie = strings.Join(httpReq.Header.Values("Cookie"), "; ")
+	httpReq.Header.Del("Cookie")
+	for _, pair := range strings.Split(e.cookie, ";") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		name, value := pair, ""
+		if i := strings.IndexByte(pair, '='); i >= 0 {
+			name, value = pair[:i], pair[i+1:]
+		}
+		e.cookies = append(e.cookies, [2]string{name, value})
+	}
+
+	names := make([]string, 0, len(httpReq.Header))
+	for name := range httpReq.Header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range httpReq.Header[name] {
+			e.headers = append(e.headers, [2]string{name, value})
+		}
+	}
+	return e, nil
+}
+
+// joinedHeaders returns the headers with the values of a repeated one
+// joined by commas, for the clients taking a map.
+func (e *exported) joinedHeaders() [][2]string {
+	var res [][2]string
+	for _, h := range e.headers {
+		if n := len(res); n > 0 && res[n-1][0] == h[0] {
+			res[n-1][1] += ", " + h[1]
+			continue
+		}
+		res = append(res, h)
+	}
+	return res
+}
+
+func (e *exported) curl() string {
+	args := []string{"curl"}
+	switch {
+	case e.method == http.MethodHead:
+		args = append(args, "--head")
+	case e.method != http.MethodGet || len(e.body) > 0:
+		args = append(args, "-X "+shellQuote(e.method))
+	}
+	args = append(args, shellQuote(e.url))
+	if e.host != "" {
+		args = append(args, "-H "+shellQuote("Host: "+e.host))
+	}
+	for _, h := range e.headers {
+		args = append(args, "-H "+shellQuote(curlHeader(h[0], h[1])))
+	}
+	if e.cookie != "" {
+		args = append(args, "-b "+shellQuote(e.cookie))
+	}
+	if len(e.body) == 0 {
+		return strings.Join(args, " \\\n  ") + "\n"
+	}
+	// curl reads a file for a body starting with @, and no argument can hold NUL
+	if e.body[0] != '@' && !strings.ContainsRune(string(e.body), 0) {
+		args = append(args, "--data-binary "+shellQuote(string(e.body)))
+		return strings.Join(args, " \\\n  ") + "\n"
+	}
+	args = append(args, "--data-binary @-")
+	return printfBody(e.body) + " | " + strings.Join(args, " \\\n  ") + "\n"
+}
+
+// curlHeader is a -H argument, curl sends "Name;" as an empty header.
+func curlHeader(name, value string) string {
+	if value == "" {
+		return name + ";"
+	}
+	return name + ": " + value
+}
+
+func (e *exported) httpie() string {
+	args := []string{"http", shellQuote(e.method), shellQuote(e.url)}
+	if e.host != "" {
+		args = append(args, shellQuote("Host:"+e.host))
+	}
+	for _, h := range e.joinedHeaders() {
+		if h[1] == "" {
+			args = append(args, shellQuote(h[0]+";"))
+			continue
+		}
+		args = append(args, shellQuote(h[0]+":"+h[1]))
+	}
+	if e.cookie != "" {
+		args = append(args, shellQuote("Cookie:"+e.cookie))
+	}
+	cmd := strings.Join(args, " \\\n  ")
+	if len(e.body) > 0 {
+		// the body is read from stdin as it is
+		return printfBody(e.body) + " | " + cmd + "\n"
+	}
+	return cmd + "\n"
+}
+
+func (e *exported) python() string {
+	var b strings.Builder
+	b.WriteString("import requests\n\n")
+	headers := e.joinedHeaders()
+	if e.host != "" {
+		headers = append([][2]string{{"Host", e.host}}, headers...)
+	}
+	args := []string{pyQuote(e.method), pyQuote(e.url)}
+	if len(headers) > 0 {
+		b.WriteString(pyDict("headers", headers))
+		args = append(args, "headers=headers")
+	}
+	if len(e.cookies) > 0 {
+		b.WriteString(pyDict("cookies", e.cookies))
+		args = append(args, "cookies=cookies")
+	}
+	if len(e.body) > 0 {
+		b.WriteString("data = " + pyBody(e.body) + "\n")
+		args = append(args, "data=data")
+	}
+	if len(args) > 2 {
+		b.WriteString("\n")
+	}
+	b.WriteString("response = requests.request(" + strings.Join(args, ", ") + ")\n")
+	b.WriteString("print(response.status_code)\nprint(response.text)\n")
+	return b.String()
+}
+
+func pyDict(name string, items [][2]string) string {
+	var b strings.Builder
+	b.WriteString(name + " = {\n")
+	for _, item := range items {
+		b.WriteString("    " + pyQuote(item[0]) + ": " + pyQuote(item[1]) + ",\n")
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+func (e *exported) goNetHTTP() (string, error) {
+	var b strings.Builder
+	imports := []string{"fmt", "io", "net/http"}
+	body := "nil"
+	if len(e.body) > 0 {
+		imports = append(imports, "strings")
+		body = "strings.NewReader(" + strconv.Quote(string(e.body)) + ")"
+	}
+	b.WriteString("package main\n\nimport (\n")
+	for _, imp := range imports {
+		b.WriteString(strconv.Quote(imp) + "\n")
+	}
+	b.WriteString(")\n\nfunc main() {\n")
+	fmt.Fprintf(&b, "req, err := http.NewRequest(%s, %s, %s)\n", strconv.Quote(e.method), strconv.Quote(e.url), body)
+	b.WriteString("if err != nil {\npanic(err)\n}\n")
+	if e.host != "" {
+		b.WriteString("req.Host = " + strconv.Quote(e.host) + "\n")
+	}
+	for _, h := range e.headers {
+		// set as they are, Header.Add would canonicalize the names
+		fmt.Fprintf(&b, "req.Header[%s] = append(req.Header[%[1]s], %s)\n", strconv.Quote(h[0]), strconv.Quote(h[1]))
+	}
+	if e.cookie != "" {
+		b.WriteString("req.Header.Set(\"Cookie\", " + strconv.Quote(e.cookie) + ")\n")
+	}
+	b.WriteString(`
+resp, err := http.DefaultClient.Do(req)
+if err != nil {
+panic(err)
+}
+defer resp.Body.Close()
+data, err := io.ReadAll(resp.Body)
+if err != nil {
+panic(err)
+}
+fmt.Println(resp.Status)
+fmt.Println(string(data))
+}
+`)
+	src, err := format.Source([]byte(b.String()))
+	if err != nil {
+		return "", errors.Wrap(err, "formatting go source")
+	}
+	return string(src), nil
+}
+
+// raw is the request in HTTP/1.1 as it goes to the upstream,
+// Content-Length follows the body.
+func (e *exported) raw() string {
+	var b strings.Builder
+	uri := e.url[strings.Index(e.url, "://")+3:]
+	host, path := uri, "/"
+	if i := strings.IndexAny(uri, "/?"); i >= 0 {
+		host, path = uri[:i], uri[i:]
+	}
+	if path[0] == '?' {
+		path = "/" + path
+	}
+	if e.host != "" {
+		host = e.host
+	}
+	b.WriteString(e.method + " " + path + " HTTP/1.1\r\nHost: " + host + "\r\n")
+	for _, h := range e.headers {
+		b.WriteString(h[0] + ": " + h[1] + "\r\n")
+	}
+	if e.cookie != "" {
+		b.WriteString("Cookie: " + e.cookie + "\r\n")
+	}
+	if len(e.body) > 0 || e.method == http.MethodPost || e.method == http.MethodPut || e.method == http.MethodPatch {
+		b.WriteString("Content-Length: " + strconv.Itoa(len(e.body)) + "\r\n")
+	}
+	b.WriteString("\r\n")
+	b.Write(e.body)
+	return b.String()
+}
+
+func (e *exported) powershell() string {
+	var b strings.Builder
+	args := []string{"Invoke-WebRequest", "-Uri " + psQuote(e.url), "-Method " + psQuote(e.method)}
+	headers := e.joinedHeaders()
+	if e.cookie != "" {
+		headers = append(headers, [2]string{"Cookie", e.cookie})
+	}
+	kept := headers[:0]
+	for _, h := range headers {
+		// Windows PowerShell refuses these two in -Headers
+		switch {
+		case strings.EqualFold(h[0], "Content-Type"):
+			args = append(args, "-ContentType "+psQuote(h[1]))
+		case strings.EqualFold(h[0], "User-Agent"):
+			args = append(args, "-UserAgent "+psQuote(h[1]))
+		default:
+			kept = append(kept, h)
+		}
+	}
+	if e.host != "" {
+		kept = append([][2]string{{"Host", e.host}}, kept...)
+	}
+	if len(kept) > 0 {
+		b.WriteString("$headers = @{\n")
+		for _, h := range kept {
+			b.WriteString("    " + psQuote(h[0]) + " = " + psQuote(h[1]) + "\n")
+		}
+		b.WriteString("}\n")
+		args = append(args, "-Headers $headers")
+	}
+	if len(e.body) > 0 {
+		if utf8.Valid(e.body) {
+			b.WriteString("$body = " + psQuote(string(e.body)) + "\n")
+		} else {
+			bytes := make([]string, len(e.body))
+			for i, c := range e.body {
+				bytes[i] = fmt.Sprintf("0x%02x", c)
+			}
+			b.WriteString("$body = [byte[]](" + strings.Join(bytes, ", ") + ")\n")
+		}
+		args = append(args, "-Body $body")
+	}
+	b.WriteString(strings.Join(args, " `\n  ") + "\n")
+	return b.String()
+}
+
+// printfBody is a printf command writing body to stdout, as it is.
+func printfBody(body []byte) string {
+	var b strings.Builder
+	for i := 0; i < len(body); {
+		r, size := utf8.DecodeRune(body[i:])
+		switch {
+		case r == '\\':
+			b.WriteString(`\\`)
+		case r == '%':
+			b.WriteString("%%")
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == '\r':
+			b.WriteString(`\r`)
+		case r == '\t':
+			b.WriteString(`\t`)
+		case r == utf8.RuneError && size == 1, r < 0x20, r == 0x7f:
+			fmt.Fprintf(&b, `\%03o`, body[i])
+		default:
+			b.Write(body[i : i+size])
+		}
+		i += size
+	}
+	if len(body) > 0 && body[0] == '-' {
+		return "printf -- " + shellQuote(b.String())
+	}
+	return "printf " + shellQuote(b.String())
+}
+
+// shellQuote quotes s for a POSIX shell, in $'...' when it has bytes
+// that single quotes would not keep readable.
+func shellQuote(s string) string {
+	plain := utf8.ValidString(s)
+	for i := 0; plain && i < len(s); i++ {
+		if c := s[i]; c < 0x20 && c != '\n' && c != '\t' || c == 0x7f {
+			plain = false
+		}
+	}
+	if plain {
+		return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+	}
+	var b strings.Builder
+	b.WriteString("$'")
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case r == '\\' || r == '\'':
+			b.WriteString(`\` + string(r))
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == '\r':
+			b.WriteString(`\r`)
+		case r == '\t':
+			b.WriteString(`\t`)
+		case r == utf8.RuneError && size == 1, r < 0x20, r == 0x7f:
+			fmt.Fprintf(&b, `\x%02x`, s[i])
+		default:
+			b.WriteString(s[i : i+size])
+		}
+		i += size
+	}
+	b.WriteString("'")
+	return b.String()
+}
+
+// pyQuote returns s as a Python string literal.
+func pyQuote(s string) string {
+	var b strings.Builder
+	b.WriteString("'")
+	for _, r := range s {
+		switch {
+		case r == '\\' || r == '\'':
+			b.WriteString(`\` + string(r))
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == '\r':
+			b.WriteString(`\r`)
+		case r == '\t':
+			b.WriteString(`\t`)
+		case r < 0x20 || r == 0x7f:
+			fmt.Fprintf(&b, `\x%02x`, r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteString("'")
+	return b.String()
+}
+
+// pyBody returns body as a Python literal, bytes when it is not UTF-8.
+func pyBody(body []byte) string {
+	if utf8.Valid(body) {
+		return pyQuote(string(body))
+	}
+	var b strings.Builder
+	b.WriteString("b'")
+	for _, c := range body {
+		switch {
+		case c == '\\' || c == '\'':
+			b.WriteString(`\` + string(rune(c)))
+		case c == '\n':
+			b.WriteString(`\n`)
+		case c == '\r':
+			b.WriteString(`\r`)
+		case c == '\t':
+			b.WriteString(`\t`)
+		case c < 0x20 || c >= 0x7f:
+			fmt.Fprintf(&b, `\x%02x`, c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteString("'")
+	return b.String()
+}
+
+// psQuote returns s as a PowerShell verbatim string.
+func psQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
@@ -0,0 +1,300 @@
+package repeater
+
+import (
+	"bytes"
+	"go/parser"
+	"go/token"
+	"os/exec"
+	"strconv"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// awkward are values the exporters have to quote.
+var awkward = []struct {
+	name, value string
+}{
+	{"plain", "abc"},
+	{"empty", ""},
+	{"single quote", "it's 'quoted'"},
+	{"double quote and expansions", "\"$HOME\" `id` $(id) !1 *"},
+	{"backslash and percent", `100% \n \\ %s`},
+	{"nul", "a\x00b"},
+	{"non-utf-8", "\xff\xfe ok \xc3"},
+	{"utf-8", "héllo ✓"},
+	{"leading at", "@/etc/passwd"},
+	{"leading dash", "-n -e"},
+	{"cr lf", "line 1\r\nline 2\n"},
+	{"controls", "\x01\t\x1b[0m\x7f"},
+}
+
+// run runs script with interpreter -c and returns its stdout, the test
+// is skipped when there is no interpreter.
+func run(t *testing.T, interpreter, script string) []byte {
+	t.Helper()
+	path, err := exec.LookPath(interpreter)
+	if err != nil {
+		t.Skipf("no %s: %v", interpreter, err)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(path, "-c", script)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err = cmd.Run(); err != nil {
+		t.Fatalf("%s -c %q: %v: %s", interpreter, script, err, stderr.String())
+	}
+	return stdout.Bytes()
+}
+
+func TestShellQuote(t *testing.T) {
+	for _, tt := range awkward {
+		if strings.IndexByte(tt.value, 0) >= 0 {
+			// no argument holds NUL, bodies with it go through printfBody
+			continue
+		}
+		t.Run(tt.name, func(t *testing.T) {
+			quoted := shellQuote(tt.value)
+			cmds := splitShell("echo " + quoted)
+			if len(cmds) != 1 || cmds[0].err != nil || len(cmds[0].words) != 2 || cmds[0].words[1] != tt.value {
+				t.Errorf("splitShell(%q) = %+v, want %q", quoted, cmds, tt.value)
+			}
+			if got := run(t, "bash", "printf %s "+quoted); string(got) != tt.value {
+				t.Errorf("bash -c printf %%s %s = %q, want %q", quoted, got, tt.value)
+			}
+			// dash knows no $'...'
+			if !strings.HasPrefix(quoted, "$") {
+				if got := run(t, "sh", "printf %s "+quoted); string(got) != tt.value {
+					t.Errorf("sh -c printf %%s %s = %q, want %q", quoted, got, tt.value)
+				}
+			}
+		})
+	}
+}
+
+func TestPrintfBody(t *testing.T) {
+	for _, tt := range awkward {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := printfBody([]byte(tt.value))
+			if strings.HasPrefix(cmd, "printf $") {
+				t.Errorf("printfBody(%q) = %s, want it single quoted", tt.value, cmd)
+			}
+			cmds := splitShell(cmd)
+			if len(cmds) != 1 || cmds[0].err != nil {
+				t.Fatalf("splitShell(%q) = %+v", cmd, cmds)
+			}
+			if out := pipedOutput(cmds[0].words); out == nil || string(*out) != tt.value {
+				t.Errorf("pipedOutput of %s = %v, want %q", cmd, out, tt.value)
+			}
+			for _, shell := range []string{"sh", "bash"} {
+				if got := run(t, shell, cmd); string(got) != tt.value {
+					t.Errorf("%s -c %s = %q, want %q", shell, cmd, got, tt.value)
+				}
+			}
+		})
+	}
+}
+
+func TestPyQuote(t *testing.T) {
+	for _, tt := range awkward {
+		t.Run(tt.name, func(t *testing.T) {
+			script := "import sys\nv = " + pyBody([]byte(tt.value)) + "\n" +
+				"sys.stdout.buffer.write(v if isinstance(v, bytes) else v.encode('utf-8', 'surrogateescape'))\n"
+			if got := run(t, "python3", script); string(got) != tt.value {
+				t.Errorf("python %s = %q, want %q", pyBody([]byte(tt.value)), got, tt.value)
+			}
+			if !utf8.ValidString(tt.value) {
+				return
+			}
+			script = "import sys\nsys.stdout.buffer.write(" + pyQuote(tt.value) + ".encode())\n"
+			if got := run(t, "python3", script); string(got) != tt.value {
+				t.Errorf("python %s = %q, want %q", pyQuote(tt.value), got, tt.value)
+			}
+		})
+	}
+}
+
+func TestPsQuote(t *testing.T) {
+	for _, tt := range awkward {
+		quoted := psQuote(tt.value)
+		// a verbatim string ends at the first lone '
+		inner := quoted[1 : len(quoted)-1]
+		if !strings.HasPrefix(quoted, "'") || !strings.HasSuffix(quoted, "'") || strings.Contains(strings.ReplaceAll(inner, "''", ""), "'") {
+			t.Errorf("psQuote(%q) = %s is not one verbatim string", tt.value, quoted)
+			continue
+		}
+		if got := strings.ReplaceAll(inner, "''", "'"); got != tt.value {
+			t.Errorf("psQuote(%q) = %s, reads back as %q", tt.value, quoted, got)
+		}
+	}
+}
+
+// exportedRequest is a stored POST to https://example.com with body and a
+// header and a cookie holding value.
+func exportedRequest(value string, body string) *RequestResponse {
+	raw := "POST /p?q=1 HTTP/1.1\r\nHost: example.com\r\nX-Value: " + value + "\r\nCookie: a=" + value + "; b=2\r\n" +
+		"Content-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + body
+	return &RequestResponse{Request: Request{Method: "POST", Raw: raw, Scheme: "https", Host: "example.com", Port: 443, IsHTTPS: true}}
+}
+
+// awkwardHeaders are the values in awkward a header can hold.
+func awkwardHeaders() []string {
+	var res []string
+	for _, tt := range awkward {
+		if !strings.ContainsAny(tt.value, "\x00\r\n\x01\x1b\x7f") && tt.value != "" && strings.TrimSpace(tt.value) == tt.value {
+			res = append(res, tt.value)
+		}
+	}
+	return res
+}
+
+func TestExportCurlRoundTrip(t *testing.T) {
+	values := awkwardHeaders()
+	for i, tt := range awkward {
+		t.Run(tt.name, func(t *testing.T) {
+			value := values[i%len(values)]
+			out, err := Export(exportedRequest(value, tt.value), "curl")
+			if err != nil {
+				t.Fatal(err)
+			}
+			items := ParseCurl(out, nil)
+			if len(items) != 1 || items[0].Err != nil {
+				t.Fatalf("ParseCurl(%q) = %+v", out, items)
+			}
+			req := items[0].Exchange.Request
+			checkImported(t, req.Method, req.URL, req.Raw, curlWant{
+				method:  "POST",
+				url:     "https://example.com/p?q=1",
+				headers: []string{"X-Value: " + value, "Cookie: a=" + value + "; b=2"},
+				body:    tt.value,
+			})
+
+			// the shell passes the same arguments and stdin to curl
+			args, stdin := splitStandIn(t, run(t, "bash", standIn("curl")+out+" </dev/null"))
+			cmds := splitShell(out)
+			words := cmds[len(cmds)-1].words
+			if !equalStrings(args, words[1:]) {
+				t.Errorf("bash passed %q to curl, splitShell read %q", args, words[1:])
+			}
+			if cmds[len(cmds)-1].stdin != nil && string(stdin) != string(*cmds[len(cmds)-1].stdin) {
+				t.Errorf("bash piped %q to curl, pipedOutput read %q", stdin, *cmds[len(cmds)-1].stdin)
+			}
+		})
+	}
+}
+
+// standIn is a shell function named name writing the number of its
+// arguments, the arguments ended by NUL and then its stdin.
+func standIn(name string) string {
+	return name + "() { printf '%s\\n' $#; printf '%s\\0' \"$@\"; cat; }\n"
+}
+
+// splitStandIn splits the output of standIn into the arguments and stdin.
+func splitStandIn(t *testing.T, out []byte) ([]string, []byte) {
+	i := bytes.IndexByte(out, '\n')
+	if i < 0 {
+		t.Fatalf("stand-in output %q has no count", out)
+	}
+	n, err := strconv.Atoi(string(out[:i]))
+	if err != nil {
+		t.Fatalf("stand-in output %q: %v", out, err)
+	}
+	out = out[i+1:]
+	args := make([]string, n)
+	for j := range args {
+		k := bytes.IndexByte(out, 0)
+		args[j], out = string(out[:k]), out[k+1:]
+	}
+	return args, out
+}
+
+func TestExportHTTPieRoundTrip(t *testing.T) {
+	values := awkwardHeaders()
+	for i, tt := range awkward {
+		t.Run(tt.name, func(t *testing.T) {
+			value := values[i%len(values)]
+			out, err := Export(exportedRequest(value, tt.value), "httpie")
+			if err != nil {
+				t.Fatal(err)
+			}
+			args, stdin := splitStandIn(t, run(t, "bash", standIn("http")+out+" </dev/null"))
+			want := []string{"POST", "https://example.com/p?q=1", "X-Value:" + value, "Cookie:a=" + value + "; b=2"}
+			if !equalStrings(args, want) {
+				t.Errorf("http arguments = %q, want %q", args, want)
+			}
+			if string(stdin) != tt.value {
+				t.Errorf("http stdin = %q, want %q", stdin, tt.value)
+			}
+		})
+	}
+}
+
+func TestExportRaw(t *testing.T) {
+	for _, tt := range awkward {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := Export(exportedRequest("v", tt.value), "raw")
+			if err != nil {
+				t.Fatal(err)
+			}
+			httpReq, body, err := parseRaw(out)
+			if err != nil {
+				t.Fatalf("parseRaw(%q): %v", out, err)
+			}
+			if httpReq.Method != "POST" || httpReq.RequestURI != "/p?q=1" || httpReq.Host != "example.com" {
+				t.Errorf("request line of %q = %s %s, Host %s", out, httpReq.Method, httpReq.RequestURI, httpReq.Host)
+			}
+			if string(body) != tt.value || httpReq.ContentLength != int64(len(tt.value)) {
+				t.Errorf("body = %q with Content-Length %d, want %q", body, httpReq.ContentLength, tt.value)
+			}
+			if got := httpReq.Header.Get("X-Value"); got != "v" {
+				t.Errorf("X-Value = %q", got)
+			}
+		})
+	}
+}
+
+func TestExportPrograms(t *testing.T) {
+	for _, tt := range awkward {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := Export(exportedRequest("it's", tt.value), "go-nethttp")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err = parser.ParseFile(token.NewFileSet(), "main.go", out, 0); err != nil {
+				t.Errorf("go-nethttp export does not parse: %v\n%s", err, out)
+			}
+			if tt.value != "" && !strings.Contains(out, strconv.Quote(tt.value)) {
+				t.Errorf("go-nethttp export lost the body %q:\n%s", tt.value, out)
+			}
+
+			out, err = Export(exportedRequest("it's", tt.value), "powershell")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(out, "'X-Value' = 'it''s'") {
+				t.Errorf("powershell export lost the header:\n%s", out)
+			}
+			if tt.value != "" && utf8.ValidString(tt.value) && !strings.Contains(out, "$body = "+psQuote(tt.value)+"\n") {
+				t.Errorf("powershell export lost the body %q:\n%s", tt.value, out)
+			}
+			if !utf8.ValidString(tt.value) && !strings.Contains(out, "$body = [byte[]](0xff, 0xfe,") {
+				t.Errorf("powershell export of a binary body is not bytes:\n%s", out)
+			}
+
+			out, err = Export(exportedRequest("it's", tt.value), "python-requests")
+			if err != nil {
+				t.Fatal(err)
+			}
+			script := "import sys, types\nrequests = types.SimpleNamespace()\n" +
+				"def request(method, url, headers=None, cookies=None, data=b''):\n" +
+				"    out = sys.stdout.buffer\n" +
+				"    out.write((method + ' ' + url + ' ' + headers['X-Value'] + ' ' + cookies['a'] + '\\n').encode())\n" +
+				"    out.write(data if isinstance(data, bytes) else data.encode('utf-8', 'surrogateescape'))\n" +
+				"    sys.exit(0)\n" +
+				"requests.request = request\nsys.modules['requests'] = requests\n" + out
+			want := "POST https://example.com/p?q=1 it's it's\n" + tt.value
+			if got := run(t, "python3", script); string(got) != want {
+				t.Errorf("python-requests export sends %q, want %q", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,522 @@
+package repeater
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	proxyserver "github.com/iiivan-lemon/technopark_proxy/internal/proxyServer"
+	"github.com/pkg/errors"
+)
+
+// HAR is an HTTP Archive 1.2, http://www.softwareishard.com/blog/har-12-spec/.
+type HAR struct {
+	Log HARLog `json:"log"`
+}
+
+type HARLog struct {
+	Version string      `json:"version"`
+	Creator HARCreator  `json:"creator"`
+	Entries []*HAREntry `json:"entries"`
+}
+
+type HARCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// HAREntry is an exchange. Comment carries the note of the request,
+// _color and _tags the rest of its annotation, _id the request itself.
+// Time is in milliseconds.
+type HAREntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         HARRequest  `json:"request"`
+	Response        HARResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         HARTimings  `json:"timings"`
+	ServerIPAddress string      `json:"serverIPAddress,omitempty"`
+	Comment         string      `json:"comment,omitempty"`
+	ID              int64       `json:"_id,omitempty"`
+	Color           string      `json:"_color,omitempty"`
+	Tags            []string    `json:"_tags,omitempty"`
+}
+
+type HARRequest struct {
+	Method      string       `json:"method"`
+	URL         string       `json:"url"`
+	HTTPVersion string       `json:"httpVersion"`
+	Cookies     []HARCookie  `json:"cookies"`
+	Headers     []HARPair    `json:"headers"`
+	QueryString []HARPair    `json:"queryString"`
+	PostData    *HARPostData `json:"postData,omitempty"`
+	HeadersSize int64        `json:"headersSize"`
+	BodySize    int64        `json:"bodySize"`
+}
+
+type HARResponse struct {
+	Status      int         `json:"status"`
+	StatusText  string      `json:"statusText"`
+	HTTPVersion string      `json:"httpVersion"`
+	Cookies     []HARCookie `json:"cookies"`
+	Headers     []HARPair   `json:"headers"`
+	Content     HARContent  `json:"content"`
+	RedirectURL string      `json:"redirectURL"`
+	HeadersSize int64       `json:"headersSize"`
+	BodySize    int64       `json:"bodySize"`
+}
+
+type HARPair struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type HARCookie struct {
+	Name     string `json:"name"`
+	Value    string `json:"value"`
+	Path     string `json:"path,omitempty"`
+	Domain   string `json:"domain,omitempty"`
+	Expires  string `json:"expires,omitempty"`
+	HTTPOnly bool   `json:"httpOnly,omitempty"`
+	Secure   bool   `json:"secure,omitempty"`
+}
+
+// HARPostData is the request body, Encoding is base64 for a body that
+// is not text, as in HARContent.
+type HARPostData struct {
+	MimeType string     `json:"mimeType"`
+	Text     string     `json:"text"`
+	Params   []HARParam `json:"params,omitempty"`
+	Encoding string     `json:"encoding,omitempty"`
+}
+
+type HARParam struct {
+	Name        string `json:"name"`
+	Value       string `json:"value,omitempty"`
+	FileName    string `json:"fileName,omitempty"`
+	ContentType string `json:"contentType,omitempty"`
+}
+
+// HARContent is the decoded response body.
+type HARContent struct {
+	Size        int64  `json:"size"`
+	Compression int64  `json:"compression,omitempty"`
+	MimeType    string `json:"mimeType"`
+	Text        string `json:"text,omitempty"`
+	Encoding    string `json:"encoding,omitempty"`
+}
+
+// HARTimings are in milliseconds, -1 for the phases that did not happen.
+// Connect includes SSL.
+type HARTimings struct {
+	Blocked float64 `json:"blocked"`
+	DNS     float64 `json:"dns"`
+	Connect float64 `json:"connect"`
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+	SSL     float64 `json:"ssl"`
+}
+
+const harCreator = "technopark_proxy"
+
+// NewHAR returns an archive of entries.
+func NewHAR(entries []*HAREntry) *HAR {
+	if entries == nil {
+		entries = []*HAREntry{}
+	}
+	return &HAR{Log: HARLog{
+		Version: "1.2",
+		Creator: HARCreator{Name: harCreator, Version: "1.0"},
+		Entries: entries,
+	}}
+}
+
+// ParseHAR reads an archive, it has to have the log with the entries.
+func ParseHAR(data []byte) (*HAR, error) {
+	var har struct {
+		Log *struct {
+			Entries []*HAREntry `json:"entries"`
+		} `json:"log"`
+	}
+	if err := json.Unmarshal(data, &har); err != nil {
+		return nil, err
+	}
+	if har.Log == nil || har.Log.Entries == nil {
+		return nil, errors.New("no log.entries")
+	}
+	return NewHAR(har.Log.Entries), nil
+}
+
+// NewHAREntry returns req with resp, its response, as an entry. resp
+// is decoded, see Response.Decode, and nil when there is no response.
+func NewHAREntry(req *RequestResponse, resp *Response) (*HAREntry, error) {
+	e, err := newExported(req)
+	if err != nil {
+		return nil, err
+	}
+	head := req.Raw
+	if i := strings.Index(head, "\r\n\r\n"); i >= 0 {
+		head = head[:i+4]
+	} else if i = strings.Index(head, "\n\n"); i >= 0 {
+		head = head[:i+2]
+	}
+	proto := req.Proto
+	if proto == "" {
+		proto = "HTTP/1.1"
+	}
+
+	entry := &HAREntry{
+		ID: req.ID,
+		Request: HARRequest{
+			Method:      e.method,
+			URL:         e.url,
+			HTTPVersion: proto,
+			Cookies:     []HARCookie{},
+			Headers:     rawHeaders(head),
+			QueryString: []HARPair{},
+			HeadersSize: int64(len(head)),
+			BodySize:    int64(len(e.body)),
+		},
+		Timings: HARTimings{Blocked: -1, DNS: -1, Connect: -1, SSL: -1},
+	}
+	if req.StartedAt != nil {
+		entry.StartedDateTime = req.StartedAt.UTC().Format(time.RFC3339Nano)
+	}
+	if req.Annotation != nil {
+		entry.Comment, entry.Color, entry.Tags = req.Annotation.Note, req.Annotation.Color, req.Annotation.Tags
+	}
+	// the proxy records requests in the absolute form, without Host
+	if pairValue(entry.Request.Headers, "Host") == "" {
+		host := e.host
+		if u, err := url.Parse(e.url); err == nil && host == "" {
+			host = u.Host
+		}
+		entry.Request.Headers = append([]HARPair{{Name: "Host", Value: host}}, entry.Request.Headers...)
+	}
+	for _, c := range e.cookies {
+		entry.Request.Cookies = append(entry.Request.Cookies, HARCookie{Name: c[0], Value: c[1]})
+	}
+	if u, err := url.Parse(e.url); err == nil && u.RawQuery != "" {
+		entry.Request.QueryString = queryPairs(u.RawQuery)
+	}
+	if len(e.body) > 0 {
+		post := &HARPostData{MimeType: pairValue(entry.Request.Headers, "Content-Type")}
+		post.Text, post.Encoding = harText(e.body)
+		if post.Encoding == "" && strings.HasPrefix(strings.ToLower(post.MimeType), "application/x-www-form-urlencoded") {
+			for _, p := range queryPairs(string(e.body)) {
+				post.Params = append(post.Params, HARParam{Name: p.Name, Value: p.Value})
+			}
+		}
+		entry.Request.PostData = post
+	}
+
+	if resp == nil {
+		entry.Response = HARResponse{
+			Cookies:     []HARCookie{},
+			Headers:     []HARPair{},
+			Content:     HARContent{MimeType: "x-unknown"},
+			HeadersSize: -1,
+			BodySize:    -1,
+		}
+		return entry, nil
+	}
+	entry.Response = harResponse(resp, proto)
+	entry.ServerIPAddress = resp.Timing.RemoteIP
+	entry.setTimings(&resp.Timing)
+	return entry, nil
+}
+
+func harResponse(resp *Response, proto string) HARResponse {
+	res := HARResponse{
+		Status:      resp.Code,
+		StatusText:  strings.TrimSpace(strings.TrimPrefix(resp.Message, strconv.Itoa(resp.Code))),
+		HTTPVersion: proto,
+		Cookies:     []HARCookie{},
+		Headers:     []HARPair{},
+		RedirectURL: resp.header("Location"),
+		HeadersSize: int64(len(resp.head())),
+		BodySize:    resp.Timing.ResponseSize,
+	}
+	names := make([]string, 0, len(resp.Headers))
+	for name := range resp.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	header := http.Header{}
+	for _, name := range names {
+		for _, value := range headerValues(resp.Headers[name]) {
+			res.Headers = append(res.Headers, HARPair{Name: name, Value: value})
+			header.Add(name, value)
+		}
+	}
+	for _, c := range (&http.Response{Header: header}).Cookies() {
+		cookie := HARCookie{Name: c.Name, Value: c.Value, Path: c.Path, Domain: c.Domain, HTTPOnly: c.HttpOnly, Secure: c.Secure}
+		if !c.Expires.IsZero() {
+			cookie.Expires = c.Expires.UTC().Format(time.RFC3339)
+		}
+		res.Cookies = append(res.Cookies, cookie)
+	}
+
+	body := []byte(resp.Body)
+	if resp.BodyBase64 {
+		body, _ = base64.StdEncoding.DecodeString(resp.Body)
+	}
+	res.Content = HARContent{Size: int64(len(body)), MimeType: resp.header("Content-Type")}
+	res.Content.Text, res.Content.Encoding = harText(body)
+	if res.Content.MimeType == "" {
+		res.Content.MimeType = "x-unknown"
+	}
+	if compression := res.Content.Size - res.BodySize; compression > 0 {
+		res.Content.Compression = compression
+	}
+	return res
+}
+
+// setTimings converts t, where TTFB and Total are counted from the
+// start, into the phases of HAR.
+func (e *HAREntry) setTimings(t *Timing) {
+	ms := func(us int64) float64 { return float64(us) / 1000 }
+	phase := func(us int64) float64 {
+		if us <= 0 {
+			return -1
+		}
+		return ms(us)
+	}
+	e.Time = ms(t.Total)
+	e.Timings.DNS = phase(t.DNS)
+	e.Timings.Connect = phase(t.Connect + t.TLS)
+	e.Timings.SSL = phase(t.TLS)
+	if wait := t.TTFB - t.DNS - t.Connect - t.TLS; wait > 0 {
+		e.Timings.Wait = ms(wait)
+	}
+	if receive := t.Total - t.TTFB; t.TTFB > 0 && receive > 0 {
+		e.Timings.Receive = ms(receive)
+	}
+}
+
+// Exchange returns the entry as the proxy records exchanges, see
+// importedRequest. Response is nil when the entry has none.
+func (e *HAREntry) Exchange() (*proxyserver.Exchange, error) {
+	u, err := url.Parse(e.Request.URL)
+	if err != nil {
+		return nil, errors.New("request.url should be an absolute http or https URL")
+	}
+	startedAt, err := time.Parse(time.RFC3339Nano, e.StartedDateTime)
+	if err != nil {
+		return nil, errors.New("startedDateTime should be in ISO 8601")
+	}
+	body, err := e.Request.body()
+	if err != nil {
+		return nil, err
+	}
+
+	headers := make([][2]string, 0, len(e.Request.Headers)+1)
+	for _, h := range e.Request.Headers {
+		headers = append(headers, [2]string{h.Name, h.Value})
+	}
+	if _, ok := lastHeader(headers, "Cookie"); !ok && len(e.Request.Cookies) > 0 {
+		pairs := make([]string, len(e.Request.Cookies))
+		for i, c := range e.Request.Cookies {
+			pairs[i] = c.Name + "=" + c.Value
+		}
+		headers = append(headers, [2]string{"Cookie", strings.Join(pairs, "; ")})
+	}
+	req, err := importedRequest(e.Request.Method, u, headers, body)
+	if err != nil {
+		return nil, errors.Wrap(err, "request")
+	}
+	req.StartedAt = startedAt
+	req.Proto = harProto(e.Request.HTTPVersion)
+	exchange := &proxyserver.Exchange{Request: req}
+
+	if e.Response.Status <= 0 {
+		return exchange, nil
+	}
+	resp, err := e.response()
+	if err != nil {
+		return nil, err
+	}
+	resp.Timing.StartedAt = startedAt
+	resp.IsHTTPS = req.IsHTTPS
+	exchange.Response = resp
+	return exchange, nil
+}
+
+func (r *HARRequest) body() ([]byte, error) {
+	if r.PostData == nil {
+		return nil, nil
+	}
+	if r.PostData.Text == "" && len(r.PostData.Params) > 0 {
+		pairs := make([]string, len(r.PostData.Params))
+		for i, p := range r.PostData.Params {
+			pairs[i] = url.QueryEscape(p.Name) + "=" + url.QueryEscape(p.Value)
+		}
+		return []byte(strings.Join(pairs, "&")), nil
+	}
+	return harData(r.PostData.Text, r.PostData.Encoding, "request.postData")
+}
+
+func (e *HAREntry) response() (*proxyserver.Response, error) {
+	r := &e.Response
+	body, err := harData(r.Content.Text, r.Content.Encoding, "response.content")
+	if err != nil {
+		return nil, err
+	}
+	statusText := r.StatusText
+	if statusText == "" {
+		statusText = http.StatusText(r.Status)
+	}
+	header := http.Header{}
+	for _, h := range r.Headers {
+		if h.Name == "" || strings.HasPrefix(h.Name, ":") {
+			continue
+		}
+		header.Add(h.Name, h.Value)
+	}
+	header.Del("Cookie")
+	if len(body) > 0 {
+		// content.text is the decoded body, the encoding and the length
+		// of the body received do not apply to it
+		header.Del("Content-Encoding")
+		header.Del("Content-Length")
+	}
+	headers := make(proxyserver.Map, len(header))
+	for name, values := range header {
+		if len(values) == 1 {
+			headers[name] = values[0]
+		} else {
+			headers[name] = values
+		}
+	}
+
+	t := &e.Timings
+	ms := func(value float64) time.Duration {
+		if value <= 0 {
+			return 0
+		}
+		return time.Duration(value * float64(time.Millisecond))
+	}
+	ttfb := ms(t.Blocked) + ms(t.DNS) + ms(t.Connect) + ms(t.Send) + ms(t.Wait)
+	total := ms(e.Time)
+	if total == 0 {
+		total = ttfb + ms(t.Receive)
+	}
+	// bodySize is what was received, -1 when unknown
+	size := int64(len(body))
+	if r.BodySize >= 0 {
+		size = r.BodySize
+	}
+	return &proxyserver.Response{
+		Code:    r.Status,
+		Message: strconv.Itoa(r.Status) + " " + statusText,
+		Headers: headers,
+		Body:    string(body),
+		Size:    size,
+		Timing: proxyserver.Timing{
+			DNS:     ms(t.DNS),
+			Connect: ms(t.Connect) - ms(t.SSL),
+			TLS:     ms(t.SSL),
+			TTFB:    ttfb,
+			Total:   total,
+		},
+		RemoteIP: strings.Trim(e.ServerIPAddress, "[]"),
+	}, nil
+}
+
+// Annotation returns what the entry carries of an annotation, nil when
+// there is nothing.
+func (e *HAREntry) Annotation() *Annotation {
+	if e.Comment == "" && e.Color == "" && len(e.Tags) == 0 {
+		return nil
+	}
+	return &Annotation{Note: e.Comment, Color: e.Color, Tags: e.Tags}
+}
+
+// harText returns data as HAR text, in base64 when it is not UTF-8.
+func harText(data []byte) (string, string) {
+	if utf8.Valid(data) {
+		return string(data), ""
+	}
+	return base64.StdEncoding.EncodeToString(data), "base64"
+}
+
+func harData(text, encoding, field string) ([]byte, error) {
+	switch encoding {
+	case "":
+		return []byte(text), nil
+	case "base64":
+		data, err := base64.StdEncoding.DecodeString(text)
+		if err != nil {
+			return nil, errors.Errorf("%s.text is not valid base64", field)
+		}
+		return data, nil
+	}
+	return nil, errors.Errorf("%s.encoding should be base64", field)
+}
+
+// harProto returns the version of an entry as the proxy records it,
+// browsers write h2 and h3 for HTTP/2 and HTTP/3.
+func harProto(version string) string {
+	switch strings.ToLower(version) {
+	case "h2", "http/2", "http/2.0":
+		return "HTTP/2.0"
+	case "h3", "http/3", "http/3.0":
+		return "HTTP/3.0"
+	case "http/1.0":
+		return "HTTP/1.0"
+	}
+	return "HTTP/1.1"
+}
+
+// rawHeaders returns the headers of a raw request in their order.
+func rawHeaders(head string) []HARPair {
+	pairs := []HARPair{}
+	lines := strings.Split(strings.ReplaceAll(head, "\r\n", "\n"), "\n")
+	for _, line := range lines[1:] {
+		i := strings.IndexByte(line, ':')
+		if i <= 0 {
+			continue
+		}
+		pairs = append(pairs, HARPair{Name: line[:i], Value: strings.TrimSpace(line[i+1:])})
+	}
+	return pairs
+}
+
+// queryPairs splits a query, or a form, keeping the order of the pairs.
+func queryPairs(query string) []HARPair {
+	pairs := []HARPair{}
+	for _, pair := range strings.Split(query, "&") {
+		if pair == "" {
+			continue
+		}
+		name, value := pair, ""
+		if i := strings.IndexByte(pair, '='); i >= 0 {
+			name, value = pair[:i], pair[i+1:]
+		}
+		pairs = append(pairs, HARPair{Name: unescape(name), Value: unescape(value)})
+	}
+	return pairs
+}
+
+func unescape(s string) string {
+	if u, err := url.QueryUnescape(s); err == nil {
+		return u
+	}
+	return s
+}
+
+func pairValue(pairs []HARPair, name string) string {
+	for _, p := range pairs {
+		if strings.EqualFold(p.Name, name) {
+			return p.Value
+		}
+	}
+	return ""
+}
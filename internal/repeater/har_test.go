@@ -0,0 +1,136 @@
+package repeater
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	proxyserver "github.com/iiivan-lemon/technopark_proxy/internal/proxyServer"
+)
+
+func TestHARRoundTrip(t *testing.T) {
+	startedAt := time.Date(2024, 5, 1, 10, 20, 30, 123000000, time.UTC)
+	post := &RequestResponse{
+		ID: 1,
+		Request: Request{
+			Method: "POST",
+			Raw: "POST /login?next=%2Fhome&a=1 HTTP/1.1\r\nHost: example.com\r\nContent-Type: application/x-www-form-urlencoded\r\n" +
+				"Cookie: sid=abc; theme=dark\r\nX-Trace: 1\r\nContent-Length: 21\r\n\r\nuser=ann&pass=p%40ss!",
+			Scheme: "https", Host: "example.com", Port: 443, IsHTTPS: true,
+			StartedAt: &startedAt,
+			Proto:     "HTTP/2.0",
+		},
+		Annotation: &Annotation{Note: "login", Color: "red", Tags: []string{"auth", "todo"}},
+	}
+	resp := &Response{
+		ID:      7,
+		Code:    302,
+		Message: "302 Found",
+		Headers: Map{
+			"Content-Type": "application/octet-stream",
+			"Location":     "/home",
+			"Set-Cookie":   []interface{}{"sid=def; Path=/; HttpOnly", "theme=light"},
+		},
+		Body: "\x00\x01\xffbinary",
+		Timing: Timing{
+			DNS: 1000, Connect: 2000, TLS: 3000, TTFB: 10000, Total: 12500,
+			ResponseSize: 9, RemoteIP: "2001:db8::1",
+		},
+	}
+	resp.Decode()
+	get := &RequestResponse{
+		ID: 2,
+		Request: Request{
+			Method:    "GET",
+			Raw:       "GET /plain HTTP/1.1\r\nHost: example.com:8080\r\n\r\n",
+			Scheme:    "http",
+			Host:      "example.com",
+			Port:      8080,
+			StartedAt: &startedAt,
+		},
+	}
+
+	var entries []*HAREntry
+	for _, pair := range []struct {
+		req  *RequestResponse
+		resp *Response
+	}{{post, resp}, {get, nil}} {
+		entry, err := NewHAREntry(pair.req, pair.resp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, entry)
+	}
+	data, err := json.Marshal(NewHAR(entries))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	items, err := ParseImport("har", data, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 {
+		t.Fatalf("ParseImport returned %d items, want 2", len(items))
+	}
+	for _, item := range items {
+		if item.Err != nil {
+			t.Fatal(item.Err)
+		}
+	}
+
+	req := items[0].Exchange.Request
+	checkImported(t, req.Method, req.URL, req.Raw, curlWant{
+		method:  "POST",
+		url:     "https://example.com/login?next=%2Fhome&a=1",
+		headers: []string{"Host: example.com", "Content-Type: application/x-www-form-urlencoded", "Cookie: sid=abc; theme=dark", "X-Trace: 1", "Content-Length: 21"},
+		body:    "user=ann&pass=p%40ss!",
+	})
+	if !req.StartedAt.Equal(startedAt) || req.Proto != "HTTP/2.0" {
+		t.Errorf("request started at %v over %s, want %v over HTTP/2.0", req.StartedAt, req.Proto, startedAt)
+	}
+	if a := items[0].Annotation; a == nil || a.Note != "login" || a.Color != "red" || !equalStrings(a.Tags, []string{"auth", "todo"}) {
+		t.Errorf("annotation = %+v", a)
+	}
+
+	got := items[0].Exchange.Response
+	if got == nil {
+		t.Fatal("the response was lost")
+	}
+	if got.Code != 302 || got.Message != "302 Found" || got.Body != "\x00\x01\xffbinary" || got.Size != 9 || got.RemoteIP != "2001:db8::1" {
+		t.Errorf("response = %d %q, body %q of %d bytes from %s", got.Code, got.Message, got.Body, got.Size, got.RemoteIP)
+	}
+	if location, _ := got.Headers["Location"].(string); location != "/home" {
+		t.Errorf("Location = %v", got.Headers["Location"])
+	}
+	if cookies, _ := got.Headers["Set-Cookie"].([]string); len(cookies) != 2 || cookies[0] != "sid=def; Path=/; HttpOnly" {
+		t.Errorf("Set-Cookie = %v", got.Headers["Set-Cookie"])
+	}
+	want := proxyserver.Timing{
+		StartedAt: startedAt,
+		DNS:       time.Millisecond, Connect: 2 * time.Millisecond, TLS: 3 * time.Millisecond,
+		TTFB: 10 * time.Millisecond, Total: 12500 * time.Microsecond,
+	}
+	if got.Timing != want {
+		t.Errorf("timing = %+v, want %+v", got.Timing, want)
+	}
+
+	plain := items[1].Exchange
+	checkImported(t, plain.Request.Method, plain.Request.URL, plain.Request.Raw, curlWant{method: "GET", url: "http://example.com:8080/plain"})
+	if plain.Response != nil || items[1].Annotation != nil {
+		t.Errorf("request without a response came back with %+v, %+v", plain.Response, items[1].Annotation)
+	}
+}
+
+func TestParseHARErrors(t *testing.T) {
+	for _, data := range []string{`not json`, `{}`, `{"log": {}}`} {
+		if _, err := ParseImport("har", []byte(data), nil); err == nil {
+			t.Errorf("ParseImport(har, %s) returned no error", data)
+		}
+	}
+	items, err := ParseImport("har", []byte(`{"log": {"entries": [{"startedDateTime": "yesterday", "request": {"method": "GET", "url": "https://example.com/"}}]}}`), nil)
+	if err != nil || len(items) != 1 || items[0].Err == nil || !strings.Contains(items[0].Err.Error(), "startedDateTime") {
+		t.Errorf("ParseImport of a bad entry = %+v, %v", items, err)
+	}
+}
@@ -0,0 +1,320 @@
+package repeater
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	proxyserver "github.com/iiivan-lemon/technopark_proxy/internal/proxyServer"
+	"github.com/pkg/errors"
+)
+
+// httpFileRequest is a request of a .http file as it is written, before
+// its variables are substituted.
+type httpFileRequest struct {
+	// the text after ###, or the @name of the request
+	title   string
+	method  string
+	url     string
+	headers [][2]string
+	// the lines of the body, "< file" and "<@ file" lines are read
+	body []string
+	err  error
+}
+
+// maxVariableDepth limits the variables referring to variables.
+const maxVariableDepth = 10
+
+var httpVariable = regexp.MustCompile(`{{\s*([^{}]*?)\s*}}`)
+
+// ParseHTTPFile reads the requests of a .http file of JetBrains IDEs or
+// VS Code REST Client. The requests are separated by ### lines, the text
+// after ### or the # @name of a request becomes its note. The {{variables}}
+// are the @name = value lines of the file, then opts.Variables, then the
+// dynamic ones: $uuid, $timestamp, $isoTimestamp and $randomInt. Response
+// handlers and references are left out.
+func ParseHTTPFile(text string, opts *ImportOptions) []Imported {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	vars := make(map[string]string, len(opts.variables()))
+	for name, value := range opts.variables() {
+		vars[name] = value
+	}
+
+	var reqs []*httpFileRequest
+	for _, block := range splitHTTPBlocks(text) {
+		if req := parseHTTPBlock(block, vars); req != nil {
+			reqs = append(reqs, req)
+		}
+	}
+
+	items := make([]Imported, 0, len(reqs))
+	for _, req := range reqs {
+		item := Imported{}
+		if req.err == nil {
+			var r *proxyserver.Request
+			r, item.Err = req.request(vars, opts)
+			if item.Err == nil {
+				item.Exchange = &proxyserver.Exchange{Request: r}
+			}
+		} else {
+			item.Err = req.err
+		}
+		if req.title != "" {
+			item.Annotation = &Annotation{Note: req.title}
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+func (o *ImportOptions) variables() map[string]string {
+	if o == nil {
+		return nil
+	}
+	return o.Variables
+}
+
+// splitHTTPBlocks splits the lines of text at the ### separators, the
+// first line of every block but the first one is its separator.
+func splitHTTPBlocks(text string) [][]string {
+	var (
+		blocks [][]string
+		block  []string
+	)
+	for _, line := range strings.Split(text, "\n") {
+		if strings.HasPrefix(line, "###") {
+			blocks = append(blocks, block)
+			block = nil
+		}
+		block = append(block, line)
+	}
+	return append(blocks, block)
+}
+
+// parseHTTPBlock reads the request of a block, nil when there is none,
+// and adds the variables the block defines to vars.
+func parseHTTPBlock(lines []string, vars map[string]string) *httpFileRequest {
+	req := &httpFileRequest{}
+	if len(lines) > 0 && strings.HasPrefix(lines[0], "###") {
+		req.title = strings.TrimSpace(strings.TrimLeft(lines[0], "#"))
+		lines = lines[1:]
+	}
+
+	i := 0
+	for ; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		if comment, ok := httpComment(line); ok {
+			if name := strings.TrimPrefix(comment, "@name"); name != comment && req.title == "" {
+				req.title = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(name), "="))
+			}
+			continue
+		}
+		if strings.HasPrefix(line, "@") {
+			if eq := strings.IndexByte(line, '='); eq > 0 {
+				vars[strings.TrimSpace(line[1:eq])] = strings.TrimSpace(line[eq+1:])
+			}
+			continue
+		}
+		if line != "" {
+			break
+		}
+	}
+	if i == len(lines) {
+		return nil
+	}
+
+	fields := strings.Fields(lines[i])
+	if n := len(fields); n > 1 && strings.HasPrefix(fields[n-1], "HTTP/") {
+		fields = fields[:n-1]
+	}
+	switch len(fields) {
+	case 1:
+		req.method, req.url = "GET", fields[0]
+	case 2:
+		req.method, req.url = fields[0], fields[1]
+	default:
+		req.err = errors.Errorf("bad request line %q", lines[i])
+		return req
+	}
+	// the query may go on in the next lines
+	for i++; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		if !strings.HasPrefix(line, "?") && !strings.HasPrefix(line, "&") {
+			break
+		}
+		req.url += line
+	}
+
+	for ; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		if line == "" {
+			i++
+			break
+		}
+		if _, ok := httpComment(line); ok {
+			continue
+		}
+		colon := strings.IndexByte(line, ':')
+		if colon <= 0 {
+			req.err = errors.Errorf("bad header %q", line)
+			return req
+		}
+		req.headers = append(req.headers, [2]string{strings.TrimSpace(line[:colon]), strings.TrimSpace(line[colon+1:])})
+	}
+
+	for ; i < len(lines); i++ {
+		line := lines[i]
+		// the response handler and the response reference end the body
+		if strings.HasPrefix(line, "> ") || strings.HasPrefix(line, ">>") || strings.HasPrefix(line, "<> ") {
+			break
+		}
+		req.body = append(req.body, line)
+	}
+	for len(req.body) > 0 && strings.TrimSpace(req.body[len(req.body)-1]) == "" {
+		req.body = req.body[:len(req.body)-1]
+	}
+	return req
+}
+
+// httpComment returns the text of a # or // comment line.
+func httpComment(line string) (string, bool) {
+	switch {
+	case strings.HasPrefix(line, "#"):
+		return strings.TrimSpace(line[1:]), true
+	case strings.HasPrefix(line, "//"):
+		return strings.TrimSpace(line[2:]), true
+	}
+	return "", false
+}
+
+// request returns the request with its variables substituted.
+func (r *httpFileRequest) request(vars map[string]string, opts *ImportOptions) (*proxyserver.Request, error) {
+	rawURL, err := expandHTTPVariables(r.url, vars, 0)
+	if err != nil {
+		return nil, err
+	}
+	headers := make([][2]string, len(r.headers))
+	for i, h := range r.headers {
+		headers[i][0] = h[0]
+		if headers[i][1], err = expandHTTPVariables(h[1], vars, 0); err != nil {
+			return nil, err
+		}
+	}
+
+	var body strings.Builder
+	for i, line := range r.body {
+		if i > 0 {
+			body.WriteByte('\n')
+		}
+		switch {
+		case strings.HasPrefix(line, "<@ ") || strings.HasPrefix(line, "< "):
+			data, err := opts.readFile(strings.TrimSpace(line[strings.IndexByte(line, ' '):]))
+			if err != nil {
+				return nil, err
+			}
+			text := string(data)
+			if strings.HasPrefix(line, "<@") {
+				if text, err = expandHTTPVariables(text, vars, 0); err != nil {
+					return nil, err
+				}
+			}
+			body.WriteString(text)
+		default:
+			text, err := expandHTTPVariables(line, vars, 0)
+			if err != nil {
+				return nil, err
+			}
+			body.WriteString(text)
+		}
+	}
+
+	// a path is sent to the Host header, a URL without a scheme over http
+	if strings.HasPrefix(rawURL, "/") {
+		host, ok := lastHeader(headers, "Host")
+		if !ok {
+			return nil, errors.Errorf("url %q has no host and there is no Host header", rawURL)
+		}
+		rawURL = "http://" + host + rawURL
+	} else if !strings.Contains(rawURL, "://") {
+		rawURL = "http://" + rawURL
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, errors.Errorf("bad url %q", rawURL)
+	}
+	return importedRequest(r.method, u, headers, []byte(body.String()))
+}
+
+// expandHTTPVariables substitutes the {{variables}} of s.
+func expandHTTPVariables(s string, vars map[string]string, depth int) (string, error) {
+	if depth > maxVariableDepth {
+		return "", errors.New("variables refer to each other too deep")
+	}
+	var err error
+	res := httpVariable.ReplaceAllStringFunc(s, func(match string) string {
+		if err != nil {
+			return ""
+		}
+		name := httpVariable.FindStringSubmatch(match)[1]
+		if strings.HasPrefix(name, "$") {
+			var value string
+			value, err = dynamicVariable(name)
+			return value
+		}
+		value, ok := vars[name]
+		if !ok {
+			err = errors.Errorf("variable %q is not defined", name)
+			return ""
+		}
+		var expanded string
+		expanded, err = expandHTTPVariables(value, vars, depth+1)
+		return expanded
+	})
+	return res, err
+}
+
+// dynamicVariable returns a value of a {{$variable}}, $randomInt takes
+// its bounds, 0 and 1000 by default.
+func dynamicVariable(variable string) (string, error) {
+	args := strings.Fields(variable)
+	switch args[0] {
+	case "$uuid", "$guid", "$random.uuid":
+		return newUUID()
+	case "$timestamp":
+		return strconv.FormatInt(time.Now().Unix(), 10), nil
+	case "$isoTimestamp":
+		return time.Now().UTC().Format(time.RFC3339), nil
+	case "$randomInt", "$random.integer":
+		min, max := int64(0), int64(1000)
+		if len(args) == 3 {
+			var err1, err2 error
+			min, err1 = strconv.ParseInt(args[1], 10, 64)
+			max, err2 = strconv.ParseInt(args[2], 10, 64)
+			if err1 != nil || err2 != nil || max <= min {
+				return "", errors.Errorf("bad bounds of %s", args[0])
+			}
+		}
+		n, err := rand.Int(rand.Reader, big.NewInt(max-min))
+		if err != nil {
+			return "", err
+		}
+		return strconv.FormatInt(min+n.Int64(), 10), nil
+	}
+	return "", errors.Errorf("unsupported dynamic variable %q", args[0])
+}
+
+// newUUID returns a random UUID, version 4.
+func newUUID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
@@ -0,0 +1,156 @@
+package repeater
+
+import (
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+func TestParseHTTPFile(t *testing.T) {
+	text := `@host = example.com
+@base = https://{{host}}
+@token = file-token
+
+### Create a user
+POST {{base}}/users HTTP/1.1
+Content-Type: application/json
+Authorization: Bearer {{token}}
+# a comment between the headers
+X-Env: {{env}}
+
+{
+  "name": "{{name}}"
+}
+
+> {% client.global.set("id", response.body.id); %}
+
+###
+# @name search
+GET {{base}}/search
+    ?q=a
+    &page=2
+
+###
+// without a method
+{{base}}/ping
+
+### By the Host header
+GET /path
+Host: {{host}}:8080
+
+### From files
+PUT https://{{host}}/upload
+Content-Type: text/plain
+
+< ./plain.txt
+<@ ./template.txt
+
+###
+`
+	opts := readFiles(map[string]string{"./plain.txt": "{{host}} as is", "./template.txt": "for {{host}}"})
+	opts.Variables = map[string]string{"env": "test", "name": "Ann", "host": "overridden.example"}
+	items := ParseHTTPFile(text, opts)
+
+	want := []struct {
+		note string
+		curlWant
+	}{
+		{"Create a user", curlWant{method: "POST", url: "https://example.com/users",
+			headers: []string{"Content-Type: application/json", "Authorization: Bearer file-token", "X-Env: test"},
+			body:    "{\n  \"name\": \"Ann\"\n}"}},
+		{"search", curlWant{method: "GET", url: "https://example.com/search?q=a&page=2"}},
+		{"", curlWant{method: "GET", url: "https://example.com/ping"}},
+		{"By the Host header", curlWant{method: "GET", url: "http://example.com:8080/path", headers: []string{"Host: example.com:8080"}}},
+		{"From files", curlWant{method: "PUT", url: "https://example.com/upload", body: "{{host}} as is\nfor example.com"}},
+	}
+	if len(items) != len(want) {
+		t.Fatalf("ParseHTTPFile returned %d requests, want %d", len(items), len(want))
+	}
+	for i, item := range items {
+		if item.Err != nil {
+			t.Errorf("request %d: %v", i, item.Err)
+			continue
+		}
+		note := ""
+		if item.Annotation != nil {
+			note = item.Annotation.Note
+		}
+		if note != want[i].note {
+			t.Errorf("request %d: note = %q, want %q", i, note, want[i].note)
+		}
+		req := item.Exchange.Request
+		checkImported(t, req.Method, req.URL, req.Raw, want[i].curlWant)
+	}
+}
+
+func TestParseHTTPFileCRLF(t *testing.T) {
+	items := ParseHTTPFile("POST https://example.com/\r\nX-A: 1\r\n\r\nline 1\r\nline 2\r\n", nil)
+	if len(items) != 1 || items[0].Err != nil {
+		t.Fatalf("ParseHTTPFile = %+v", items)
+	}
+	req := items[0].Exchange.Request
+	checkImported(t, req.Method, req.URL, req.Raw, curlWant{method: "POST", url: "https://example.com/", headers: []string{"X-A: 1"}, body: "line 1\nline 2"})
+}
+
+func TestParseHTTPFileErrors(t *testing.T) {
+	tests := []struct {
+		name, text string
+	}{
+		{"undefined variable", "GET https://example.com/{{missing}}"},
+		{"undefined variable in a header", "GET https://example.com/\nX-A: {{missing}}"},
+		{"variables referring to each other", "@a = {{b}}\n@b = {{a}}\nGET https://example.com/{{a}}"},
+		{"bad request line", "GET https://example.com/ a b"},
+		{"bad header", "GET https://example.com/\nnot a header"},
+		{"bad header name", "GET https://example.com/\nBad Name: 1"},
+		{"path without a host", "GET /path"},
+		{"bad method", "GE(T https://example.com/"},
+		{"bad url", "GET https://exa mple.com/"},
+		{"unknown dynamic variable", "GET https://example.com/{{$nope}}"},
+		{"bad randomInt bounds", "GET https://example.com/{{$randomInt 5 1}}"},
+		{"missing file", "POST https://example.com/\n\n< ./missing.txt"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			items := ParseHTTPFile(tt.text, readFiles(nil))
+			if len(items) != 1 || items[0].Err == nil {
+				t.Errorf("ParseHTTPFile(%q) = %+v, want one error", tt.text, items)
+			}
+		})
+	}
+
+	// a bad request does not hide the others
+	items := ParseHTTPFile("GET https://example.com/1\n###\nGET https://example.com/{{missing}}\n###\nGET https://example.com/3", nil)
+	if len(items) != 3 || items[0].Err != nil || items[1].Err == nil || items[2].Err != nil {
+		t.Errorf("ParseHTTPFile = %+v, want the second request to fail", items)
+	}
+}
+
+func TestParseHTTPFileTruncated(t *testing.T) {
+	text := "@a = {{$uuid}}\n### t\nPOST https://example.com/{{a}}\n  ?x={{$randomInt 1 3}}\nHost: h\n\n<@ ./f\n> {% %}\n###\n/p"
+	opts := readFiles(map[string]string{"./f": "{{a}}"})
+	for i := 0; i <= len(text); i++ {
+		ParseHTTPFile(text[:i], opts)
+	}
+}
+
+func TestDynamicVariables(t *testing.T) {
+	uuid, err := dynamicVariable("$uuid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`).MatchString(uuid) {
+		t.Errorf("$uuid = %q, want a version 4 UUID", uuid)
+	}
+	for i := 0; i < 20; i++ {
+		value, err := dynamicVariable("$randomInt 5 7")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n, err := strconv.Atoi(value); err != nil || n < 5 || n >= 7 {
+			t.Errorf("$randomInt 5 7 = %q, want 5 or 6", value)
+		}
+	}
+	if _, err = dynamicVariable("$timestamp"); err != nil {
+		t.Error(err)
+	}
+}
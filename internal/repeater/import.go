@@ -0,0 +1,221 @@
+package repeater
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	proxyserver "github.com/iiivan-lemon/technopark_proxy/internal/proxyServer"
+	"github.com/iiivan-lemon/technopark_proxy/internal/utils/redact"
+	"github.com/pkg/errors"
+)
+
+// ImportFormats are the files requests are imported from: HAR, curl
+// commands and .http files of JetBrains IDEs and VS Code REST Client.
+var ImportFormats = []string{"har", "curl", "http"}
+
+// IsImportFormat reports whether format is one of ImportFormats.
+func IsImportFormat(format string) bool {
+	for _, f := range ImportFormats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+// ErrNothingToImport is returned for a file without a single request.
+var ErrNothingToImport = errors.New("no requests to import")
+
+// Imported is a request read from an imported file, Err when it could
+// not be read. Annotation is what the file says about the request.
+type Imported struct {
+	Exchange   *proxyserver.Exchange
+	Annotation *Annotation
+	Err        error
+}
+
+// ImportOptions are what a file may refer to besides itself.
+type ImportOptions struct {
+	// values of the {{variables}} of .http files, the file's own take precedence
+	Variables map[string]string
+	// reads the files the requests refer to, as curl -d @file does;
+	// they are refused when it is nil
+	ReadFile func(name string) ([]byte, error)
+}
+
+func (o *ImportOptions) readFile(name string) ([]byte, error) {
+	if o == nil || o.ReadFile == nil {
+		return nil, errors.Errorf("cannot read %s, files are read only by the import command", name)
+	}
+	data, err := o.ReadFile(name)
+	return data, errors.Wrapf(err, "reading %s", name)
+}
+
+// ParseImport reads the requests of a file in format, see ImportFormats.
+func ParseImport(format string, data []byte, opts *ImportOptions) ([]Imported, error) {
+	var (
+		items []Imported
+		err   error
+	)
+	switch format {
+	case "har":
+		items, err = parseHARImport(data)
+	case "curl":
+		items = ParseCurl(string(data), opts)
+	case "http":
+		items = ParseHTTPFile(string(data), opts)
+	default:
+		return nil, errors.Errorf("unknown import format %q", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
+		return nil, ErrNothingToImport
+	}
+	return items, nil
+}
+
+func parseHARImport(data []byte) ([]Imported, error) {
+	har, err := ParseHAR(data)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]Imported, len(har.Log.Entries))
+	for i, entry := range har.Log.Entries {
+		items[i].Exchange, items[i].Err = entry.Exchange()
+		items[i].Annotation = entry.Annotation()
+	}
+	return items, nil
+}
+
+// StoreImported stores the requests of items into the session, redacted
+// as the proxy redacts what it records. The items that could not be read
+// are skipped, so are the annotations that are not valid. It stops at the
+// first request the repository refuses.
+func StoreImported(repo Repository, redactor *redact.Redactor, sessionID int64, items []Imported) (*ImportResult, error) {
+	res := &ImportResult{IDs: []int64{}}
+	for i, item := range items {
+		if item.Err != nil {
+			res.Skipped = append(res.Skipped, ImportError{Entry: i, Error: item.Err.Error()})
+			continue
+		}
+		item.Exchange.Request.SessionID = sessionID
+		item.Exchange.Request.Source = proxyserver.SourceImport
+		id, err := storeExchange(repo, redactor, item.Exchange)
+		if id != 0 {
+			res.IDs = append(res.IDs, id)
+		}
+		if err != nil {
+			res.Imported = len(res.IDs)
+			return res, err
+		}
+		if item.Annotation != nil && checkAnnotation(item.Annotation) == nil {
+			if _, err = repo.SetAnnotation(int(id), item.Annotation); err != nil {
+				res.Imported = len(res.IDs)
+				return res, errors.Wrap(err, "SetAnnotation error")
+			}
+		}
+	}
+	res.Imported = len(res.IDs)
+	return res, nil
+}
+
+// storeExchange redacts the exchange and stores it. The id is returned
+// once the request is stored, even when the response could not be.
+func storeExchange(repo Repository, redactor *redact.Redactor, exchange *proxyserver.Exchange) (int64, error) {
+	proxyserver.RedactExchange(redactor, exchange)
+	id, err := repo.InsertRequest(exchange.Request)
+	if err != nil {
+		return 0, errors.Wrap(err, "InsertRequest error")
+	}
+	if exchange.Response != nil {
+		if err = repo.InsertResponse(id, exchange.Response); err != nil {
+			return int64(id), errors.Wrap(err, "InsertResponse error")
+		}
+	}
+	return int64(id), nil
+}
+
+// importedRequest returns the request an import describes as the proxy
+// records requests, started now. The raw request is rebuilt in HTTP/1.1:
+// Host comes from the headers or from u, Content-Length follows the body.
+func importedRequest(method string, u *url.URL, headers [][2]string, body []byte) (*proxyserver.Request, error) {
+	scheme := strings.ToLower(u.Scheme)
+	if !u.IsAbs() || u.Host == "" || scheme != "http" && scheme != "https" {
+		return nil, errors.New("url should be an absolute http or https URL")
+	}
+	if !isToken(method) {
+		return nil, errors.New("method should be an HTTP method")
+	}
+
+	host, length := "", false
+	var head strings.Builder
+	for _, h := range headers {
+		switch name := strings.ToLower(h[0]); {
+		case name == ":authority", name == "host":
+			host = h[1]
+		case strings.HasPrefix(name, ":"), name == "transfer-encoding":
+			// HTTP/2 pseudo-headers, the body is not chunked any more
+		case !isToken(h[0]) || strings.ContainsAny(h[1], "\r\n"):
+			return nil, errors.Errorf("bad header %q", h[0])
+		case name == "content-length":
+			if !length {
+				head.WriteString(h[0] + ": " + strconv.Itoa(len(body)) + "\r\n")
+			}
+			length = true
+		default:
+			head.WriteString(h[0] + ": " + h[1] + "\r\n")
+		}
+	}
+	if !length && len(body) > 0 {
+		head.WriteString("Content-Length: " + strconv.Itoa(len(body)) + "\r\n")
+	}
+	if host == "" {
+		host = u.Host
+	}
+	raw := method + " " + u.RequestURI() + " HTTP/1.1\r\nHost: " + host + "\r\n" + head.String() + "\r\n" + string(body)
+
+	httpReq, _, err := parseRaw(raw)
+	if err != nil {
+		return nil, errors.Wrap(err, "request")
+	}
+	httpReq.URL.Scheme, httpReq.URL.Host = scheme, u.Host
+	setBody(httpReq, body)
+	req := proxyserver.FormRequestData(httpReq, []byte(raw))
+	req.StartedAt = time.Now()
+	req.Source = proxyserver.SourceImport
+	return req, nil
+}
+
+// lastHeader returns the value of the last header called name, false
+// when there is none.
+func lastHeader(headers [][2]string, name string) (string, bool) {
+	for i := len(headers) - 1; i >= 0; i-- {
+		if strings.EqualFold(headers[i][0], name) {
+			return headers[i][1], true
+		}
+	}
+	return "", false
+}
+
+// setHeader replaces the headers called name by one with value, in the
+// place of the first of them.
+func setHeader(headers [][2]string, name, value string) [][2]string {
+	res := headers[:0:0]
+	set := false
+	for _, h := range headers {
+		if !strings.EqualFold(h[0], name) {
+			res = append(res, h)
+		} else if !set {
+			res = append(res, [2]string{h[0], value})
+			set = true
+		}
+	}
+	if !set {
+		res = append(res, [2]string{name, value})
+	}
+	return res
+}
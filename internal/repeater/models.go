@@ -2,8 +2,13 @@ package repeater
 
 import (
 	"database/sql/driver"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type Map map[string]interface{}
@@ -13,8 +18,17 @@ func (p Map) Value() (driver.Value, error) {
 	return j, err
 }
 func (p *Map) Scan(src interface{}) error {
-	source, ok := src.([]byte)
-	if !ok {
+	var source []byte
+	switch src := src.(type) {
+	case nil:
+		*p = nil
+		return nil
+	case []byte:
+		source = src
+	case string:
+		// json kept in a text column
+		source = []byte(src)
+	default:
 		return errors.New("type assertion .([]byte) failed")
 	}
 
@@ -24,6 +38,12 @@ func (p *Map) Scan(src interface{}) error {
 		return err
 	}
 
+	if i == nil {
+		// json null, stored for a nil map
+		*p = nil
+		return nil
+	}
+	var ok bool
 	*p, ok = i.(map[string]interface{})
 	if !ok {
 		return errors.New("type assertion .(map[string]interface{}) failed")
@@ -33,25 +53,247 @@ func (p *Map) Scan(src interface{}) error {
 }
 
 type RequestResponse struct {
-	ID int64 `json:"id"`
+	ID        int64 `json:"id"`
+	SessionID int64 `json:"session_id"`
 	Request
+	// timing of the latest response, nil if there is none
+	Timing *Timing `json:"timing,omitempty"`
+	// nil until the request is annotated
+	Annotation *Annotation `json:"annotation,omitempty"`
+	// set by GET /requests/:id?include=response or include=responses
+	Response  *Response  `json:"response,omitempty"`
+	Responses []Response `json:"responses,omitempty"`
 }
 
 type Request struct {
-	Method     string `json:"method"`
-	Path       string `json:"path"`
-	GetParams  Map    `json:"get_params"`
-	Headers    Map    `json:"headers"`
-	Cookies    Map    `json:"cookies"`
-	PostParams Map    `json:"post_params"`
-	Raw        string `json:"raw"`
-	IsHTTPS    bool   `json:"is_https"`
+	Method     string     `json:"method"`
+	Path       string     `json:"path"`
+	GetParams  Map        `json:"get_params"`
+	Headers    Map        `json:"headers"`
+	Cookies    Map        `json:"cookies"`
+	PostParams Map        `json:"post_params"`
+	Raw        string     `json:"raw"`
+	IsHTTPS    bool       `json:"is_https"`
+	StartedAt  *time.Time `json:"started_at"`
+	Size       int64      `json:"size"`
+	Scheme     string     `json:"scheme"`
+	Host       string     `json:"host"`
+	Port       int        `json:"port"`
+	URL        string     `json:"url"`
+	Query      string     `json:"query"`
+	ClientAddr string     `json:"client_addr"`
+	Proto      string     `json:"proto"`
+	// Raw before redaction, sealed; replayed instead of Raw when the key is known
+	Original string `json:"-"`
+	// proxy, repeater, scanner or import
+	Source string `json:"source"`
+	// request a repeat was made from, nil for the others and once it is deleted
+	ParentID *int64 `json:"parent_id"`
+}
+
+// Target returns the upstream the request was sent to. Rows recorded
+// before the target was stored fall back to the Host header.
+func (r *Request) Target() (scheme, host string, port int, ok bool) {
+	if r.Host != "" && r.Port > 0 {
+		return r.Scheme, r.Host, r.Port, true
+	}
+	authority, _ := r.Headers["Host"].(string)
+	if authority == "" {
+		return "", "", 0, false
+	}
+	scheme, port = "http", 80
+	if r.IsHTTPS {
+		scheme, port = "https", 443
+	}
+	host, portStr, err := net.SplitHostPort(authority)
+	if err != nil {
+		return scheme, strings.Trim(authority, "[]"), port, true
+	}
+	if p, err := strconv.Atoi(portStr); err == nil && p > 0 {
+		port = p
+	}
+	return scheme, host, port, true
 }
+
+// Response is a stored response, see Decode.
 type Response struct {
-	Code    int    `json:"code"`
+	// zero for a response to an edited repeat
+	ID        int64 `json:"id,omitempty"`
+	RequestID int64 `json:"request_id,omitempty"`
+	SessionID int64 `json:"session_id,omitempty"`
+	Code      int   `json:"code"`
+	// status line, e.g. 200 OK
 	Message string `json:"message"`
 	Headers Map    `json:"headers"`
-	Body    string `json:"body"`
-	Raw     string `json:"raw"`
-	IsHTTPS bool   `json:"is_https"`
+	// decoded from Content-Encoding, in base64 when it is not text
+	Body       string `json:"body"`
+	BodyBase64 bool   `json:"body_base64,omitempty"`
+	// rebuilt out of the stored status, headers and body as it was
+	// received, not the bytes of the upstream: the status line says
+	// HTTP/1.1 and the headers go by name without the hop-by-hop ones.
+	// In base64 when it is not text.
+	Raw       string `json:"raw"`
+	RawBase64 bool   `json:"raw_base64,omitempty"`
+	Timing    Timing `json:"timing"`
+}
+
+// Timing of an exchange, durations are in microseconds.
+type Timing struct {
+	DNS          int64 `json:"dns_us"`
+	Connect      int64 `json:"connect_us"`
+	TLS          int64 `json:"tls_us"`
+	TTFB         int64 `json:"ttfb_us"`
+	Total        int64 `json:"total_us"`
+	ResponseSize int64 `json:"response_size"`
+	// address the upstream was reached at
+	RemoteIP string `json:"remote_ip"`
+	// set when the body is kept in the blob store, see GET /blobs/:hash
+	BodyHash string `json:"body_hash,omitempty"`
+}
+
+// RequestsFilter narrows and orders the list of stored requests
+// of a session. Zero values mean no restriction.
+type RequestsFilter struct {
+	SessionID   int64
+	SortBy      string
+	Desc        bool
+	MinDuration time.Duration
+	MaxDuration time.Duration
+	Since       time.Time
+	Until       time.Time
+	// requests carrying all of the tags
+	Tags []string
+	// upper-cased
+	Methods []string
+	// lower-cased
+	Hosts []string
+	// * matches any run of characters, ? any one
+	PathGlob string
+	HTTPS    *bool
+	// codes of the latest response, any of the ranges
+	Statuses []StatusRange
+	// media type of the latest response, type/* for any subtype
+	ContentType string
+	// request body size in bytes, MaxSize 0 means no limit
+	MinSize int64
+	MaxSize int64
+
+	// requests listed after the cursor, in the order of the cursor
+	After *Cursor
+	// 0 means no limit
+	Limit int
+	// JSON fields of RequestResponse to load, all of them when empty
+	Fields []string
+}
+
+// Empty reports whether the filter lets every request through,
+// whatever the order.
+func (f *RequestsFilter) Empty() bool {
+	return f.MinDuration == 0 && f.MaxDuration == 0 && f.Since.IsZero() && f.Until.IsZero() && len(f.Tags) == 0 &&
+		len(f.Methods) == 0 && len(f.Hosts) == 0 && f.PathGlob == "" && f.HTTPS == nil && len(f.Statuses) == 0 &&
+		f.ContentType == "" && f.MinSize == 0 && f.MaxSize == 0 && f.After == nil && f.Limit == 0
+}
+
+// Wants reports whether field, a JSON field of RequestResponse, is to be loaded.
+func (f *RequestsFilter) Wants(field string) bool {
+	if len(f.Fields) == 0 {
+		return true
+	}
+	for _, name := range f.Fields {
+		if name == field {
+			return true
+		}
+	}
+	return false
+}
+
+// StatusRange is an inclusive range of response codes.
+type StatusRange struct {
+	Min int
+	Max int
+}
+
+// Cursor points at the last request of a page: its id and its value of
+// the sort key, nil when the request has none.
+type Cursor struct {
+	SortBy string `json:"s"`
+	Desc   bool   `json:"d,omitempty"`
+	ID     int64  `json:"i"`
+	Value  *int64 `json:"v,omitempty"`
+}
+
+// CursorAt returns the cursor pointing at r in the order of filter.
+func CursorAt(r *RequestResponse, filter *RequestsFilter) *Cursor {
+	c := &Cursor{SortBy: filter.SortBy, Desc: filter.Desc, ID: r.ID}
+	if c.SortBy == "" {
+		c.SortBy = "id"
+	}
+	if value, ok := r.SortValue(c.SortBy); ok {
+		c.Value = &value
+	}
+	return c
+}
+
+// Encode returns the cursor as an opaque URL-safe string.
+func (c *Cursor) Encode() string {
+	j, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(j)
+}
+
+// DecodeCursor parses a cursor returned by Encode.
+func DecodeCursor(s string) (*Cursor, error) {
+	j, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	c := &Cursor{}
+	if err = json.Unmarshal(j, c); err != nil {
+		return nil, err
+	}
+	if !IsSortKey(c.SortBy) || c.ID <= 0 {
+		return nil, errors.New("bad cursor")
+	}
+	return c, nil
+}
+
+// SortValue returns the value of the sort key of r, started_at in
+// nanoseconds, false when r has none.
+func (r *RequestResponse) SortValue(key string) (int64, bool) {
+	switch key {
+	case "started_at":
+		if r.StartedAt == nil {
+			return 0, false
+		}
+		return r.StartedAt.UnixNano(), true
+	case "size":
+		return r.Size, true
+	case "duration", "ttfb", "response_size":
+	default:
+		return r.ID, true
+	}
+	if r.Timing == nil {
+		return 0, false
+	}
+	switch key {
+	case "duration":
+		return r.Timing.Total, true
+	case "ttfb":
+		return r.Timing.TTFB, true
+	default:
+		return r.Timing.ResponseSize, true
+	}
+}
+
+// ImportResult lists the requests an import stored, in the order of the
+// imported file, and the entries it skipped.
+type ImportResult struct {
+	Imported int           `json:"imported"`
+	IDs      []int64       `json:"ids"`
+	Skipped  []ImportError `json:"skipped,omitempty"`
+}
+
+// ImportError is an entry that was not imported, Entry counts from 0.
+type ImportError struct {
+	Entry int    `json:"entry"`
+	Error string `json:"error"`
 }
@@ -0,0 +1,204 @@
+package repeater
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// PayloadTypes are the kinds of PayloadSet.
+var PayloadTypes = []string{"list", "wordlist", "numbers", "chars"}
+
+// PayloadSet is the values put into the positions of an attack: the
+// Values of a list, the lines of a Wordlist file, the Numbers from From
+// to To by Step, zero-padded to Width, or every string of Charset from
+// MinLength to MaxLength characters long.
+type PayloadSet struct {
+	Type     string   `json:"type"`
+	Values   []string `json:"values,omitempty"`
+	Wordlist string   `json:"wordlist,omitempty"`
+	From     int64    `json:"from,omitempty"`
+	To       int64    `json:"to,omitempty"`
+	Step     int64    `json:"step,omitempty"`
+	Width    int      `json:"width,omitempty"`
+	Charset  string   `json:"charset,omitempty"`
+	// MinLength and MaxLength are in characters
+	MinLength int `json:"min_length,omitempty"`
+	MaxLength int `json:"max_length,omitempty"`
+}
+
+// payloads are the values of a set, generated as they are needed.
+type payloads interface {
+	Len() int64
+	At(i int64) string
+}
+
+type listPayloads []string
+
+func (l listPayloads) Len() int64 {
+	return int64(len(l))
+}
+
+func (l listPayloads) At(i int64) string {
+	return l[i]
+}
+
+type numberPayloads struct {
+	from, step, n int64
+	width         int
+}
+
+func (p *numberPayloads) Len() int64 {
+	return p.n
+}
+
+func (p *numberPayloads) At(i int64) string {
+	v := p.from + i*p.step
+	s := strconv.FormatInt(v, 10)
+	if v < 0 {
+		s = s[1:]
+	}
+	if len(s) < p.width {
+		s = strings.Repeat("0", p.width-len(s)) + s
+	}
+	if v < 0 {
+		s = "-" + s
+	}
+	return s
+}
+
+// charPayloads are the strings of chars, shortest first, then in the
+// order of chars.
+type charPayloads struct {
+	chars []rune
+	min   int
+	// the number of strings of each length from min
+	counts []int64
+	n      int64
+}
+
+func (p *charPayloads) Len() int64 {
+	return p.n
+}
+
+func (p *charPayloads) At(i int64) string {
+	length := p.min
+	for _, count := range p.counts {
+		if i < count {
+			break
+		}
+		i -= count
+		length++
+	}
+	res := make([]rune, length)
+	base := int64(len(p.chars))
+	for j := length - 1; j >= 0; j-- {
+		res[j] = p.chars[i%base]
+		i /= base
+	}
+	return string(res)
+}
+
+const maxWordlistSize = 64 << 20
+
+// payloads returns the values of the set, at most limit of them; a set
+// with more is an error. Wordlists are read from dir.
+func (s *PayloadSet) payloads(dir string, limit int64) (payloads, error) {
+	var p payloads
+	switch s.Type {
+	case "list":
+		p = listPayloads(s.Values)
+	case "wordlist":
+		words, err := readWordlist(dir, s.Wordlist)
+		if err != nil {
+			return nil, err
+		}
+		p = listPayloads(words)
+	case "numbers":
+		step := s.Step
+		if step == 0 {
+			step = 1
+		}
+		if step < 0 && s.From < s.To || step > 0 && s.From > s.To || s.Width < 0 || s.Width > 64 {
+			return nil, errors.New("numbers should go from from to to by step")
+		}
+		n := (s.To-s.From)/step + 1
+		if n <= 0 || n > limit {
+			return nil, errors.Errorf("payload set has more than %d payloads", limit)
+		}
+		p = &numberPayloads{from: s.From, step: step, n: n, width: s.Width}
+	case "chars":
+		chars := []rune(s.Charset)
+		if len(chars) == 0 || !utf8.ValidString(s.Charset) || s.MinLength < 1 || s.MaxLength < s.MinLength {
+			return nil, errors.New("chars should have a charset and 1 <= min_length <= max_length")
+		}
+		seen := make(map[rune]bool, len(chars))
+		for _, c := range chars {
+			if seen[c] {
+				return nil, errors.New("charset should not repeat characters")
+			}
+			seen[c] = true
+		}
+		cp := &charPayloads{chars: chars, min: s.MinLength}
+		for length := s.MinLength; length <= s.MaxLength; length++ {
+			count := int64(1)
+			for i := 0; i < length; i++ {
+				if count > limit/int64(len(chars)) {
+					return nil, errors.Errorf("payload set has more than %d payloads", limit)
+				}
+				count *= int64(len(chars))
+			}
+			cp.counts = append(cp.counts, count)
+			if cp.n += count; cp.n > limit {
+				return nil, errors.Errorf("payload set has more than %d payloads", limit)
+			}
+		}
+		p = cp
+	default:
+		return nil, errors.New("payload set type should be list, wordlist, numbers or chars")
+	}
+	if p.Len() == 0 {
+		return nil, errors.New("payload set is empty")
+	}
+	if p.Len() > limit {
+		return nil, errors.Errorf("payload set has more than %d payloads", limit)
+	}
+	return p, nil
+}
+
+// readWordlist returns the lines of the file name in dir, without the
+// empty ones. name cannot leave dir.
+func readWordlist(dir, name string) ([]string, error) {
+	if dir == "" {
+		return nil, errors.New("no wordlist directory is configured")
+	}
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return nil, errors.New("wordlist should be the name of a file of the wordlist directory")
+	}
+	info, err := os.Stat(filepath.Join(dir, name))
+	if err != nil {
+		return nil, errors.Errorf("no wordlist %s", name)
+	}
+	if info.Size() > maxWordlistSize {
+		return nil, errors.Errorf("wordlist %s is larger than 64 MiB", name)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading wordlist %s", name)
+	}
+	var words []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, len(data)+1)
+	for scanner.Scan() {
+		if word := strings.TrimSuffix(scanner.Text(), "\r"); word != "" {
+			words = append(words, word)
+		}
+	}
+	return words, errors.Wrapf(scanner.Err(), "reading wordlist %s", name)
+}
@@ -0,0 +1,88 @@
+package repeater
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// all returns every payload of p.
+func all(p payloads) []string {
+	res := make([]string, 0, p.Len())
+	for i := int64(0); i < p.Len(); i++ {
+		res = append(res, p.At(i))
+	}
+	return res
+}
+
+func TestPayloadSets(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "words.txt"), []byte("admin\r\n\nroot\nguest"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		set  PayloadSet
+		want []string
+	}{
+		{"list", PayloadSet{Type: "list", Values: []string{"a", "", "§"}}, []string{"a", "", "§"}},
+		{"wordlist", PayloadSet{Type: "wordlist", Wordlist: "words.txt"}, []string{"admin", "root", "guest"}},
+		{"numbers", PayloadSet{Type: "numbers", From: 1, To: 3}, []string{"1", "2", "3"}},
+		{"numbers by step", PayloadSet{Type: "numbers", From: 0, To: 10, Step: 4, Width: 3}, []string{"000", "004", "008"}},
+		{"numbers down", PayloadSet{Type: "numbers", From: 1, To: -2, Step: -1, Width: 2}, []string{"01", "00", "-01", "-02"}},
+		{"single number", PayloadSet{Type: "numbers", From: 7, To: 7}, []string{"7"}},
+		{"chars", PayloadSet{Type: "chars", Charset: "ab", MinLength: 1, MaxLength: 2}, []string{"a", "b", "aa", "ab", "ba", "bb"}},
+		{"multibyte chars", PayloadSet{Type: "chars", Charset: "яё", MinLength: 2, MaxLength: 2}, []string{"яя", "яё", "ёя", "ёё"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := tt.set.payloads(dir, 100)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := all(p); !equalStrings(got, tt.want) {
+				t.Errorf("payloads = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPayloadSetErrors(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{"empty.txt": "\n\r\n", ".hidden": "a"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		name string
+		dir  string
+		set  PayloadSet
+	}{
+		{"unknown type", dir, PayloadSet{Type: "dates"}},
+		{"empty list", dir, PayloadSet{Type: "list"}},
+		{"list over the limit", dir, PayloadSet{Type: "list", Values: []string{"1", "2", "3", "4", "5"}}},
+		{"no wordlist directory", "", PayloadSet{Type: "wordlist", Wordlist: "words.txt"}},
+		{"wordlist outside the directory", dir, PayloadSet{Type: "wordlist", Wordlist: "../words.txt"}},
+		{"hidden wordlist", dir, PayloadSet{Type: "wordlist", Wordlist: ".hidden"}},
+		{"missing wordlist", dir, PayloadSet{Type: "wordlist", Wordlist: "missing.txt"}},
+		{"empty wordlist", dir, PayloadSet{Type: "wordlist", Wordlist: "empty.txt"}},
+		{"numbers the wrong way", dir, PayloadSet{Type: "numbers", From: 3, To: 1}},
+		{"numbers over the limit", dir, PayloadSet{Type: "numbers", From: 1, To: 5}},
+		{"numbers overflowing", dir, PayloadSet{Type: "numbers", From: -1 << 62, To: 1 << 62}},
+		{"too wide numbers", dir, PayloadSet{Type: "numbers", From: 1, To: 2, Width: 65}},
+		{"no charset", dir, PayloadSet{Type: "chars", MinLength: 1, MaxLength: 1}},
+		{"repeated chars", dir, PayloadSet{Type: "chars", Charset: "aa", MinLength: 1, MaxLength: 1}},
+		{"no length", dir, PayloadSet{Type: "chars", Charset: "ab"}},
+		{"lengths the wrong way", dir, PayloadSet{Type: "chars", Charset: "ab", MinLength: 2, MaxLength: 1}},
+		{"chars over the limit", dir, PayloadSet{Type: "chars", Charset: "ab", MinLength: 1, MaxLength: 2}},
+		{"chars overflowing", dir, PayloadSet{Type: "chars", Charset: "abcdefghij", MinLength: 1, MaxLength: 40}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if p, err := tt.set.payloads(tt.dir, 4); err == nil {
+				t.Errorf("payloads = %q, want an error", all(p))
+			}
+		})
+	}
+}
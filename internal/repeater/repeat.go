@@ -0,0 +1,350 @@
+package repeater
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+
+	proxyserver "github.com/iiivan-lemon/technopark_proxy/internal/proxyServer"
+	"github.com/iiivan-lemon/technopark_proxy/internal/utils/forward"
+	httperrors "github.com/iiivan-lemon/technopark_proxy/internal/utils/httpErrors"
+	"github.com/iiivan-lemon/technopark_proxy/internal/utils/middleware"
+	"github.com/iiivan-lemon/technopark_proxy/internal/utils/resolver"
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+)
+
+// RepeatPatch is the body of POST /repeat/:id: edits of the stored
+// request, or Raw, a whole edited request, instead of them.
+type RepeatPatch struct {
+	Method string `json:"method"`
+	// a path with a query or an absolute URL, which changes the upstream too
+	URL string `json:"url"`
+	// values are strings or lists of strings, null removes the entry
+	Query   Map `json:"query"`
+	Headers Map `json:"headers"`
+	Cookies Map `json:"cookies"`
+	// replaces the body, in base64 when BodyBase64 is set
+	Body       *string `json:"body"`
+	BodyBase64 bool    `json:"body_base64"`
+	Raw        string  `json:"raw"`
+}
+
+func (p *RepeatPatch) hasEdits() bool {
+	return p.Method != "" || p.URL != "" || p.Query != nil || p.Headers != nil || p.Cookies != nil || p.Body != nil
+}
+
+// target is where a repeated request goes.
+type target struct {
+	scheme string
+	host   string
+	port   int
+}
+
+// parseRaw reads a request dump. The body is whatever follows the
+// headers, Content-Length is set after it, so edits of the body need
+// not fix the header.
+func parseRaw(raw string) (*http.Request, []byte, error) {
+	head, body := raw, ""
+	if i := strings.Index(raw, "\r\n\r\n"); i >= 0 {
+		head, body = raw[:i], raw[i+4:]
+	} else if i = strings.Index(raw, "\n\n"); i >= 0 {
+		head, body = raw[:i], raw[i+2:]
+	}
+	req, err := http.ReadRequest(bufio.NewReader(strings.NewReader(head + "\r\n\r\n")))
+	if err != nil {
+		return nil, nil, err
+	}
+	data := []byte(body)
+	if chunked(req) {
+		if decoded, err := io.ReadAll(httputil.NewChunkedReader(strings.NewReader(body))); err == nil {
+			data = decoded
+		}
+	}
+	req.TransferEncoding = nil
+	req.Header.Del("Transfer-Encoding")
+	return req, data, nil
+}
+
+func chunked(req *http.Request) bool {
+	for _, te := range req.TransferEncoding {
+		if strings.EqualFold(te, "chunked") {
+			return true
+		}
+	}
+	return false
+}
+
+// apply edits req, its body and its target by p.
+func (p *RepeatPatch) apply(req *http.Request, body []byte, to *target) ([]byte, error) {
+	if p.Method != "" {
+		if !isToken(p.Method) {
+			return nil, errors.New(httperrors.BAD_METHOD)
+		}
+		req.Method = strings.ToUpper(p.Method)
+	}
+
+	if p.URL != "" {
+		u, err := url.Parse(p.URL)
+		if err != nil {
+			return nil, errors.New(httperrors.BAD_URL)
+		}
+		if u.IsAbs() {
+			scheme := strings.ToLower(u.Scheme)
+			if scheme != "http" && scheme != "https" || u.Hostname() == "" {
+				return nil, errors.New(httperrors.BAD_URL)
+			}
+			to.scheme = scheme
+			to.host, to.port = proxyserver.SplitAuthority(u.Host, scheme)
+			req.Host = proxyserver.JoinAuthority(to.host, to.port, scheme)
+		} else if !strings.HasPrefix(u.Path, "/") {
+			return nil, errors.New(httperrors.BAD_URL)
+		}
+		req.URL.Path, req.URL.RawPath, req.URL.RawQuery = u.Path, u.RawPath, u.RawQuery
+	}
+
+	if p.Query != nil {
+		query := req.URL.Query()
+		err := edit(p.Query, func(name string, values []string) {
+			query[name] = values
+		}, query.Del)
+		if err != nil {
+			return nil, err
+		}
+		req.URL.RawQuery = query.Encode()
+	}
+
+	if p.Headers != nil {
+		err := edit(p.Headers, func(name string, values []string) {
+			if strings.EqualFold(name, "Host") {
+				req.Host = values[0]
+				return
+			}
+			req.Header[http.CanonicalHeaderKey(name)] = values
+		}, req.Header.Del)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if p.Cookies != nil {
+		cookies := req.Cookies()
+		err := edit(p.Cookies, func(name string, values []string) {
+			for i := range cookies {
+				if cookies[i].Name == name {
+					cookies[i].Value = values[0]
+					return
+				}
+			}
+			cookies = append(cookies, &http.Cookie{Name: name, Value: values[0]})
+		}, func(name string) {
+			kept := cookies[:0]
+			for _, c := range cookies {
+				if c.Name != name {
+					kept = append(kept, c)
+				}
+			}
+			cookies = kept
+		})
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Del("Cookie")
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+	}
+
+	if p.Body != nil {
+		body = []byte(*p.Body)
+		if p.BodyBase64 {
+			decoded, err := base64.StdEncoding.DecodeString(*p.Body)
+			if err != nil {
+				return nil, errors.New(httperrors.BAD_BODY_BASE64)
+			}
+			body = decoded
+		}
+	}
+	return body, nil
+}
+
+// edit calls set for the entries of m with values and del for the null ones.
+func edit(m Map, set func(name string, values []string), del func(name string)) error {
+	for name, value := range m {
+		if name == "" {
+			return errors.New(httperrors.BAD_EDIT_VALUE)
+		}
+		if value == nil {
+			del(name)
+			continue
+		}
+		values := headerValues(value)
+		if len(values) == 0 {
+			return errors.New(httperrors.BAD_EDIT_VALUE)
+		}
+		if list, ok := value.([]interface{}); ok && len(list) != len(values) {
+			return errors.New(httperrors.BAD_EDIT_VALUE)
+		}
+		set(name, values)
+	}
+	return nil
+}
+
+// isToken reports whether s is an HTTP token, as methods are.
+func isToken(s string) bool {
+	for _, c := range s {
+		if c <= ' ' || c >= 0x7f || strings.ContainsRune(`()<>@,;:\"/[]?={}`, c) {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// send sends req with body to the upstream at to and reads the whole
+// response. Content-Length is set to the length of body. The exchange
+// is returned along with the error when the upstream never answers.
+func (rs *RepeaterServer) send(ctx context.Context, req *http.Request, body []byte, to *target) (*proxyserver.Exchange, error) {
+	addr := proxyserver.JoinAuthority(to.host, to.port, to.scheme)
+	if req.Host == "" {
+		req.Host = addr
+	}
+	req.URL.Scheme, req.URL.Host, req.URL.Opaque = to.scheme, addr, ""
+	req.RequestURI = ""
+	forward.RemoveHopByHop(req.Header)
+	// a request without User-Agent is sent without it, not with Go's
+	if _, ok := req.Header["User-Agent"]; !ok {
+		req.Header["User-Agent"] = nil
+	}
+	// the transport writes Content-Length out of ContentLength, not the header
+	req.Header.Del("Content-Length")
+	req.ContentLength = int64(len(body))
+	setBody(req, body)
+
+	timing := proxyserver.NewTiming()
+	var dump bytes.Buffer
+	if err := req.Write(&dump); err != nil {
+		return nil, errors.Wrap(err, "dump request error")
+	}
+	setBody(req, body)
+	exchange := &proxyserver.Exchange{Request: proxyserver.FormRequestData(req, dump.Bytes())}
+	exchange.Request.StartedAt = timing.StartedAt
+
+	var remoteIP string
+	trace := timing.Trace()
+	trace.GotConn = func(info httptrace.GotConnInfo) {
+		remoteIP = resolver.RemoteIP(info.Conn)
+	}
+	upstreamResp, err := rs.transport.RoundTrip(req.WithContext(httptrace.WithClientTrace(ctx, trace)))
+	if err != nil {
+		return exchange, errors.Wrap(err, "round trip")
+	}
+	defer upstreamResp.Body.Close()
+	data, err := io.ReadAll(upstreamResp.Body)
+	if err != nil {
+		return exchange, errors.Wrap(err, "reading upstream's response")
+	}
+	timing.Finish()
+
+	forward.RemoveHopByHop(upstreamResp.Header)
+	exchange.Response = proxyserver.FormResponseData(upstreamResp, string(data))
+	exchange.Response.Timing = *timing
+	exchange.Response.RemoteIP = remoteIP
+	return exchange, nil
+}
+
+// retarget changes to to the upstream of an absolute request target,
+// which has to be http or https.
+func retarget(req *http.Request, to *target) error {
+	if !req.URL.IsAbs() {
+		return nil
+	}
+	if req.URL.Scheme != "http" && req.URL.Scheme != "https" || req.URL.Hostname() == "" {
+		return errors.New(httperrors.BAD_URL)
+	}
+	to.scheme = req.URL.Scheme
+	to.host, to.port = proxyserver.SplitAuthority(req.URL.Host, to.scheme)
+	return nil
+}
+
+// sendRaw sends the raw request to the upstream at to, or the one of its
+// absolute request target, as send does.
+func (rs *RepeaterServer) sendRaw(ctx context.Context, raw string, to *target) (*proxyserver.Exchange, error) {
+	req, body, err := parseRaw(raw)
+	if err != nil {
+		return nil, errors.New(httperrors.BAD_RAW_REQUEST)
+	}
+	if err = retarget(req, to); err != nil {
+		return nil, err
+	}
+	return rs.send(ctx, req, body, to)
+}
+
+func setBody(req *http.Request, body []byte) {
+	req.Body = nil
+	if len(body) > 0 {
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+}
+
+// record stores a repeat of parent and returns its id, zero when it
+// could not be stored. The request goes to the session of parent, with
+// parent as its parent and the repeater as its source.
+func (rs *RepeaterServer) record(ctx echo.Context, parent *RequestResponse, exchange *proxyserver.Exchange) int64 {
+	logger := middleware.GetLoggerFromCtx(ctx)
+	requestId := middleware.GetRequestIdFromCtx(ctx)
+
+	req := exchange.Request
+	req.SessionID = parent.SessionID
+	req.ParentID = parent.ID
+	req.Source = proxyserver.SourceRepeater
+	id, err := rs.store(exchange)
+	if err != nil {
+		logger.Error(requestId, err.Error())
+		return 0
+	}
+	return id
+}
+
+// store redacts the exchange as the proxy redacts what it records and
+// stores it. The id is set once the request is stored, even when the
+// response could not be.
+func (rs *RepeaterServer) store(exchange *proxyserver.Exchange) (int64, error) {
+	return storeExchange(rs.repo, rs.redactor, exchange)
+}
+
+// newResponse returns a copy of resp as the repeater serves responses,
+// decoded, see Response.Decode.
+func newResponse(resp *proxyserver.Response) *Response {
+	headers := make(Map, len(resp.Headers))
+	for name, value := range resp.Headers {
+		if values, ok := value.([]string); ok {
+			value = append([]string(nil), values...)
+		}
+		headers[name] = value
+	}
+	t := resp.Timing
+	res := &Response{
+		Code:    resp.Code,
+		Message: resp.Message,
+		Headers: headers,
+		Body:    resp.Body,
+		Timing: Timing{
+			DNS:          t.DNS.Microseconds(),
+			Connect:      t.Connect.Microseconds(),
+			TLS:          t.TLS.Microseconds(),
+			TTFB:         t.TTFB.Microseconds(),
+			Total:        t.Total.Microseconds(),
+			ResponseSize: resp.Size,
+			RemoteIP:     resp.RemoteIP,
+		},
+	}
+	res.Decode()
+	return res
+}
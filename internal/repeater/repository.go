@@ -1,58 +1,105 @@
 package repeater
 
 import (
-	"github.com/jackc/pgx"
+	"time"
+
+	proxyserver "github.com/iiivan-lemon/technopark_proxy/internal/proxyServer"
 )
 
-type RepeaterRepository struct {
-	conn *pgx.ConnPool
-}
+// Repository gives access to the recorded traffic.
+type Repository interface {
+	GetAllRequests(filter *RequestsFilter) ([]RequestResponse, error)
+	// GetRequestByID returns nil, nil when there is no such request.
+	GetRequestByID(id int) (*RequestResponse, error)
+	// GetRepeats returns the repeats made from a request and from its
+	// repeats, oldest first.
+	GetRepeats(id int) ([]RequestResponse, error)
+	// InsertRequest and InsertResponse store a repeat.
+	InsertRequest(req *proxyserver.Request) (uint, error)
+	InsertResponse(reqID uint, resp *proxyserver.Response) error
+	// GetResponses returns the responses of a request with their bodies
+	// as they were received, oldest first, see Response.Decode.
+	GetResponses(requestID int) ([]Response, error)
+	// GetResponsesOf returns the responses of the requests as GetResponses
+	// does, in the order of their request ids.
+	GetResponsesOf(requestIDs []int64) ([]Response, error)
+	// GetResponse returns nil, nil when there is no such response.
+	GetResponse(id int) (*Response, error)
+	// GetBlob returns nil, nil when there is no such blob.
+	GetBlob(hash string) ([]byte, error)
+	// DeleteRequest returns false when there is no such request.
+	DeleteRequest(id int) (bool, error)
+	// DeleteRequests deletes the requests matching filter and returns their number.
+	DeleteRequests(filter *RequestsFilter) (int, error)
+	// Search returns up to query.Limit hits, newest requests first.
+	Search(query *SearchQuery) ([]SearchHit, error)
+	// SetAnnotation replaces the note, color and tags of a request,
+	// it returns false when there is no such request.
+	SetAnnotation(id int, annotation *Annotation) (bool, error)
+	// AddTags returns false when there is no such request.
+	AddTags(id int, tags []string) (bool, error)
+	// RemoveTag returns false when the request has no such tag.
+	RemoveTag(id int, tag string) (bool, error)
+	// GetTags returns every tag with the number of requests of the session
+	// carrying it, including the tags no request carries.
+	GetTags(sessionID int64) ([]TagCount, error)
+	// DeleteTag removes a tag from the requests of the session and forgets
+	// it once no request carries it. It returns false when there is no such tag.
+	DeleteTag(sessionID int64, tag string) (bool, error)
 
-const (
-	getAllQueries  = `SELECT id, method, path, get_params, headers, cookies, post_params, raw, is_https from requests;`
-	getRequestByID = `SELECT id, method, path, get_params, headers, cookies, post_params, raw, is_https from requests WHERE id = $1;`
-)
+	// CreateSession sets the id and the creation time of session,
+	// it returns ErrSessionExists when the name is taken.
+	CreateSession(session *Session) error
+	GetSessions() ([]Session, error)
+	// GetSession returns nil, nil when there is no such session.
+	GetSession(id int64) (*Session, error)
+	// ActiveSession returns the session the proxy records into.
+	ActiveSession() (*Session, error)
+	// UpdateSession saves the name, description and scope of session,
+	// it returns false when there is no such session.
+	UpdateSession(session *Session) (bool, error)
+	// ActivateSession makes the proxy record into the session.
+	ActivateSession(id int64) (bool, error)
+	ArchiveSession(id int64) (bool, error)
 
-func NewRepeaterRepository(conn *pgx.ConnPool) *RepeaterRepository {
-	return &RepeaterRepository{
-		conn: conn,
-	}
+	// CreateAttack sets the id and the creation time of attack.
+	CreateAttack(attack *Attack) error
+	// GetAttacks returns the attacks of the session, newest first, Done
+	// is the number of their results.
+	GetAttacks(sessionID int64) ([]Attack, error)
+	// GetAttack returns nil, nil when there is no such attack.
+	GetAttack(id int64) (*Attack, error)
+	// UpdateAttack saves the status an attack ended in.
+	UpdateAttack(id int64, status, errText string, finishedAt *time.Time) error
+	// DeleteAttack deletes the attack and its results, not the requests
+	// it sent. It returns false when there is no such attack.
+	DeleteAttack(id int64) (bool, error)
+	InsertAttackResult(res *AttackResult) error
+	GetAttackResults(filter *AttackResultsFilter) ([]AttackResult, error)
 }
 
-func (p *RepeaterRepository) GetAllRequests() ([]RequestResponse, error) {
-	rows, err := p.conn.Query(getAllQueries)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	res := make([]RequestResponse, 0)
+// SortKeys are the values accepted by RequestsFilter.SortBy.
+var SortKeys = []string{"id", "started_at", "size", "duration", "ttfb", "response_size"}
 
-	for rows.Next() {
-		req := RequestResponse{}
-		err = rows.Scan(&req.ID, &req.Method, &req.Path, &req.GetParams, &req.Headers, &req.Cookies, &req.PostParams, &req.Raw, &req.IsHTTPS)
-		if err == pgx.ErrNoRows {
-			return nil, nil
+func IsSortKey(key string) bool {
+	for _, k := range SortKeys {
+		if k == key {
+			return true
 		}
-		if err != nil {
-			return nil, err
-		}
-		res = append(res, req)
 	}
-
-	return res, nil
+	return false
 }
-func (p *RepeaterRepository) GetRequestByID(id int) (*RequestResponse, error) {
-	req := &RequestResponse{}
 
-	err := p.conn.QueryRow(getRequestByID, id).
-		Scan(&req.ID, &req.Method, &req.Path, &req.GetParams, &req.Headers, &req.Cookies, &req.PostParams, &req.Raw, &req.IsHTTPS)
-	if err == pgx.ErrNoRows {
-		return nil, nil
-	}
-	if err != nil {
-		return nil, err
-	}
+// RequestFields are the JSON fields of RequestResponse accepted by
+// RequestsFilter.Fields.
+var RequestFields = []string{"id", "session_id", "method", "path", "get_params", "headers", "cookies", "post_params", "raw",
+	"is_https", "started_at", "size", "scheme", "host", "port", "url", "query", "client_addr", "proto", "source", "parent_id", "timing", "annotation"}
 
-	return req, nil
+func IsRequestField(field string) bool {
+	for _, f := range RequestFields {
+		if f == field {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,132 @@
+package repeater
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// a body inflating past it is left encoded
+const maxDecodedBody = 64 << 20
+
+// Decode rebuilds Raw out of the status line, the headers and Body as it
+// was received, then decodes Body from its Content-Encoding. Bodies in
+// an encoding other than gzip and deflate are left as they are.
+func (r *Response) Decode() {
+	received := r.Body
+	r.Raw, r.RawBase64 = textOrBase64(r.head() + received)
+	body, err := decodeBody([]byte(received), r.header("Content-Encoding"))
+	if err != nil {
+		body = []byte(received)
+	}
+	r.Body, r.BodyBase64 = textOrBase64(string(body))
+}
+
+// head returns the status line and the headers, sorted by name.
+func (r *Response) head() string {
+	status := r.Message
+	if status == "" {
+		status = strconv.Itoa(r.Code) + " " + http.StatusText(r.Code)
+	}
+	var b strings.Builder
+	b.WriteString("HTTP/1.1 " + status + "\r\n")
+	names := make([]string, 0, len(r.Headers))
+	for name := range r.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range headerValues(r.Headers[name]) {
+			b.WriteString(name + ": " + value + "\r\n")
+		}
+	}
+	b.WriteString("\r\n")
+	return b.String()
+}
+
+// header returns the values of a header joined by commas.
+func (r *Response) header(name string) string {
+	for key, value := range r.Headers {
+		if strings.EqualFold(key, name) {
+			return strings.Join(headerValues(value), ", ")
+		}
+	}
+	return ""
+}
+
+// headerValues returns the values of a header map entry, a value or a list.
+func headerValues(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case []string:
+		return v
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// decodeBody undoes the encodings of a Content-Encoding value,
+// the last one applied first.
+func decodeBody(body []byte, encodings string) ([]byte, error) {
+	if encodings == "" {
+		return body, nil
+	}
+	list := strings.Split(encodings, ",")
+	for i := len(list) - 1; i >= 0; i-- {
+		var (
+			reader io.ReadCloser
+			err    error
+		)
+		switch strings.ToLower(strings.TrimSpace(list[i])) {
+		case "", "identity":
+			continue
+		case "gzip", "x-gzip":
+			reader, err = gzip.NewReader(bytes.NewReader(body))
+		case "deflate":
+			// deflate is meant to be zlib-wrapped, some servers send it raw
+			if reader, err = zlib.NewReader(bytes.NewReader(body)); err != nil {
+				reader, err = flate.NewReader(bytes.NewReader(body)), nil
+			}
+		default:
+			return nil, errors.New("unsupported content encoding " + list[i])
+		}
+		if err != nil {
+			return nil, err
+		}
+		decoded, err := io.ReadAll(io.LimitReader(reader, maxDecodedBody+1))
+		reader.Close()
+		if err != nil {
+			return nil, err
+		}
+		if len(decoded) > maxDecodedBody {
+			return nil, errors.New("decoded body is too large")
+		}
+		body = decoded
+	}
+	return body, nil
+}
+
+// textOrBase64 returns s as it is when it is UTF-8, in base64 otherwise.
+func textOrBase64(s string) (string, bool) {
+	if utf8.ValidString(s) {
+		return s, false
+	}
+	return base64.StdEncoding.EncodeToString([]byte(s)), true
+}
@@ -0,0 +1,148 @@
+package repeater
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Fields GET /search looks in. Request headers include the cookies.
+const (
+	FieldURL             = "url"
+	FieldRequestHeaders  = "request_headers"
+	FieldRequestBody     = "request_body"
+	FieldResponseHeaders = "response_headers"
+	FieldResponseBody    = "response_body"
+)
+
+var SearchFields = []string{FieldURL, FieldRequestHeaders, FieldRequestBody, FieldResponseHeaders, FieldResponseBody}
+
+func IsSearchField(field string) bool {
+	for _, f := range SearchFields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// Marks around the matches in snippets.
+const (
+	HighlightStart = "<mark>"
+	HighlightStop  = "</mark>"
+)
+
+// snippetContext is how many bytes around a match a snippet shows.
+const snippetContext = 60
+
+// SearchQuery is what GET /search looks for: all the words of Text,
+// in any case, or a match of Regex when it is set.
+type SearchQuery struct {
+	SessionID int64
+	Text      string
+	Regex     *regexp.Regexp
+	Fields    []string
+	Limit     int
+}
+
+// Terms are the lower-cased words of Text.
+func (q *SearchQuery) Terms() []string {
+	return strings.Fields(strings.ToLower(q.Text))
+}
+
+// SearchHit is a field of a request, or of one of its responses,
+// matching a SearchQuery.
+type SearchHit struct {
+	RequestID int64  `json:"request_id"`
+	Method    string `json:"method"`
+	URL       string `json:"url"`
+	Field     string `json:"field"`
+	Snippet   string `json:"snippet"`
+	// where the whole request is
+	Link string `json:"link"`
+}
+
+// Match reports whether text matches q and returns the highlighted
+// part of text around the first match.
+func (q *SearchQuery) Match(text string) (string, bool) {
+	var spans [][]int
+	if q.Regex != nil {
+		spans = q.Regex.FindAllStringIndex(text, -1)
+	} else {
+		lower := strings.ToLower(text)
+		for _, term := range q.Terms() {
+			i := strings.Index(lower, term)
+			if i < 0 {
+				return "", false
+			}
+			spans = append(spans, []int{i, i + len(term)})
+		}
+	}
+	if len(spans) == 0 {
+		return "", false
+	}
+	return snippet(text, spans), true
+}
+
+// snippet cuts text around the first span and highlights
+// the spans that fall into the cut.
+func snippet(text string, spans [][]int) string {
+	first := spans[0]
+	for _, s := range spans {
+		if s[0] < first[0] {
+			first = s
+		}
+	}
+	from := runeStart(text, first[0]-snippetContext)
+	to := runeStart(text, first[1]+snippetContext)
+
+	var b strings.Builder
+	if from > 0 {
+		b.WriteString("…")
+	}
+	pos := from
+	for _, s := range sortedSpans(spans) {
+		if s[0] < pos || s[1] > to || s[0] == s[1] {
+			continue
+		}
+		b.WriteString(text[pos:s[0]])
+		b.WriteString(HighlightStart)
+		b.WriteString(text[s[0]:s[1]])
+		b.WriteString(HighlightStop)
+		pos = s[1]
+	}
+	b.WriteString(text[pos:to])
+	if to < len(text) {
+		b.WriteString("…")
+	}
+	return b.String()
+}
+
+// runeStart clamps i into text and moves it back to the start of a rune.
+func runeStart(text string, i int) int {
+	if i <= 0 {
+		return 0
+	}
+	if i >= len(text) {
+		return len(text)
+	}
+	for i > 0 && !utf8.RuneStart(text[i]) {
+		i--
+	}
+	return i
+}
+
+func sortedSpans(spans [][]int) [][]int {
+	sorted := append([][]int(nil), spans...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i][0] < sorted[j][0] })
+	return sorted
+}
+
+// RequestBody is the part of a raw request after the headers.
+func RequestBody(raw string) string {
+	if i := strings.Index(raw, "\r\n\r\n"); i >= 0 {
+		return raw[i+4:]
+	}
+	return ""
+}
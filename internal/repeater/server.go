@@ -1,47 +1,90 @@
 package repeater
 
 import (
-	"bufio"
+	"context"
 	"crypto/tls"
-	"fmt"
+	"encoding/json"
 	"io"
 	"net/http"
+	"net/url"
+	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/iiivan-lemon/technopark_proxy/config"
+	"github.com/iiivan-lemon/technopark_proxy/internal/storage/blobs"
 	httperrors "github.com/iiivan-lemon/technopark_proxy/internal/utils/httpErrors"
 	"github.com/iiivan-lemon/technopark_proxy/internal/utils/middleware"
+	"github.com/iiivan-lemon/technopark_proxy/internal/utils/redact"
+	"github.com/iiivan-lemon/technopark_proxy/internal/utils/resolver"
 	"github.com/labstack/echo/v4"
 	echomw "github.com/labstack/echo/v4/middleware"
 	"github.com/pkg/errors"
 )
 
 type RepeaterServer struct {
-	repo RepeaterRepository
+	repo Repository
 	CA   *tls.Certificate
 	// proxy server's tls-config for connecting to client as server
 	ProxyAsServerTLSConfig *tls.Config
 
 	// proxy server's tls-config for connecting to upstream-server as client
 	ProxyAsClientTLSConfig *tls.Config
+
+	resolver  *resolver.Resolver
+	transport *http.Transport
+	metrics   MetricsWriter
+	// where the proxy records, the default scope of the endpoints
+	sessions *ActiveSession
+	// opens the originals of redacted requests for replay
+	redactor *redact.Redactor
+	differ   *Differ
+
+	mu       sync.Mutex
+	httpServ *http.Server
+	// runs the attacks, set by ListenAndServe
+	attacker *attacker
+}
+
+// MetricsWriter reports its state in the Prometheus text format.
+type MetricsWriter interface {
+	WriteMetrics(w io.Writer) error
 }
 
-func NewRepeaterServer(repo *RepeaterRepository, caCert *tls.Certificate, servConf, clientConf *tls.Config) *RepeaterServer {
+func NewRepeaterServer(repo Repository, sessions *ActiveSession, redactor *redact.Redactor, differ *Differ, caCert *tls.Certificate, servConf, clientConf *tls.Config, res *resolver.Resolver, metrics MetricsWriter) *RepeaterServer {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = res.DialContext
+	// edited repeats go to whatever upstream, as the proxy does
+	transport.TLSClientConfig = &tls.Config{}
+	if clientConf != nil {
+		transport.TLSClientConfig = clientConf.Clone()
+	}
+	transport.TLSClientConfig.InsecureSkipVerify = true
+	// the body is returned as the upstream sent it
+	transport.DisableCompression = true
 	return &RepeaterServer{
-		repo:                   *repo,
+		repo:                   repo,
 		CA:                     caCert,
 		ProxyAsServerTLSConfig: servConf,
 		ProxyAsClientTLSConfig: clientConf,
+		resolver:               res,
+		transport:              transport,
+		metrics:                metrics,
+		sessions:               sessions,
+		redactor:               redactor,
+		differ:                 differ,
 	}
 }
 
-func (rs *RepeaterServer) ListenAndServe(repeaterConf *config.ServerConfig, mw *middleware.CommonMiddleware) {
+// ListenAndServe blocks until the server fails or Shutdown is called.
+// A graceful stop is not reported as an error.
+func (rs *RepeaterServer) ListenAndServe(repeaterConf *config.ServerConfig, mw *middleware.CommonMiddleware) error {
 	e := echo.New()
 	e.Use(echomw.Recover(), mw.RequestIdMiddleware, mw.AccessLogMiddleware, mw.PanicMiddleware)
 
-	httpServ := http.Server{
+	httpServ := &http.Server{
 		Addr:         repeaterConf.Addr(),
 		ReadTimeout:  time.Duration(repeaterConf.ReadTimeout) * time.Second,
 		WriteTimeout: time.Duration(repeaterConf.WriteTimeout) * time.Second,
@@ -50,123 +93,1646 @@ func (rs *RepeaterServer) ListenAndServe(repeaterConf *config.ServerConfig, mw *
 
 	e.GET("/requests", rs.HandleAllRequests)
 	e.GET("/requests/:id", rs.HandleRequestByID)
+	e.GET("/requests/:id/response", rs.HandleRequestResponse)
+	e.GET("/requests/:id/repeats", rs.HandleRepeats)
+	e.GET("/requests/:id/export", rs.HandleExport)
+	e.GET("/responses/:id", rs.HandleResponseByID)
+	e.GET("/diff", rs.HandleDiff)
+	e.GET("/export/har", rs.HandleExportHAR)
+	e.POST("/import/:format", rs.HandleImport)
+	e.DELETE("/requests", rs.HandleDeleteRequests)
+	e.DELETE("/requests/:id", rs.HandleDeleteRequest)
+	e.GET("/requests/:id/annotation", rs.HandleAnnotation)
+	e.PUT("/requests/:id/annotation", rs.HandleSetAnnotation)
+	e.DELETE("/requests/:id/annotation", rs.HandleDeleteAnnotation)
+	e.POST("/requests/:id/tags", rs.HandleAddTags)
+	e.DELETE("/requests/:id/tags/:tag", rs.HandleRemoveTag)
+	e.GET("/tags", rs.HandleTags)
+	e.DELETE("/tags/:tag", rs.HandleDeleteTag)
 	e.GET("/repeat/:id", rs.HandleRepeatRequest)
+	e.POST("/repeat/:id", rs.HandleRepeatWithEdits)
+	e.GET("/search", rs.HandleSearch)
+	e.GET("/blobs/:hash", rs.HandleBlob)
+	e.GET("/metrics", rs.HandleMetrics)
+	e.GET("/sessions", rs.HandleSessions)
+	e.POST("/sessions", rs.HandleCreateSession)
+	e.GET("/sessions/:sid", rs.HandleSession)
+	e.PATCH("/sessions/:sid", rs.HandleUpdateSession)
+	e.POST("/sessions/:sid/activate", rs.HandleActivateSession)
+	e.POST("/sessions/:sid/archive", rs.HandleArchiveSession)
+	e.GET("/sessions/:sid/export", rs.HandleExportSession)
+	e.GET("/attacks", rs.HandleAttacks)
+	e.POST("/attacks", rs.HandleCreateAttack)
+	e.GET("/attacks/:aid", rs.HandleAttack)
+	e.GET("/attacks/:aid/results", rs.HandleAttackResults)
+	e.POST("/attacks/:aid/pause", rs.HandlePauseAttack)
+	e.POST("/attacks/:aid/resume", rs.HandleResumeAttack)
+	e.POST("/attacks/:aid/cancel", rs.HandleCancelAttack)
+	e.DELETE("/attacks/:aid", rs.HandleDeleteAttack)
+
+	rs.mu.Lock()
+	rs.httpServ = httpServ
+	rs.attacker = newAttacker(&repeaterConf.Attack, rs.repo, rs)
+	rs.mu.Unlock()
+
+	if err := e.StartServer(httpServ); err != nil && err != http.ErrServerClosed {
+		return errors.Wrap(err, "repeater server error")
+	}
+	return nil
+}
 
-	e.Logger.Fatal(e.StartServer(&httpServ))
+// Shutdown stops accepting connections and waits for in-flight repeats
+// to finish until ctx expires. The attacks are stopped, they show as
+// interrupted from then on.
+func (rs *RepeaterServer) Shutdown(ctx context.Context) error {
+	rs.mu.Lock()
+	httpServ, attacker := rs.httpServ, rs.attacker
+	rs.mu.Unlock()
+	if httpServ == nil {
+		return nil
+	}
+	err := httpServ.Shutdown(ctx)
+	if closeErr := attacker.close(ctx); err == nil {
+		err = closeErr
+	}
+	return err
 }
 
-func (rs *RepeaterServer) HandleAllRequests(ctx echo.Context) error {
+// sessionID returns the session a call is scoped to: the session query
+// parameter, or the active session when there is none.
+func (rs *RepeaterServer) sessionID(ctx echo.Context) (int64, error) {
+	value := ctx.QueryParam("session")
+	if value == "" {
+		if active := rs.sessions.Get(); active != nil {
+			return active.ID, nil
+		}
+		return 0, echo.NewHTTPError(http.StatusNotFound, httperrors.NO_SUCH_SESSION)
+	}
+	session, err := rs.getSession(ctx, value)
+	if err != nil {
+		return 0, err
+	}
+	return session.ID, nil
+}
+
+// getSession loads the session id refers to, the error is ready
+// to be returned by a handler.
+func (rs *RepeaterServer) getSession(ctx echo.Context, id string) (*Session, error) {
 	logger := middleware.GetLoggerFromCtx(ctx)
 	requestId := middleware.GetRequestIdFromCtx(ctx)
-	requests, err := rs.repo.GetAllRequests()
+
+	sessionID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil || sessionID <= 0 {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, httperrors.BAD_SESSION_ID)
+	}
+	session, err := rs.repo.GetSession(sessionID)
 	if err != nil {
-		logger.Error(requestId, errors.Wrap(err, "request dump error").Error())
-		return echo.NewHTTPError(http.StatusServiceUnavailable, httperrors.INTERNAL_SERVER_ERR)
+		logger.Error(requestId, errors.Wrap(err, "GetSession error").Error())
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, httperrors.INTERNAL_SERVER_ERR)
+	}
+	if session == nil {
+		return nil, echo.NewHTTPError(http.StatusNotFound, httperrors.NO_SUCH_SESSION)
 	}
-	return ctx.JSON(http.StatusOK, requests)
+	return session, nil
 }
 
-func (rs *RepeaterServer) HandleRepeatRequest(ctx echo.Context) error {
+// writableSession returns the error of a write to the history of an
+// archived session, which is read-only. The error is ready to be returned.
+func (rs *RepeaterServer) writableSession(ctx echo.Context, sessionID int64) error {
+	logger := middleware.GetLoggerFromCtx(ctx)
+	requestId := middleware.GetRequestIdFromCtx(ctx)
+
+	session, err := rs.repo.GetSession(sessionID)
+	if err != nil {
+		logger.Error(requestId, errors.Wrap(err, "GetSession error").Error())
+		return echo.NewHTTPError(http.StatusInternalServerError, httperrors.INTERNAL_SERVER_ERR)
+	}
+	if session != nil && session.ArchivedAt != nil {
+		return echo.NewHTTPError(http.StatusConflict, httperrors.SESSION_ARCHIVED)
+	}
+	return nil
+}
+
+// getRequest loads the request :id, nil when the session the call is
+// scoped to has no such request. The error is ready to be returned.
+func (rs *RepeaterServer) getRequest(ctx echo.Context) (*RequestResponse, error) {
+	return rs.requestByID(ctx, ctx.Param("id"))
+}
+
+// requestByID is getRequest for an id given elsewhere than in :id.
+func (rs *RepeaterServer) requestByID(ctx echo.Context, id string) (*RequestResponse, error) {
 	logger := middleware.GetLoggerFromCtx(ctx)
 	requestId := middleware.GetRequestIdFromCtx(ctx)
 
-	reqId, err := strconv.Atoi(ctx.Param("id"))
+	reqId, err := strconv.Atoi(id)
 	if err != nil || reqId < 0 {
-		return echo.NewHTTPError(http.StatusBadRequest, httperrors.BAD_REQUEST_ID)
+		return nil, echo.NewHTTPError(http.StatusBadRequest, httperrors.BAD_REQUEST_ID)
+	}
+	sessionID, err := rs.sessionID(ctx)
+	if err != nil {
+		return nil, err
 	}
 	req, err := rs.repo.GetRequestByID(reqId)
 	if err != nil {
 		logger.Error(requestId, errors.Wrap(err, "GetRequestByID error").Error())
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, httperrors.INTERNAL_SERVER_ERR)
+	}
+	if req == nil || req.SessionID != sessionID {
+		return nil, nil
+	}
+	return req, nil
+}
+
+func (rs *RepeaterServer) HandleAllRequests(ctx echo.Context) error {
+	logger := middleware.GetLoggerFromCtx(ctx)
+	requestId := middleware.GetRequestIdFromCtx(ctx)
+	filter, err := parseRequestsFilter(ctx)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if filter.SessionID, err = rs.sessionID(ctx); err != nil {
+		return err
+	}
+	limit, err := parseRequestsPage(ctx, filter)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	// one more tells whether there is a next page
+	filter.Limit = limit + 1
+	requests, err := rs.repo.GetAllRequests(filter)
+	if err != nil {
+		logger.Error(requestId, errors.Wrap(err, "request dump error").Error())
+		return echo.NewHTTPError(http.StatusServiceUnavailable, httperrors.INTERNAL_SERVER_ERR)
+	}
+	requests = nextPage(ctx, requests, limit, filter)
+	if len(filter.Fields) == 0 {
+		return ctx.JSON(http.StatusOK, requests)
+	}
+	projected, err := projectRequests(requests, filter.Fields)
+	if err != nil {
+		logger.Error(requestId, errors.Wrap(err, "request dump error").Error())
+		return echo.NewHTTPError(http.StatusInternalServerError, httperrors.INTERNAL_SERVER_ERR)
+	}
+	return ctx.JSON(http.StatusOK, projected)
+}
+
+// nextPage cuts requests, loaded with filter.Limit one past limit, down
+// to the page and points the response at the next one, if there is one.
+func nextPage(ctx echo.Context, requests []RequestResponse, limit int, filter *RequestsFilter) []RequestResponse {
+	if len(requests) <= limit {
+		return requests
+	}
+	requests = requests[:limit]
+	cursor := CursorAt(&requests[limit-1], filter).Encode()
+	next := *ctx.Request().URL
+	query := next.Query()
+	query.Set("cursor", cursor)
+	next.RawQuery = query.Encode()
+	ctx.Response().Header().Set("X-Next-Cursor", cursor)
+	ctx.Response().Header().Set("Link", "<"+next.RequestURI()+`>; rel="next"`)
+	return requests
+}
+
+// projectRequests leaves only fields in the JSON objects of requests.
+func projectRequests(requests []RequestResponse, fields []string) ([]map[string]json.RawMessage, error) {
+	res := make([]map[string]json.RawMessage, len(requests))
+	for i := range requests {
+		j, err := json.Marshal(&requests[i])
+		if err != nil {
+			return nil, err
+		}
+		var all map[string]json.RawMessage
+		if err = json.Unmarshal(j, &all); err != nil {
+			return nil, err
+		}
+		res[i] = make(map[string]json.RawMessage, len(fields))
+		for _, field := range fields {
+			if value, ok := all[field]; ok {
+				res[i][field] = value
+			}
+		}
+	}
+	return res, nil
+}
+
+func (rs *RepeaterServer) HandleSearch(ctx echo.Context) error {
+	logger := middleware.GetLoggerFromCtx(ctx)
+	requestId := middleware.GetRequestIdFromCtx(ctx)
+
+	query, err := parseSearchQuery(ctx)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if query.SessionID, err = rs.sessionID(ctx); err != nil {
+		return err
+	}
+	hits, err := rs.repo.Search(query)
+	if err != nil {
+		logger.Error(requestId, errors.Wrap(err, "Search error").Error())
+		return echo.NewHTTPError(http.StatusInternalServerError, httperrors.INTERNAL_SERVER_ERR)
+	}
+	for i := range hits {
+		hits[i].Link = "/requests/" + strconv.FormatInt(hits[i].RequestID, 10)
+	}
+	return ctx.JSON(http.StatusOK, hits)
+}
+
+func (rs *RepeaterServer) HandleBlob(ctx echo.Context) error {
+	logger := middleware.GetLoggerFromCtx(ctx)
+	requestId := middleware.GetRequestIdFromCtx(ctx)
+
+	hash := strings.ToLower(ctx.Param("hash"))
+	if !blobs.IsHash(hash) {
+		return echo.NewHTTPError(http.StatusBadRequest, httperrors.BAD_BLOB_HASH)
+	}
+	data, err := rs.repo.GetBlob(hash)
+	if err != nil {
+		logger.Error(requestId, errors.Wrap(err, "GetBlob error").Error())
 		return echo.NewHTTPError(http.StatusInternalServerError, httperrors.INTERNAL_SERVER_ERR)
 	}
+	if data == nil {
+		return echo.NewHTTPError(http.StatusNotFound, httperrors.NO_SUCH_BLOB)
+	}
+	// the same content may have come with different types, so it is sniffed
+	ctx.Response().Header().Set("ETag", `"`+hash+`"`)
+	return ctx.Blob(http.StatusOK, http.DetectContentType(data), data)
+}
+
+func (rs *RepeaterServer) HandleMetrics(ctx echo.Context) error {
+	if rs.metrics == nil {
+		return echo.NewHTTPError(http.StatusNotFound)
+	}
+	ctx.Response().Header().Set(echo.HeaderContentType, "text/plain; version=0.0.4; charset=utf-8")
+	ctx.Response().WriteHeader(http.StatusOK)
+	return rs.metrics.WriteMetrics(ctx.Response())
+}
+
+// HandleRepeatRequest sends the request :id again, stores the repeat and
+// returns the upstream's response as it is, X-Repeat-Id refers to the repeat.
+func (rs *RepeaterServer) HandleRepeatRequest(ctx echo.Context) error {
+	logger := middleware.GetLoggerFromCtx(ctx)
+	requestId := middleware.GetRequestIdFromCtx(ctx)
+
+	req, err := rs.getRequest(ctx)
+	if err != nil {
+		return err
+	}
 	if req == nil {
-		return echo.NewHTTPError(http.StatusBadRequest, httperrors.NO_SUCH_REQUEST)
+		return echo.NewHTTPError(http.StatusNotFound, httperrors.NO_SUCH_REQUEST)
+	}
+	if err = rs.writableSession(ctx, req.SessionID); err != nil {
+		return err
+	}
+	scheme, host, port, ok := req.Target()
+	if !ok {
+		return echo.NewHTTPError(http.StatusBadRequest, httperrors.NO_UPSTREAM_ERR)
 	}
 
-	httpReq, err := http.ReadRequest(bufio.NewReader(strings.NewReader(req.Raw)))
+	httpReq, body, err := parseRaw(rs.replayRaw(ctx, req))
 	if err != nil {
 		logger.Error(requestId, errors.Wrap(err, "http ReadRequest error").Error())
 		return echo.NewHTTPError(http.StatusInternalServerError, httperrors.INTERNAL_SERVER_ERR)
 	}
-	var upstreamResp *http.Response
+	httpReq.RemoteAddr = ctx.Request().RemoteAddr
+	exchange, err := rs.send(ctx.Request().Context(), httpReq, body, &target{scheme: scheme, host: host, port: port})
+	if err != nil {
+		logger.Error(requestId, errors.Wrap(err, "repeat error").Error())
+		if exchange != nil {
+			rs.record(ctx, req, exchange)
+		}
+		return echo.NewHTTPError(http.StatusServiceUnavailable, httperrors.UPSTREAM_UNAVAIBLE_ERR)
+	}
+
+	// taken before the exchange is redacted for storing
+	resp := exchange.Response
+	code, respBody := resp.Code, resp.Body
+	for name, value := range resp.Headers {
+		for _, v := range headerValues(value) {
+			ctx.Response().Header().Add(name, v)
+		}
+	}
+	if id := rs.record(ctx, req, exchange); id > 0 {
+		ctx.Response().Header().Set("X-Repeat-Id", strconv.FormatInt(id, 10))
+	}
+
+	ctx.Response().WriteHeader(code)
+	if _, err = io.WriteString(ctx.Response(), respBody); err != nil {
+		logger.Error(requestId, errors.Wrap(err, "copy upstream's response to client").Error())
+	}
+	return nil
+}
+
+// replayRaw returns the raw request to repeat: the original, with the
+// secrets, when it is kept and the key is known, the redacted one otherwise.
+func (rs *RepeaterServer) replayRaw(ctx echo.Context, req *RequestResponse) string {
+	if req.Original == "" || !rs.redactor.KeepsOriginals() {
+		return req.Raw
+	}
+	original, err := rs.redactor.Open(req.Original)
+	if err != nil {
+		middleware.GetLoggerFromCtx(ctx).Warn(middleware.GetRequestIdFromCtx(ctx),
+			errors.Wrap(err, "opening original request, replaying the redacted one").Error())
+		return req.Raw
+	}
+	return original
+}
+
+// HandleRepeatWithEdits sends the request :id edited by a RepeatPatch,
+// stores the repeat and returns the upstream's response with the timing
+// of the exchange, request_id refers to the repeat.
+func (rs *RepeaterServer) HandleRepeatWithEdits(ctx echo.Context) error {
+	logger := middleware.GetLoggerFromCtx(ctx)
+	requestId := middleware.GetRequestIdFromCtx(ctx)
+
+	patch := &RepeatPatch{}
+	if err := json.NewDecoder(ctx.Request().Body).Decode(patch); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, httperrors.BAD_REPEAT)
+	}
+	if patch.Raw != "" && patch.hasEdits() {
+		return echo.NewHTTPError(http.StatusBadRequest, httperrors.RAW_WITH_EDITS)
+	}
 
-	host, ok := req.Headers["Host"].(string)
+	req, err := rs.getRequest(ctx)
+	if err != nil {
+		return err
+	}
+	if req == nil {
+		return echo.NewHTTPError(http.StatusNotFound, httperrors.NO_SUCH_REQUEST)
+	}
+	if err = rs.writableSession(ctx, req.SessionID); err != nil {
+		return err
+	}
+	scheme, host, port, ok := req.Target()
 	if !ok {
 		return echo.NewHTTPError(http.StatusBadRequest, httperrors.NO_UPSTREAM_ERR)
 	}
+	to := &target{scheme: scheme, host: host, port: port}
 
-	httpReq.Host = host
-	httpReq.URL.Host = host
-	httpReq.URL.Scheme = "http"
-	httpReq.URL.Opaque = ""
-
-	if req.IsHTTPS {
-		httpReq.Host = fmt.Sprintf("%s:%s", host, "443")
-		clientConfig := tls.Config{}
-		if rs.ProxyAsClientTLSConfig != nil {
-			clientConfig = *rs.ProxyAsClientTLSConfig
+	var httpReq *http.Request
+	var body []byte
+	if patch.Raw != "" {
+		if httpReq, body, err = parseRaw(patch.Raw); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, httperrors.BAD_RAW_REQUEST)
 		}
-		clientConfig.InsecureSkipVerify = true
-		connToUpstream, err := tls.Dial("tcp", httpReq.Host, &clientConfig)
-		if err != nil {
-			logger.Error(requestId, errors.Wrap(err, "dial error").Error())
-			return echo.NewHTTPError(http.StatusServiceUnavailable, httperrors.UPSTREAM_UNAVAIBLE_ERR)
+		// an absolute request target changes the upstream
+		if err = retarget(httpReq, to); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 		}
-		defer connToUpstream.Close()
-
-		_, err = connToUpstream.Write([]byte(req.Raw))
-		if err != nil {
-			logger.Error(requestId, errors.Wrap(err, "write request error").Error())
-			return nil
+	} else {
+		if httpReq, body, err = parseRaw(rs.replayRaw(ctx, req)); err != nil {
+			logger.Error(requestId, errors.Wrap(err, "http ReadRequest error").Error())
+			return echo.NewHTTPError(http.StatusInternalServerError, httperrors.INTERNAL_SERVER_ERR)
 		}
-
-		serverReader := bufio.NewReader(connToUpstream)
-		upstreamResp, err = http.ReadResponse(serverReader, httpReq)
-		if err != nil {
-			logger.Error(requestId, errors.Wrap(err, "read response error").Error())
-			return nil
+		if body, err = patch.apply(httpReq, body, to); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 		}
+	}
 
-	} else {
-		upstreamResp, err = http.DefaultTransport.RoundTrip(httpReq)
-		if err != nil {
-			logger.Error(requestId, errors.Wrap(err, "round trip").Error())
-			return echo.NewHTTPError(http.StatusServiceUnavailable, httperrors.INTERNAL_SERVER_ERR)
+	httpReq.RemoteAddr = ctx.Request().RemoteAddr
+	exchange, err := rs.send(ctx.Request().Context(), httpReq, body, to)
+	if err != nil {
+		logger.Error(requestId, errors.Wrap(err, "repeat error").Error())
+		if exchange != nil {
+			rs.record(ctx, req, exchange)
 		}
-		defer upstreamResp.Body.Close()
+		return echo.NewHTTPError(http.StatusServiceUnavailable, httperrors.UPSTREAM_UNAVAIBLE_ERR)
+	}
+	// the caller gets the response before it is redacted for storing
+	resp := newResponse(exchange.Response)
+	if id := rs.record(ctx, req, exchange); id > 0 {
+		resp.RequestID, resp.SessionID = id, req.SessionID
+	}
+	return ctx.JSON(http.StatusOK, resp)
+}
+
+// HandleRequestByID returns a request, with include=response its latest
+// response and with include=responses all of them, oldest first.
+func (rs *RepeaterServer) HandleRequestByID(ctx echo.Context) error {
+	include := ctx.QueryParam("include")
+	if include != "" && include != "response" && include != "responses" {
+		return echo.NewHTTPError(http.StatusBadRequest, httperrors.BAD_INCLUDE)
+	}
+	req, err := rs.getRequest(ctx)
+	if err != nil {
+		return err
+	}
+	if req == nil {
+		return echo.NewHTTPError(http.StatusNotFound, httperrors.NO_SUCH_REQUEST)
+	}
+	if include == "" {
+		return ctx.JSON(http.StatusOK, req)
+	}
+
+	responses, err := rs.getResponses(ctx, req.ID)
+	if err != nil {
+		return err
+	}
+	if include == "responses" {
+		req.Responses = responses
+	} else if len(responses) > 0 {
+		req.Response = &responses[len(responses)-1]
+	}
+	return ctx.JSON(http.StatusOK, req)
+}
+
+// HandleRequestResponse returns the latest response of a request,
+// with all=true all of them, oldest first.
+func (rs *RepeaterServer) HandleRequestResponse(ctx echo.Context) error {
+	req, err := rs.getRequest(ctx)
+	if err != nil {
+		return err
+	}
+	if req == nil {
+		return echo.NewHTTPError(http.StatusNotFound, httperrors.NO_SUCH_REQUEST)
+	}
+	responses, err := rs.getResponses(ctx, req.ID)
+	if err != nil {
+		return err
+	}
+	if ctx.QueryParam("all") == "true" {
+		return ctx.JSON(http.StatusOK, responses)
+	}
+	if len(responses) == 0 {
+		return echo.NewHTTPError(http.StatusNotFound, httperrors.NO_SUCH_RESPONSE)
+	}
+	return ctx.JSON(http.StatusOK, &responses[len(responses)-1])
+}
+
+// HandleRepeats returns the repeats made from a request and from its
+// repeats, oldest first, parent_id links each to what it repeats.
+func (rs *RepeaterServer) HandleRepeats(ctx echo.Context) error {
+	logger := middleware.GetLoggerFromCtx(ctx)
+	requestId := middleware.GetRequestIdFromCtx(ctx)
+
+	req, err := rs.getRequest(ctx)
+	if err != nil {
+		return err
+	}
+	if req == nil {
+		return echo.NewHTTPError(http.StatusNotFound, httperrors.NO_SUCH_REQUEST)
+	}
+	repeats, err := rs.repo.GetRepeats(int(req.ID))
+	if err != nil {
+		logger.Error(requestId, errors.Wrap(err, "GetRepeats error").Error())
+		return echo.NewHTTPError(http.StatusInternalServerError, httperrors.INTERNAL_SERVER_ERR)
+	}
+	return ctx.JSON(http.StatusOK, repeats)
+}
+
+// HandleExport returns the request as a command or a program that sends
+// it again, format is one of ExportFormats, curl by default.
+func (rs *RepeaterServer) HandleExport(ctx echo.Context) error {
+	logger := middleware.GetLoggerFromCtx(ctx)
+	requestId := middleware.GetRequestIdFromCtx(ctx)
 
+	format := ctx.QueryParam("format")
+	if format == "" {
+		format = ExportFormats[0]
+	}
+	if !IsExportFormat(format) {
+		return echo.NewHTTPError(http.StatusBadRequest, httperrors.BAD_EXPORT_FORMAT)
+	}
+	req, err := rs.getRequest(ctx)
+	if err != nil {
+		return err
+	}
+	if req == nil {
+		return echo.NewHTTPError(http.StatusNotFound, httperrors.NO_SUCH_REQUEST)
+	}
+	res, err := Export(req, format)
+	if err != nil {
+		logger.Error(requestId, errors.Wrap(err, "Export error").Error())
+		return echo.NewHTTPError(http.StatusInternalServerError, httperrors.INTERNAL_SERVER_ERR)
 	}
+	return ctx.String(http.StatusOK, res)
+}
 
-	for key, values := range upstreamResp.Header {
-		for _, value := range values {
-			ctx.Response().Header().Add(key, value)
+// HandleDiff compares the latest responses of the requests a and b,
+// with format=text only in the unified format. ignore_headers and mask
+// add to the ignored headers and the masked regexes of the config.
+func (rs *RepeaterServer) HandleDiff(ctx echo.Context) error {
+	format := ctx.QueryParam("format")
+	if format != "" && format != "json" && format != "text" {
+		return echo.NewHTTPError(http.StatusBadRequest, httperrors.BAD_DIFF_FORMAT)
+	}
+	differ := rs.differ
+	ignore, masks := listParam(ctx, "ignore_headers"), ctx.QueryParams()["mask"]
+	if len(ignore) > 0 || len(masks) > 0 {
+		var err error
+		if differ, err = rs.differ.With(ignore, masks); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, httperrors.BAD_MASK)
 		}
 	}
 
-	ctx.Response().Status = upstreamResp.StatusCode
-	if _, err = io.Copy(ctx.Response(), upstreamResp.Body); err != nil {
-		logger.Error(requestId, errors.Wrap(err, "copy upstream's response to client").Error())
+	a, err := rs.latestResponse(ctx, ctx.QueryParam("a"))
+	if err != nil {
+		return err
+	}
+	b, err := rs.latestResponse(ctx, ctx.QueryParam("b"))
+	if err != nil {
+		return err
+	}
+	diff := differ.Diff(a, b)
+	if format == "text" {
+		return ctx.String(http.StatusOK, diff.Unified)
+	}
+	return ctx.JSON(http.StatusOK, diff)
+}
+
+// latestResponse returns the latest response of a request, decoded.
+// The error is ready to be returned.
+func (rs *RepeaterServer) latestResponse(ctx echo.Context, id string) (*Response, error) {
+	if id == "" {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, httperrors.NO_DIFF_IDS)
+	}
+	req, err := rs.requestByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if req == nil {
+		return nil, echo.NewHTTPError(http.StatusNotFound, httperrors.NO_SUCH_REQUEST)
+	}
+	responses, err := rs.getResponses(ctx, req.ID)
+	if err != nil {
+		return nil, err
+	}
+	if len(responses) == 0 {
+		return nil, echo.NewHTTPError(http.StatusNotFound, httperrors.NO_SUCH_RESPONSE)
+	}
+	return &responses[len(responses)-1], nil
+}
+
+func (rs *RepeaterServer) HandleResponseByID(ctx echo.Context) error {
+	logger := middleware.GetLoggerFromCtx(ctx)
+	requestId := middleware.GetRequestIdFromCtx(ctx)
+
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil || id <= 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, httperrors.BAD_RESPONSE_ID)
+	}
+	sessionID, err := rs.sessionID(ctx)
+	if err != nil {
+		return err
+	}
+	resp, err := rs.repo.GetResponse(id)
+	if err != nil {
+		logger.Error(requestId, errors.Wrap(err, "GetResponse error").Error())
 		return echo.NewHTTPError(http.StatusInternalServerError, httperrors.INTERNAL_SERVER_ERR)
 	}
+	if resp == nil || resp.SessionID != sessionID {
+		return echo.NewHTTPError(http.StatusNotFound, httperrors.NO_SUCH_RESPONSE)
+	}
+	resp.Decode()
+	return ctx.JSON(http.StatusOK, resp)
+}
 
-	return nil
+// getResponses returns the decoded responses of a request, oldest first.
+func (rs *RepeaterServer) getResponses(ctx echo.Context, requestID int64) ([]Response, error) {
+	logger := middleware.GetLoggerFromCtx(ctx)
+	requestId := middleware.GetRequestIdFromCtx(ctx)
+
+	responses, err := rs.repo.GetResponses(int(requestID))
+	if err != nil {
+		logger.Error(requestId, errors.Wrap(err, "GetResponses error").Error())
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, httperrors.INTERNAL_SERVER_ERR)
+	}
+	for i := range responses {
+		responses[i].Decode()
+	}
+	return responses, nil
 }
 
-func (rs *RepeaterServer) HandleRequestByID(ctx echo.Context) error {
+func (rs *RepeaterServer) HandleDeleteRequest(ctx echo.Context) error {
 	logger := middleware.GetLoggerFromCtx(ctx)
 	requestId := middleware.GetRequestIdFromCtx(ctx)
 
-	reqId, err := strconv.Atoi(ctx.Param("id"))
-	if err != nil || reqId < 0 {
-		return echo.NewHTTPError(http.StatusBadRequest, httperrors.BAD_REQUEST_ID)
+	req, err := rs.getRequest(ctx)
+	if err != nil {
+		return err
 	}
-	req, err := rs.repo.GetRequestByID(reqId)
+	if req == nil {
+		return echo.NewHTTPError(http.StatusNotFound, httperrors.NO_SUCH_REQUEST)
+	}
+	if err = rs.writableSession(ctx, req.SessionID); err != nil {
+		return err
+	}
+	reqId := int(req.ID)
+	deleted, err := rs.repo.DeleteRequest(reqId)
 	if err != nil {
-		logger.Error(requestId, errors.Wrap(err, "GetRequestByID error").Error())
+		logger.Error(requestId, errors.Wrap(err, "DeleteRequest error").Error())
+		return echo.NewHTTPError(http.StatusInternalServerError, httperrors.INTERNAL_SERVER_ERR)
+	}
+	if !deleted {
+		return echo.NewHTTPError(http.StatusNotFound, httperrors.NO_SUCH_REQUEST)
+	}
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+// HandleDeleteRequests deletes the requests matching the filter of
+// GET /requests. Deleting everything takes all=true.
+func (rs *RepeaterServer) HandleDeleteRequests(ctx echo.Context) error {
+	logger := middleware.GetLoggerFromCtx(ctx)
+	requestId := middleware.GetRequestIdFromCtx(ctx)
+
+	filter, err := parseRequestsFilter(ctx)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if filter.SessionID, err = rs.sessionID(ctx); err != nil {
+		return err
+	}
+	if err = rs.writableSession(ctx, filter.SessionID); err != nil {
+		return err
+	}
+	if filter.Empty() && ctx.QueryParam("all") != "true" {
+		return echo.NewHTTPError(http.StatusBadRequest, httperrors.NO_DELETE_FILTER)
+	}
+	deleted, err := rs.repo.DeleteRequests(filter)
+	if err != nil {
+		logger.Error(requestId, errors.Wrap(err, "DeleteRequests error").Error())
 		return echo.NewHTTPError(http.StatusInternalServerError, httperrors.INTERNAL_SERVER_ERR)
 	}
+	return ctx.JSON(http.StatusOK, map[string]int{"deleted": deleted})
+}
+
+func (rs *RepeaterServer) HandleAnnotation(ctx echo.Context) error {
+	req, err := rs.getRequest(ctx)
+	if err != nil {
+		return err
+	}
 	if req == nil {
-		return echo.NewHTTPError(http.StatusBadRequest, httperrors.NO_SUCH_REQUEST)
+		return echo.NewHTTPError(http.StatusNotFound, httperrors.NO_SUCH_REQUEST)
 	}
-	return ctx.JSON(http.StatusOK, req)
+	annotation := req.Annotation
+	if annotation == nil {
+		annotation = &Annotation{}
+	}
+	if annotation.Tags == nil {
+		annotation.Tags = []string{}
+	}
+	return ctx.JSON(http.StatusOK, annotation)
+}
+
+// HandleSetAnnotation replaces the note, color and tags of a request
+// with the ones in the body.
+func (rs *RepeaterServer) HandleSetAnnotation(ctx echo.Context) error {
+	annotation := &Annotation{}
+	if err := ctx.Bind(annotation); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, httperrors.BAD_ANNOTATION)
+	}
+	if err := checkAnnotation(annotation); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return rs.setAnnotation(ctx, annotation)
+}
+
+func (rs *RepeaterServer) HandleDeleteAnnotation(ctx echo.Context) error {
+	return rs.setAnnotation(ctx, &Annotation{})
+}
+
+func (rs *RepeaterServer) setAnnotation(ctx echo.Context, annotation *Annotation) error {
+	logger := middleware.GetLoggerFromCtx(ctx)
+	requestId := middleware.GetRequestIdFromCtx(ctx)
+
+	req, err := rs.getRequest(ctx)
+	if err != nil {
+		return err
+	}
+	if req == nil {
+		return echo.NewHTTPError(http.StatusNotFound, httperrors.NO_SUCH_REQUEST)
+	}
+	if err = rs.writableSession(ctx, req.SessionID); err != nil {
+		return err
+	}
+	reqId := int(req.ID)
+	found, err := rs.repo.SetAnnotation(reqId, annotation)
+	if err != nil {
+		logger.Error(requestId, errors.Wrap(err, "SetAnnotation error").Error())
+		return echo.NewHTTPError(http.StatusInternalServerError, httperrors.INTERNAL_SERVER_ERR)
+	}
+	if !found {
+		return echo.NewHTTPError(http.StatusNotFound, httperrors.NO_SUCH_REQUEST)
+	}
+	if ctx.Request().Method == http.MethodDelete {
+		return ctx.NoContent(http.StatusNoContent)
+	}
+	return ctx.JSON(http.StatusOK, annotation)
+}
+
+// HandleAddTags adds the tags of the body, {"tags": [...]}, to a request.
+func (rs *RepeaterServer) HandleAddTags(ctx echo.Context) error {
+	logger := middleware.GetLoggerFromCtx(ctx)
+	requestId := middleware.GetRequestIdFromCtx(ctx)
+
+	req, err := rs.getRequest(ctx)
+	if err != nil {
+		return err
+	}
+	if req == nil {
+		return echo.NewHTTPError(http.StatusNotFound, httperrors.NO_SUCH_REQUEST)
+	}
+	if err = rs.writableSession(ctx, req.SessionID); err != nil {
+		return err
+	}
+	reqId := int(req.ID)
+	annotation := &Annotation{}
+	if err = ctx.Bind(annotation); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, httperrors.BAD_ANNOTATION)
+	}
+	if err = checkAnnotation(annotation); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	found, err := rs.repo.AddTags(reqId, annotation.Tags)
+	if err != nil {
+		logger.Error(requestId, errors.Wrap(err, "AddTags error").Error())
+		return echo.NewHTTPError(http.StatusInternalServerError, httperrors.INTERNAL_SERVER_ERR)
+	}
+	if !found {
+		return echo.NewHTTPError(http.StatusNotFound, httperrors.NO_SUCH_REQUEST)
+	}
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+func (rs *RepeaterServer) HandleRemoveTag(ctx echo.Context) error {
+	logger := middleware.GetLoggerFromCtx(ctx)
+	requestId := middleware.GetRequestIdFromCtx(ctx)
+
+	req, err := rs.getRequest(ctx)
+	if err != nil {
+		return err
+	}
+	if req == nil {
+		return echo.NewHTTPError(http.StatusNotFound, httperrors.NO_SUCH_REQUEST)
+	}
+	if err = rs.writableSession(ctx, req.SessionID); err != nil {
+		return err
+	}
+	reqId := int(req.ID)
+	tag, err := url.PathUnescape(ctx.Param("tag"))
+	if err != nil || !IsTag(tag) {
+		return echo.NewHTTPError(http.StatusBadRequest, httperrors.BAD_TAG)
+	}
+	removed, err := rs.repo.RemoveTag(reqId, tag)
+	if err != nil {
+		logger.Error(requestId, errors.Wrap(err, "RemoveTag error").Error())
+		return echo.NewHTTPError(http.StatusInternalServerError, httperrors.INTERNAL_SERVER_ERR)
+	}
+	if !removed {
+		return echo.NewHTTPError(http.StatusNotFound, httperrors.NO_SUCH_TAG)
+	}
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+func (rs *RepeaterServer) HandleTags(ctx echo.Context) error {
+	logger := middleware.GetLoggerFromCtx(ctx)
+	requestId := middleware.GetRequestIdFromCtx(ctx)
+
+	sessionID, err := rs.sessionID(ctx)
+	if err != nil {
+		return err
+	}
+	tags, err := rs.repo.GetTags(sessionID)
+	if err != nil {
+		logger.Error(requestId, errors.Wrap(err, "GetTags error").Error())
+		return echo.NewHTTPError(http.StatusInternalServerError, httperrors.INTERNAL_SERVER_ERR)
+	}
+	return ctx.JSON(http.StatusOK, tags)
+}
+
+// HandleDeleteTag removes a tag from the requests of the session.
+func (rs *RepeaterServer) HandleDeleteTag(ctx echo.Context) error {
+	logger := middleware.GetLoggerFromCtx(ctx)
+	requestId := middleware.GetRequestIdFromCtx(ctx)
+
+	tag, err := url.PathUnescape(ctx.Param("tag"))
+	if err != nil || !IsTag(tag) {
+		return echo.NewHTTPError(http.StatusBadRequest, httperrors.BAD_TAG)
+	}
+	sessionID, err := rs.sessionID(ctx)
+	if err != nil {
+		return err
+	}
+	if err = rs.writableSession(ctx, sessionID); err != nil {
+		return err
+	}
+	deleted, err := rs.repo.DeleteTag(sessionID, tag)
+	if err != nil {
+		logger.Error(requestId, errors.Wrap(err, "DeleteTag error").Error())
+		return echo.NewHTTPError(http.StatusInternalServerError, httperrors.INTERNAL_SERVER_ERR)
+	}
+	if !deleted {
+		return echo.NewHTTPError(http.StatusNotFound, httperrors.NO_SUCH_TAG)
+	}
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+func (rs *RepeaterServer) HandleSessions(ctx echo.Context) error {
+	logger := middleware.GetLoggerFromCtx(ctx)
+	requestId := middleware.GetRequestIdFromCtx(ctx)
+
+	sessions, err := rs.repo.GetSessions()
+	if err != nil {
+		logger.Error(requestId, errors.Wrap(err, "GetSessions error").Error())
+		return echo.NewHTTPError(http.StatusInternalServerError, httperrors.INTERNAL_SERVER_ERR)
+	}
+	return ctx.JSON(http.StatusOK, sessions)
+}
+
+func (rs *RepeaterServer) HandleSession(ctx echo.Context) error {
+	session, err := rs.getSession(ctx, ctx.Param("sid"))
+	if err != nil {
+		return err
+	}
+	return ctx.JSON(http.StatusOK, session)
+}
+
+// sessionBody is the body of POST and PATCH /sessions,
+// the fields left out keep their values.
+type sessionBody struct {
+	Name        *string `json:"name"`
+	Description *string `json:"description"`
+	Scope       *Scope  `json:"scope"`
+}
+
+func (b *sessionBody) apply(session *Session) error {
+	if b.Name != nil {
+		session.Name = *b.Name
+	}
+	if b.Description != nil {
+		session.Description = *b.Description
+	}
+	if b.Scope != nil {
+		session.Scope = *b.Scope
+	}
+	if !IsSessionName(session.Name) {
+		return errors.New(httperrors.BAD_SESSION_NAME)
+	}
+	if !session.Scope.Valid() {
+		return errors.New(httperrors.BAD_SCOPE)
+	}
+	return nil
+}
+
+// HandleCreateSession creates a session, the proxy keeps recording into
+// the active one until the new one is activated.
+func (rs *RepeaterServer) HandleCreateSession(ctx echo.Context) error {
+	logger := middleware.GetLoggerFromCtx(ctx)
+	requestId := middleware.GetRequestIdFromCtx(ctx)
+
+	body := &sessionBody{}
+	if err := ctx.Bind(body); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, httperrors.BAD_SESSION)
+	}
+	session := &Session{}
+	if err := body.apply(session); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	err := rs.repo.CreateSession(session)
+	if err == ErrSessionExists {
+		return echo.NewHTTPError(http.StatusConflict, httperrors.SESSION_EXISTS)
+	}
+	if err != nil {
+		logger.Error(requestId, errors.Wrap(err, "CreateSession error").Error())
+		return echo.NewHTTPError(http.StatusInternalServerError, httperrors.INTERNAL_SERVER_ERR)
+	}
+	return ctx.JSON(http.StatusCreated, session)
+}
+
+// HandleUpdateSession changes the name, description or scope of a session,
+// a new scope of the active session applies to the traffic from now on.
+func (rs *RepeaterServer) HandleUpdateSession(ctx echo.Context) error {
+	logger := middleware.GetLoggerFromCtx(ctx)
+	requestId := middleware.GetRequestIdFromCtx(ctx)
+
+	session, err := rs.getSession(ctx, ctx.Param("sid"))
+	if err != nil {
+		return err
+	}
+	body := &sessionBody{}
+	if err = ctx.Bind(body); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, httperrors.BAD_SESSION)
+	}
+	if err = body.apply(session); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	found, err := rs.repo.UpdateSession(session)
+	if err == ErrSessionExists {
+		return echo.NewHTTPError(http.StatusConflict, httperrors.SESSION_EXISTS)
+	}
+	if err != nil {
+		logger.Error(requestId, errors.Wrap(err, "UpdateSession error").Error())
+		return echo.NewHTTPError(http.StatusInternalServerError, httperrors.INTERNAL_SERVER_ERR)
+	}
+	if !found {
+		return echo.NewHTTPError(http.StatusNotFound, httperrors.NO_SUCH_SESSION)
+	}
+	if session.Active {
+		rs.sessions.Set(session)
+	}
+	return ctx.JSON(http.StatusOK, session)
+}
+
+// HandleActivateSession switches the proxy to recording into a session.
+func (rs *RepeaterServer) HandleActivateSession(ctx echo.Context) error {
+	logger := middleware.GetLoggerFromCtx(ctx)
+	requestId := middleware.GetRequestIdFromCtx(ctx)
+
+	session, err := rs.getSession(ctx, ctx.Param("sid"))
+	if err != nil {
+		return err
+	}
+	if session.ArchivedAt != nil {
+		return echo.NewHTTPError(http.StatusConflict, httperrors.SESSION_ARCHIVED)
+	}
+	found, err := rs.repo.ActivateSession(session.ID)
+	if err != nil {
+		logger.Error(requestId, errors.Wrap(err, "ActivateSession error").Error())
+		return echo.NewHTTPError(http.StatusInternalServerError, httperrors.INTERNAL_SERVER_ERR)
+	}
+	if !found {
+		return echo.NewHTTPError(http.StatusNotFound, httperrors.NO_SUCH_SESSION)
+	}
+	session.Active = true
+	rs.sessions.Set(session)
+	return ctx.JSON(http.StatusOK, session)
+}
+
+// HandleArchiveSession makes the history of a session read-only, the
+// writes to it are refused with 409 and its running attacks are cancelled.
+func (rs *RepeaterServer) HandleArchiveSession(ctx echo.Context) error {
+	logger := middleware.GetLoggerFromCtx(ctx)
+	requestId := middleware.GetRequestIdFromCtx(ctx)
+
+	session, err := rs.getSession(ctx, ctx.Param("sid"))
+	if err != nil {
+		return err
+	}
+	if session.Active {
+		return echo.NewHTTPError(http.StatusConflict, httperrors.SESSION_ACTIVE)
+	}
+	if session.ArchivedAt == nil {
+		if _, err = rs.repo.ArchiveSession(session.ID); err != nil {
+			logger.Error(requestId, errors.Wrap(err, "ArchiveSession error").Error())
+			return echo.NewHTTPError(http.StatusInternalServerError, httperrors.INTERNAL_SERVER_ERR)
+		}
+		rs.attacker.cancelSession(session.ID)
+		if session, err = rs.getSession(ctx, ctx.Param("sid")); err != nil {
+			return err
+		}
+	}
+	return ctx.JSON(http.StatusOK, session)
+}
+
+// HandleExportSession returns a session with its requests, annotations
+// included, as a JSON file.
+func (rs *RepeaterServer) HandleExportSession(ctx echo.Context) error {
+	logger := middleware.GetLoggerFromCtx(ctx)
+	requestId := middleware.GetRequestIdFromCtx(ctx)
+
+	session, err := rs.getSession(ctx, ctx.Param("sid"))
+	if err != nil {
+		return err
+	}
+	requests, err := rs.repo.GetAllRequests(&RequestsFilter{SessionID: session.ID})
+	if err != nil {
+		logger.Error(requestId, errors.Wrap(err, "request dump error").Error())
+		return echo.NewHTTPError(http.StatusInternalServerError, httperrors.INTERNAL_SERVER_ERR)
+	}
+	filename := "session-" + strconv.FormatInt(session.ID, 10) + ".json"
+	ctx.Response().Header().Set(echo.HeaderContentDisposition, `attachment; filename="`+filename+`"`)
+	return ctx.JSON(http.StatusOK, &SessionExport{Session: session, Requests: requests})
+}
+
+// HandleExportHAR returns a page of the requests matching the filters of
+// GET /requests as a HAR file, an entry for each of their responses. It
+// is paged as GET /requests is, fields are left out.
+func (rs *RepeaterServer) HandleExportHAR(ctx echo.Context) error {
+	logger := middleware.GetLoggerFromCtx(ctx)
+	requestId := middleware.GetRequestIdFromCtx(ctx)
+
+	filter, err := parseRequestsFilter(ctx)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if filter.SessionID, err = rs.sessionID(ctx); err != nil {
+		return err
+	}
+	limit, err := parseRequestsPage(ctx, filter)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	// the entries are made of whole requests
+	filter.Fields = nil
+	filter.Limit = limit + 1
+	requests, err := rs.repo.GetAllRequests(filter)
+	if err != nil {
+		logger.Error(requestId, errors.Wrap(err, "request dump error").Error())
+		return echo.NewHTTPError(http.StatusInternalServerError, httperrors.INTERNAL_SERVER_ERR)
+	}
+	requests = nextPage(ctx, requests, limit, filter)
+
+	ids := make([]int64, len(requests))
+	for i := range requests {
+		ids[i] = requests[i].ID
+	}
+	all, err := rs.repo.GetResponsesOf(ids)
+	if err != nil {
+		logger.Error(requestId, errors.Wrap(err, "GetResponsesOf error").Error())
+		return echo.NewHTTPError(http.StatusInternalServerError, httperrors.INTERNAL_SERVER_ERR)
+	}
+	byRequest := make(map[int64][]Response, len(requests))
+	for i := range all {
+		all[i].Decode()
+		byRequest[all[i].RequestID] = append(byRequest[all[i].RequestID], all[i])
+	}
+
+	entries := make([]*HAREntry, 0, len(all)+len(requests))
+	for i := range requests {
+		req := &requests[i]
+		responses := byRequest[req.ID]
+		if len(responses) == 0 {
+			responses = []Response{{}}
+		}
+		for j := range responses {
+			var resp *Response
+			if responses[j].ID != 0 {
+				resp = &responses[j]
+			}
+			entry, err := NewHAREntry(req, resp)
+			if err != nil {
+				// a request the proxy could not parse is left out, not the whole file
+				logger.Error(requestId, errors.Wrap(err, "NewHAREntry error").Error())
+				break
+			}
+			entries = append(entries, entry)
+		}
+	}
+	filename := "session-" + strconv.FormatInt(filter.SessionID, 10) + ".har"
+	ctx.Response().Header().Set(echo.HeaderContentDisposition, `attachment; filename="`+filename+`"`)
+	return ctx.JSON(http.StatusOK, NewHAR(entries))
+}
+
+const maxImportSize = 64 << 20
+
+// HandleImport stores the requests of the file in the body as requests
+// of the session, source=import. The format is har, curl or http, see
+// ImportFormats; var=name=value sets the variables of .http files.
+// Requests that cannot be imported are skipped and listed in the result.
+func (rs *RepeaterServer) HandleImport(ctx echo.Context) error {
+	logger := middleware.GetLoggerFromCtx(ctx)
+	requestId := middleware.GetRequestIdFromCtx(ctx)
+
+	format := ctx.Param("format")
+	if !IsImportFormat(format) {
+		return echo.NewHTTPError(http.StatusBadRequest, httperrors.BAD_IMPORT_FORMAT)
+	}
+	opts := &ImportOptions{Variables: map[string]string{}}
+	for _, v := range ctx.QueryParams()["var"] {
+		i := strings.IndexByte(v, '=')
+		if i <= 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, httperrors.BAD_IMPORT_VARIABLE)
+		}
+		opts.Variables[v[:i]] = v[i+1:]
+	}
+	sessionID, err := rs.sessionID(ctx)
+	if err != nil {
+		return err
+	}
+	if err = rs.writableSession(ctx, sessionID); err != nil {
+		return err
+	}
+	data, err := readImport(ctx)
+	if err != nil {
+		return err
+	}
+	items, err := ParseImport(format, data, opts)
+	if err == ErrNothingToImport {
+		return echo.NewHTTPError(http.StatusBadRequest, httperrors.NOTHING_TO_IMPORT)
+	}
+	if err != nil && format == "har" {
+		return echo.NewHTTPError(http.StatusBadRequest, httperrors.BAD_HAR)
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, httperrors.BAD_IMPORT+": "+err.Error())
+	}
+
+	res, err := StoreImported(rs.repo, rs.redactor, sessionID, items)
+	if err != nil {
+		logger.Error(requestId, err.Error())
+		return echo.NewHTTPError(http.StatusInternalServerError, httperrors.INTERNAL_SERVER_ERR)
+	}
+	return ctx.JSON(http.StatusCreated, res)
+}
+
+// readImport reads the body of an import, up to maxImportSize bytes.
+func readImport(ctx echo.Context) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(ctx.Request().Body, maxImportSize+1))
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest)
+	}
+	if len(data) > maxImportSize {
+		return nil, echo.NewHTTPError(http.StatusRequestEntityTooLarge, httperrors.IMPORT_TOO_LARGE)
+	}
+	return data, nil
+}
+
+// attackBody is the body of POST /attacks, see Attack.
+type attackBody struct {
+	RequestID   int64        `json:"request_id"`
+	Type        string       `json:"type"`
+	Template    string       `json:"template"`
+	Payloads    []PayloadSet `json:"payloads"`
+	Concurrency int          `json:"concurrency"`
+	Delay       int          `json:"delay"`
+	Grep        []string     `json:"grep"`
+}
+
+// HandleCreateAttack starts an attack on the upstream of a request of
+// the session, the attack is returned while it runs.
+func (rs *RepeaterServer) HandleCreateAttack(ctx echo.Context) error {
+	logger := middleware.GetLoggerFromCtx(ctx)
+	requestId := middleware.GetRequestIdFromCtx(ctx)
+
+	body := &attackBody{}
+	if err := json.NewDecoder(ctx.Request().Body).Decode(body); err != nil || body.RequestID <= 0 || body.Template == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, httperrors.BAD_ATTACK)
+	}
+	req, err := rs.requestByID(ctx, strconv.FormatInt(body.RequestID, 10))
+	if err != nil {
+		return err
+	}
+	if req == nil {
+		return echo.NewHTTPError(http.StatusNotFound, httperrors.NO_SUCH_REQUEST)
+	}
+	if err = rs.writableSession(ctx, req.SessionID); err != nil {
+		return err
+	}
+	attack := &Attack{
+		Type:        body.Type,
+		Template:    body.Template,
+		Payloads:    body.Payloads,
+		Concurrency: body.Concurrency,
+		Delay:       body.Delay,
+		Grep:        body.Grep,
+	}
+	if err = rs.attacker.start(attack, req); err != nil {
+		if IsAttackError(err) {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		logger.Error(requestId, errors.Wrap(err, "starting attack error").Error())
+		return echo.NewHTTPError(http.StatusInternalServerError, httperrors.INTERNAL_SERVER_ERR)
+	}
+	rs.attacker.view(attack)
+	return ctx.JSON(http.StatusCreated, attack)
+}
+
+// HandleAttacks lists the attacks of the session, newest first.
+func (rs *RepeaterServer) HandleAttacks(ctx echo.Context) error {
+	logger := middleware.GetLoggerFromCtx(ctx)
+	requestId := middleware.GetRequestIdFromCtx(ctx)
+
+	sessionID, err := rs.sessionID(ctx)
+	if err != nil {
+		return err
+	}
+	attacks, err := rs.repo.GetAttacks(sessionID)
+	if err != nil {
+		logger.Error(requestId, errors.Wrap(err, "GetAttacks error").Error())
+		return echo.NewHTTPError(http.StatusInternalServerError, httperrors.INTERNAL_SERVER_ERR)
+	}
+	for i := range attacks {
+		rs.attacker.view(&attacks[i])
+	}
+	return ctx.JSON(http.StatusOK, attacks)
+}
+
+// getAttack loads the attack :aid of the session the call is scoped to
+// with the state of its job. The error is ready to be returned.
+func (rs *RepeaterServer) getAttack(ctx echo.Context) (*Attack, error) {
+	logger := middleware.GetLoggerFromCtx(ctx)
+	requestId := middleware.GetRequestIdFromCtx(ctx)
+
+	id, err := strconv.ParseInt(ctx.Param("aid"), 10, 64)
+	if err != nil || id <= 0 {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, httperrors.BAD_ATTACK_ID)
+	}
+	sessionID, err := rs.sessionID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	attack, err := rs.repo.GetAttack(id)
+	if err != nil {
+		logger.Error(requestId, errors.Wrap(err, "GetAttack error").Error())
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, httperrors.INTERNAL_SERVER_ERR)
+	}
+	if attack == nil || attack.SessionID != sessionID {
+		return nil, echo.NewHTTPError(http.StatusNotFound, httperrors.NO_SUCH_ATTACK)
+	}
+	rs.attacker.view(attack)
+	return attack, nil
+}
+
+func (rs *RepeaterServer) HandleAttack(ctx echo.Context) error {
+	attack, err := rs.getAttack(ctx)
+	if err != nil {
+		return err
+	}
+	return ctx.JSON(http.StatusOK, attack)
+}
+
+const (
+	defaultAttackResultsLimit = 100
+	maxAttackResultsLimit     = 1000
+)
+
+// HandleAttackResults lists the results of an attack: sort (index,
+// status, length, duration) and order, status as for GET /requests, 0
+// for no response, matched=true for the results matching a grep, limit
+// and offset.
+func (rs *RepeaterServer) HandleAttackResults(ctx echo.Context) error {
+	logger := middleware.GetLoggerFromCtx(ctx)
+	requestId := middleware.GetRequestIdFromCtx(ctx)
+
+	attack, err := rs.getAttack(ctx)
+	if err != nil {
+		return err
+	}
+	filter, err := parseAttackResultsFilter(ctx)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	filter.AttackID = attack.ID
+	results, err := rs.repo.GetAttackResults(filter)
+	if err != nil {
+		logger.Error(requestId, errors.Wrap(err, "GetAttackResults error").Error())
+		return echo.NewHTTPError(http.StatusInternalServerError, httperrors.INTERNAL_SERVER_ERR)
+	}
+	return ctx.JSON(http.StatusOK, results)
+}
+
+// HandlePauseAttack stops handing out the requests of a running attack,
+// the ones in flight complete.
+func (rs *RepeaterServer) HandlePauseAttack(ctx echo.Context) error {
+	return rs.controlAttack(ctx, rs.attacker.pause, httperrors.ATTACK_NOT_RUNNING)
+}
+
+func (rs *RepeaterServer) HandleResumeAttack(ctx echo.Context) error {
+	return rs.controlAttack(ctx, rs.attacker.resume, httperrors.ATTACK_NOT_PAUSED)
+}
+
+// HandleCancelAttack stops a running or paused attack for good, the
+// requests in flight are aborted.
+func (rs *RepeaterServer) HandleCancelAttack(ctx echo.Context) error {
+	return rs.controlAttack(ctx, rs.attacker.cancel, httperrors.ATTACK_OVER)
+}
+
+// controlAttack applies change to the job of the attack :aid and returns
+// the attack, 409 with refused when change does not apply.
+func (rs *RepeaterServer) controlAttack(ctx echo.Context, change func(id int64) bool, refused string) error {
+	attack, err := rs.getAttack(ctx)
+	if err != nil {
+		return err
+	}
+	if !change(attack.ID) {
+		return echo.NewHTTPError(http.StatusConflict, refused)
+	}
+	if attack, err = rs.getAttack(ctx); err != nil {
+		return err
+	}
+	return ctx.JSON(http.StatusOK, attack)
+}
+
+// HandleDeleteAttack deletes an attack that is over and its results,
+// the requests it sent stay.
+func (rs *RepeaterServer) HandleDeleteAttack(ctx echo.Context) error {
+	logger := middleware.GetLoggerFromCtx(ctx)
+	requestId := middleware.GetRequestIdFromCtx(ctx)
+
+	attack, err := rs.getAttack(ctx)
+	if err != nil {
+		return err
+	}
+	if err = rs.writableSession(ctx, attack.SessionID); err != nil {
+		return err
+	}
+	if rs.attacker.running(attack.ID) {
+		return echo.NewHTTPError(http.StatusConflict, httperrors.ATTACK_RUNNING)
+	}
+	deleted, err := rs.repo.DeleteAttack(attack.ID)
+	if err != nil {
+		logger.Error(requestId, errors.Wrap(err, "DeleteAttack error").Error())
+		return echo.NewHTTPError(http.StatusInternalServerError, httperrors.INTERNAL_SERVER_ERR)
+	}
+	if !deleted {
+		return echo.NewHTTPError(http.StatusNotFound, httperrors.NO_SUCH_ATTACK)
+	}
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+// checkAnnotation validates an annotation from a request body and
+// sorts its tags.
+func checkAnnotation(annotation *Annotation) error {
+	if len(annotation.Note) > maxNoteLen {
+		return errors.New(httperrors.NOTE_TOO_LONG)
+	}
+	if annotation.Color != "" && !IsColor(annotation.Color) {
+		return errors.New(httperrors.BAD_COLOR)
+	}
+	for _, tag := range annotation.Tags {
+		if !IsTag(tag) {
+			return errors.New(httperrors.BAD_TAG)
+		}
+	}
+	annotation.Tags = SortTags(annotation.Tags)
+	return nil
+}
+
+// parseRequestsFilter reads the query of GET /requests:
+// sort (id, started_at, size, duration, ttfb, response_size), order (asc, desc),
+// min_duration and max_duration in milliseconds, since and until in RFC 3339,
+// tag for requests carrying all of the tags, method, host, status (200, 5xx)
+// for any of the values, path glob, https, content_type of the response
+// (application/json, image/*), min_size and max_size of the body in bytes.
+// The lists are repeated or comma-separated.
+func parseRequestsFilter(ctx echo.Context) (*RequestsFilter, error) {
+	filter := &RequestsFilter{SortBy: ctx.QueryParam("sort")}
+	if filter.SortBy != "" && !IsSortKey(filter.SortBy) {
+		return nil, errors.New(httperrors.BAD_SORT_KEY)
+	}
+
+	switch ctx.QueryParam("order") {
+	case "", "asc":
+	case "desc":
+		filter.Desc = true
+	default:
+		return nil, errors.New(httperrors.BAD_SORT_ORDER)
+	}
+
+	for param, dst := range map[string]*time.Duration{
+		"min_duration": &filter.MinDuration,
+		"max_duration": &filter.MaxDuration,
+	} {
+		if value := ctx.QueryParam(param); value != "" {
+			ms, err := strconv.ParseFloat(value, 64)
+			if err != nil || ms < 0 {
+				return nil, errors.New(httperrors.BAD_DURATION)
+			}
+			*dst = time.Duration(ms * float64(time.Millisecond))
+		}
+	}
+
+	for param, dst := range map[string]*time.Time{
+		"since": &filter.Since,
+		"until": &filter.Until,
+	} {
+		if value := ctx.QueryParam(param); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return nil, errors.New(httperrors.BAD_TIME)
+			}
+			*dst = t
+		}
+	}
+
+	for _, tag := range listParam(ctx, "tag") {
+		if !IsTag(tag) {
+			return nil, errors.New(httperrors.BAD_TAG)
+		}
+		filter.Tags = append(filter.Tags, tag)
+	}
+
+	for _, method := range listParam(ctx, "method") {
+		filter.Methods = append(filter.Methods, strings.ToUpper(method))
+	}
+	for _, host := range listParam(ctx, "host") {
+		filter.Hosts = append(filter.Hosts, strings.ToLower(host))
+	}
+	filter.PathGlob = ctx.QueryParam("path")
+
+	if value := ctx.QueryParam("https"); value != "" {
+		https, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, errors.New(httperrors.BAD_HTTPS)
+		}
+		filter.HTTPS = &https
+	}
+
+	for _, status := range listParam(ctx, "status") {
+		r, ok := parseStatusRange(status)
+		if !ok {
+			return nil, errors.New(httperrors.BAD_STATUS)
+		}
+		filter.Statuses = append(filter.Statuses, r)
+	}
+
+	if value := ctx.QueryParam("content_type"); value != "" {
+		value = strings.ToLower(strings.TrimSpace(value))
+		if i := strings.IndexByte(value, '/'); i <= 0 || i == len(value)-1 || strings.ContainsAny(value, "; ") {
+			return nil, errors.New(httperrors.BAD_CONTENT_TYPE)
+		}
+		filter.ContentType = value
+	}
+
+	for param, dst := range map[string]*int64{
+		"min_size": &filter.MinSize,
+		"max_size": &filter.MaxSize,
+	} {
+		if value := ctx.QueryParam(param); value != "" {
+			size, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || size < 0 {
+				return nil, errors.New(httperrors.BAD_SIZE)
+			}
+			*dst = size
+		}
+	}
+	return filter, nil
+}
+
+// listParam returns the values of a repeated or comma-separated
+// query parameter, without the empty ones.
+func listParam(ctx echo.Context, name string) []string {
+	var values []string
+	for _, value := range ctx.QueryParams()[name] {
+		for _, v := range strings.Split(value, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				values = append(values, v)
+			}
+		}
+	}
+	return values
+}
+
+// parseStatusRange parses a response code, 404, or a class of them, 4xx.
+func parseStatusRange(status string) (StatusRange, bool) {
+	if len(status) != 3 || status[0] < '1' || status[0] > '5' {
+		return StatusRange{}, false
+	}
+	if strings.EqualFold(status[1:], "xx") {
+		min := int(status[0]-'0') * 100
+		return StatusRange{Min: min, Max: min + 99}, true
+	}
+	code, err := strconv.Atoi(status)
+	if err != nil {
+		return StatusRange{}, false
+	}
+	return StatusRange{Min: code, Max: code}, true
+}
+
+const (
+	defaultRequestsLimit = 100
+	maxRequestsLimit     = 1000
+)
+
+// parseRequestsPage reads the rest of the query of GET /requests into
+// filter: cursor, the X-Next-Cursor of the previous page with the same
+// sort and order, and fields, comma-separated RequestFields to return,
+// and returns limit.
+func parseRequestsPage(ctx echo.Context, filter *RequestsFilter) (int, error) {
+	limit := defaultRequestsLimit
+	if value := ctx.QueryParam("limit"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil || limit <= 0 {
+			return 0, errors.New(httperrors.BAD_LIMIT)
+		}
+		if limit > maxRequestsLimit {
+			limit = maxRequestsLimit
+		}
+	}
+
+	if value := ctx.QueryParam("cursor"); value != "" {
+		c, err := DecodeCursor(value)
+		sortBy := filter.SortBy
+		if sortBy == "" {
+			sortBy = "id"
+		}
+		if err != nil || c.SortBy != sortBy || c.Desc != filter.Desc {
+			return 0, errors.New(httperrors.BAD_CURSOR)
+		}
+		filter.After = c
+	}
+
+	if fields := listParam(ctx, "fields"); len(fields) > 0 {
+		// the id is always there to refer to the request by
+		filter.Fields = []string{"id"}
+		for _, field := range fields {
+			if !IsRequestField(field) {
+				return 0, errors.New(httperrors.BAD_FIELD)
+			}
+			if field != "id" {
+				filter.Fields = append(filter.Fields, field)
+			}
+		}
+	}
+	return limit, nil
+}
+
+const (
+	defaultSearchLimit = 50
+	maxSearchLimit     = 500
+)
+
+// parseSearchQuery reads the query of GET /search: q, mode (text, regex),
+// in (comma-separated repeater.SearchFields, all of them by default)
+// and limit.
+func parseSearchQuery(ctx echo.Context) (*SearchQuery, error) {
+	query := &SearchQuery{
+		Text:   ctx.QueryParam("q"),
+		Fields: SearchFields,
+		Limit:  defaultSearchLimit,
+	}
+	if strings.TrimSpace(query.Text) == "" {
+		return nil, errors.New(httperrors.NO_SEARCH_QUERY)
+	}
+
+	switch ctx.QueryParam("mode") {
+	case "", "text":
+	case "regex":
+		re, err := regexp.Compile(query.Text)
+		if err != nil {
+			return nil, errors.New(httperrors.BAD_SEARCH_REGEX)
+		}
+		query.Regex = re
+	default:
+		return nil, errors.New(httperrors.BAD_SEARCH_MODE)
+	}
+
+	if in := ctx.QueryParam("in"); in != "" {
+		query.Fields = nil
+		for _, field := range strings.Split(in, ",") {
+			field = strings.TrimSpace(field)
+			if !IsSearchField(field) {
+				return nil, errors.New(httperrors.BAD_SEARCH_FIELD)
+			}
+			query.Fields = append(query.Fields, field)
+		}
+	}
+
+	if value := ctx.QueryParam("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			return nil, errors.New(httperrors.BAD_LIMIT)
+		}
+		if limit > maxSearchLimit {
+			limit = maxSearchLimit
+		}
+		query.Limit = limit
+	}
+	return query, nil
+}
+
+// parseAttackResultsFilter reads the query of GET /attacks/:aid/results.
+func parseAttackResultsFilter(ctx echo.Context) (*AttackResultsFilter, error) {
+	filter := &AttackResultsFilter{Limit: defaultAttackResultsLimit}
+	if sortBy := ctx.QueryParam("sort"); sortBy != "" {
+		if !IsAttackResultSortKey(sortBy) {
+			return nil, errors.New(httperrors.BAD_ATTACK_SORT_KEY)
+		}
+		filter.SortBy = sortBy
+	}
+	switch ctx.QueryParam("order") {
+	case "", "asc":
+	case "desc":
+		filter.Desc = true
+	default:
+		return nil, errors.New(httperrors.BAD_SORT_ORDER)
+	}
+
+	for _, status := range listParam(ctx, "status") {
+		if status == "0" {
+			filter.Statuses = append(filter.Statuses, StatusRange{})
+			continue
+		}
+		r, ok := parseStatusRange(status)
+		if !ok {
+			return nil, errors.New(httperrors.BAD_STATUS)
+		}
+		filter.Statuses = append(filter.Statuses, r)
+	}
+
+	if value := ctx.QueryParam("matched"); value != "" {
+		matched, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, errors.New(httperrors.BAD_MATCHED)
+		}
+		filter.Matched = matched
+	}
+
+	if value := ctx.QueryParam("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			return nil, errors.New(httperrors.BAD_LIMIT)
+		}
+		if limit > maxAttackResultsLimit {
+			limit = maxAttackResultsLimit
+		}
+		filter.Limit = limit
+	}
+	if value := ctx.QueryParam("offset"); value != "" {
+		offset, err := strconv.Atoi(value)
+		if err != nil || offset < 0 {
+			return nil, errors.New(httperrors.BAD_OFFSET)
+		}
+		filter.Offset = offset
+	}
+	return filter, nil
 }
@@ -0,0 +1,128 @@
+package repeater
+
+import (
+	"encoding/json"
+	"errors"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Session is a named capture history. The proxy records into the active
+// session only, the hosts out of its scope are proxied but not recorded.
+type Session struct {
+	ID          int64     `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Scope       Scope     `json:"scope"`
+	Active      bool      `json:"active"`
+	CreatedAt   time.Time `json:"created_at"`
+	// archived sessions keep their history read-only and cannot be activated
+	ArchivedAt *time.Time `json:"archived_at,omitempty"`
+}
+
+// SessionExport is a session together with its history.
+type SessionExport struct {
+	Session  *Session          `json:"session"`
+	Requests []RequestResponse `json:"requests"`
+}
+
+// ErrSessionExists is returned when a session name is taken.
+var ErrSessionExists = errors.New("session name is taken")
+
+// Scope rules are host patterns in the syntax of path.Match, e.g. *.example.com.
+type Scope struct {
+	// hosts recorded, every host when empty
+	Include []string `json:"include"`
+	// hosts never recorded, even if included
+	Exclude []string `json:"exclude"`
+}
+
+// Contains reports whether traffic to host is recorded.
+func (s *Scope) Contains(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if matchesAny(s.Exclude, host) {
+		return false
+	}
+	return len(s.Include) == 0 || matchesAny(s.Include, host)
+}
+
+func matchesAny(patterns []string, host string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(strings.ToLower(pattern), host); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Valid reports whether all the patterns are well-formed.
+func (s *Scope) Valid() bool {
+	for _, patterns := range [][]string{s.Include, s.Exclude} {
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); pattern == "" || err != nil {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Text is the scope as kept in the sessions table.
+func (s *Scope) Text() string {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return "{}"
+	}
+	return string(b)
+}
+
+// ParseScope reads a scope written by Text.
+func ParseScope(text string) (Scope, error) {
+	var s Scope
+	if text == "" {
+		return s, nil
+	}
+	err := json.Unmarshal([]byte(text), &s)
+	return s, err
+}
+
+const maxSessionNameLen = 128
+
+func IsSessionName(name string) bool {
+	return name != "" && len(name) <= maxSessionNameLen && strings.TrimSpace(name) == name
+}
+
+// ActiveSession holds the session the proxy records into,
+// it is shared by the proxy and the repeater.
+type ActiveSession struct {
+	mu      sync.RWMutex
+	session *Session
+}
+
+func NewActiveSession(session *Session) *ActiveSession {
+	return &ActiveSession{session: session}
+}
+
+func (a *ActiveSession) Get() *Session {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.session
+}
+
+func (a *ActiveSession) Set(session *Session) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.session = session
+}
+
+// Capture returns the id of the active session and whether traffic
+// to host goes into it.
+func (a *ActiveSession) Capture(host string) (int64, bool) {
+	session := a.Get()
+	if session == nil {
+		return 0, false
+	}
+	return session.ID, session.Scope.Contains(host)
+}
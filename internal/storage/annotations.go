@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"github.com/iiivan-lemon/technopark_proxy/internal/repeater"
+)
+
+// Queries on annotations and tags shared by the SQL backends.
+const (
+	CountRequestQuery     = `SELECT count(*) FROM requests WHERE id = $1;`
+	UpsertAnnotationQuery = `INSERT INTO annotations(request_id, note, color, updated_at) VALUES($1, $2, $3, $4)
+	ON CONFLICT(request_id) DO UPDATE SET note = excluded.note, color = excluded.color, updated_at = excluded.updated_at;`
+	DeleteAnnotationQuery = `DELETE FROM annotations WHERE request_id = $1;`
+
+	InsertTagQuery = `INSERT INTO tags(name) VALUES($1) ON CONFLICT(name) DO NOTHING;`
+	// the tag is inserted first by InsertTagQuery
+	TagRequestQuery   = `INSERT INTO request_tags(request_id, tag_id) VALUES($1, (SELECT id FROM tags WHERE name = $2)) ON CONFLICT DO NOTHING;`
+	UntagRequestQuery = `DELETE FROM request_tags WHERE request_id = $1 AND tag_id = (SELECT id FROM tags WHERE name = $2);`
+	ClearTagsQuery    = `DELETE FROM request_tags WHERE request_id = $1;`
+	// counts the requests of a session
+	TagsQuery = `SELECT t.name, count(r.id) FROM tags t LEFT JOIN request_tags rt ON rt.tag_id = t.id
+	LEFT JOIN requests r ON r.id = rt.request_id AND r.session_id = $1 GROUP BY t.name ORDER BY t.name;`
+	CountTagQuery        = `SELECT count(*) FROM tags WHERE name = $1;`
+	UntagSessionQuery    = `DELETE FROM request_tags WHERE tag_id = (SELECT id FROM tags WHERE name = $1) AND request_id IN (SELECT id FROM requests WHERE session_id = $2);`
+	DeleteUnusedTagQuery = `DELETE FROM tags WHERE name = $1 AND NOT EXISTS (SELECT 1 FROM request_tags WHERE tag_id = tags.id);`
+
+	selectRequestTags    = `SELECT rt.request_id, t.name FROM request_tags rt JOIN tags t ON t.id = rt.tag_id`
+	RequestTagsByIDQuery = selectRequestTags + ` WHERE rt.request_id = $1 ORDER BY t.name;`
+	RepeatsTagsQuery     = lineage + selectRequestTags + ` WHERE rt.request_id IN (SELECT id FROM lineage) ORDER BY t.name;`
+)
+
+// RequestTagsQuery builds the query selecting the tags of the requests
+// listed by RequestsQuery for the same filter.
+func RequestTagsQuery(filter *repeater.RequestsFilter) (string, []interface{}) {
+	page, args := requestsPage(filter)
+	return selectRequestTags + ` WHERE rt.request_id IN (SELECT r.id` + fromRequests + page + `) ORDER BY t.name;`, args
+}
+
+// ScanTags sets the tags of requests out of rows selected by
+// RequestTagsQuery or RequestTagsByIDQuery.
+func ScanTags(rows Rows, requests []repeater.RequestResponse) error {
+	byID := make(map[int64]*repeater.RequestResponse, len(requests))
+	for i := range requests {
+		byID[requests[i].ID] = &requests[i]
+	}
+	for rows.Next() {
+		var (
+			id   int64
+			name string
+		)
+		if err := rows.Scan(&id, &name); err != nil {
+			return err
+		}
+		req, ok := byID[id]
+		if !ok {
+			continue
+		}
+		if req.Annotation == nil {
+			req.Annotation = &repeater.Annotation{}
+		}
+		req.Annotation.Tags = append(req.Annotation.Tags, name)
+	}
+	return rows.Err()
+}
@@ -0,0 +1,193 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/iiivan-lemon/technopark_proxy/internal/repeater"
+	"github.com/pkg/errors"
+)
+
+// Queries on attacks shared by the SQL backends. The template and the
+// payloads of an attack and the payloads of its results are sealed, the
+// payloads as JSON text.
+const (
+	selectAttacks = `SELECT a.id, a.session_id, a.request_id, a.type, a.template, a.payloads, a.concurrency, a.delay, a.grep,
+	a.scheme, a.host, a.port, a.status, a.total, (SELECT count(*) FROM attack_results res WHERE res.attack_id = a.id),
+	a.error, a.created_at, a.finished_at FROM attacks a`
+	AttacksQuery      = selectAttacks + ` WHERE a.session_id = $1 ORDER BY a.id DESC;`
+	AttackByIDQuery   = selectAttacks + ` WHERE a.id = $1;`
+	InsertAttackQuery = `INSERT INTO attacks(session_id, request_id, type, template, payloads, concurrency, delay, grep,
+	scheme, host, port, status, total, created_at) VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14) RETURNING id;`
+	UpdateAttackQuery = `UPDATE attacks SET status = $1, error = $2, finished_at = $3 WHERE id = $4;`
+	// the results go with the attack
+	DeleteAttackQuery       = `DELETE FROM attacks WHERE id = $1;`
+	InsertAttackResultQuery = `INSERT INTO attack_results(attack_id, idx, payloads, position, request_id, status, length,
+	duration_us, grep, error, started_at) VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11);`
+	selectAttackResults = `SELECT res.attack_id, res.idx, res.payloads, res.position, res.request_id, res.status, res.length,
+	res.duration_us, res.grep, res.error, res.started_at FROM attack_results res`
+)
+
+// attackResultColumns are the columns AttackResultsFilter.SortBy sorts by.
+var attackResultColumns = map[string]string{
+	"index":    "res.idx",
+	"status":   "res.status",
+	"length":   "res.length",
+	"duration": "res.duration_us",
+}
+
+// AttackResultsQuery builds the query listing the results of an attack
+// that match filter, by index within equal sort values. The offset only
+// applies with a limit.
+func AttackResultsQuery(filter *repeater.AttackResultsFilter) (string, []interface{}) {
+	args := []interface{}{filter.AttackID}
+	query := selectAttackResults + ` WHERE res.attack_id = $1`
+	if len(filter.Statuses) > 0 {
+		ranges := make([]string, len(filter.Statuses))
+		for i, status := range filter.Statuses {
+			args = append(args, status.Min, status.Max)
+			ranges[i] = fmt.Sprintf("res.status BETWEEN $%d AND $%d", len(args)-1, len(args))
+		}
+		query += " AND (" + strings.Join(ranges, " OR ") + ")"
+	}
+	if filter.Matched {
+		// grep is a json list of booleans
+		query += ` AND res.grep LIKE '%true%'`
+	}
+
+	column, ok := attackResultColumns[filter.SortBy]
+	if !ok {
+		column = "res.idx"
+	}
+	order := "ASC"
+	if filter.Desc {
+		order = "DESC"
+	}
+	query += fmt.Sprintf(" ORDER BY %s %s, res.idx", column, order)
+	if filter.Limit > 0 {
+		args = append(args, filter.Limit, filter.Offset)
+		query += fmt.Sprintf(" LIMIT $%d OFFSET $%d", len(args)-1, len(args))
+	}
+	return query + ";", args
+}
+
+// SealedAttack is what an attack keeps in its text columns.
+type SealedAttack struct {
+	Template string
+	Payloads string
+	Grep     string
+}
+
+func (c *Cipher) SealAttack(attack *repeater.Attack) (*SealedAttack, error) {
+	payloads, err := json.Marshal(attack.Payloads)
+	if err != nil {
+		return nil, errors.Wrap(err, "encoding attack payloads")
+	}
+	grep, err := json.Marshal(attack.Grep)
+	if err != nil {
+		return nil, errors.Wrap(err, "encoding attack grep")
+	}
+	sealed := &SealedAttack{Grep: string(grep)}
+	if sealed.Template, err = c.Seal(attack.Template); err != nil {
+		return nil, err
+	}
+	if sealed.Payloads, err = c.Seal(string(payloads)); err != nil {
+		return nil, err
+	}
+	return sealed, nil
+}
+
+// SealedAttackResult is what an attack result keeps in its text columns.
+type SealedAttackResult struct {
+	Payloads string
+	Grep     string
+}
+
+func (c *Cipher) SealAttackResult(res *repeater.AttackResult) (*SealedAttackResult, error) {
+	payloads, err := json.Marshal(res.Payloads)
+	if err != nil {
+		return nil, errors.Wrap(err, "encoding attack result payloads")
+	}
+	grep, err := json.Marshal(res.Grep)
+	if err != nil {
+		return nil, errors.Wrap(err, "encoding attack result grep")
+	}
+	sealed := &SealedAttackResult{Grep: string(grep)}
+	if sealed.Payloads, err = c.Seal(string(payloads)); err != nil {
+		return nil, err
+	}
+	return sealed, nil
+}
+
+// openJSONText decodes a text column holding JSON, sealed or not, into v.
+func (c *Cipher) openJSONText(text string, v interface{}) error {
+	text, err := c.Open(text)
+	if err != nil {
+		return err
+	}
+	return errors.Wrap(json.Unmarshal([]byte(text), v), "decoding json column")
+}
+
+// ScanAttack scans a row selected by the attack queries and opens what c sealed.
+func ScanAttack(row RowScanner, c *Cipher) (*repeater.Attack, error) {
+	attack := &repeater.Attack{}
+	var (
+		requestID                sql.NullInt64
+		template, payloads, grep string
+		finishedAt               sql.NullTime
+	)
+	err := row.Scan(&attack.ID, &attack.SessionID, &requestID, &attack.Type, &template, &payloads, &attack.Concurrency,
+		&attack.Delay, &grep, &attack.Scheme, &attack.Host, &attack.Port, &attack.Status, &attack.Total, &attack.Done,
+		&attack.Error, &attack.CreatedAt, &finishedAt)
+	if err != nil {
+		return nil, err
+	}
+	if requestID.Valid {
+		attack.RequestID = &requestID.Int64
+	}
+	if finishedAt.Valid {
+		t := finishedAt.Time
+		attack.FinishedAt = &t
+	}
+	if attack.Template, err = c.Open(template); err != nil {
+		return nil, err
+	}
+	if err = c.openJSONText(payloads, &attack.Payloads); err != nil {
+		return nil, err
+	}
+	if err = c.openJSONText(grep, &attack.Grep); err != nil {
+		return nil, err
+	}
+	return attack, nil
+}
+
+// ScanAttackResult scans a row selected by AttackResultsQuery and opens
+// what c sealed.
+func ScanAttackResult(row RowScanner, c *Cipher) (*repeater.AttackResult, error) {
+	res := &repeater.AttackResult{}
+	var (
+		position, requestID sql.NullInt64
+		payloads, grep      string
+	)
+	err := row.Scan(&res.AttackID, &res.Index, &payloads, &position, &requestID, &res.Status, &res.Length,
+		&res.Duration, &grep, &res.Error, &res.StartedAt)
+	if err != nil {
+		return nil, err
+	}
+	if position.Valid {
+		pos := int(position.Int64)
+		res.Position = &pos
+	}
+	if requestID.Valid {
+		res.RequestID = &requestID.Int64
+	}
+	if err = c.openJSONText(payloads, &res.Payloads); err != nil {
+		return nil, err
+	}
+	if err = c.openJSONText(grep, &res.Grep); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
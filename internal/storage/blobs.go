@@ -0,0 +1,210 @@
+package storage
+
+import (
+	"sync"
+
+	"github.com/iiivan-lemon/technopark_proxy/config"
+	"github.com/iiivan-lemon/technopark_proxy/internal/storage/blobs"
+	"github.com/pkg/errors"
+)
+
+const (
+	BlobsInDB = "db"
+	BlobsInFS = "fs"
+)
+
+// Queries on the blobs table shared by the SQL backends. The reference
+// count goes up with every response stored with the hash and down with
+// every response deleted, the latter is done by a trigger.
+const (
+	UpsertBlobQuery = `INSERT INTO blobs(hash, size, refs, data) VALUES($1, $2, $3, $4)
+	ON CONFLICT(hash) DO UPDATE SET refs = blobs.refs + excluded.refs;`
+	GetBlobQuery      = `SELECT data FROM blobs WHERE hash = $1;`
+	CollectBlobsQuery = `DELETE FROM blobs WHERE refs <= 0 RETURNING hash;`
+)
+
+// Blobs decides which bodies leave the rows and where their data goes.
+type Blobs struct {
+	Threshold int
+	// nil keeps the data in the blobs table
+	Dir *blobs.Dir
+	// seals the data when the traffic is encrypted
+	cipher *Cipher
+	// held for reading from the insert of blob rows to the write of their
+	// files and for writing by the collection, so that the files of
+	// rows inserted meanwhile are not removed
+	mu sync.RWMutex
+}
+
+// SetCipher makes the blobs sealed from now on, blobs are addressed by
+// the hash of their data in the clear all the same.
+func (b *Blobs) SetCipher(c *Cipher) {
+	b.cipher = c
+}
+
+func NewBlobs(conf *config.BlobConfig) (*Blobs, error) {
+	b := &Blobs{Threshold: conf.Threshold}
+	switch conf.Backend {
+	case BlobsInDB, "":
+	case BlobsInFS:
+		dir, err := blobs.NewDir(conf.Dir)
+		if err != nil {
+			return nil, err
+		}
+		b.Dir = dir
+	default:
+		return nil, errors.Errorf("unknown blob backend %q", conf.Backend)
+	}
+	return b, nil
+}
+
+// Blob is a body moved out of its row.
+type Blob struct {
+	Hash string
+	Size int64
+	// what goes into blobs.data, nil when the data is in a file
+	Data []byte
+	// what goes into the file, written by Write
+	file []byte
+}
+
+// Column is Data as the blobs.data argument, an untyped nil when the
+// data is in a file: drivers may bind a nil []byte as an empty value.
+func (b *Blob) Column() interface{} {
+	if b.Data == nil {
+		return nil
+	}
+	return b.Data
+}
+
+// Split returns what stays in the row and, for a body above the
+// threshold, the blob to reference. With a Dir the file is written by
+// Write once the row of the blob is committed.
+func (b *Blobs) Split(body string) (string, *Blob, error) {
+	if b == nil || b.Threshold <= 0 || len(body) <= b.Threshold {
+		return body, nil, nil
+	}
+	sealed, err := b.cipher.Seal(body)
+	if err != nil {
+		return "", nil, err
+	}
+	data := []byte(sealed)
+	blob := &Blob{
+		Hash: blobs.Hash([]byte(body)),
+		Size: int64(len(body)),
+		Data: data,
+	}
+	if b.Dir != nil {
+		blob.Data, blob.file = nil, data
+	}
+	return "", blob, nil
+}
+
+// Hold keeps the collection from removing files until release is
+// called. It is held from before the insert of blob rows to their Write.
+func (b *Blobs) Hold() (release func()) {
+	if b == nil {
+		return func() {}
+	}
+	b.mu.RLock()
+	return b.mu.RUnlock
+}
+
+// Write writes the files of blobs returned by Split, once their rows
+// are committed: a failed insert leaves no file behind.
+func (b *Blobs) Write(list []*Blob) error {
+	if b == nil || b.Dir == nil {
+		return nil
+	}
+	for _, blob := range list {
+		if blob == nil || blob.file == nil {
+			continue
+		}
+		if err := b.Dir.Put(blob.Hash, blob.file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Get reads the data of a blob from its file, data is what the blobs
+// table holds for it. Missing blobs are nil.
+func (b *Blobs) Get(hash string, data []byte) ([]byte, error) {
+	if b == nil {
+		return data, nil
+	}
+	if data == nil && b.Dir != nil {
+		var err error
+		if data, err = b.Dir.Get(hash); err != nil {
+			return nil, err
+		}
+	}
+	if data == nil {
+		return nil, nil
+	}
+	text, err := b.cipher.Open(string(data))
+	if err != nil {
+		return nil, errors.Wrapf(err, "opening blob %s", hash)
+	}
+	return []byte(text), nil
+}
+
+// Reseal seals the files of the blobs again with the active data key
+// of c, it returns the number of files resealed.
+func (b *Blobs) Reseal(c *Cipher, hashes []string) (int, error) {
+	if b == nil || b.Dir == nil {
+		return 0, nil
+	}
+	n := 0
+	for _, hash := range hashes {
+		data, err := b.Dir.Get(hash)
+		if err != nil {
+			return n, err
+		}
+		if data == nil {
+			continue
+		}
+		sealed, ok, err := c.Reseal(SealedColumn{Kind: BytesColumn}, data)
+		if err != nil {
+			return n, errors.Wrapf(err, "resealing blob %s", hash)
+		}
+		if !ok {
+			continue
+		}
+		if err = b.Dir.Replace(hash, []byte(sealed)); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// Collect runs collect, which deletes the rows of the blobs no response
+// refers to and returns their hashes, and removes their files. No insert
+// is between its rows and its files meanwhile, see Hold.
+func (b *Blobs) Collect(collect func() ([]string, error)) (int, error) {
+	if b == nil {
+		hashes, err := collect()
+		return len(hashes), err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	hashes, err := collect()
+	if err != nil {
+		return 0, err
+	}
+	return len(hashes), b.removed(hashes)
+}
+
+// removed drops the files of blobs deleted from the table.
+func (b *Blobs) removed(hashes []string) error {
+	if b.Dir == nil {
+		return nil
+	}
+	for _, hash := range hashes {
+		if err := b.Dir.Remove(hash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
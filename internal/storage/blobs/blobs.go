@@ -0,0 +1,102 @@
+// Package blobs keeps large bodies once per content, addressed by SHA-256.
+package blobs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// Hash returns the key data is stored under.
+func Hash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// IsHash reports whether s looks like a key returned by Hash.
+func IsHash(s string) bool {
+	if len(s) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// Dir keeps blobs as files under a directory, fanned out by the first
+// two characters of the hash.
+type Dir struct {
+	path string
+}
+
+func NewDir(path string) (*Dir, error) {
+	if path == "" {
+		return nil, errors.New("blob directory is not set")
+	}
+	if err := os.MkdirAll(path, 0o700); err != nil {
+		return nil, errors.Wrap(err, "creating blob directory")
+	}
+	return &Dir{path: path}, nil
+}
+
+func (d *Dir) file(hash string) string {
+	return filepath.Join(d.path, hash[:2], hash)
+}
+
+// Put writes data unless a blob with the same hash is already there.
+func (d *Dir) Put(hash string, data []byte) error {
+	if _, err := os.Stat(d.file(hash)); err == nil {
+		return nil
+	}
+	return d.Replace(hash, data)
+}
+
+// Replace writes data over the blob with the hash, if there is one.
+func (d *Dir) Replace(hash string, data []byte) error {
+	name := d.file(hash)
+	if err := os.MkdirAll(filepath.Dir(name), 0o700); err != nil {
+		return errors.Wrap(err, "creating blob directory")
+	}
+
+	// written aside and renamed, so that a blob is either complete or absent
+	tmp, err := os.CreateTemp(filepath.Dir(name), hash+".*.tmp")
+	if err != nil {
+		return errors.Wrap(err, "writing blob")
+	}
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return errors.Wrap(err, "writing blob")
+	}
+	if err = tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return errors.Wrap(err, "writing blob")
+	}
+	if err = os.Rename(tmp.Name(), name); err != nil {
+		os.Remove(tmp.Name())
+		return errors.Wrap(err, "writing blob")
+	}
+	return nil
+}
+
+// Get returns nil when there is no such blob.
+func (d *Dir) Get(hash string) ([]byte, error) {
+	data, err := os.ReadFile(d.file(hash))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "reading blob")
+	}
+	return data, nil
+}
+
+func (d *Dir) Remove(hash string) error {
+	err := os.Remove(d.file(hash))
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "removing blob")
+	}
+	return nil
+}
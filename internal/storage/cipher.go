@@ -0,0 +1,460 @@
+package storage
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/iiivan-lemon/technopark_proxy/config"
+	proxyserver "github.com/iiivan-lemon/technopark_proxy/internal/proxyServer"
+	"github.com/iiivan-lemon/technopark_proxy/internal/repeater"
+	"github.com/iiivan-lemon/technopark_proxy/internal/utils/seal"
+	"github.com/pkg/errors"
+)
+
+// Queries on the data_keys table shared by the SQL backends. The data
+// keys are kept wrapped by the master key, the newest one seals.
+const (
+	DataKeysQuery       = `SELECT id, wrapped FROM data_keys ORDER BY id;`
+	InsertDataKeyQuery  = `INSERT INTO data_keys(wrapped, created_at) VALUES($1, $2) RETURNING id;`
+	RewrapDataKeyQuery  = `UPDATE data_keys SET wrapped = $1 WHERE id = $2;`
+	DeleteDataKeysQuery = `DELETE FROM data_keys WHERE id <> $1;`
+)
+
+// Encryptable is implemented by the backends keeping the captured
+// traffic encrypted at rest.
+type Encryptable interface {
+	// EnableEncryption unwraps the data keys with the master key,
+	// creating the first one if there is none.
+	EnableEncryption(masterKey []byte) error
+	// RotateKey wraps the data keys with a new master key and reseals the
+	// rows with a new data key, it returns the number of values resealed.
+	RotateKey(masterKey []byte) (int, error)
+}
+
+// LoadMasterKey reads the master key set in conf.
+func LoadMasterKey(conf *config.EncryptionConfig) ([]byte, error) {
+	key, err := seal.LoadKey(conf.KeyFile, conf.KeyEnv)
+	return key, errors.Wrap(err, "loading storage master key")
+}
+
+// sealedPrefix starts the values sealed by a Cipher, it is followed by
+// the id of the data key and the sealed value.
+const sealedPrefix = "enc:v1:"
+
+// Cipher is envelope encryption of the columns holding captured traffic:
+// values are sealed by a data key, data keys by the master key. A nil
+// Cipher keeps values in the clear, values stored in the clear are read
+// as they are.
+type Cipher struct {
+	master *seal.Sealer
+	keys   map[int64][]byte
+	aeads  map[int64]*seal.Sealer
+	// the data key sealing new values
+	active int64
+}
+
+func NewCipher(masterKey []byte) (*Cipher, error) {
+	master, err := seal.NewSealer(masterKey)
+	if err != nil {
+		return nil, err
+	}
+	return &Cipher{
+		master: master,
+		keys:   make(map[int64][]byte),
+		aeads:  make(map[int64]*seal.Sealer),
+	}, nil
+}
+
+// AddKey unwraps a data key read from data_keys, the newest key seals.
+func (c *Cipher) AddKey(id int64, wrapped string) error {
+	key, err := c.master.Open(wrapped)
+	if err != nil {
+		return errors.Wrapf(err, "unwrapping data key %d, is it the right master key", id)
+	}
+	aead, err := seal.NewSealer(key)
+	if err != nil {
+		return err
+	}
+	c.keys[id], c.aeads[id] = key, aead
+	if id > c.active {
+		c.active = id
+	}
+	return nil
+}
+
+// Active is the id of the data key sealing new values, 0 when there is none.
+func (c *Cipher) Active() int64 {
+	return c.active
+}
+
+// GenerateKey returns a new data key wrapped by the master key,
+// it is added once stored.
+func (c *Cipher) GenerateKey() (string, error) {
+	key := make([]byte, seal.KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return "", errors.Wrap(err, "generating data key")
+	}
+	return c.master.Seal(key)
+}
+
+// Rewrap returns a Cipher with the same data keys under another master
+// key together with the data keys wrapped by it.
+func (c *Cipher) Rewrap(masterKey []byte) (*Cipher, map[int64]string, error) {
+	next, err := NewCipher(masterKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	wrapped := make(map[int64]string, len(c.keys))
+	for id, key := range c.keys {
+		if wrapped[id], err = next.master.Seal(key); err != nil {
+			return nil, nil, err
+		}
+		next.keys[id], next.aeads[id] = key, c.aeads[id]
+	}
+	next.active = c.active
+	return next, wrapped, nil
+}
+
+// Seal encrypts a value of a text column.
+func (c *Cipher) Seal(value string) (string, error) {
+	if c == nil || value == "" {
+		return value, nil
+	}
+	sealed, err := c.aeads[c.active].Seal([]byte(value))
+	if err != nil {
+		return "", err
+	}
+	return sealedPrefix + strconv.FormatInt(c.active, 10) + ":" + sealed, nil
+}
+
+// Open decrypts a value sealed by Seal, other values are returned as they are.
+func (c *Cipher) Open(value string) (string, error) {
+	if !strings.HasPrefix(value, sealedPrefix) {
+		return value, nil
+	}
+	if c == nil {
+		return "", errors.New("stored traffic is encrypted, storage.encryption is off")
+	}
+	rest := value[len(sealedPrefix):]
+	i := strings.IndexByte(rest, ':')
+	if i < 0 {
+		return "", errors.New("malformed sealed value")
+	}
+	id, err := strconv.ParseInt(rest[:i], 10, 64)
+	if err != nil {
+		return "", errors.New("malformed sealed value")
+	}
+	aead, ok := c.aeads[id]
+	if !ok {
+		return "", errors.Errorf("no data key %d", id)
+	}
+	data, err := aead.Open(rest[i+1:])
+	return string(data), err
+}
+
+// SealJSON encodes a value of a json column. A sealed value is kept as
+// a JSON string, so that it still fits a json typed column.
+func (c *Cipher) SealJSON(v interface{}) (string, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", errors.Wrap(err, "encoding json column")
+	}
+	if c == nil || string(b) == "null" {
+		return string(b), nil
+	}
+	sealed, err := c.Seal(string(b))
+	if err != nil {
+		return "", err
+	}
+	b, err = json.Marshal(sealed)
+	return string(b), errors.Wrap(err, "encoding json column")
+}
+
+// OpenJSON returns the JSON of a value written by SealJSON, nil stays nil.
+func (c *Cipher) OpenJSON(src []byte) ([]byte, error) {
+	if len(src) == 0 || src[0] != '"' {
+		return src, nil
+	}
+	var sealed string
+	if err := json.Unmarshal(src, &sealed); err != nil {
+		return nil, errors.Wrap(err, "decoding json column")
+	}
+	if !strings.HasPrefix(sealed, sealedPrefix) {
+		return src, nil
+	}
+	text, err := c.Open(sealed)
+	return []byte(text), err
+}
+
+// Scanner is what a json column is decoded into, e.g. repeater.Map.
+type Scanner interface {
+	Scan(src interface{}) error
+}
+
+// ScanJSON decodes a json column read as bytes into dst.
+func (c *Cipher) ScanJSON(src []byte, dst Scanner) error {
+	if src == nil {
+		return dst.Scan(nil)
+	}
+	text, err := c.OpenJSON(src)
+	if err != nil {
+		return err
+	}
+	return dst.Scan(text)
+}
+
+// SealedRequest is what a request keeps in its sealed columns.
+type SealedRequest struct {
+	Headers string
+	Cookies string
+	Raw     string
+}
+
+func (c *Cipher) SealRequest(req *proxyserver.Request) (*SealedRequest, error) {
+	var (
+		sealed SealedRequest
+		err    error
+	)
+	if sealed.Headers, err = c.SealJSON(req.Headers); err != nil {
+		return nil, err
+	}
+	if sealed.Cookies, err = c.SealJSON(req.Cookies); err != nil {
+		return nil, err
+	}
+	if sealed.Raw, err = c.Seal(req.Raw); err != nil {
+		return nil, err
+	}
+	return &sealed, nil
+}
+
+// SealedTunnel is what a tunnel keeps in its payload columns.
+type SealedTunnel struct {
+	ClientPayload []byte
+	ServerPayload []byte
+}
+
+func (c *Cipher) SealTunnel(tunnel *proxyserver.Tunnel) (*SealedTunnel, error) {
+	var (
+		sealed SealedTunnel
+		err    error
+	)
+	if sealed.ClientPayload, err = c.sealBytes(tunnel.ClientPayload); err != nil {
+		return nil, err
+	}
+	if sealed.ServerPayload, err = c.sealBytes(tunnel.ServerPayload); err != nil {
+		return nil, err
+	}
+	return &sealed, nil
+}
+
+// OpenTunnel opens the payloads of a tunnel read from its columns.
+func (c *Cipher) OpenTunnel(tunnel *proxyserver.Tunnel) error {
+	var err error
+	if tunnel.ClientPayload, err = c.openBytes(tunnel.ClientPayload); err != nil {
+		return err
+	}
+	tunnel.ServerPayload, err = c.openBytes(tunnel.ServerPayload)
+	return err
+}
+
+// sealBytes seals a value of a bytes column, empty values stay as they are.
+func (c *Cipher) sealBytes(value []byte) ([]byte, error) {
+	if c == nil || len(value) == 0 {
+		return value, nil
+	}
+	sealed, err := c.Seal(string(value))
+	if err != nil {
+		return nil, err
+	}
+	return []byte(sealed), nil
+}
+
+func (c *Cipher) openBytes(value []byte) ([]byte, error) {
+	if len(value) == 0 {
+		return value, nil
+	}
+	text, err := c.Open(string(value))
+	if err != nil {
+		return nil, err
+	}
+	return []byte(text), nil
+}
+
+// Kinds of SealedColumn.
+const (
+	TextColumn = iota
+	JSONColumn
+	BytesColumn
+)
+
+type SealedColumn struct {
+	Name string
+	Kind int
+}
+
+// SealedTable is a table with columns holding captured traffic, paged
+// through by its key when the rows are resealed.
+type SealedTable struct {
+	Name string
+	Key  string
+	// the key is text, it is an integer otherwise
+	TextKey bool
+	Columns []SealedColumn
+}
+
+// SealedTables are the tables RotateKey reseals.
+var SealedTables = []SealedTable{
+	{Name: "requests", Key: "id", Columns: []SealedColumn{{"raw", TextColumn}, {"headers", JSONColumn}, {"cookies", JSONColumn}}},
+	{Name: "responses", Key: "id", Columns: []SealedColumn{{"body", TextColumn}, {"headers", JSONColumn}}},
+	{Name: "blobs", Key: "hash", TextKey: true, Columns: []SealedColumn{{"data", BytesColumn}}},
+	{Name: "attacks", Key: "id", Columns: []SealedColumn{{"template", TextColumn}, {"payloads", TextColumn}}},
+	{Name: "attack_results", Key: "id", Columns: []SealedColumn{{"payloads", TextColumn}}},
+	{Name: "tunnels", Key: "id", Columns: []SealedColumn{{"client_payload", BytesColumn}, {"server_payload", BytesColumn}}},
+}
+
+// ResealPage is the number of rows read at once by RotateKey.
+const ResealPage = 500
+
+// PageQuery selects the next ResealPage rows after the key bound to $1.
+func (t *SealedTable) PageQuery() string {
+	cols := make([]string, len(t.Columns))
+	for i, col := range t.Columns {
+		cols[i] = col.Name
+	}
+	return fmt.Sprintf(`SELECT %s, %s FROM %s WHERE %s > $1 ORDER BY %s LIMIT %d;`,
+		t.Key, strings.Join(cols, ", "), t.Name, t.Key, t.Key, ResealPage)
+}
+
+// UpdateQuery sets the columns in order, the key is the last parameter.
+func (t *SealedTable) UpdateQuery() string {
+	sets := make([]string, len(t.Columns))
+	for i, col := range t.Columns {
+		sets[i] = fmt.Sprintf("%s = $%d", col.Name, i+1)
+	}
+	return fmt.Sprintf(`UPDATE %s SET %s WHERE %s = $%d;`, t.Name, strings.Join(sets, ", "), t.Key, len(t.Columns)+1)
+}
+
+// FirstKey is below every key of the table.
+func (t *SealedTable) FirstKey() interface{} {
+	if t.TextKey {
+		return ""
+	}
+	return int64(0)
+}
+
+// NewKey is where a key of the table is scanned into.
+func (t *SealedTable) NewKey() interface{} {
+	if t.TextKey {
+		return new(string)
+	}
+	return new(int64)
+}
+
+// Reseal opens a value of col read as bytes, with any of the data keys,
+// and seals it with the active one. It reports whether the value is to
+// be written back, NULLs and empty values are left alone.
+func (c *Cipher) Reseal(col SealedColumn, value []byte) (string, bool, error) {
+	if len(value) == 0 {
+		return "", false, nil
+	}
+	switch col.Kind {
+	case JSONColumn:
+		text, err := c.OpenJSON(value)
+		if err != nil {
+			return "", false, err
+		}
+		if string(text) == "null" {
+			return "", false, nil
+		}
+		sealed, err := c.SealJSON(json.RawMessage(text))
+		return sealed, true, err
+	default:
+		text, err := c.Open(string(value))
+		if err != nil {
+			return "", false, err
+		}
+		sealed, err := c.Seal(text)
+		return sealed, true, err
+	}
+}
+
+// SealedSearchQuery selects what GET /search looks through when the
+// columns are sealed and SQL cannot match them: the requests of the
+// session bound to $1 with their responses, newest first.
+const SealedSearchQuery = `SELECT r.id, r.method, coalesce(r.url, r.path, ''), r.headers, r.cookies, r.raw, resp.id, resp.headers, resp.body
+	FROM requests r LEFT JOIN responses resp ON resp.request_id = r.id
+	WHERE r.session_id = $1 ORDER BY r.id DESC, resp.id DESC;`
+
+// SearchSealed opens the rows selected by SealedSearchQuery and matches
+// them here, up to q.Limit hits. As with SQL, bodies kept in the blob
+// store are not searched.
+func SearchSealed(q *repeater.SearchQuery, rows Rows, c *Cipher) ([]repeater.SearchHit, error) {
+	hits := make([]repeater.SearchHit, 0)
+	var last int64
+	for len(hits) < q.Limit && rows.Next() {
+		var (
+			hit                           repeater.SearchHit
+			headers, cookies, respHeaders []byte
+			raw                           string
+			respID                        sql.NullInt64
+			respBody                      sql.NullString
+		)
+		err := rows.Scan(&hit.RequestID, &hit.Method, &hit.URL, &headers, &cookies, &raw, &respID, &respHeaders, &respBody)
+		if err != nil {
+			return nil, errors.Wrap(err, "search error")
+		}
+		// the fields of a request are matched with its first row only
+		first := hit.RequestID != last
+		last = hit.RequestID
+
+		for _, field := range q.Fields {
+			var text []byte
+			switch {
+			case field == repeater.FieldURL && first:
+				text = []byte(hit.URL)
+			case field == repeater.FieldRequestHeaders && first:
+				h, err := c.OpenJSON(headers)
+				if err != nil {
+					return nil, err
+				}
+				ck, err := c.OpenJSON(cookies)
+				if err != nil {
+					return nil, err
+				}
+				text = append(append(h, ' '), ck...)
+			case field == repeater.FieldRequestBody && first:
+				r, err := c.Open(raw)
+				if err != nil {
+					return nil, err
+				}
+				text = []byte(repeater.RequestBody(r))
+			case field == repeater.FieldResponseHeaders && respID.Valid:
+				if text, err = c.OpenJSON(respHeaders); err != nil {
+					return nil, err
+				}
+			case field == repeater.FieldResponseBody && respID.Valid:
+				body, err := c.Open(respBody.String)
+				if err != nil {
+					return nil, err
+				}
+				text = []byte(body)
+			default:
+				continue
+			}
+			snippet, ok := q.Match(string(text))
+			if !ok {
+				continue
+			}
+			hit.Field, hit.Snippet = field, snippet
+			hits = append(hits, hit)
+			if len(hits) == q.Limit {
+				break
+			}
+		}
+	}
+	return hits, errors.Wrap(rows.Err(), "search error")
+}
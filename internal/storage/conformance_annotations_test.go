@@ -0,0 +1,67 @@
+package storage_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/iiivan-lemon/technopark_proxy/internal/repeater"
+	"github.com/iiivan-lemon/technopark_proxy/internal/storage"
+)
+
+func testAnnotations(t *testing.T, s storage.Storage) {
+	a := insert(t, s, newRequest("/a"))
+	b := insert(t, s, newRequest("/b"))
+
+	ok, err := s.SetAnnotation(int(a), &repeater.Annotation{Note: "note", Color: "red", Tags: []string{"x", "y"}})
+	if !ok || err != nil {
+		t.Fatalf("SetAnnotation = %v, %v", ok, err)
+	}
+	if ok, err = s.AddTags(int(b), []string{"y"}); !ok || err != nil {
+		t.Fatalf("AddTags = %v, %v", ok, err)
+	}
+	got, err := s.GetRequestByID(int(a))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if an := got.Annotation; an == nil || an.Note != "note" || an.Color != "red" || strings.Join(an.Tags, ",") != "x,y" {
+		t.Errorf("Annotation = %+v", got.Annotation)
+	}
+
+	filtered, err := s.GetAllRequests(&repeater.RequestsFilter{SessionID: 1, Tags: []string{"y"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equalIDs(ids(filtered), []int64{a, b}) {
+		t.Errorf("requests tagged y = %v, want %v", ids(filtered), []int64{a, b})
+	}
+
+	if ok, err = s.RemoveTag(int(a), "x"); !ok || err != nil {
+		t.Errorf("RemoveTag = %v, %v, want true, nil", ok, err)
+	}
+	if ok, err = s.RemoveTag(int(a), "x"); ok || err != nil {
+		t.Errorf("RemoveTag of a removed tag = %v, %v, want false, nil", ok, err)
+	}
+	tags, err := s.GetTags(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := make(map[string]int)
+	for _, tag := range tags {
+		counts[tag.Name] = tag.Requests
+	}
+	// a tag no request carries is still known
+	if len(counts) != 2 || counts["x"] != 0 || counts["y"] != 2 {
+		t.Errorf("GetTags = %+v, want x on none and y on 2", tags)
+	}
+
+	if ok, err = s.DeleteTag(1, "y"); !ok || err != nil {
+		t.Errorf("DeleteTag = %v, %v, want true, nil", ok, err)
+	}
+	if got, _ = s.GetRequestByID(int(b)); got.Annotation != nil && len(got.Annotation.Tags) > 0 {
+		t.Errorf("tags of b = %v after DeleteTag", got.Annotation.Tags)
+	}
+
+	if ok, err := s.SetAnnotation(404, &repeater.Annotation{Note: "n"}); ok || err != nil {
+		t.Errorf("SetAnnotation = %v, %v, want false, nil", ok, err)
+	}
+}
@@ -0,0 +1,103 @@
+package storage_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/iiivan-lemon/technopark_proxy/internal/repeater"
+	"github.com/iiivan-lemon/technopark_proxy/internal/storage"
+)
+
+func testAttacks(t *testing.T, s storage.Storage) {
+	requestID := insert(t, s, newRequest("/a"))
+	attack := &repeater.Attack{
+		SessionID:   1,
+		RequestID:   &requestID,
+		Type:        "sniper",
+		Template:    "GET /§a§ HTTP/1.1\r\n\r\n",
+		Payloads:    []repeater.PayloadSet{{Type: "list", Values: []string{"x", "y"}}},
+		Concurrency: 2,
+		Grep:        []string{"admin"},
+		Scheme:      "http",
+		Host:        "example.com",
+		Port:        80,
+		Status:      repeater.AttackRunning,
+		Total:       2,
+	}
+	if err := s.CreateAttack(attack); err != nil {
+		t.Fatal(err)
+	}
+	if attack.ID == 0 || attack.CreatedAt.IsZero() {
+		t.Errorf("CreateAttack left id %d and creation time %v", attack.ID, attack.CreatedAt)
+	}
+
+	for i, status := range []int{500, 200} {
+		sent := insert(t, s, newRequest("/x"))
+		res := &repeater.AttackResult{
+			AttackID:  attack.ID,
+			Index:     int64(i),
+			Payloads:  []string{"x"},
+			RequestID: &sent,
+			Status:    status,
+			Length:    int64(10 * (i + 1)),
+			Grep:      []bool{status == 200},
+			StartedAt: time.Now(),
+		}
+		if err := s.InsertAttackResult(res); err != nil {
+			t.Fatal(err)
+		}
+	}
+	finishedAt := time.Now().Truncate(time.Second)
+	if err := s.UpdateAttack(attack.ID, repeater.AttackFinished, "", &finishedAt); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.GetAttack(attack.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got == nil || got.Status != repeater.AttackFinished || got.Done != 2 || got.FinishedAt == nil ||
+		got.Template != attack.Template || len(got.Payloads) != 1 || got.Payloads[0].Values[1] != "y" {
+		t.Errorf("GetAttack = %+v", got)
+	}
+	attacks, err := s.GetAttacks(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attacks) != 1 || attacks[0].ID != attack.ID {
+		t.Errorf("GetAttacks = %+v", attacks)
+	}
+
+	results, err := s.GetAttackResults(&repeater.AttackResultsFilter{AttackID: attack.ID, SortBy: "status"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].Status != 200 || results[1].Status != 500 {
+		t.Errorf("results by status = %+v", results)
+	}
+	matched, err := s.GetAttackResults(&repeater.AttackResultsFilter{AttackID: attack.ID, Matched: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matched) != 1 || matched[0].Index != 1 {
+		t.Errorf("matched results = %+v", matched)
+	}
+
+	// the requests the attack sent are kept
+	if ok, err := s.DeleteAttack(attack.ID); !ok || err != nil {
+		t.Fatalf("DeleteAttack = %v, %v", ok, err)
+	}
+	if got, err = s.GetAttack(attack.ID); got != nil || err != nil {
+		t.Errorf("GetAttack after DeleteAttack = %+v, %v", got, err)
+	}
+	if req, err := s.GetRequestByID(int(*results[0].RequestID)); req == nil || err != nil {
+		t.Errorf("request sent by the attack = %v, %v after DeleteAttack", req, err)
+	}
+
+	if attack, err := s.GetAttack(404); attack != nil || err != nil {
+		t.Errorf("GetAttack = %v, %v, want nil, nil", attack, err)
+	}
+	if ok, err := s.DeleteAttack(404); ok || err != nil {
+		t.Errorf("DeleteAttack = %v, %v, want false, nil", ok, err)
+	}
+}
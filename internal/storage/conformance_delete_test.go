@@ -0,0 +1,35 @@
+package storage_test
+
+import (
+	"testing"
+
+	"github.com/iiivan-lemon/technopark_proxy/internal/repeater"
+	"github.com/iiivan-lemon/technopark_proxy/internal/storage"
+)
+
+func testDeleteRequests(t *testing.T, s storage.Storage) {
+	get := insert(t, s, newRequest("/keep"))
+	post := newRequest("/drop")
+	post.Method = "POST"
+	insert(t, s, post)
+	insert(t, s, post)
+
+	n, err := s.DeleteRequests(&repeater.RequestsFilter{SessionID: 1, Methods: []string{"POST"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("DeleteRequests = %d, want 2", n)
+	}
+	all, err := s.GetAllRequests(&repeater.RequestsFilter{SessionID: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equalIDs(ids(all), []int64{get}) {
+		t.Errorf("GetAllRequests = %v, want %v", ids(all), []int64{get})
+	}
+
+	if ok, err := s.DeleteRequest(404); ok || err != nil {
+		t.Errorf("DeleteRequest = %v, %v, want false, nil", ok, err)
+	}
+}
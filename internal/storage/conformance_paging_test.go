@@ -0,0 +1,165 @@
+package storage_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/iiivan-lemon/technopark_proxy/internal/repeater"
+	"github.com/iiivan-lemon/technopark_proxy/internal/storage"
+)
+
+// testPaging pages through requests by every sort key, some of which the
+// requests without a response have no value of.
+func testPaging(t *testing.T, s storage.Storage) {
+	startedAt := time.Now().Truncate(time.Second)
+	// size, and the total duration in milliseconds of the response, 0 for none
+	rows := []struct {
+		size     int64
+		duration int
+	}{{5, 30}, {3, 0}, {5, 10}, {0, 0}, {3, 30}, {7, 20}, {5, 0}, {1, 10}, {3, 40}}
+	var all []int64
+	for i, row := range rows {
+		req := newRequest(fmt.Sprintf("/%d", i))
+		req.Size = row.size
+		// pairs of requests start at the same time
+		req.StartedAt = startedAt.Add(time.Duration(i/2) * time.Second)
+		if row.duration == 0 {
+			all = append(all, insert(t, s, req))
+			continue
+		}
+		resp := newResponse(200, fmt.Sprintf("body %d", i%3))
+		resp.Timing.TTFB = time.Duration(row.duration/2) * time.Millisecond
+		resp.Timing.Total = time.Duration(row.duration) * time.Millisecond
+		all = append(all, insert(t, s, req, resp))
+	}
+
+	for _, key := range repeater.SortKeys {
+		for _, desc := range []bool{false, true} {
+			full, err := s.GetAllRequests(&repeater.RequestsFilter{SortBy: key, Desc: desc})
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkOrder(t, full, key, desc)
+			if len(full) != len(all) {
+				t.Fatalf("%s desc %v: %d requests, want %d", key, desc, len(full), len(all))
+			}
+			for _, limit := range []int{1, 2, 4} {
+				paged := pages(t, s, &repeater.RequestsFilter{SortBy: key, Desc: desc, Limit: limit})
+				if !equalIDs(paged, ids(full)) {
+					t.Errorf("%s desc %v by %d: pages %v, want %v", key, desc, limit, paged, ids(full))
+				}
+			}
+		}
+	}
+
+	// a cursor goes with the filter
+	https := false
+	paged := pages(t, s, &repeater.RequestsFilter{SortBy: "duration", MinDuration: 20 * time.Millisecond, HTTPS: &https, Limit: 1})
+	if want := []int64{all[5], all[0], all[4], all[8]}; !equalIDs(paged, want) {
+		t.Errorf("filtered pages %v, want %v", paged, want)
+	}
+	// a cursor past the last request
+	last := &repeater.RequestResponse{ID: all[len(all)-1]}
+	filter := &repeater.RequestsFilter{Limit: 2}
+	filter.After = repeater.CursorAt(last, filter)
+	if res, err := s.GetAllRequests(filter); err != nil || len(res) != 0 {
+		t.Errorf("page after the last request = %v, %v", ids(res), err)
+	}
+
+	testProjection(t, s, all[0])
+}
+
+// pages lists the requests of filter a page at a time, each after the
+// cursor at the last request of the one before.
+func pages(t *testing.T, s storage.Storage, filter *repeater.RequestsFilter) []int64 {
+	t.Helper()
+	var res []int64
+	seen := make(map[int64]bool)
+	for {
+		page, err := s.GetAllRequests(filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page) > filter.Limit {
+			t.Fatalf("page of %d requests, limit %d", len(page), filter.Limit)
+		}
+		for _, r := range page {
+			if seen[r.ID] {
+				t.Fatalf("request %d listed twice, after %v", r.ID, res)
+			}
+			seen[r.ID] = true
+			res = append(res, r.ID)
+		}
+		if len(page) < filter.Limit {
+			return res
+		}
+		filter.After = repeater.CursorAt(&page[len(page)-1], filter)
+	}
+}
+
+// checkOrder checks that requests go by the value of key, the ones
+// without it last, and ties by id.
+func checkOrder(t *testing.T, requests []repeater.RequestResponse, key string, desc bool) {
+	t.Helper()
+	for i := 1; i < len(requests); i++ {
+		a, b := &requests[i-1], &requests[i]
+		va, okA := a.SortValue(key)
+		vb, okB := b.SortValue(key)
+		var ordered bool
+		switch {
+		case okA != okB:
+			ordered = okA
+		case !okA || va == vb:
+			ordered = a.ID < b.ID != desc
+		default:
+			ordered = va < vb != desc
+		}
+		if !ordered {
+			t.Errorf("%s desc %v: request %d goes before %d", key, desc, a.ID, b.ID)
+		}
+	}
+}
+
+// testProjection checks that the costly fields left out are not loaded
+// and the wanted ones are.
+func testProjection(t *testing.T, s storage.Storage, id int64) {
+	if _, err := s.SetAnnotation(int(id), &repeater.Annotation{Note: "projected", Tags: []string{"paged"}}); err != nil {
+		t.Fatal(err)
+	}
+	filter := &repeater.RequestsFilter{Fields: []string{"id", "method", "path", "timing"}, Limit: 1}
+	res, err := s.GetAllRequests(filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 1 {
+		t.Fatalf("%d requests, want 1", len(res))
+	}
+	r := res[0]
+	if r.ID != id || r.Method != "GET" || r.Path != "/0" || r.Timing == nil || r.Timing.Total != 30000 {
+		t.Errorf("projected request = %+v", r)
+	}
+	if r.Raw != "" || len(r.Headers) != 0 || len(r.GetParams) != 0 || len(r.Cookies) != 0 || len(r.PostParams) != 0 ||
+		r.Annotation != nil && len(r.Annotation.Tags) != 0 {
+		t.Errorf("projected request has fields it does not want: %+v", r)
+	}
+
+	filter.Fields = []string{"raw", "headers", "annotation"}
+	if res, err = s.GetAllRequests(filter); err != nil {
+		t.Fatal(err)
+	}
+	r = res[0]
+	if r.Raw == "" || r.Headers["Host"] != "example.com" || len(r.Cookies) != 0 ||
+		r.Annotation == nil || r.Annotation.Note != "projected" || len(r.Annotation.Tags) != 1 {
+		t.Errorf("request projected to raw, headers and annotation = %+v", r)
+	}
+
+	// a request loaded by its id has every field
+	full, err := s.GetRequestByID(int(id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if full.Raw == "" || full.Cookies["sid"] != "x" || full.Annotation == nil || len(full.Annotation.Tags) != 1 {
+		t.Errorf("request loaded by id = %+v", full)
+	}
+}
@@ -0,0 +1,42 @@
+package storage_test
+
+import (
+	"testing"
+
+	proxyserver "github.com/iiivan-lemon/technopark_proxy/internal/proxyServer"
+	"github.com/iiivan-lemon/technopark_proxy/internal/storage"
+)
+
+func testRepeats(t *testing.T, s storage.Storage) {
+	original := insert(t, s, newRequest("/a"), newResponse(200, "a"))
+	repeat := newRequest("/a")
+	repeat.Source = proxyserver.SourceRepeater
+	repeat.ParentID = original
+	first := insert(t, s, repeat, newResponse(200, "b"))
+	repeat.ParentID = first
+	second := insert(t, s, repeat, newResponse(200, "c"))
+	insert(t, s, newRequest("/other"))
+
+	repeats, err := s.GetRepeats(int(original))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equalIDs(ids(repeats), []int64{first, second}) {
+		t.Errorf("GetRepeats = %v, want %v", ids(repeats), []int64{first, second})
+	}
+	if repeats[0].Source != proxyserver.SourceRepeater || repeats[0].ParentID == nil || *repeats[0].ParentID != original {
+		t.Errorf("lineage of the repeat = %q, %v", repeats[0].Source, repeats[0].ParentID)
+	}
+
+	// as on delete set null
+	if ok, err := s.DeleteRequest(int(first)); !ok || err != nil {
+		t.Fatalf("DeleteRequest = %v, %v", ok, err)
+	}
+	got, err := s.GetRequestByID(int(second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ParentID != nil {
+		t.Errorf("ParentID = %d after deleting the parent, want nil", *got.ParentID)
+	}
+}
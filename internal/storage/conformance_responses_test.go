@@ -0,0 +1,38 @@
+package storage_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/iiivan-lemon/technopark_proxy/internal/storage"
+)
+
+func testResponsesOf(t *testing.T, s storage.Storage) {
+	long := strings.Repeat("blob ", blobThreshold)
+	a := insert(t, s, newRequest("/a"), newResponse(200, "a1"), newResponse(500, long))
+	b := insert(t, s, newRequest("/b"))
+	c := insert(t, s, newRequest("/c"), newResponse(201, "c1"))
+
+	responses, err := s.GetResponsesOf([]int64{c, 404, b, a, c})
+	if err != nil {
+		t.Fatal(err)
+	}
+	type got struct {
+		requestID int64
+		code      int
+		body      string
+	}
+	want := []got{{a, 200, "a1"}, {a, 500, long}, {c, 201, "c1"}}
+	if len(responses) != len(want) {
+		t.Fatalf("GetResponsesOf returned %d responses, want %d", len(responses), len(want))
+	}
+	for i, resp := range responses {
+		if g := (got{resp.RequestID, resp.Code, resp.Body}); g != want[i] {
+			t.Errorf("response %d = %+v, want %+v", i, g, want[i])
+		}
+	}
+
+	if responses, err = s.GetResponsesOf(nil); err != nil || len(responses) != 0 {
+		t.Errorf("GetResponsesOf(nil) = %v, %v, want none", responses, err)
+	}
+}
@@ -0,0 +1,66 @@
+package storage_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/iiivan-lemon/technopark_proxy/internal/repeater"
+	"github.com/iiivan-lemon/technopark_proxy/internal/storage"
+)
+
+func testSessions(t *testing.T, s storage.Storage) {
+	active, err := s.ActiveSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if active == nil || active.ID != 1 || active.Name != "default" {
+		t.Fatalf("ActiveSession = %+v, want the default session", active)
+	}
+
+	session := &repeater.Session{Name: "audit", Scope: repeater.Scope{Include: []string{"*.example.com"}}}
+	if err = s.CreateSession(session); err != nil {
+		t.Fatal(err)
+	}
+	if session.ID == 0 || session.CreatedAt.IsZero() {
+		t.Errorf("CreateSession left id %d and creation time %v", session.ID, session.CreatedAt)
+	}
+	if err = s.CreateSession(&repeater.Session{Name: "audit"}); err != repeater.ErrSessionExists {
+		t.Errorf("CreateSession of a taken name = %v, want ErrSessionExists", err)
+	}
+
+	session.Description = "described"
+	if ok, err := s.UpdateSession(session); !ok || err != nil {
+		t.Errorf("UpdateSession = %v, %v", ok, err)
+	}
+	if ok, err := s.ActivateSession(session.ID); !ok || err != nil {
+		t.Fatalf("ActivateSession = %v, %v", ok, err)
+	}
+	if active, err = s.ActiveSession(); err != nil || active.ID != session.ID {
+		t.Errorf("ActiveSession = %+v, %v, want %d", active, err, session.ID)
+	}
+	got, err := s.GetSession(session.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Description != "described" || strings.Join(got.Scope.Include, ",") != "*.example.com" || !got.Active {
+		t.Errorf("GetSession = %+v", got)
+	}
+
+	if ok, err := s.ArchiveSession(1); !ok || err != nil {
+		t.Fatalf("ArchiveSession = %v, %v", ok, err)
+	}
+	if got, err = s.GetSession(1); err != nil || got.ArchivedAt == nil {
+		t.Errorf("GetSession of an archived session = %+v, %v", got, err)
+	}
+	sessions, err := s.GetSessions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 2 {
+		t.Errorf("GetSessions = %+v, want 2 sessions", sessions)
+	}
+
+	if session, err := s.GetSession(404); session != nil || err != nil {
+		t.Errorf("GetSession = %v, %v, want nil, nil", session, err)
+	}
+}
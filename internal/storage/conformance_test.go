@@ -0,0 +1,248 @@
+package storage_test
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	proxyserver "github.com/iiivan-lemon/technopark_proxy/internal/proxyServer"
+	"github.com/iiivan-lemon/technopark_proxy/internal/repeater"
+	"github.com/iiivan-lemon/technopark_proxy/internal/storage"
+	"github.com/iiivan-lemon/technopark_proxy/internal/storage/blobs"
+	"github.com/iiivan-lemon/technopark_proxy/internal/storage/memory"
+	"github.com/iiivan-lemon/technopark_proxy/internal/storage/sqlite"
+)
+
+// blobThreshold moves the bodies longer than it to the blobs in every backend.
+const blobThreshold = 16
+
+// backends are the backends the conformance tests run against, each
+// returning an empty store with the default session.
+var backends = []struct {
+	name string
+	open func(t *testing.T) storage.Storage
+}{
+	{"memory", func(t *testing.T) storage.Storage {
+		return memory.NewStorage(blobThreshold)
+	}},
+	{"sqlite", func(t *testing.T) storage.Storage {
+		return openSQLite(t, &storage.Blobs{Threshold: blobThreshold})
+	}},
+	{"sqlite with blob files", func(t *testing.T) storage.Storage {
+		dir, err := blobs.NewDir(filepath.Join(t.TempDir(), "blobs"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return openSQLite(t, &storage.Blobs{Threshold: blobThreshold, Dir: dir})
+	}},
+}
+
+func openSQLite(t *testing.T, b *storage.Blobs) storage.Storage {
+	store, err := sqlite.NewStorage(filepath.Join(t.TempDir(), "proxy.db"), b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runner, err := store.Migrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = runner.Up(); err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+// conformance are the behaviours of repeater.Repository every backend shares.
+var conformance = []struct {
+	name string
+	run  func(t *testing.T, s storage.Storage)
+}{
+	{"requests and responses", testRequests},
+	{"paging", testPaging},
+	{"missing rows", testMissing},
+	{"delete requests", testDeleteRequests},
+	{"annotations", testAnnotations},
+	{"sessions", testSessions},
+	{"repeats", testRepeats},
+	{"responses of requests", testResponsesOf},
+	{"attacks", testAttacks},
+}
+
+func TestConformance(t *testing.T) {
+	for _, backend := range backends {
+		for _, tt := range conformance {
+			t.Run(backend.name+"/"+tt.name, func(t *testing.T) {
+				s := backend.open(t)
+				defer s.Close()
+				tt.run(t, s)
+			})
+		}
+	}
+}
+
+func newRequest(path string) *proxyserver.Request {
+	return &proxyserver.Request{
+		Method:    "GET",
+		Path:      path,
+		GetParams: proxyserver.Map{"q": "1"},
+		Headers:   proxyserver.Map{"Host": "example.com", "Accept": []string{"a", "b"}},
+		Cookies:   proxyserver.Map{"sid": "x"},
+		Raw:       "GET " + path + " HTTP/1.1\r\nHost: example.com\r\n\r\n",
+		StartedAt: time.Now().Truncate(time.Second),
+		Scheme:    "http",
+		Host:      "example.com",
+		Port:      80,
+		URL:       "http://example.com" + path,
+		Proto:     "HTTP/1.1",
+		SessionID: 1,
+	}
+}
+
+func newResponse(code int, body string) *proxyserver.Response {
+	return &proxyserver.Response{
+		Code:     code,
+		Message:  "OK",
+		Headers:  proxyserver.Map{"Content-Type": "text/plain"},
+		Body:     body,
+		Size:     int64(len(body)),
+		RemoteIP: "127.0.0.1",
+	}
+}
+
+// insert stores req with its responses and returns its id.
+func insert(t *testing.T, s storage.Storage, req *proxyserver.Request, responses ...*proxyserver.Response) int64 {
+	t.Helper()
+	id, err := s.InsertRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, resp := range responses {
+		if err = s.InsertResponse(id, resp); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return int64(id)
+}
+
+func ids(requests []repeater.RequestResponse) []int64 {
+	res := make([]int64, len(requests))
+	for i, r := range requests {
+		res[i] = r.ID
+	}
+	return res
+}
+
+func equalIDs(a, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func testRequests(t *testing.T, s storage.Storage) {
+	req := newRequest("/a")
+	long := strings.Repeat("large body ", 4)
+	id := insert(t, s, req, newResponse(200, "short"), newResponse(201, long))
+
+	got, err := s.GetRequestByID(int(id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got == nil || got.ID != id || got.Method != "GET" || got.Path != "/a" || got.URL != req.URL || got.SessionID != 1 {
+		t.Fatalf("GetRequestByID = %+v", got)
+	}
+	if got.Headers["Host"] != "example.com" || got.Cookies["sid"] != "x" || got.GetParams["q"] != "1" {
+		t.Errorf("maps of the request = %v, %v, %v", got.Headers, got.Cookies, got.GetParams)
+	}
+	if got.Source != proxyserver.SourceProxy || got.ParentID != nil {
+		t.Errorf("lineage = %q, %v, want proxy without a parent", got.Source, got.ParentID)
+	}
+	if got.StartedAt == nil || !got.StartedAt.Equal(req.StartedAt) {
+		t.Errorf("StartedAt = %v, want %v", got.StartedAt, req.StartedAt)
+	}
+	// the latest response gives the timing
+	if got.Timing == nil || got.Timing.ResponseSize != int64(len(long)) || got.Timing.BodyHash == "" {
+		t.Errorf("Timing = %+v, want the one of the latest response", got.Timing)
+	}
+
+	// changing what was read changes nothing stored
+	got.Headers["Host"] = "changed"
+	req.Headers["Accept"].([]string)[0] = "changed"
+	again, err := s.GetRequestByID(int(id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Headers["Host"] != "example.com" {
+		t.Errorf("Host header = %v after changing a read copy", again.Headers["Host"])
+	}
+	if accept := fmt.Sprint(again.Headers["Accept"]); accept != "[a b]" {
+		t.Errorf("Accept header = %v after changing the inserted request", accept)
+	}
+
+	responses, err := s.GetResponses(int(id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(responses) != 2 || responses[0].Code != 200 || responses[1].Code != 201 {
+		t.Fatalf("GetResponses = %+v, want 200 then 201", responses)
+	}
+	if responses[0].Body != "short" || responses[0].Timing.BodyHash != "" {
+		t.Errorf("inline response = %q, %q", responses[0].Body, responses[0].Timing.BodyHash)
+	}
+	if responses[1].Body != long || responses[1].RequestID != id || responses[1].Timing.RemoteIP != "127.0.0.1" {
+		t.Errorf("blob response = %+v", responses[1])
+	}
+	if responses[0].Headers["Content-Type"] != "text/plain" {
+		t.Errorf("response headers = %v", responses[0].Headers)
+	}
+
+	resp, err := s.GetResponse(int(responses[1].ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || resp.Body != long || resp.Code != 201 {
+		t.Errorf("GetResponse = %+v", resp)
+	}
+	blob, err := s.GetBlob(responses[1].Timing.BodyHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(blob) != long {
+		t.Errorf("GetBlob = %q, want %q", blob, long)
+	}
+
+	all, err := s.GetAllRequests(&repeater.RequestsFilter{SessionID: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equalIDs(ids(all), []int64{id}) {
+		t.Errorf("GetAllRequests = %v, want %v", ids(all), []int64{id})
+	}
+
+	// the blob goes with the last response referring to it
+	if ok, err := s.DeleteRequest(int(id)); !ok || err != nil {
+		t.Fatalf("DeleteRequest = %v, %v", ok, err)
+	}
+	if blob, err = s.GetBlob(responses[1].Timing.BodyHash); blob != nil || err != nil {
+		t.Errorf("GetBlob after DeleteRequest = %q, %v, want nil, nil", blob, err)
+	}
+}
+
+func testMissing(t *testing.T, s storage.Storage) {
+	if req, err := s.GetRequestByID(404); req != nil || err != nil {
+		t.Errorf("GetRequestByID = %v, %v, want nil, nil", req, err)
+	}
+	if resp, err := s.GetResponse(404); resp != nil || err != nil {
+		t.Errorf("GetResponse = %v, %v, want nil, nil", resp, err)
+	}
+	if blob, err := s.GetBlob("missing"); blob != nil || err != nil {
+		t.Errorf("GetBlob = %v, %v, want nil, nil", blob, err)
+	}
+}
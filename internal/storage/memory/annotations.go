@@ -0,0 +1,122 @@
+package memory
+
+import (
+	"sort"
+
+	"github.com/iiivan-lemon/technopark_proxy/internal/repeater"
+)
+
+func (s *Storage) SetAnnotation(id int, annotation *repeater.Annotation) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.byID[int64(id)]
+	if !ok {
+		return false, nil
+	}
+	r.note, r.color, r.tags = annotation.Note, annotation.Color, nil
+	s.tagRequest(r, annotation.Tags)
+	return true, nil
+}
+
+func (s *Storage) AddTags(id int, tags []string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.byID[int64(id)]
+	if !ok {
+		return false, nil
+	}
+	s.tagRequest(r, tags)
+	return true, nil
+}
+
+// tagRequest adds tags to r, the caller holds s.mu.
+func (s *Storage) tagRequest(r *request, tags []string) {
+	for _, tag := range tags {
+		s.tags[tag] = true
+	}
+	r.tags = repeater.SortTags(append(r.tags, tags...))
+}
+
+func (s *Storage) RemoveTag(id int, tag string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.byID[int64(id)]
+	if !ok {
+		return false, nil
+	}
+	for i, t := range r.tags {
+		if t == tag {
+			r.tags = append(r.tags[:i:i], r.tags[i+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *Storage) GetTags(sessionID int64) ([]repeater.TagCount, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	counts := make(map[string]int, len(s.tags))
+	for _, r := range s.requests {
+		if r.req.SessionID != sessionID {
+			continue
+		}
+		for _, tag := range r.tags {
+			counts[tag]++
+		}
+	}
+	tags := make([]repeater.TagCount, 0, len(s.tags))
+	for tag := range s.tags {
+		tags = append(tags, repeater.TagCount{Name: tag, Requests: counts[tag]})
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i].Name < tags[j].Name })
+	return tags, nil
+}
+
+func (s *Storage) DeleteTag(sessionID int64, tag string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.tags[tag] {
+		return false, nil
+	}
+	used := false
+	for _, r := range s.requests {
+		if r.req.SessionID != sessionID {
+			used = used || r.hasTags([]string{tag})
+			continue
+		}
+		for i, t := range r.tags {
+			if t == tag {
+				r.tags = append(r.tags[:i:i], r.tags[i+1:]...)
+				break
+			}
+		}
+	}
+	if !used {
+		delete(s.tags, tag)
+	}
+	return true, nil
+}
+
+// hasTags reports whether r carries all of tags.
+func (r *request) hasTags(tags []string) bool {
+	for _, tag := range tags {
+		i := sort.SearchStrings(r.tags, tag)
+		if i == len(r.tags) || r.tags[i] != tag {
+			return false
+		}
+	}
+	return true
+}
+
+// annotation is nil when r is not annotated.
+func (r *request) annotation() *repeater.Annotation {
+	if r.note == "" && r.color == "" && len(r.tags) == 0 {
+		return nil
+	}
+	return &repeater.Annotation{
+		Note:  r.note,
+		Color: r.color,
+		Tags:  append([]string(nil), r.tags...),
+	}
+}
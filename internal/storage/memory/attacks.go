@@ -0,0 +1,212 @@
+package memory
+
+import (
+	"sort"
+	"time"
+
+	"github.com/iiivan-lemon/technopark_proxy/internal/repeater"
+)
+
+func (s *Storage) CreateAttack(attack *repeater.Attack) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastAttackID++
+	attack.ID = s.lastAttackID
+	attack.CreatedAt = time.Now()
+	stored := copyAttack(attack)
+	s.attacks = append(s.attacks, stored)
+	return nil
+}
+
+// copyAttack copies what the caller could change of attack.
+func copyAttack(attack *repeater.Attack) *repeater.Attack {
+	res := *attack
+	if attack.RequestID != nil {
+		id := *attack.RequestID
+		res.RequestID = &id
+	}
+	if attack.FinishedAt != nil {
+		t := *attack.FinishedAt
+		res.FinishedAt = &t
+	}
+	res.Payloads = append([]repeater.PayloadSet(nil), attack.Payloads...)
+	res.Grep = append([]string{}, attack.Grep...)
+	return &res
+}
+
+// attack returns nil when there is no such attack, the caller holds s.mu.
+func (s *Storage) attack(id int64) *repeater.Attack {
+	for _, attack := range s.attacks {
+		if attack.ID == id {
+			return attack
+		}
+	}
+	return nil
+}
+
+// viewAttack returns a copy of attack with the number of its results,
+// the caller holds s.mu.
+func (s *Storage) viewAttack(attack *repeater.Attack) repeater.Attack {
+	res := copyAttack(attack)
+	res.Done = int64(len(s.attackResults[attack.ID]))
+	return *res
+}
+
+func (s *Storage) GetAttacks(sessionID int64) ([]repeater.Attack, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	attacks := make([]repeater.Attack, 0)
+	for i := len(s.attacks) - 1; i >= 0; i-- {
+		if s.attacks[i].SessionID == sessionID {
+			attacks = append(attacks, s.viewAttack(s.attacks[i]))
+		}
+	}
+	return attacks, nil
+}
+
+func (s *Storage) GetAttack(id int64) (*repeater.Attack, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	attack := s.attack(id)
+	if attack == nil {
+		return nil, nil
+	}
+	res := s.viewAttack(attack)
+	return &res, nil
+}
+
+func (s *Storage) UpdateAttack(id int64, status, errText string, finishedAt *time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if attack := s.attack(id); attack != nil {
+		attack.Status, attack.Error = status, errText
+		attack.FinishedAt = nil
+		if finishedAt != nil {
+			t := *finishedAt
+			attack.FinishedAt = &t
+		}
+	}
+	return nil
+}
+
+func (s *Storage) DeleteAttack(id int64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, attack := range s.attacks {
+		if attack.ID == id {
+			s.attacks = append(s.attacks[:i], s.attacks[i+1:]...)
+			delete(s.attackResults, id)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *Storage) InsertAttackResult(res *repeater.AttackResult) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.attack(res.AttackID) == nil {
+		return nil
+	}
+	stored := *res
+	stored.Payloads = append([]string(nil), res.Payloads...)
+	stored.Grep = append([]bool(nil), res.Grep...)
+	s.attackResults[res.AttackID] = append(s.attackResults[res.AttackID], &stored)
+	return nil
+}
+
+// attackResultValue returns the value of res the results are sorted by.
+func attackResultValue(res *repeater.AttackResult, key string) int64 {
+	switch key {
+	case "status":
+		return int64(res.Status)
+	case "length":
+		return res.Length
+	case "duration":
+		return res.Duration
+	}
+	return res.Index
+}
+
+// attackResultMatches reports whether res is selected by filter.
+func attackResultMatches(res *repeater.AttackResult, filter *repeater.AttackResultsFilter) bool {
+	if len(filter.Statuses) > 0 {
+		in := false
+		for _, status := range filter.Statuses {
+			if res.Status >= status.Min && res.Status <= status.Max {
+				in = true
+				break
+			}
+		}
+		if !in {
+			return false
+		}
+	}
+	if filter.Matched {
+		for _, matched := range res.Grep {
+			if matched {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func (s *Storage) GetAttackResults(filter *repeater.AttackResultsFilter) ([]repeater.AttackResult, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	selected := make([]*repeater.AttackResult, 0)
+	for _, res := range s.attackResults[filter.AttackID] {
+		if attackResultMatches(res, filter) {
+			selected = append(selected, res)
+		}
+	}
+	// as the SQL backends, by index within equal values
+	sort.SliceStable(selected, func(i, j int) bool {
+		a, b := attackResultValue(selected[i], filter.SortBy), attackResultValue(selected[j], filter.SortBy)
+		if a != b {
+			return a < b != filter.Desc
+		}
+		return selected[i].Index < selected[j].Index
+	})
+	if filter.Limit > 0 {
+		if filter.Offset >= len(selected) {
+			selected = selected[:0]
+		} else {
+			selected = selected[filter.Offset:]
+		}
+		if len(selected) > filter.Limit {
+			selected = selected[:filter.Limit]
+		}
+	}
+
+	results := make([]repeater.AttackResult, len(selected))
+	for i, res := range selected {
+		results[i] = *res
+		results[i].Payloads = append([]string(nil), res.Payloads...)
+		results[i].Grep = append([]bool(nil), res.Grep...)
+	}
+	return results, nil
+}
+
+// unlinkAttacks forgets the deleted requests of the attacks and their
+// results, as on delete set null. The caller holds s.mu.
+func (s *Storage) unlinkAttacks() {
+	for _, attack := range s.attacks {
+		if attack.RequestID != nil {
+			if _, ok := s.byID[*attack.RequestID]; !ok {
+				attack.RequestID = nil
+			}
+		}
+	}
+	for _, results := range s.attackResults {
+		for _, res := range results {
+			if res.RequestID != nil {
+				if _, ok := s.byID[*res.RequestID]; !ok {
+					res.RequestID = nil
+				}
+			}
+		}
+	}
+}
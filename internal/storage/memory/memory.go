@@ -0,0 +1,639 @@
+// Package memory is the storage backend keeping everything in process memory.
+// Nothing survives a restart, it is meant for tests and short sessions.
+package memory
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	proxyserver "github.com/iiivan-lemon/technopark_proxy/internal/proxyServer"
+	"github.com/iiivan-lemon/technopark_proxy/internal/repeater"
+	"github.com/iiivan-lemon/technopark_proxy/internal/storage"
+	"github.com/iiivan-lemon/technopark_proxy/internal/storage/blobs"
+	"github.com/pkg/errors"
+)
+
+type request struct {
+	id  int64
+	req proxyserver.Request
+	// oldest first
+	responses []*response
+	note      string
+	color     string
+	// sorted
+	tags []string
+}
+
+type response struct {
+	id   int64
+	resp proxyserver.Response
+	// set when the body of resp is in blobs
+	bodyHash string
+}
+
+// latest returns the latest response of r, nil until there is one.
+func (r *request) latest() *response {
+	if len(r.responses) == 0 {
+		return nil
+	}
+	return r.responses[len(r.responses)-1]
+}
+
+type blob struct {
+	data []byte
+	refs int
+}
+
+type Storage struct {
+	mu         sync.RWMutex
+	requests   []*request
+	byID       map[int64]*request
+	tunnels    []proxyserver.Tunnel
+	lastID     int64
+	lastRespID int64
+	// bodies longer than blobThreshold are kept once in blobs
+	blobThreshold int
+	blobs         map[string]*blob
+	// every tag ever set, including the ones no request carries
+	tags          map[string]bool
+	sessions      []*repeater.Session
+	lastSessionID int64
+	// oldest first
+	attacks      []*repeater.Attack
+	lastAttackID int64
+	// by attack id, in the order they were inserted
+	attackResults map[int64][]*repeater.AttackResult
+}
+
+func NewStorage(blobThreshold int) *Storage {
+	return &Storage{
+		byID:          make(map[int64]*request),
+		blobThreshold: blobThreshold,
+		blobs:         make(map[string]*blob),
+		tags:          make(map[string]bool),
+		attackResults: make(map[int64][]*repeater.AttackResult),
+		// as the SQL backends after their migrations
+		sessions: []*repeater.Session{{
+			ID:        1,
+			Name:      "default",
+			Active:    true,
+			CreatedAt: time.Now(),
+		}},
+		lastSessionID: 1,
+	}
+}
+
+func (s *Storage) Close() error {
+	return nil
+}
+
+func (s *Storage) InsertRequest(req *proxyserver.Request) (uint, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastID++
+	r := &request{
+		id:  s.lastID,
+		req: copyRequest(req),
+	}
+	s.requests = append(s.requests, r)
+	s.byID[r.id] = r
+	return uint(r.id), nil
+}
+
+func (s *Storage) InsertResponse(reqID uint, resp *proxyserver.Response) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.byID[int64(reqID)]
+	if !ok {
+		return errors.Errorf("inserting response error: no request %d", reqID)
+	}
+	s.addResponse(r, resp)
+	return nil
+}
+
+// addResponse adds the latest response of r, the caller holds s.mu.
+func (s *Storage) addResponse(r *request, resp *proxyserver.Response) {
+	s.lastRespID++
+	stored := &response{id: s.lastRespID, resp: *resp}
+	stored.resp.Headers = copyMap(resp.Headers)
+	if s.blobThreshold > 0 && len(stored.resp.Body) > s.blobThreshold {
+		data := []byte(stored.resp.Body)
+		hash := blobs.Hash(data)
+		b, ok := s.blobs[hash]
+		if !ok {
+			b = &blob{data: data}
+			s.blobs[hash] = b
+		}
+		b.refs++
+		stored.bodyHash = hash
+		stored.resp.Body = ""
+	}
+	r.responses = append(r.responses, stored)
+}
+
+func (s *Storage) GetResponses(requestID int) ([]repeater.Response, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	res := make([]repeater.Response, 0)
+	if r, ok := s.byID[int64(requestID)]; ok {
+		for _, resp := range r.responses {
+			res = append(res, *s.toRepeaterResponse(r, resp))
+		}
+	}
+	return res, nil
+}
+
+func (s *Storage) GetResponsesOf(requestIDs []int64) ([]repeater.Response, error) {
+	ids := append([]int64(nil), requestIDs...)
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	res := make([]repeater.Response, 0)
+	for i, id := range ids {
+		r, ok := s.byID[id]
+		if !ok || i > 0 && ids[i-1] == id {
+			continue
+		}
+		for _, resp := range r.responses {
+			res = append(res, *s.toRepeaterResponse(r, resp))
+		}
+	}
+	return res, nil
+}
+
+func (s *Storage) GetResponse(id int) (*repeater.Response, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, r := range s.requests {
+		for _, resp := range r.responses {
+			if resp.id == int64(id) {
+				return s.toRepeaterResponse(r, resp), nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// toRepeaterResponse returns resp with the body read out of the blobs,
+// the caller holds s.mu.
+func (s *Storage) toRepeaterResponse(r *request, resp *response) *repeater.Response {
+	t := resp.resp.Timing
+	res := &repeater.Response{
+		ID:        resp.id,
+		RequestID: r.id,
+		SessionID: r.req.SessionID,
+		Code:      resp.resp.Code,
+		Message:   resp.resp.Message,
+		Headers:   copyMap(resp.resp.Headers),
+		Body:      resp.resp.Body,
+		Timing: repeater.Timing{
+			DNS:          t.DNS.Microseconds(),
+			Connect:      t.Connect.Microseconds(),
+			TLS:          t.TLS.Microseconds(),
+			TTFB:         t.TTFB.Microseconds(),
+			Total:        t.Total.Microseconds(),
+			ResponseSize: resp.resp.Size,
+			RemoteIP:     resp.resp.RemoteIP,
+			BodyHash:     resp.bodyHash,
+		},
+	}
+	if b, ok := s.blobs[resp.bodyHash]; ok {
+		res.Body = string(b.data)
+	}
+	return res
+}
+
+func (s *Storage) GetBlob(hash string) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if b, ok := s.blobs[hash]; ok {
+		return b.data, nil
+	}
+	return nil, nil
+}
+
+func (s *Storage) CollectBlobs() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.collectBlobs(), nil
+}
+
+// collectBlobs is CollectBlobs for a caller holding s.mu.
+func (s *Storage) collectBlobs() int {
+	n := 0
+	for hash, b := range s.blobs {
+		if b.refs <= 0 {
+			delete(s.blobs, hash)
+			n++
+		}
+	}
+	return n
+}
+
+func (s *Storage) DeleteRequest(id int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := s.deleteWhere(func(r *request) bool { return r.id == int64(id) })
+	return n > 0, nil
+}
+
+func (s *Storage) DeleteRequests(filter *repeater.RequestsFilter) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.deleteWhere(matcher(filter)), nil
+}
+
+func (s *Storage) Prune(retention *storage.Retention) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deleted := 0
+	if retention.MaxAge > 0 {
+		cutoff := time.Now().Add(-retention.MaxAge)
+		deleted += s.deleteWhere(func(r *request) bool {
+			return !r.req.StartedAt.IsZero() && r.req.StartedAt.Before(cutoff)
+		})
+		kept := s.tunnels[:0]
+		for _, t := range s.tunnels {
+			if t.StartedAt.Before(cutoff) {
+				deleted++
+				continue
+			}
+			kept = append(kept, t)
+		}
+		s.tunnels = kept
+	}
+	if retention.MaxRequestsPerHost > 0 {
+		perHost := make(map[string]int)
+		deleted += s.deleteNewestFirst(func(r *request) bool {
+			perHost[r.req.Host]++
+			return perHost[r.req.Host] > retention.MaxRequestsPerHost
+		})
+	}
+	if retention.MaxRequests > 0 {
+		n := 0
+		deleted += s.deleteNewestFirst(func(*request) bool {
+			n++
+			return n > retention.MaxRequests
+		})
+	}
+	if retention.MaxBodyBytes > 0 {
+		var total int64
+		deleted += s.deleteNewestFirst(func(r *request) bool {
+			total += r.req.Size
+			for _, resp := range r.responses {
+				total += resp.resp.Size
+			}
+			return total > retention.MaxBodyBytes
+		})
+	}
+	return deleted, nil
+}
+
+// deleteNewestFirst is deleteWhere calling drop from the newest request
+// to the oldest, for limits that keep the newest ones.
+func (s *Storage) deleteNewestFirst(drop func(*request) bool) int {
+	dropped := make(map[int64]bool)
+	for i := len(s.requests) - 1; i >= 0; i-- {
+		if drop(s.requests[i]) {
+			dropped[s.requests[i].id] = true
+		}
+	}
+	return s.deleteWhere(func(r *request) bool { return dropped[r.id] })
+}
+
+// deleteWhere removes the requests drop returns true for, together with
+// the references of their responses to blobs. The caller holds s.mu.
+func (s *Storage) deleteWhere(drop func(*request) bool) int {
+	kept := s.requests[:0]
+	n := 0
+	for _, r := range s.requests {
+		if !drop(r) {
+			kept = append(kept, r)
+			continue
+		}
+		for _, resp := range r.responses {
+			if resp.bodyHash != "" {
+				s.blobs[resp.bodyHash].refs--
+			}
+		}
+		delete(s.byID, r.id)
+		n++
+	}
+	for i := len(kept); i < len(s.requests); i++ {
+		s.requests[i] = nil
+	}
+	s.requests = kept
+	if n > 0 {
+		// as on delete set null
+		for _, r := range s.requests {
+			if _, ok := s.byID[r.req.ParentID]; !ok {
+				r.req.ParentID = 0
+			}
+		}
+		s.unlinkAttacks()
+		s.collectBlobs()
+	}
+	return n
+}
+
+func (s *Storage) InsertTunnel(tunnel *proxyserver.Tunnel) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tunnels = append(s.tunnels, *tunnel)
+	return nil
+}
+
+func (s *Storage) InsertBatch(exchanges []proxyserver.Exchange, tunnels []proxyserver.Tunnel) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, ex := range exchanges {
+		s.lastID++
+		r := &request{
+			id:  s.lastID,
+			req: copyRequest(ex.Request),
+		}
+		if ex.Response != nil {
+			s.addResponse(r, ex.Response)
+		}
+		s.requests = append(s.requests, r)
+		s.byID[r.id] = r
+	}
+	s.tunnels = append(s.tunnels, tunnels...)
+	return nil
+}
+
+func (s *Storage) GetAllRequests(filter *repeater.RequestsFilter) ([]repeater.RequestResponse, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	match := matcher(filter)
+	res := make([]repeater.RequestResponse, 0)
+	for _, r := range s.requests {
+		if match(r) {
+			res = append(res, *r.toRepeater())
+		}
+	}
+
+	sort.SliceStable(res, func(i, j int) bool {
+		return before(&res[i], filter.SortBy, res[j].ID, sortValue(&res[j], filter.SortBy), filter.Desc)
+	})
+	if c := filter.After; c != nil {
+		i := sort.Search(len(res), func(i int) bool {
+			return !before(&res[i], c.SortBy, c.ID, c.Value, c.Desc)
+		})
+		if i < len(res) && res[i].ID == c.ID {
+			i++
+		}
+		res = res[i:]
+	}
+	if filter.Limit > 0 && len(res) > filter.Limit {
+		res = res[:filter.Limit]
+	}
+	for i := range res {
+		project(&res[i], filter)
+	}
+	return res, nil
+}
+
+// project leaves out what the SQL backends do not load when filter
+// does not want it, see storage.RequestsQuery.
+func project(r *repeater.RequestResponse, filter *repeater.RequestsFilter) {
+	if !filter.Wants("get_params") {
+		r.GetParams = nil
+	}
+	if !filter.Wants("headers") {
+		r.Headers = nil
+	}
+	if !filter.Wants("cookies") {
+		r.Cookies = nil
+	}
+	if !filter.Wants("post_params") {
+		r.PostParams = nil
+	}
+	if !filter.Wants("raw") {
+		r.Raw, r.Original = "", ""
+	}
+	if !filter.Wants("annotation") && r.Annotation != nil {
+		r.Annotation.Tags = nil
+	}
+}
+
+func sortValue(r *repeater.RequestResponse, key string) *int64 {
+	if value, ok := r.SortValue(key); ok {
+		return &value
+	}
+	return nil
+}
+
+// before reports whether r goes before the request with id and value of
+// the sort key, nil for none: as in SQL, those go last in both orders
+// and ties go by id.
+func before(r *repeater.RequestResponse, key string, id int64, value *int64, desc bool) bool {
+	v := sortValue(r, key)
+	switch {
+	case (v == nil) != (value == nil):
+		return v != nil
+	case v == nil || *v == *value:
+		if desc {
+			return r.ID > id
+		}
+		return r.ID < id
+	case desc:
+		return *v > *value
+	default:
+		return *v < *value
+	}
+}
+
+// globRegexp matches what a glob of RequestsFilter.PathGlob does.
+func globRegexp(glob string) *regexp.Regexp {
+	expr := regexp.QuoteMeta(glob)
+	expr = strings.NewReplacer(`\*`, ".*", `\?`, ".").Replace(expr)
+	return regexp.MustCompile(`(?s)^` + expr + `$`)
+}
+
+func (s *Storage) GetRequestByID(id int) (*repeater.RequestResponse, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	r, ok := s.byID[int64(id)]
+	if !ok {
+		return nil, nil
+	}
+	return r.toRepeater(), nil
+}
+
+func (s *Storage) GetRepeats(id int) ([]repeater.RequestResponse, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	// requests are kept in id order, a repeat comes after its parent
+	lineage := map[int64]bool{int64(id): true}
+	res := make([]repeater.RequestResponse, 0)
+	for _, r := range s.requests {
+		if r.req.ParentID != 0 && lineage[r.req.ParentID] {
+			lineage[r.id] = true
+			res = append(res, *r.toRepeater())
+		}
+	}
+	return res, nil
+}
+
+// matcher returns the predicate of the requests matching filter.
+func matcher(filter *repeater.RequestsFilter) func(*request) bool {
+	var path *regexp.Regexp
+	if filter.PathGlob != "" {
+		path = globRegexp(filter.PathGlob)
+	}
+	return func(r *request) bool {
+		return matches(r, filter) && (path == nil || path.MatchString(r.req.Path))
+	}
+}
+
+func matches(r *request, filter *repeater.RequestsFilter) bool {
+	var latest *proxyserver.Response
+	if resp := r.latest(); resp != nil {
+		latest = &resp.resp
+	}
+	if filter.SessionID > 0 && r.req.SessionID != filter.SessionID {
+		return false
+	}
+	if filter.MinDuration > 0 && (latest == nil || latest.Timing.Total < filter.MinDuration) {
+		return false
+	}
+	if filter.MaxDuration > 0 && (latest == nil || latest.Timing.Total > filter.MaxDuration) {
+		return false
+	}
+	if !filter.Since.IsZero() && r.req.StartedAt.Before(filter.Since) {
+		return false
+	}
+	if !filter.Until.IsZero() && r.req.StartedAt.After(filter.Until) {
+		return false
+	}
+	if len(filter.Methods) > 0 && !contains(filter.Methods, r.req.Method) {
+		return false
+	}
+	if len(filter.Hosts) > 0 && !contains(filter.Hosts, r.req.Host) {
+		return false
+	}
+	if filter.HTTPS != nil && r.req.IsHTTPS != *filter.HTTPS {
+		return false
+	}
+	if len(filter.Statuses) > 0 && (latest == nil || !inRanges(filter.Statuses, latest.Code)) {
+		return false
+	}
+	if filter.ContentType != "" && (latest == nil || !matchesMediaType(filter.ContentType, latest.ContentType())) {
+		return false
+	}
+	if filter.MinSize > 0 && r.req.Size < filter.MinSize {
+		return false
+	}
+	if filter.MaxSize > 0 && r.req.Size > filter.MaxSize {
+		return false
+	}
+	return r.hasTags(filter.Tags)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func inRanges(ranges []repeater.StatusRange, code int) bool {
+	for _, r := range ranges {
+		if code >= r.Min && code <= r.Max {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesMediaType matches a media type against type/subtype or type/*.
+func matchesMediaType(pattern, mediaType string) bool {
+	if strings.HasSuffix(pattern, "/*") {
+		return strings.HasPrefix(mediaType, strings.TrimSuffix(pattern, "*"))
+	}
+	return mediaType == pattern
+}
+
+// copyMap copies m so that neither the caller nor what is stored sees the
+// changes of the other, as newResponse of the repeater copies headers.
+func copyMap(m map[string]interface{}) map[string]interface{} {
+	if m == nil {
+		return nil
+	}
+	res := make(map[string]interface{}, len(m))
+	for name, value := range m {
+		if values, ok := value.([]string); ok {
+			value = append([]string(nil), values...)
+		}
+		res[name] = value
+	}
+	return res
+}
+
+// copyRequest copies req with its maps.
+func copyRequest(req *proxyserver.Request) proxyserver.Request {
+	res := *req
+	res.GetParams = copyMap(req.GetParams)
+	res.Headers = copyMap(req.Headers)
+	res.Cookies = copyMap(req.Cookies)
+	res.PostParams = copyMap(req.PostParams)
+	return res
+}
+
+func (r *request) toRepeater() *repeater.RequestResponse {
+	res := &repeater.RequestResponse{
+		ID:        r.id,
+		SessionID: r.req.SessionID,
+		Request: repeater.Request{
+			Method:     r.req.Method,
+			Path:       r.req.Path,
+			GetParams:  copyMap(r.req.GetParams),
+			Headers:    copyMap(r.req.Headers),
+			Cookies:    copyMap(r.req.Cookies),
+			PostParams: copyMap(r.req.PostParams),
+			Raw:        r.req.Raw,
+			IsHTTPS:    r.req.IsHTTPS,
+			Size:       r.req.Size,
+			Scheme:     r.req.Scheme,
+			Host:       r.req.Host,
+			Port:       r.req.Port,
+			URL:        r.req.URL,
+			Query:      r.req.Query,
+			ClientAddr: r.req.ClientAddr,
+			Proto:      r.req.Proto,
+			Original:   r.req.Original,
+		},
+		Annotation: r.annotation(),
+	}
+	res.Source, _ = storage.Lineage(&r.req)
+	if r.req.ParentID != 0 {
+		parentID := r.req.ParentID
+		res.ParentID = &parentID
+	}
+	if !r.req.StartedAt.IsZero() {
+		startedAt := r.req.StartedAt
+		res.StartedAt = &startedAt
+	}
+	if latest := r.latest(); latest != nil {
+		t := latest.resp.Timing
+		res.Timing = &repeater.Timing{
+			DNS:          t.DNS.Microseconds(),
+			Connect:      t.Connect.Microseconds(),
+			TLS:          t.TLS.Microseconds(),
+			TTFB:         t.TTFB.Microseconds(),
+			Total:        t.Total.Microseconds(),
+			ResponseSize: latest.resp.Size,
+			RemoteIP:     latest.resp.RemoteIP,
+			BodyHash:     latest.bodyHash,
+		}
+	}
+	return res
+}
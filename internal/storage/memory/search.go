@@ -0,0 +1,82 @@
+package memory
+
+import (
+	"encoding/json"
+
+	proxyserver "github.com/iiivan-lemon/technopark_proxy/internal/proxyServer"
+	"github.com/iiivan-lemon/technopark_proxy/internal/repeater"
+)
+
+// Search scans the requests newest first. As in the other backends,
+// bodies kept in the blob store are not searched.
+func (s *Storage) Search(q *repeater.SearchQuery) ([]repeater.SearchHit, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	hits := make([]repeater.SearchHit, 0)
+	for i := len(s.requests) - 1; i >= 0 && len(hits) < q.Limit; i-- {
+		r := s.requests[i]
+		if r.req.SessionID != q.SessionID {
+			continue
+		}
+		for _, field := range q.Fields {
+			text, ok := fieldText(r, field)
+			if !ok {
+				continue
+			}
+			snippet, ok := q.Match(text)
+			if !ok {
+				continue
+			}
+			url := r.req.URL
+			if url == "" {
+				url = r.req.Path
+			}
+			hits = append(hits, repeater.SearchHit{
+				RequestID: r.id,
+				Method:    r.req.Method,
+				URL:       url,
+				Field:     field,
+				Snippet:   snippet,
+			})
+			if len(hits) == q.Limit {
+				break
+			}
+		}
+	}
+	return hits, nil
+}
+
+// fieldText is the text of a repeater.SearchFields field,
+// false when the request has no such field.
+func fieldText(r *request, field string) (string, bool) {
+	switch field {
+	case repeater.FieldURL:
+		if r.req.URL != "" {
+			return r.req.URL, true
+		}
+		return r.req.Path, true
+	case repeater.FieldRequestHeaders:
+		return jsonText(r.req.Headers) + " " + jsonText(r.req.Cookies), true
+	case repeater.FieldRequestBody:
+		return repeater.RequestBody(r.req.Raw), true
+	case repeater.FieldResponseHeaders:
+		latest := r.latest()
+		if latest == nil {
+			return "", false
+		}
+		return jsonText(latest.resp.Headers), true
+	case repeater.FieldResponseBody:
+		latest := r.latest()
+		if latest == nil {
+			return "", false
+		}
+		return latest.resp.Body, true
+	}
+	return "", false
+}
+
+func jsonText(m proxyserver.Map) string {
+	b, _ := json.Marshal(m)
+	return string(b)
+}
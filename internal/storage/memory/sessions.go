@@ -0,0 +1,115 @@
+package memory
+
+import (
+	"time"
+
+	"github.com/iiivan-lemon/technopark_proxy/internal/repeater"
+)
+
+func (s *Storage) CreateSession(session *repeater.Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sessionNameTaken(session) {
+		return repeater.ErrSessionExists
+	}
+	s.lastSessionID++
+	session.ID = s.lastSessionID
+	session.CreatedAt = time.Now()
+	session.Active = false
+	session.ArchivedAt = nil
+	stored := *session
+	s.sessions = append(s.sessions, &stored)
+	return nil
+}
+
+// sessionNameTaken reports whether another session has the name of
+// session, the caller holds s.mu.
+func (s *Storage) sessionNameTaken(session *repeater.Session) bool {
+	for _, other := range s.sessions {
+		if other.Name == session.Name && other.ID != session.ID {
+			return true
+		}
+	}
+	return false
+}
+
+// session returns nil when there is no such session, the caller holds s.mu.
+func (s *Storage) session(id int64) *repeater.Session {
+	for _, session := range s.sessions {
+		if session.ID == id {
+			return session
+		}
+	}
+	return nil
+}
+
+func (s *Storage) GetSessions() ([]repeater.Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	sessions := make([]repeater.Session, 0, len(s.sessions))
+	for _, session := range s.sessions {
+		sessions = append(sessions, *session)
+	}
+	return sessions, nil
+}
+
+func (s *Storage) GetSession(id int64) (*repeater.Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	session := s.session(id)
+	if session == nil {
+		return nil, nil
+	}
+	res := *session
+	return &res, nil
+}
+
+func (s *Storage) ActiveSession() (*repeater.Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, session := range s.sessions {
+		if session.Active {
+			res := *session
+			return &res, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *Storage) UpdateSession(session *repeater.Session) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored := s.session(session.ID)
+	if stored == nil {
+		return false, nil
+	}
+	if s.sessionNameTaken(session) {
+		return false, repeater.ErrSessionExists
+	}
+	stored.Name, stored.Description, stored.Scope = session.Name, session.Description, session.Scope
+	return true, nil
+}
+
+func (s *Storage) ActivateSession(id int64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.session(id) == nil {
+		return false, nil
+	}
+	for _, session := range s.sessions {
+		session.Active = session.ID == id
+	}
+	return true, nil
+}
+
+func (s *Storage) ArchiveSession(id int64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session := s.session(id)
+	if session == nil || session.ArchivedAt != nil {
+		return false, nil
+	}
+	now := time.Now()
+	session.ArchivedAt = &now
+	return true, nil
+}
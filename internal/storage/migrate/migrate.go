@@ -0,0 +1,198 @@
+// Package migrate applies versioned schema migrations embedded into the binary.
+//
+// Migrations are files named "<version>_<name>.up.sql" and
+// "<version>_<name>.down.sql". Applied versions are kept in the
+// schema_migrations table by the backend specific Driver.
+package migrate
+
+import (
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+var fileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Migration changes the schema by one version.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Driver runs migrations against a particular database.
+type Driver interface {
+	// Applied returns the applied versions, creating the version table if needed.
+	Applied() ([]int, error)
+	// Apply runs script and adds (up) or removes (down) version in the version
+	// table within a single transaction.
+	Apply(m *Migration, up bool) error
+}
+
+type Runner struct {
+	driver     Driver
+	migrations []Migration
+}
+
+// NewRunner loads the migrations found in dir of fsys.
+func NewRunner(driver Driver, fsys fs.FS, dir string) (*Runner, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading migrations")
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		match := fileName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			continue
+		}
+		version, _ := strconv.Atoi(match[1])
+		script, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, errors.Wrap(err, "reading migration "+entry.Name())
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+		if m.Name != match[2] {
+			return nil, errors.Errorf("migration %d has two names: %s and %s", version, m.Name, match[2])
+		}
+		if match[3] == "up" {
+			m.Up = string(script)
+		} else {
+			m.Down = string(script)
+		}
+	}
+
+	r := &Runner{driver: driver}
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, errors.Errorf("migration %d_%s has no up script", m.Version, m.Name)
+		}
+		r.migrations = append(r.migrations, *m)
+	}
+	sort.Slice(r.migrations, func(i, j int) bool {
+		return r.migrations[i].Version < r.migrations[j].Version
+	})
+	return r, nil
+}
+
+// Latest is the version the embedded migrations lead to.
+func (r *Runner) Latest() int {
+	if len(r.migrations) == 0 {
+		return 0
+	}
+	return r.migrations[len(r.migrations)-1].Version
+}
+
+// Version is the highest applied version, 0 for an empty database.
+func (r *Runner) Version() (int, error) {
+	applied, err := r.driver.Applied()
+	if err != nil {
+		return 0, err
+	}
+	version := 0
+	for _, v := range applied {
+		if v > version {
+			version = v
+		}
+	}
+	return version, nil
+}
+
+// Up applies every migration that is not applied yet and returns their number.
+func (r *Runner) Up() (int, error) {
+	return r.To(r.Latest())
+}
+
+// Down reverts the last steps applied migrations.
+func (r *Runner) Down(steps int) (int, error) {
+	applied, err := r.appliedSet()
+	if err != nil {
+		return 0, err
+	}
+	done := 0
+	for i := len(r.migrations) - 1; i >= 0 && done < steps; i-- {
+		m := &r.migrations[i]
+		if !applied[m.Version] {
+			continue
+		}
+		if err = r.revert(m); err != nil {
+			return done, err
+		}
+		done++
+	}
+	return done, nil
+}
+
+// To applies or reverts migrations until version is the latest applied one.
+func (r *Runner) To(version int) (int, error) {
+	applied, err := r.appliedSet()
+	if err != nil {
+		return 0, err
+	}
+	if version != 0 && !r.known(version) {
+		return 0, errors.Errorf("unknown migration version %d", version)
+	}
+
+	done := 0
+	for i := len(r.migrations) - 1; i >= 0; i-- {
+		m := &r.migrations[i]
+		if m.Version > version && applied[m.Version] {
+			if err = r.revert(m); err != nil {
+				return done, err
+			}
+			done++
+		}
+	}
+	for i := range r.migrations {
+		m := &r.migrations[i]
+		if m.Version <= version && !applied[m.Version] {
+			if err = r.driver.Apply(m, true); err != nil {
+				return done, errors.Wrapf(err, "applying migration %d_%s", m.Version, m.Name)
+			}
+			done++
+		}
+	}
+	return done, nil
+}
+
+func (r *Runner) revert(m *Migration) error {
+	if m.Down == "" {
+		return errors.Errorf("migration %d_%s cannot be reverted", m.Version, m.Name)
+	}
+	if err := r.driver.Apply(m, false); err != nil {
+		return errors.Wrapf(err, "reverting migration %d_%s", m.Version, m.Name)
+	}
+	return nil
+}
+
+func (r *Runner) known(version int) bool {
+	for _, m := range r.migrations {
+		if m.Version == version {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Runner) appliedSet() (map[int]bool, error) {
+	applied, err := r.driver.Applied()
+	if err != nil {
+		return nil, errors.Wrap(err, "reading applied migrations")
+	}
+	set := make(map[int]bool, len(applied))
+	for _, v := range applied {
+		set[v] = true
+	}
+	return set, nil
+}
@@ -0,0 +1,136 @@
+package postgres
+
+import (
+	"time"
+
+	"github.com/iiivan-lemon/technopark_proxy/internal/repeater"
+	"github.com/iiivan-lemon/technopark_proxy/internal/storage"
+	"github.com/jackc/pgx"
+	"github.com/pkg/errors"
+)
+
+// scanTags runs a query of storage.ScanTags over requests.
+func (p *Storage) scanTags(requests []repeater.RequestResponse, query string, args ...interface{}) error {
+	if len(requests) == 0 {
+		return nil
+	}
+	rows, err := p.conn.Query(query, args...)
+	if err != nil {
+		return errors.Wrap(err, "getting tags error")
+	}
+	defer rows.Close()
+	return errors.Wrap(storage.ScanTags(rows, requests), "getting tags error")
+}
+
+func (p *Storage) SetAnnotation(id int, annotation *repeater.Annotation) (bool, error) {
+	tx, err := p.conn.Begin()
+	if err != nil {
+		return false, errors.Wrap(err, "annotating error")
+	}
+	defer tx.Rollback()
+
+	if ok, err := requestExists(tx, id); !ok || err != nil {
+		return false, err
+	}
+	if annotation.Note == "" && annotation.Color == "" {
+		_, err = tx.Exec(storage.DeleteAnnotationQuery, id)
+	} else {
+		_, err = tx.Exec(storage.UpsertAnnotationQuery, id, annotation.Note, annotation.Color, time.Now())
+	}
+	if err != nil {
+		return false, errors.Wrap(err, "annotating error")
+	}
+	if _, err = tx.Exec(storage.ClearTagsQuery, id); err != nil {
+		return false, errors.Wrap(err, "annotating error")
+	}
+	if err = tagRequest(tx, id, annotation.Tags); err != nil {
+		return false, err
+	}
+	return true, errors.Wrap(tx.Commit(), "annotating error")
+}
+
+func (p *Storage) AddTags(id int, tags []string) (bool, error) {
+	tx, err := p.conn.Begin()
+	if err != nil {
+		return false, errors.Wrap(err, "tagging error")
+	}
+	defer tx.Rollback()
+
+	if ok, err := requestExists(tx, id); !ok || err != nil {
+		return false, err
+	}
+	if err = tagRequest(tx, id, tags); err != nil {
+		return false, err
+	}
+	return true, errors.Wrap(tx.Commit(), "tagging error")
+}
+
+func requestExists(tx *pgx.Tx, id int) (bool, error) {
+	var n int64
+	if err := tx.QueryRow(storage.CountRequestQuery, id).Scan(&n); err != nil {
+		return false, errors.Wrap(err, "getting request error")
+	}
+	return n > 0, nil
+}
+
+func tagRequest(tx *pgx.Tx, id int, tags []string) error {
+	for _, tag := range tags {
+		if _, err := tx.Exec(storage.InsertTagQuery, tag); err != nil {
+			return errors.Wrap(err, "tagging error")
+		}
+		if _, err := tx.Exec(storage.TagRequestQuery, id, tag); err != nil {
+			return errors.Wrap(err, "tagging error")
+		}
+	}
+	return nil
+}
+
+func (p *Storage) RemoveTag(id int, tag string) (bool, error) {
+	res, err := p.conn.Exec(storage.UntagRequestQuery, id, tag)
+	if err != nil {
+		return false, errors.Wrap(err, "untagging error")
+	}
+	return res.RowsAffected() > 0, nil
+}
+
+func (p *Storage) GetTags(sessionID int64) ([]repeater.TagCount, error) {
+	rows, err := p.conn.Query(storage.TagsQuery, sessionID)
+	if err != nil {
+		return nil, errors.Wrap(err, "getting tags error")
+	}
+	defer rows.Close()
+
+	tags := make([]repeater.TagCount, 0)
+	for rows.Next() {
+		var (
+			tag   repeater.TagCount
+			count int64
+		)
+		if err = rows.Scan(&tag.Name, &count); err != nil {
+			return nil, errors.Wrap(err, "getting tags error")
+		}
+		tag.Requests = int(count)
+		tags = append(tags, tag)
+	}
+	return tags, errors.Wrap(rows.Err(), "getting tags error")
+}
+
+func (p *Storage) DeleteTag(sessionID int64, tag string) (bool, error) {
+	tx, err := p.conn.Begin()
+	if err != nil {
+		return false, errors.Wrap(err, "deleting tag error")
+	}
+	defer tx.Rollback()
+
+	var n int64
+	if err = tx.QueryRow(storage.CountTagQuery, tag).Scan(&n); err != nil || n == 0 {
+		return false, errors.Wrap(err, "deleting tag error")
+	}
+	if _, err = tx.Exec(storage.UntagSessionQuery, tag, sessionID); err != nil {
+		return false, errors.Wrap(err, "deleting tag error")
+	}
+	if _, err = tx.Exec(storage.DeleteUnusedTagQuery, tag); err != nil {
+		return false, errors.Wrap(err, "deleting tag error")
+	}
+	return true, errors.Wrap(tx.Commit(), "deleting tag error")
+}
@@ -0,0 +1,94 @@
+package postgres
+
+import (
+	"time"
+
+	"github.com/iiivan-lemon/technopark_proxy/internal/repeater"
+	"github.com/iiivan-lemon/technopark_proxy/internal/storage"
+	"github.com/jackc/pgx"
+	"github.com/pkg/errors"
+)
+
+func (p *Storage) CreateAttack(attack *repeater.Attack) error {
+	sealed, err := p.cipher.SealAttack(attack)
+	if err != nil {
+		return errors.Wrap(err, "creating attack error")
+	}
+	createdAt := time.Now()
+	err = p.conn.QueryRow(storage.InsertAttackQuery, attack.SessionID, attack.RequestID, attack.Type, sealed.Template,
+		sealed.Payloads, attack.Concurrency, attack.Delay, sealed.Grep, attack.Scheme, attack.Host, attack.Port,
+		attack.Status, attack.Total, createdAt).Scan(&attack.ID)
+	if err != nil {
+		return errors.Wrap(err, "creating attack error")
+	}
+	attack.CreatedAt = createdAt
+	return nil
+}
+
+func (p *Storage) GetAttacks(sessionID int64) ([]repeater.Attack, error) {
+	rows, err := p.conn.Query(storage.AttacksQuery, sessionID)
+	if err != nil {
+		return nil, errors.Wrap(err, "getting attacks error")
+	}
+	defer rows.Close()
+
+	attacks := make([]repeater.Attack, 0)
+	for rows.Next() {
+		attack, err := storage.ScanAttack(rows, p.cipher)
+		if err != nil {
+			return nil, errors.Wrap(err, "getting attacks error")
+		}
+		attacks = append(attacks, *attack)
+	}
+	return attacks, errors.Wrap(rows.Err(), "getting attacks error")
+}
+
+func (p *Storage) GetAttack(id int64) (*repeater.Attack, error) {
+	attack, err := storage.ScanAttack(p.conn.QueryRow(storage.AttackByIDQuery, id), p.cipher)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	return attack, errors.Wrap(err, "getting attack error")
+}
+
+func (p *Storage) UpdateAttack(id int64, status, errText string, finishedAt *time.Time) error {
+	_, err := p.conn.Exec(storage.UpdateAttackQuery, status, errText, finishedAt, id)
+	return errors.Wrap(err, "updating attack error")
+}
+
+func (p *Storage) DeleteAttack(id int64) (bool, error) {
+	res, err := p.conn.Exec(storage.DeleteAttackQuery, id)
+	if err != nil {
+		return false, errors.Wrap(err, "deleting attack error")
+	}
+	return res.RowsAffected() > 0, nil
+}
+
+func (p *Storage) InsertAttackResult(res *repeater.AttackResult) error {
+	sealed, err := p.cipher.SealAttackResult(res)
+	if err != nil {
+		return errors.Wrap(err, "inserting attack result error")
+	}
+	_, err = p.conn.Exec(storage.InsertAttackResultQuery, res.AttackID, res.Index, sealed.Payloads, res.Position,
+		res.RequestID, res.Status, res.Length, res.Duration, sealed.Grep, res.Error, res.StartedAt)
+	return errors.Wrap(err, "inserting attack result error")
+}
+
+func (p *Storage) GetAttackResults(filter *repeater.AttackResultsFilter) ([]repeater.AttackResult, error) {
+	query, args := storage.AttackResultsQuery(filter)
+	rows, err := p.conn.Query(query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "getting attack results error")
+	}
+	defer rows.Close()
+
+	results := make([]repeater.AttackResult, 0)
+	for rows.Next() {
+		res, err := storage.ScanAttackResult(rows, p.cipher)
+		if err != nil {
+			return nil, errors.Wrap(err, "getting attack results error")
+		}
+		results = append(results, *res)
+	}
+	return results, errors.Wrap(rows.Err(), "getting attack results error")
+}
@@ -0,0 +1,76 @@
+package postgres
+
+import (
+	"embed"
+
+	"github.com/iiivan-lemon/technopark_proxy/internal/storage/migrate"
+	"github.com/jackc/pgx"
+)
+
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+const (
+	createVersionTable = `create table if not exists schema_migrations(
+    version bigint primary key,
+    name text not null,
+    applied_at timestamptz not null default now()
+);`
+	selectVersions = `SELECT version FROM schema_migrations ORDER BY version;`
+	insertVersion  = `INSERT INTO schema_migrations(version, name) VALUES($1, $2);`
+	deleteVersion  = `DELETE FROM schema_migrations WHERE version = $1;`
+)
+
+// Migrations returns the runner of the embedded schema migrations.
+func (p *Storage) Migrations() (*migrate.Runner, error) {
+	return migrate.NewRunner(migrationDriver{conn: p.conn}, migrations, "migrations")
+}
+
+type migrationDriver struct {
+	conn *pgx.ConnPool
+}
+
+func (d migrationDriver) Applied() ([]int, error) {
+	if _, err := d.conn.Exec(createVersionTable); err != nil {
+		return nil, err
+	}
+	rows, err := d.conn.Query(selectVersions)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var versions []int
+	for rows.Next() {
+		var version int
+		if err = rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		versions = append(versions, version)
+	}
+	return versions, rows.Err()
+}
+
+func (d migrationDriver) Apply(m *migrate.Migration, up bool) error {
+	tx, err := d.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if up {
+		_, err = tx.Exec(m.Up)
+		if err == nil {
+			_, err = tx.Exec(insertVersion, m.Version, m.Name)
+		}
+	} else {
+		_, err = tx.Exec(m.Down)
+		if err == nil {
+			_, err = tx.Exec(deleteVersion, m.Version)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,2 @@
+drop table if exists responses;
+drop table if exists requests;
@@ -1,4 +1,3 @@
-drop table requests cascade;
 create table if not exists requests(
     id bigserial primary key,
     method text,
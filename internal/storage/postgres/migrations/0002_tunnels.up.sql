@@ -0,0 +1,11 @@
+create table if not exists tunnels(
+    id bigserial primary key,
+    host text,
+    client_addr text,
+    bytes_sent bigint,
+    bytes_received bigint,
+    client_payload bytea,
+    server_payload bytea,
+    started_at timestamptz,
+    duration_ms bigint
+);
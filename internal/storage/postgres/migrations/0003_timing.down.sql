@@ -0,0 +1,11 @@
+drop index if exists responses_request_id_idx;
+alter table responses drop column if exists total_us;
+alter table responses drop column if exists ttfb_us;
+alter table responses drop column if exists tls_us;
+alter table responses drop column if exists connect_us;
+alter table responses drop column if exists dns_us;
+alter table responses drop column if exists size;
+
+drop index if exists requests_started_at_idx;
+alter table requests drop column if exists size;
+alter table requests drop column if exists started_at;
@@ -0,0 +1,11 @@
+alter table requests add column if not exists started_at timestamptz;
+alter table requests add column if not exists size bigint;
+create index if not exists requests_started_at_idx on requests(started_at);
+
+alter table responses add column if not exists size bigint;
+alter table responses add column if not exists dns_us bigint;
+alter table responses add column if not exists connect_us bigint;
+alter table responses add column if not exists tls_us bigint;
+alter table responses add column if not exists ttfb_us bigint;
+alter table responses add column if not exists total_us bigint;
+create index if not exists responses_request_id_idx on responses(request_id);
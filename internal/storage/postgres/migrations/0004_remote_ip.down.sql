@@ -0,0 +1 @@
+alter table responses drop column if exists remote_ip;
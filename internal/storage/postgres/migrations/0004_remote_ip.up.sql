@@ -0,0 +1 @@
+alter table responses add column if not exists remote_ip text;
@@ -0,0 +1,11 @@
+drop index if exists requests_client_addr_idx;
+drop index if exists requests_url_idx;
+drop index if exists requests_scheme_idx;
+drop index if exists requests_host_port_idx;
+alter table requests drop column if exists proto;
+alter table requests drop column if exists client_addr;
+alter table requests drop column if exists query;
+alter table requests drop column if exists url;
+alter table requests drop column if exists port;
+alter table requests drop column if exists host;
+alter table requests drop column if exists scheme;
@@ -0,0 +1,21 @@
+alter table requests add column if not exists scheme text;
+alter table requests add column if not exists host text;
+alter table requests add column if not exists port int;
+alter table requests add column if not exists url text;
+alter table requests add column if not exists query text;
+alter table requests add column if not exists client_addr text;
+alter table requests add column if not exists proto text;
+
+-- older rows only have the Host header and is_https to go by
+update requests set
+    scheme = case when is_https then 'https' else 'http' end,
+    host = regexp_replace(headers->>'Host', ':[0-9]+$', ''),
+    port = coalesce(substring(headers->>'Host' from ':([0-9]+)$')::int, case when is_https then 443 else 80 end)
+where scheme is null;
+update requests set url = scheme || '://' || (headers->>'Host') || coalesce(path, '')
+where url is null and headers->>'Host' is not null;
+
+create index if not exists requests_host_port_idx on requests(host, port);
+create index if not exists requests_scheme_idx on requests(scheme);
+create index if not exists requests_url_idx on requests(url);
+create index if not exists requests_client_addr_idx on requests(client_addr);
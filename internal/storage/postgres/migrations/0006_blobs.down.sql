@@ -0,0 +1,5 @@
+drop trigger if exists responses_release_blob on responses;
+drop function if exists release_blob();
+drop index if exists responses_body_hash_idx;
+alter table responses drop column if exists body_hash;
+drop table if exists blobs;
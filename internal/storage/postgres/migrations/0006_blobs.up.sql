@@ -0,0 +1,22 @@
+create table if not exists blobs(
+    hash text primary key,
+    size bigint,
+    refs bigint not null default 0,
+    -- null when the data is kept on the filesystem
+    data bytea
+);
+create index if not exists blobs_refs_idx on blobs(refs) where refs <= 0;
+
+alter table responses add column if not exists body_hash text;
+create index if not exists responses_body_hash_idx on responses(body_hash);
+
+create or replace function release_blob() returns trigger as $$
+begin
+    update blobs set refs = refs - 1 where hash = old.body_hash;
+    return old;
+end;
+$$ language plpgsql;
+
+drop trigger if exists responses_release_blob on responses;
+create trigger responses_release_blob after delete on responses
+    for each row when (old.body_hash is not null) execute procedure release_blob();
@@ -0,0 +1,3 @@
+alter table responses drop constraint if exists responses_request_id_fkey;
+alter table responses add constraint responses_request_id_fkey
+    foreign key (request_id) references requests(id);
@@ -0,0 +1,5 @@
+drop index if exists responses_body_fts_idx;
+drop index if exists responses_headers_fts_idx;
+drop index if exists requests_body_fts_idx;
+drop index if exists requests_headers_fts_idx;
+drop index if exists requests_url_fts_idx;
@@ -0,0 +1,11 @@
+-- full-text indexes over the expressions searched by GET /search
+create index if not exists requests_url_fts_idx on requests
+    using gin (to_tsvector('simple', left(coalesce(url, path, ''), 100000)));
+create index if not exists requests_headers_fts_idx on requests
+    using gin (to_tsvector('simple', left(coalesce(headers::text, '') || ' ' || coalesce(cookies::text, ''), 100000)));
+create index if not exists requests_body_fts_idx on requests
+    using gin (to_tsvector('simple', left(CASE WHEN position(E'\r\n\r\n' in raw) > 0 THEN substring(raw from position(E'\r\n\r\n' in raw) + 4) ELSE '' END, 100000)));
+create index if not exists responses_headers_fts_idx on responses
+    using gin (to_tsvector('simple', left(coalesce(headers::text, ''), 100000)));
+create index if not exists responses_body_fts_idx on responses
+    using gin (to_tsvector('simple', left(coalesce(body, ''), 100000)));
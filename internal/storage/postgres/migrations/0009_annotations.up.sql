@@ -0,0 +1,17 @@
+create table if not exists tags(
+    id bigserial primary key,
+    name text not null unique
+);
+create table if not exists request_tags(
+    request_id bigint not null references requests(id) on delete cascade,
+    tag_id bigint not null references tags(id) on delete cascade,
+    primary key (request_id, tag_id)
+);
+create index if not exists request_tags_tag_id_idx on request_tags(tag_id);
+
+create table if not exists annotations(
+    request_id bigint primary key references requests(id) on delete cascade,
+    note text not null default '',
+    color text not null default '',
+    updated_at timestamptz not null default now()
+);
@@ -0,0 +1,3 @@
+alter table tunnels drop column if exists session_id;
+alter table requests drop column if exists session_id;
+drop table if exists sessions;
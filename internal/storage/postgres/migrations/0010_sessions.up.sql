@@ -0,0 +1,25 @@
+create table if not exists sessions(
+    id bigserial primary key,
+    name text not null unique,
+    description text not null default '',
+    -- repeater.Scope as json
+    scope text not null default '{}',
+    active bool not null default false,
+    created_at timestamptz not null default now(),
+    archived_at timestamptz
+);
+create unique index if not exists sessions_active_idx on sessions(active) where active;
+
+-- the history recorded so far goes to the default session
+insert into sessions(id, name, active) values (1, 'default', true) on conflict do nothing;
+select setval('sessions_id_seq', (select max(id) from sessions));
+
+alter table requests add column if not exists session_id bigint references sessions(id);
+update requests set session_id = 1 where session_id is null;
+alter table requests alter column session_id set not null;
+create index if not exists requests_session_id_idx on requests(session_id, id);
+
+alter table tunnels add column if not exists session_id bigint references sessions(id);
+update tunnels set session_id = 1 where session_id is null;
+alter table tunnels alter column session_id set not null;
+create index if not exists tunnels_session_id_idx on tunnels(session_id);
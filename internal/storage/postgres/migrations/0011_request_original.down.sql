@@ -0,0 +1 @@
+alter table requests drop column if exists original;
@@ -0,0 +1 @@
+alter table requests add column if not exists original text;
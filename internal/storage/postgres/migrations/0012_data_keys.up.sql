@@ -0,0 +1,6 @@
+-- data keys sealing the captured traffic, wrapped by the master key
+create table if not exists data_keys(
+    id bigserial primary key,
+    wrapped text not null,
+    created_at timestamptz not null default now()
+);
@@ -0,0 +1,21 @@
+-- media type of the response, GET /requests filters on it as headers may be sealed
+alter table responses add column if not exists content_type text;
+update responses set content_type = lower(trim(split_part(
+        case jsonb_typeof(headers->'Content-Type')
+            when 'string' then headers->>'Content-Type'
+            when 'array' then headers->'Content-Type'->>0
+        end, ';', 1)))
+    where content_type is null and jsonb_typeof(headers) = 'object';
+
+-- rows recorded before the columns existed are listed as 0,
+-- the pages of GET /requests are keyed on the same values
+update requests set size = 0 where size is null;
+update responses set size = coalesce(size, 0), ttfb_us = coalesce(ttfb_us, 0), total_us = coalesce(total_us, 0)
+    where size is null or ttfb_us is null or total_us is null;
+
+create index if not exists requests_session_started_at_idx on requests(session_id, started_at, id);
+create index if not exists requests_session_size_idx on requests(session_id, size, id);
+create index if not exists requests_session_method_idx on requests(session_id, method);
+create index if not exists requests_session_host_idx on requests(session_id, host);
+-- the latest response of a request without a heap lookup
+create index if not exists responses_request_id_id_idx on responses(request_id, id);
@@ -0,0 +1,5 @@
+-- the request a repeat was made from, a repeat outlives it
+alter table requests add column if not exists parent_id bigint references requests(id) on delete set null;
+-- what sent the request: proxy, repeater or scanner
+alter table requests add column if not exists source text not null default 'proxy' check (source in ('proxy', 'repeater', 'scanner'));
+create index if not exists requests_parent_id_idx on requests(parent_id);
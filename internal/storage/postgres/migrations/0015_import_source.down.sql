@@ -0,0 +1,4 @@
+-- imported requests are kept as recorded by the proxy
+update requests set source = 'proxy' where source = 'import';
+alter table requests drop constraint if exists requests_source_check;
+alter table requests add constraint requests_source_check check (source in ('proxy', 'repeater', 'scanner'));
@@ -0,0 +1,3 @@
+-- requests imported from HAR, curl commands and .http files
+alter table requests drop constraint if exists requests_source_check;
+alter table requests add constraint requests_source_check check (source in ('proxy', 'repeater', 'scanner', 'import'));
@@ -0,0 +1,2 @@
+drop table if exists attack_results;
+drop table if exists attacks;
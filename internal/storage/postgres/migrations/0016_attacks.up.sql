@@ -0,0 +1,43 @@
+-- repeater.Attack, its template and payloads are sealed when the storage is encrypted
+create table if not exists attacks(
+    id bigserial primary key,
+    session_id bigint not null references sessions(id),
+    -- the request the attack is built on, an attack outlives it
+    request_id bigint references requests(id) on delete set null,
+    type text not null check (type in ('sniper', 'battering-ram', 'pitchfork', 'cluster-bomb')),
+    template text not null,
+    -- the repeater.PayloadSet list as json
+    payloads text not null,
+    concurrency int not null,
+    delay int not null default 0,
+    -- regular expressions as a json list
+    grep text not null default '[]',
+    scheme text not null,
+    host text not null,
+    port int not null,
+    status text not null,
+    total bigint not null,
+    error text not null default '',
+    created_at timestamptz not null,
+    finished_at timestamptz
+);
+create index if not exists attacks_session_id_idx on attacks(session_id, id);
+
+-- repeater.AttackResult, the payloads are sealed when the storage is encrypted
+create table if not exists attack_results(
+    id bigserial primary key,
+    attack_id bigint not null references attacks(id) on delete cascade,
+    idx bigint not null,
+    payloads text not null,
+    position int,
+    request_id bigint references requests(id) on delete set null,
+    status int not null,
+    length bigint not null,
+    duration_us bigint not null,
+    -- a json list of booleans, one per regular expression
+    grep text not null,
+    error text not null default '',
+    started_at timestamptz not null,
+    unique (attack_id, idx)
+);
+create index if not exists attack_results_request_id_idx on attack_results(request_id);
@@ -0,0 +1,427 @@
+// Package postgres is the storage backend on top of a PostgreSQL server.
+package postgres
+
+import (
+	"time"
+
+	proxyserver "github.com/iiivan-lemon/technopark_proxy/internal/proxyServer"
+	"github.com/iiivan-lemon/technopark_proxy/internal/repeater"
+	"github.com/iiivan-lemon/technopark_proxy/internal/storage"
+	"github.com/jackc/pgx"
+	"github.com/pkg/errors"
+)
+
+type Storage struct {
+	conn  *pgx.ConnPool
+	blobs *storage.Blobs
+	// nil until EnableEncryption
+	cipher *storage.Cipher
+}
+
+func NewStorage(conn *pgx.ConnPool, blobs *storage.Blobs) *Storage {
+	return &Storage{
+		conn:  conn,
+		blobs: blobs,
+	}
+}
+
+func (p *Storage) Close() error {
+	p.conn.Close()
+	return nil
+}
+
+func (p *Storage) InsertRequest(req *proxyserver.Request) (uint, error) {
+	var id uint
+	sealed, err := p.cipher.SealRequest(req)
+	if err != nil {
+		return id, err
+	}
+	source, parentID := storage.Lineage(req)
+	err = p.conn.QueryRow(storage.InsertRequestQuery, req.Method, req.Path, req.GetParams, jsonb(sealed.Headers), jsonb(sealed.Cookies), req.PostParams, sealed.Raw, req.IsHTTPS,
+		req.StartedAt, req.Size, req.Scheme, req.Host, req.Port, req.URL, req.Query, req.ClientAddr, req.Proto, req.SessionID, req.Original, source, parentID).Scan(&id)
+	if err != nil {
+		return id, errors.Wrap(err, "inserting request error")
+	}
+	return id, nil
+
+}
+
+func (p *Storage) InsertResponse(reqID uint, resp *proxyserver.Response) error {
+	defer p.blobs.Hold()()
+	body, blob, err := p.blobs.Split(resp.Body)
+	if err != nil {
+		return err
+	}
+	headers, err := p.cipher.SealJSON(resp.Headers)
+	if err != nil {
+		return err
+	}
+	if body, err = p.cipher.Seal(body); err != nil {
+		return err
+	}
+	tx, err := p.conn.Begin()
+	if err != nil {
+		return errors.Wrap(err, "inserting response error")
+	}
+	defer tx.Rollback()
+
+	var bodyHash interface{}
+	if blob != nil {
+		if _, err = tx.Exec(storage.UpsertBlobQuery, blob.Hash, blob.Size, 1, blob.Column()); err != nil {
+			return errors.Wrap(err, "inserting blob error")
+		}
+		bodyHash = blob.Hash
+	}
+	t := resp.Timing
+	res, err := tx.Exec(storage.InsertResponseQuery, reqID, resp.Code, resp.Message, jsonb(headers), body, resp.Size,
+		t.DNS.Microseconds(), t.Connect.Microseconds(), t.TLS.Microseconds(), t.TTFB.Microseconds(), t.Total.Microseconds(), resp.RemoteIP, bodyHash, resp.ContentType())
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() != 1 {
+		return errors.New("inserting response error")
+	}
+	if err = tx.Commit(); err != nil {
+		return errors.Wrap(err, "inserting response error")
+	}
+	return p.blobs.Write([]*storage.Blob{blob})
+}
+
+func (p *Storage) InsertTunnel(tunnel *proxyserver.Tunnel) error {
+	sealed, err := p.cipher.SealTunnel(tunnel)
+	if err != nil {
+		return err
+	}
+	_, err = p.conn.Exec(storage.InsertTunnelQuery, tunnel.Host, tunnel.ClientAddr, tunnel.BytesSent, tunnel.BytesReceived,
+		sealed.ClientPayload, sealed.ServerPayload, tunnel.StartedAt, tunnel.Duration.Milliseconds(), tunnel.SessionID)
+	if err != nil {
+		return errors.Wrap(err, "inserting tunnel error")
+	}
+	return nil
+}
+
+// columns filled by COPY in InsertBatch
+var (
+	requestColumns = []string{"id", "method", "path", "get_params", "headers", "cookies", "post_params", "raw", "is_https", "started_at", "size",
+		"scheme", "host", "port", "url", "query", "client_addr", "proto", "session_id", "original", "source", "parent_id"}
+	responseColumns = []string{"request_id", "code", "message", "headers", "body", "size", "dns_us", "connect_us", "tls_us", "ttfb_us", "total_us", "remote_ip", "body_hash", "content_type"}
+	tunnelColumns   = []string{"host", "client_addr", "bytes_sent", "bytes_received", "client_payload", "server_payload", "started_at", "duration_ms", "session_id"}
+)
+
+// InsertBatch copies the rows in with COPY, request ids are taken from
+// the sequence up front so that responses can refer to them.
+func (p *Storage) InsertBatch(exchanges []proxyserver.Exchange, tunnels []proxyserver.Tunnel) error {
+	defer p.blobs.Hold()()
+	tx, err := p.conn.Begin()
+	if err != nil {
+		return errors.Wrap(err, "begin batch error")
+	}
+	defer tx.Rollback()
+
+	// written once the rows are committed
+	var written []*storage.Blob
+
+	if len(exchanges) > 0 {
+		rows, err := tx.Query(`SELECT nextval('requests_id_seq') FROM generate_series(1, $1);`, len(exchanges))
+		if err != nil {
+			return errors.Wrap(err, "allocating request ids error")
+		}
+		ids := make([]int64, 0, len(exchanges))
+		for rows.Next() {
+			var id int64
+			if err = rows.Scan(&id); err != nil {
+				rows.Close()
+				return errors.Wrap(err, "allocating request ids error")
+			}
+			ids = append(ids, id)
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return errors.Wrap(err, "allocating request ids error")
+		}
+
+		requests := make([][]interface{}, 0, len(exchanges))
+		responses := make([][]interface{}, 0, len(exchanges))
+		// a body repeated within the batch takes one upsert
+		blobs := make(map[string]*storage.Blob)
+		blobRefs := make(map[string]int)
+		for i, ex := range exchanges {
+			req := ex.Request
+			sealed, err := p.cipher.SealRequest(req)
+			if err != nil {
+				return err
+			}
+			source, parentID := storage.Lineage(req)
+			requests = append(requests, []interface{}{ids[i], req.Method, req.Path, req.GetParams, jsonb(sealed.Headers), jsonb(sealed.Cookies), req.PostParams,
+				sealed.Raw, req.IsHTTPS, req.StartedAt, req.Size, req.Scheme, req.Host, req.Port, req.URL, req.Query, req.ClientAddr, req.Proto, req.SessionID, req.Original,
+				source, parentID})
+			if resp := ex.Response; resp != nil {
+				body, blob, err := p.blobs.Split(resp.Body)
+				if err != nil {
+					return err
+				}
+				headers, err := p.cipher.SealJSON(resp.Headers)
+				if err != nil {
+					return err
+				}
+				if body, err = p.cipher.Seal(body); err != nil {
+					return err
+				}
+				var bodyHash interface{}
+				if blob != nil {
+					blobs[blob.Hash] = blob
+					blobRefs[blob.Hash]++
+					bodyHash = blob.Hash
+				}
+				t := resp.Timing
+				responses = append(responses, []interface{}{ids[i], resp.Code, resp.Message, jsonb(headers), body, resp.Size,
+					t.DNS.Microseconds(), t.Connect.Microseconds(), t.TLS.Microseconds(), t.TTFB.Microseconds(), t.Total.Microseconds(), resp.RemoteIP, bodyHash, resp.ContentType()})
+			}
+		}
+		for hash, blob := range blobs {
+			if _, err = tx.Exec(storage.UpsertBlobQuery, hash, blob.Size, blobRefs[hash], blob.Column()); err != nil {
+				return errors.Wrap(err, "inserting blob error")
+			}
+			written = append(written, blob)
+		}
+		if _, err = tx.CopyFrom(pgx.Identifier{"requests"}, requestColumns, pgx.CopyFromRows(requests)); err != nil {
+			return errors.Wrap(err, "copying requests error")
+		}
+		if len(responses) > 0 {
+			if _, err = tx.CopyFrom(pgx.Identifier{"responses"}, responseColumns, pgx.CopyFromRows(responses)); err != nil {
+				return errors.Wrap(err, "copying responses error")
+			}
+		}
+	}
+
+	if len(tunnels) > 0 {
+		rows := make([][]interface{}, 0, len(tunnels))
+		for i := range tunnels {
+			t := &tunnels[i]
+			sealed, err := p.cipher.SealTunnel(t)
+			if err != nil {
+				return err
+			}
+			rows = append(rows, []interface{}{t.Host, t.ClientAddr, t.BytesSent, t.BytesReceived, sealed.ClientPayload, sealed.ServerPayload,
+				t.StartedAt, t.Duration.Milliseconds(), t.SessionID})
+		}
+		if _, err = tx.CopyFrom(pgx.Identifier{"tunnels"}, tunnelColumns, pgx.CopyFromRows(rows)); err != nil {
+			return errors.Wrap(err, "copying tunnels error")
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return errors.Wrap(err, "commit batch error")
+	}
+	return p.blobs.Write(written)
+}
+
+func (p *Storage) GetAllRequests(filter *repeater.RequestsFilter) ([]repeater.RequestResponse, error) {
+	query, args := storage.RequestsQuery(filter)
+	rows, err := p.conn.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := make([]repeater.RequestResponse, 0)
+
+	for rows.Next() {
+		req, err := storage.ScanRequest(rows, p.cipher)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, *req)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	if len(res) == 0 || !filter.Wants("annotation") {
+		return res, nil
+	}
+	query, args = storage.RequestTagsQuery(filter)
+	return res, p.scanTags(res, query, args...)
+}
+
+func (p *Storage) GetRequestByID(id int) (*repeater.RequestResponse, error) {
+	req, err := storage.ScanRequest(p.conn.QueryRow(storage.GetRequestByIDQuery, id), p.cipher)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	res := []repeater.RequestResponse{*req}
+	return &res[0], p.scanTags(res, storage.RequestTagsByIDQuery, id)
+}
+
+func (p *Storage) GetRepeats(id int) ([]repeater.RequestResponse, error) {
+	rows, err := p.conn.Query(storage.RepeatsQuery, id)
+	if err != nil {
+		return nil, errors.Wrap(err, "getting repeats error")
+	}
+	defer rows.Close()
+
+	res := make([]repeater.RequestResponse, 0)
+	for rows.Next() {
+		req, err := storage.ScanRequest(rows, p.cipher)
+		if err != nil {
+			return nil, errors.Wrap(err, "getting repeats error")
+		}
+		res = append(res, *req)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "getting repeats error")
+	}
+	rows.Close()
+	return res, p.scanTags(res, storage.RepeatsTagsQuery, id)
+}
+
+func (p *Storage) GetResponses(requestID int) ([]repeater.Response, error) {
+	return p.queryResponses(storage.ResponsesByRequestQuery, requestID)
+}
+
+func (p *Storage) GetResponsesOf(requestIDs []int64) ([]repeater.Response, error) {
+	if len(requestIDs) == 0 {
+		return []repeater.Response{}, nil
+	}
+	query, args := storage.ResponsesOfQuery(requestIDs)
+	return p.queryResponses(query, args...)
+}
+
+// queryResponses reads the responses selected by query with their bodies.
+func (p *Storage) queryResponses(query string, args ...interface{}) ([]repeater.Response, error) {
+	rows, err := p.conn.Query(query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "getting responses error")
+	}
+	defer rows.Close()
+
+	res := make([]repeater.Response, 0)
+	for rows.Next() {
+		resp, err := storage.ScanResponse(rows, p.cipher)
+		if err != nil {
+			return nil, errors.Wrap(err, "getting responses error")
+		}
+		res = append(res, *resp)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "getting responses error")
+	}
+	rows.Close()
+
+	for i := range res {
+		if err = p.blobBody(&res[i]); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+func (p *Storage) GetResponse(id int) (*repeater.Response, error) {
+	resp, err := storage.ScanResponse(p.conn.QueryRow(storage.GetResponseByIDQuery, id), p.cipher)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "getting response error")
+	}
+	return resp, p.blobBody(resp)
+}
+
+// blobBody reads the body of resp kept in the blob store.
+func (p *Storage) blobBody(resp *repeater.Response) error {
+	if resp.Timing.BodyHash == "" {
+		return nil
+	}
+	data, err := p.GetBlob(resp.Timing.BodyHash)
+	if err != nil {
+		return err
+	}
+	resp.Body = string(data)
+	return nil
+}
+
+func (p *Storage) GetBlob(hash string) ([]byte, error) {
+	var data []byte
+	err := p.conn.QueryRow(storage.GetBlobQuery, hash).Scan(&data)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "getting blob error")
+	}
+	return p.blobs.Get(hash, data)
+}
+
+func (p *Storage) CollectBlobs() (int, error) {
+	return p.blobs.Collect(p.collectBlobs)
+}
+
+// collectBlobs deletes the rows of the blobs no response refers to.
+func (p *Storage) collectBlobs() ([]string, error) {
+	rows, err := p.conn.Query(storage.CollectBlobsQuery)
+	if err != nil {
+		return nil, errors.Wrap(err, "collecting blobs error")
+	}
+	defer rows.Close()
+
+	var hashes []string
+	for rows.Next() {
+		var hash string
+		if err = rows.Scan(&hash); err != nil {
+			return nil, errors.Wrap(err, "collecting blobs error")
+		}
+		hashes = append(hashes, hash)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "collecting blobs error")
+	}
+	return hashes, nil
+}
+
+func (p *Storage) DeleteRequest(id int) (bool, error) {
+	n, err := p.deleteAndCollect(storage.Query{SQL: storage.DeleteRequestQuery, Args: []interface{}{id}})
+	return n > 0, err
+}
+
+func (p *Storage) DeleteRequests(filter *repeater.RequestsFilter) (int, error) {
+	query, args := storage.DeleteRequestsQuery(filter)
+	return p.deleteAndCollect(storage.Query{SQL: query, Args: args})
+}
+
+func (p *Storage) Prune(retention *storage.Retention) (int, error) {
+	return p.deleteAndCollect(retention.Queries(time.Now())...)
+}
+
+// deleteAndCollect runs the deletes in one transaction, then drops the
+// blobs they left without references.
+func (p *Storage) deleteAndCollect(queries ...storage.Query) (int, error) {
+	if len(queries) == 0 {
+		return 0, nil
+	}
+	tx, err := p.conn.Begin()
+	if err != nil {
+		return 0, errors.Wrap(err, "deleting error")
+	}
+	defer tx.Rollback()
+
+	deleted := 0
+	for _, q := range queries {
+		res, err := tx.Exec(q.SQL, q.Args...)
+		if err != nil {
+			return 0, errors.Wrap(err, "deleting error")
+		}
+		deleted += int(res.RowsAffected())
+	}
+	if err = tx.Commit(); err != nil {
+		return 0, errors.Wrap(err, "deleting error")
+	}
+
+	if _, err = p.CollectBlobs(); err != nil {
+		return deleted, err
+	}
+	return deleted, nil
+}
@@ -0,0 +1,79 @@
+package postgres
+
+import (
+	"fmt"
+
+	"github.com/iiivan-lemon/technopark_proxy/internal/repeater"
+	"github.com/iiivan-lemon/technopark_proxy/internal/storage"
+	"github.com/pkg/errors"
+)
+
+// searchFields are the expressions of repeater.SearchFields. The full-text
+// indexes of migration 0008 are built over ftsText of the same expressions.
+var searchFields = map[string]string{
+	repeater.FieldURL:             `coalesce(r.url, r.path, '')`,
+	repeater.FieldRequestHeaders:  `coalesce(r.headers::text, '') || ' ' || coalesce(r.cookies::text, '')`,
+	repeater.FieldRequestBody:     `CASE WHEN position(E'\r\n\r\n' in r.raw) > 0 THEN substring(r.raw from position(E'\r\n\r\n' in r.raw) + 4) ELSE '' END`,
+	repeater.FieldResponseHeaders: `coalesce(resp.headers::text, '')`,
+	repeater.FieldResponseBody:    `coalesce(resp.body, '')`,
+}
+
+// ftsText cuts the text to index, a tsvector cannot hold a large body.
+func ftsText(expr string) string {
+	return "left(" + expr + ", 100000)"
+}
+
+var headlineOptions = fmt.Sprintf("StartSel=%s, StopSel=%s, MaxWords=20, MinWords=5", repeater.HighlightStart, repeater.HighlightStop)
+
+// Search runs full-text queries against the indexes, with snippets made
+// by ts_headline, and regular expressions with snippets made here.
+// Bodies kept in the blob store are not searched.
+func (p *Storage) Search(q *repeater.SearchQuery) ([]repeater.SearchHit, error) {
+	// the indexes cover sealed text when the traffic is encrypted
+	if p.cipher != nil {
+		rows, err := p.conn.Query(storage.SealedSearchQuery, q.SessionID)
+		if err != nil {
+			return nil, errors.Wrap(err, "search error")
+		}
+		defer rows.Close()
+		return storage.SearchSealed(q, rows, p.cipher)
+	}
+
+	var query string
+	if q.Regex != nil {
+		query = storage.SearchSQL(q, searchFields, func(expr string) string {
+			return expr + " ~ $1"
+		}, "text", 3)
+	} else {
+		query = storage.SearchSQL(q, searchFields, func(expr string) string {
+			return "to_tsvector('simple', " + ftsText(expr) + ") @@ plainto_tsquery('simple', $1)"
+		}, "ts_headline('simple', "+ftsText("text")+", plainto_tsquery('simple', $1), '"+headlineOptions+"')", 3)
+	}
+	pattern := q.Text
+	if q.Regex != nil {
+		pattern = q.Regex.String()
+	}
+
+	rows, err := p.conn.Query(query, pattern, q.SessionID, q.Limit)
+	if err != nil {
+		return nil, errors.Wrap(err, "search error")
+	}
+	defer rows.Close()
+
+	hits := make([]repeater.SearchHit, 0)
+	for rows.Next() {
+		var (
+			hit  repeater.SearchHit
+			text string
+		)
+		if err = rows.Scan(&hit.RequestID, &hit.Method, &hit.URL, &hit.Field, &text); err != nil {
+			return nil, errors.Wrap(err, "search error")
+		}
+		hit.Snippet = text
+		if q.Regex != nil {
+			hit.Snippet, _ = q.Match(text)
+		}
+		hits = append(hits, hit)
+	}
+	return hits, errors.Wrap(rows.Err(), "search error")
+}
@@ -0,0 +1,124 @@
+package postgres
+
+import (
+	"time"
+
+	"github.com/iiivan-lemon/technopark_proxy/internal/repeater"
+	"github.com/iiivan-lemon/technopark_proxy/internal/storage"
+	"github.com/jackc/pgx"
+	"github.com/pkg/errors"
+)
+
+func (p *Storage) CreateSession(session *repeater.Session) error {
+	tx, err := p.conn.Begin()
+	if err != nil {
+		return errors.Wrap(err, "creating session error")
+	}
+	defer tx.Rollback()
+
+	if err = checkSessionName(tx, session); err != nil {
+		return err
+	}
+	createdAt := time.Now()
+	err = tx.QueryRow(storage.InsertSessionQuery, session.Name, session.Description, session.Scope.Text(), createdAt).Scan(&session.ID)
+	if err != nil {
+		return errors.Wrap(err, "creating session error")
+	}
+	if err = tx.Commit(); err != nil {
+		return errors.Wrap(err, "creating session error")
+	}
+	session.CreatedAt = createdAt
+	return nil
+}
+
+// checkSessionName returns repeater.ErrSessionExists when another
+// session has the name of session.
+func checkSessionName(tx *pgx.Tx, session *repeater.Session) error {
+	var n int64
+	if err := tx.QueryRow(storage.CountSessionNameQuery, session.Name, session.ID).Scan(&n); err != nil {
+		return errors.Wrap(err, "checking session name error")
+	}
+	if n > 0 {
+		return repeater.ErrSessionExists
+	}
+	return nil
+}
+
+func (p *Storage) GetSessions() ([]repeater.Session, error) {
+	rows, err := p.conn.Query(storage.SessionsQuery)
+	if err != nil {
+		return nil, errors.Wrap(err, "getting sessions error")
+	}
+	defer rows.Close()
+
+	sessions := make([]repeater.Session, 0)
+	for rows.Next() {
+		session, err := storage.ScanSession(rows)
+		if err != nil {
+			return nil, errors.Wrap(err, "getting sessions error")
+		}
+		sessions = append(sessions, *session)
+	}
+	return sessions, errors.Wrap(rows.Err(), "getting sessions error")
+}
+
+func (p *Storage) GetSession(id int64) (*repeater.Session, error) {
+	session, err := storage.ScanSession(p.conn.QueryRow(storage.SessionByIDQuery, id))
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	return session, errors.Wrap(err, "getting session error")
+}
+
+func (p *Storage) ActiveSession() (*repeater.Session, error) {
+	session, err := storage.ScanSession(p.conn.QueryRow(storage.ActiveSessionQuery))
+	return session, errors.Wrap(err, "getting active session error")
+}
+
+func (p *Storage) UpdateSession(session *repeater.Session) (bool, error) {
+	tx, err := p.conn.Begin()
+	if err != nil {
+		return false, errors.Wrap(err, "updating session error")
+	}
+	defer tx.Rollback()
+
+	if err = checkSessionName(tx, session); err != nil {
+		return false, err
+	}
+	res, err := tx.Exec(storage.UpdateSessionQuery, session.Name, session.Description, session.Scope.Text(), session.ID)
+	if err != nil {
+		return false, errors.Wrap(err, "updating session error")
+	}
+	if res.RowsAffected() == 0 {
+		return false, nil
+	}
+	return true, errors.Wrap(tx.Commit(), "updating session error")
+}
+
+func (p *Storage) ActivateSession(id int64) (bool, error) {
+	tx, err := p.conn.Begin()
+	if err != nil {
+		return false, errors.Wrap(err, "activating session error")
+	}
+	defer tx.Rollback()
+
+	var n int64
+	if err = tx.QueryRow(storage.CountSessionQuery, id).Scan(&n); err != nil || n == 0 {
+		return false, errors.Wrap(err, "activating session error")
+	}
+	if _, err = tx.Exec(storage.DeactivateQuery); err != nil {
+		return false, errors.Wrap(err, "activating session error")
+	}
+	if _, err = tx.Exec(storage.ActivateSessionQuery, id); err != nil {
+		return false, errors.Wrap(err, "activating session error")
+	}
+	return true, errors.Wrap(tx.Commit(), "activating session error")
+}
+
+func (p *Storage) ArchiveSession(id int64) (bool, error) {
+	res, err := p.conn.Exec(storage.ArchiveSessionQuery, time.Now(), id)
+	if err != nil {
+		return false, errors.Wrap(err, "archiving session error")
+	}
+	return res.RowsAffected() > 0, nil
+}
@@ -0,0 +1,400 @@
+// Package queue moves recording off the proxy's request path: records are
+// queued, written to the storage in batches by a single writer, and what
+// cannot be written is dropped, waited for or spilled to disk.
+package queue
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/iiivan-lemon/technopark_proxy/config"
+	proxyserver "github.com/iiivan-lemon/technopark_proxy/internal/proxyServer"
+	log "github.com/iiivan-lemon/technopark_proxy/internal/tools/logger"
+	"github.com/pkg/errors"
+)
+
+const (
+	OverflowDrop  = "drop"
+	OverflowBlock = "block"
+	OverflowSpill = "spill"
+)
+
+const (
+	defaultSize          = 10000
+	defaultBatchSize     = 500
+	defaultFlushInterval = 200 * time.Millisecond
+
+	// pause before retrying a storage that failed, doubled up to maxBackoff
+	minBackoff = 100 * time.Millisecond
+	maxBackoff = 10 * time.Second
+)
+
+// record is either an exchange or a tunnel.
+type record struct {
+	Exchange *proxyserver.Exchange `json:"exchange,omitempty"`
+	Tunnel   *proxyserver.Tunnel   `json:"tunnel,omitempty"`
+}
+
+// Queue implements proxyserver.Recorder on top of a proxyserver.Repository.
+type Queue struct {
+	repo          proxyserver.Repository
+	logger        *log.ServLogger
+	overflow      string
+	batchSize     int
+	flushInterval time.Duration
+	spill         *spill
+
+	// closed and the close of records are guarded by mu, so that
+	// nothing is sent on a closed channel
+	mu      sync.RWMutex
+	closed  bool
+	records chan record
+	// closed when Close runs out of time: nothing is retried any more
+	abort     chan struct{}
+	abortOnce sync.Once
+	done      chan struct{}
+
+	// owned by the writer goroutine
+	backoff time.Duration
+	retryAt time.Time
+
+	written, dropped, spilled, replayed, rejected, failedBatches int64
+}
+
+// NewQueue starts the writer, spilled records left from a previous run
+// are written as soon as the storage accepts them.
+func NewQueue(repo proxyserver.Repository, conf *config.QueueConfig, logger *log.ServLogger) (*Queue, error) {
+	q := &Queue{
+		repo:          repo,
+		logger:        logger,
+		overflow:      conf.Overflow,
+		batchSize:     conf.BatchSize,
+		flushInterval: time.Duration(conf.FlushInterval) * time.Millisecond,
+		abort:         make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+	size := conf.Size
+	if size <= 0 {
+		size = defaultSize
+	}
+	if q.batchSize <= 0 {
+		q.batchSize = defaultBatchSize
+	}
+	if q.flushInterval <= 0 {
+		q.flushInterval = defaultFlushInterval
+	}
+
+	switch q.overflow {
+	case OverflowDrop, OverflowBlock:
+	case OverflowSpill:
+		sp, err := newSpill(conf.SpillDir)
+		if err != nil {
+			return nil, err
+		}
+		q.spill = sp
+	case "":
+		q.overflow = OverflowDrop
+	default:
+		return nil, errors.Errorf("unknown queue overflow mode %q", conf.Overflow)
+	}
+
+	q.records = make(chan record, size)
+	go q.run()
+	return q, nil
+}
+
+func (q *Queue) RecordExchange(exchange *proxyserver.Exchange) {
+	q.enqueue(record{Exchange: exchange})
+}
+
+func (q *Queue) RecordTunnel(tunnel *proxyserver.Tunnel) {
+	q.enqueue(record{Tunnel: tunnel})
+}
+
+func (q *Queue) enqueue(rec record) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.closed {
+		atomic.AddInt64(&q.dropped, 1)
+		return
+	}
+
+	select {
+	case q.records <- rec:
+		return
+	default:
+	}
+
+	switch q.overflow {
+	case OverflowBlock:
+		select {
+		case q.records <- rec:
+		case <-q.abort:
+			atomic.AddInt64(&q.dropped, 1)
+		}
+	case OverflowSpill:
+		q.spillRecords([]record{rec})
+	default:
+		atomic.AddInt64(&q.dropped, 1)
+	}
+}
+
+// Close stops taking records and writes out the queued ones. When ctx
+// expires first, what is left is spilled or dropped without retries.
+func (q *Queue) Close(ctx context.Context) error {
+	stopWatch := make(chan struct{})
+	defer close(stopWatch)
+	go func() {
+		select {
+		case <-ctx.Done():
+			q.abortOnce.Do(func() { close(q.abort) })
+		case <-stopWatch:
+		}
+	}()
+
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.records)
+	}
+	q.mu.Unlock()
+
+	<-q.done
+	if q.spill != nil {
+		if err := q.spill.close(); err != nil {
+			return err
+		}
+	}
+	return ctx.Err()
+}
+
+func (q *Queue) run() {
+	defer close(q.done)
+	ticker := time.NewTicker(q.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]record, 0, q.batchSize)
+	for {
+		select {
+		case rec, ok := <-q.records:
+			if !ok {
+				q.flush(batch)
+				return
+			}
+			batch = append(batch, rec)
+			if len(batch) >= q.batchSize {
+				q.flush(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				q.flush(batch)
+				batch = batch[:0]
+			} else {
+				q.replay()
+			}
+		}
+	}
+}
+
+// flush writes a batch. While the storage is failing, batches are not
+// sent to it until the backoff passes: they are spilled or dropped right
+// away, or, in block mode, retried until they get through. Records the
+// storage refuses one by one do not hold the others back.
+func (q *Queue) flush(batch []record) {
+	if len(batch) == 0 {
+		return
+	}
+	for {
+		if q.aborted() || time.Now().Before(q.retryAt) {
+			if q.overflow != OverflowBlock || q.aborted() {
+				q.reject(batch)
+				return
+			}
+			q.sleep(time.Until(q.retryAt))
+			continue
+		}
+
+		err := q.write(batch)
+		if err == nil {
+			atomic.AddInt64(&q.written, int64(len(batch)))
+			return
+		}
+		atomic.AddInt64(&q.failedBatches, 1)
+		q.logger.Error(0, errors.Wrap(err, "writing recorded batch error").Error())
+		if batch = q.writeEach(batch, &q.written); len(batch) == 0 {
+			return
+		}
+	}
+}
+
+// writeEach writes the records of a refused batch one at a time and
+// counts the stored ones in stored. A record is refused for good when
+// the storage still takes an empty batch after it, the records not tried
+// once the storage itself fails are returned.
+func (q *Queue) writeEach(batch []record, stored *int64) []record {
+	for i := range batch {
+		err := q.write(batch[i : i+1])
+		if err == nil {
+			atomic.AddInt64(stored, 1)
+			continue
+		}
+		if q.write(nil) != nil {
+			return batch[i:]
+		}
+		q.refuse(batch[i], err)
+	}
+	return nil
+}
+
+// refuse keeps a record the storage refuses aside in spill mode, or
+// drops it.
+func (q *Queue) refuse(rec record, err error) {
+	atomic.AddInt64(&q.rejected, 1)
+	q.logger.Error(0, errors.Wrap(err, "writing recorded record error").Error())
+	if q.overflow == OverflowSpill {
+		if err = q.spill.reject(rec); err == nil {
+			return
+		}
+		q.logger.Error(0, err.Error())
+	}
+	atomic.AddInt64(&q.dropped, 1)
+}
+
+// write sends a batch to the storage and keeps track of its health.
+func (q *Queue) write(batch []record) error {
+	var (
+		exchanges []proxyserver.Exchange
+		tunnels   []proxyserver.Tunnel
+	)
+	for _, rec := range batch {
+		switch {
+		case rec.Exchange != nil:
+			exchanges = append(exchanges, *rec.Exchange)
+		case rec.Tunnel != nil:
+			tunnels = append(tunnels, *rec.Tunnel)
+		}
+	}
+
+	if err := q.repo.InsertBatch(exchanges, tunnels); err != nil {
+		q.backoff *= 2
+		if q.backoff < minBackoff {
+			q.backoff = minBackoff
+		}
+		if q.backoff > maxBackoff {
+			q.backoff = maxBackoff
+		}
+		q.retryAt = time.Now().Add(q.backoff)
+		return err
+	}
+	q.backoff = 0
+	q.retryAt = time.Time{}
+	return nil
+}
+
+// reject spills records the storage could not take, or drops them.
+func (q *Queue) reject(batch []record) {
+	if q.overflow == OverflowSpill {
+		q.spillRecords(batch)
+		return
+	}
+	atomic.AddInt64(&q.dropped, int64(len(batch)))
+}
+
+func (q *Queue) spillRecords(recs []record) {
+	if err := q.spill.write(recs); err != nil {
+		q.logger.Error(0, err.Error())
+		atomic.AddInt64(&q.dropped, int64(len(recs)))
+		return
+	}
+	atomic.AddInt64(&q.spilled, int64(len(recs)))
+}
+
+// replay writes spilled records back once the queue is idle and the
+// storage is healthy. A segment is removed once all of it is stored or
+// refused, what is left when the storage fails is replayed later.
+func (q *Queue) replay() {
+	if q.spill == nil || q.aborted() || time.Now().Before(q.retryAt) {
+		return
+	}
+	segments, err := q.spill.segments()
+	if err != nil {
+		q.logger.Error(0, err.Error())
+		return
+	}
+	for _, segment := range segments {
+		recs, err := q.spill.read(segment)
+		if err != nil {
+			q.logger.Error(0, err.Error())
+			continue
+		}
+		for len(recs) > 0 {
+			n := q.batchSize
+			if n > len(recs) {
+				n = len(recs)
+			}
+			if err = q.write(recs[:n]); err != nil {
+				atomic.AddInt64(&q.failedBatches, 1)
+				q.logger.Error(0, errors.Wrap(err, "replaying spilled batch error").Error())
+				if left := q.writeEach(recs[:n], &q.replayed); len(left) > 0 {
+					if err = q.spill.rewrite(segment, append(left, recs[n:]...)); err != nil {
+						q.logger.Error(0, err.Error())
+					}
+					return
+				}
+			} else {
+				atomic.AddInt64(&q.replayed, int64(n))
+			}
+			recs = recs[n:]
+		}
+		if err = q.spill.remove(segment); err != nil {
+			q.logger.Error(0, err.Error())
+			return
+		}
+	}
+}
+
+func (q *Queue) aborted() bool {
+	select {
+	case <-q.abort:
+		return true
+	default:
+		return false
+	}
+}
+
+func (q *Queue) sleep(d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-q.abort:
+	}
+}
+
+// WriteMetrics reports the queue state in the Prometheus text format.
+func (q *Queue) WriteMetrics(w io.Writer) error {
+	metrics := []struct {
+		name, kind, help string
+		value            int64
+	}{
+		{"proxy_queue_depth", "gauge", "Records waiting to be written.", int64(len(q.records))},
+		{"proxy_queue_capacity", "gauge", "Records the queue holds before overflowing.", int64(cap(q.records))},
+		{"proxy_queue_written_total", "counter", "Records written to the storage.", atomic.LoadInt64(&q.written)},
+		{"proxy_queue_dropped_total", "counter", "Records lost to overflow or storage failures.", atomic.LoadInt64(&q.dropped)},
+		{"proxy_queue_spilled_total", "counter", "Records spilled to disk.", atomic.LoadInt64(&q.spilled)},
+		{"proxy_queue_replayed_total", "counter", "Spilled records written to the storage.", atomic.LoadInt64(&q.replayed)},
+		{"proxy_queue_rejected_total", "counter", "Records the storage refused one by one, kept aside in spill mode.", atomic.LoadInt64(&q.rejected)},
+		{"proxy_queue_failed_batches_total", "counter", "Batches the storage refused.", atomic.LoadInt64(&q.failedBatches)},
+	}
+	for _, m := range metrics {
+		if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %d\n", m.name, m.help, m.name, m.kind, m.name, m.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
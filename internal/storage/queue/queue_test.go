@@ -0,0 +1,256 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/iiivan-lemon/technopark_proxy/config"
+	proxyserver "github.com/iiivan-lemon/technopark_proxy/internal/proxyServer"
+	log "github.com/iiivan-lemon/technopark_proxy/internal/tools/logger"
+	"go.uber.org/zap"
+)
+
+const poison = "/poison"
+
+// repository stands in for the storage: it fails everything while down
+// and always refuses a batch holding a request to poison.
+type repository struct {
+	mu     sync.Mutex
+	down   bool
+	stored []string
+}
+
+func (r *repository) setDown(down bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.down = down
+}
+
+func (r *repository) paths() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	res := append([]string(nil), r.stored...)
+	sort.Strings(res)
+	return res
+}
+
+func (r *repository) InsertRequest(req *proxyserver.Request) (uint, error) {
+	return 0, r.InsertBatch([]proxyserver.Exchange{{Request: req}}, nil)
+}
+
+func (r *repository) InsertResponse(reqID uint, resp *proxyserver.Response) error {
+	return errors.New("not supported")
+}
+
+func (r *repository) InsertTunnel(tunnel *proxyserver.Tunnel) error {
+	return r.InsertBatch(nil, []proxyserver.Tunnel{*tunnel})
+}
+
+func (r *repository) InsertBatch(exchanges []proxyserver.Exchange, tunnels []proxyserver.Tunnel) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.down {
+		return errors.New("storage is down")
+	}
+	for _, exchange := range exchanges {
+		if exchange.Request.Path == poison {
+			return errors.New("constraint violated")
+		}
+	}
+	for _, exchange := range exchanges {
+		r.stored = append(r.stored, exchange.Request.Path)
+	}
+	for _, tunnel := range tunnels {
+		r.stored = append(r.stored, tunnel.Host)
+	}
+	return nil
+}
+
+func newQueue(t *testing.T, repo *repository, conf config.QueueConfig) *Queue {
+	if conf.FlushInterval == 0 {
+		conf.FlushInterval = 10
+	}
+	q, err := NewQueue(repo, &conf, log.NewServLogger(zap.NewNop().Sugar()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return q
+}
+
+func closeQueue(t *testing.T, q *Queue) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := q.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func exchange(path string) *proxyserver.Exchange {
+	return &proxyserver.Exchange{Request: &proxyserver.Request{Method: "GET", Path: path}}
+}
+
+// waitFor polls cond until it holds or the test runs out of patience.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestDrop(t *testing.T) {
+	repo := &repository{down: true}
+	q := newQueue(t, repo, config.QueueConfig{Size: 2, BatchSize: 2, Overflow: OverflowDrop})
+	for _, path := range []string{"/a", "/b", "/c", "/d", "/e"} {
+		q.RecordExchange(exchange(path))
+	}
+	closeQueue(t, q)
+
+	if got := repo.paths(); len(got) != 0 {
+		t.Errorf("stored %v while the storage was down", got)
+	}
+	if dropped := atomic.LoadInt64(&q.dropped); dropped != 5 {
+		t.Errorf("dropped = %d, want 5", dropped)
+	}
+	q.RecordTunnel(&proxyserver.Tunnel{Host: "late.example:443"})
+	if dropped := atomic.LoadInt64(&q.dropped); dropped != 6 {
+		t.Errorf("dropped after Close = %d, want 6", dropped)
+	}
+}
+
+func TestBlock(t *testing.T) {
+	repo := &repository{down: true}
+	q := newQueue(t, repo, config.QueueConfig{Size: 1, BatchSize: 1, Overflow: OverflowBlock})
+	recorded := make(chan struct{})
+	go func() {
+		defer close(recorded)
+		for _, path := range []string{"/a", "/b", "/c"} {
+			q.RecordExchange(exchange(path))
+		}
+	}()
+
+	waitFor(t, "a failed batch", func() bool { return atomic.LoadInt64(&q.failedBatches) > 0 })
+	select {
+	case <-recorded:
+		t.Fatal("the queue took every record while the storage was down")
+	default:
+	}
+	repo.setDown(false)
+	<-recorded
+	closeQueue(t, q)
+
+	if got, want := repo.paths(), []string{"/a", "/b", "/c"}; !equal(got, want) {
+		t.Errorf("stored %v, want %v", got, want)
+	}
+	if dropped := atomic.LoadInt64(&q.dropped); dropped != 0 {
+		t.Errorf("dropped = %d, want 0", dropped)
+	}
+}
+
+func TestRefusedRecord(t *testing.T) {
+	repo := &repository{}
+	q := newQueue(t, repo, config.QueueConfig{BatchSize: 3, Overflow: OverflowDrop})
+	for _, path := range []string{"/a", poison, "/b"} {
+		q.RecordExchange(exchange(path))
+	}
+	closeQueue(t, q)
+
+	if got, want := repo.paths(), []string{"/a", "/b"}; !equal(got, want) {
+		t.Errorf("stored %v, want %v", got, want)
+	}
+	if rejected, dropped := atomic.LoadInt64(&q.rejected), atomic.LoadInt64(&q.dropped); rejected != 1 || dropped != 1 {
+		t.Errorf("rejected, dropped = %d, %d, want 1, 1", rejected, dropped)
+	}
+	if written := atomic.LoadInt64(&q.written); written != 2 {
+		t.Errorf("written = %d, want 2", written)
+	}
+}
+
+func TestSpillReplay(t *testing.T) {
+	dir := t.TempDir()
+	repo := &repository{down: true}
+	q := newQueue(t, repo, config.QueueConfig{BatchSize: 2, Overflow: OverflowSpill, SpillDir: dir})
+	for _, path := range []string{"/a", poison, "/b"} {
+		q.RecordExchange(exchange(path))
+	}
+	q.RecordTunnel(&proxyserver.Tunnel{Host: "chat.example:5222"})
+	waitFor(t, "the records to spill", func() bool { return atomic.LoadInt64(&q.spilled) == 4 })
+
+	repo.setDown(false)
+	waitFor(t, "the spill to drain", func() bool {
+		return atomic.LoadInt64(&q.replayed) == 3 && atomic.LoadInt64(&q.rejected) == 1
+	})
+	closeQueue(t, q)
+
+	if got, want := repo.paths(), []string{"/a", "/b", "chat.example:5222"}; !equal(got, want) {
+		t.Errorf("stored %v, want %v", got, want)
+	}
+	segments, err := filepath.Glob(filepath.Join(dir, "*"+segmentSuffix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(segments) != 0 {
+		t.Errorf("segments left after replay: %v", segments)
+	}
+	sp := &spill{dir: filepath.Join(dir, rejectedDir)}
+	rejected, err := sp.read(filepath.Join(sp.dir, "rejected"+segmentSuffix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rejected) != 1 || rejected[0].Exchange == nil || rejected[0].Exchange.Request.Path != poison {
+		t.Errorf("rejected records = %+v, want the poison one", rejected)
+	}
+}
+
+func TestReplayStorageDown(t *testing.T) {
+	dir := t.TempDir()
+	sp, err := newSpill(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = sp.write([]record{{Exchange: exchange("/a")}, {Exchange: exchange("/b")}}); err != nil {
+		t.Fatal(err)
+	}
+	if err = sp.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	repo := &repository{down: true}
+	q := newQueue(t, repo, config.QueueConfig{BatchSize: 1, Overflow: OverflowSpill, SpillDir: dir})
+	waitFor(t, "a failed replay", func() bool { return atomic.LoadInt64(&q.failedBatches) > 0 })
+	if rejected := atomic.LoadInt64(&q.rejected); rejected != 0 {
+		t.Errorf("rejected = %d while the storage was down, want 0", rejected)
+	}
+
+	repo.setDown(false)
+	waitFor(t, "the spill to drain", func() bool { return atomic.LoadInt64(&q.replayed) == 2 })
+	closeQueue(t, q)
+
+	if got, want := repo.paths(), []string{"/a", "/b"}; !equal(got, want) {
+		t.Errorf("stored %v, want %v", got, want)
+	}
+	if _, err = os.Stat(filepath.Join(dir, rejectedDir)); !os.IsNotExist(err) {
+		t.Errorf("records were rejected while the storage was down: %v", err)
+	}
+}
@@ -0,0 +1,158 @@
+package queue
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const segmentSuffix = ".jsonl"
+
+// rejectedDir is where records the storage refuses are kept, out of the
+// way of replay.
+const rejectedDir = "rejected"
+
+// spill keeps records as JSON lines in segment files. Records are appended
+// to the current segment, replay only touches segments closed by segments().
+type spill struct {
+	dir string
+
+	mu      sync.Mutex
+	current *os.File
+	writer  *bufio.Writer
+}
+
+func newSpill(dir string) (*spill, error) {
+	if dir == "" {
+		dir = "spill"
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, errors.Wrap(err, "creating spill directory")
+	}
+	return &spill{dir: dir}, nil
+}
+
+func (s *spill) write(recs []record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.current == nil {
+		name := filepath.Join(s.dir, fmt.Sprintf("%020d%s", time.Now().UnixNano(), segmentSuffix))
+		f, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+		if err != nil {
+			return errors.Wrap(err, "opening spill segment")
+		}
+		s.current = f
+		s.writer = bufio.NewWriter(f)
+	}
+
+	enc := json.NewEncoder(s.writer)
+	for i := range recs {
+		if err := enc.Encode(&recs[i]); err != nil {
+			return errors.Wrap(err, "spilling record")
+		}
+	}
+	return errors.Wrap(s.writer.Flush(), "spilling record")
+}
+
+// segments closes the current segment and lists all of them, oldest first.
+func (s *spill) segments() ([]string, error) {
+	if err := s.close(); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "listing spill segments")
+	}
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), segmentSuffix) {
+			names = append(names, filepath.Join(s.dir, entry.Name()))
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (s *spill) read(segment string) ([]record, error) {
+	f, err := os.Open(segment)
+	if err != nil {
+		return nil, errors.Wrap(err, "opening spill segment")
+	}
+	defer f.Close()
+
+	var recs []record
+	dec := json.NewDecoder(f)
+	for dec.More() {
+		var rec record
+		if err = dec.Decode(&rec); err != nil {
+			return nil, errors.Wrapf(err, "reading spill segment %s", segment)
+		}
+		recs = append(recs, rec)
+	}
+	return recs, nil
+}
+
+// rewrite replaces a partly replayed segment with the records still left.
+func (s *spill) rewrite(segment string, recs []record) error {
+	tmp := segment + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return errors.Wrap(err, "rewriting spill segment")
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for i := range recs {
+		if err = enc.Encode(&recs[i]); err != nil {
+			f.Close()
+			return errors.Wrap(err, "rewriting spill segment")
+		}
+	}
+	if err = w.Flush(); err != nil {
+		f.Close()
+		return errors.Wrap(err, "rewriting spill segment")
+	}
+	if err = f.Close(); err != nil {
+		return errors.Wrap(err, "rewriting spill segment")
+	}
+	return errors.Wrap(os.Rename(tmp, segment), "rewriting spill segment")
+}
+
+// reject appends a record the storage refuses to the rejected segment.
+func (s *spill) reject(rec record) error {
+	dir := filepath.Join(s.dir, rejectedDir)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return errors.Wrap(err, "creating rejected directory")
+	}
+	f, err := os.OpenFile(filepath.Join(dir, "rejected"+segmentSuffix), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return errors.Wrap(err, "opening rejected segment")
+	}
+	if err = json.NewEncoder(f).Encode(&rec); err != nil {
+		f.Close()
+		return errors.Wrap(err, "rejecting record")
+	}
+	return errors.Wrap(f.Close(), "rejecting record")
+}
+
+func (s *spill) remove(segment string) error {
+	return errors.Wrap(os.Remove(segment), "removing spill segment")
+}
+
+func (s *spill) close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.current == nil {
+		return nil
+	}
+	err := s.current.Close()
+	s.current, s.writer = nil, nil
+	return errors.Wrap(err, "closing spill segment")
+}
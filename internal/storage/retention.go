@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/iiivan-lemon/technopark_proxy/config"
+	log "github.com/iiivan-lemon/technopark_proxy/internal/tools/logger"
+	"github.com/pkg/errors"
+)
+
+// Retention limits the history, zero values mean no limit.
+// The oldest requests go first.
+type Retention struct {
+	MaxAge             time.Duration
+	MaxRequests        int
+	MaxRequestsPerHost int
+	// request and response bodies together
+	MaxBodyBytes int64
+}
+
+func NewRetention(conf *config.RetentionConfig) *Retention {
+	return &Retention{
+		MaxAge:             time.Duration(conf.MaxAge) * time.Hour,
+		MaxRequests:        conf.MaxRequests,
+		MaxRequestsPerHost: conf.MaxRequestsPerHost,
+		MaxBodyBytes:       conf.MaxBodyBytes,
+	}
+}
+
+// Query is a statement with its arguments.
+type Query struct {
+	SQL  string
+	Args []interface{}
+}
+
+// Queries returns the statements enforcing the limits, in order.
+// The windowed ones work in both Postgres and SQLite.
+func (r *Retention) Queries(now time.Time) []Query {
+	var queries []Query
+	if r.MaxAge > 0 {
+		cutoff := now.Add(-r.MaxAge).UTC()
+		queries = append(queries,
+			Query{`DELETE FROM requests WHERE started_at < $1;`, []interface{}{cutoff}},
+			Query{`DELETE FROM tunnels WHERE started_at < $1;`, []interface{}{cutoff}},
+		)
+	}
+	if r.MaxRequestsPerHost > 0 {
+		queries = append(queries, Query{`DELETE FROM requests WHERE id IN (SELECT id FROM (
+	SELECT id, row_number() OVER (PARTITION BY host ORDER BY id DESC) AS n FROM requests) t WHERE n > $1);`,
+			[]interface{}{r.MaxRequestsPerHost}})
+	}
+	if r.MaxRequests > 0 {
+		queries = append(queries, Query{`DELETE FROM requests WHERE id IN (SELECT id FROM (
+	SELECT id, row_number() OVER (ORDER BY id DESC) AS n FROM requests) t WHERE n > $1);`,
+			[]interface{}{r.MaxRequests}})
+	}
+	if r.MaxBodyBytes > 0 {
+		queries = append(queries, Query{`DELETE FROM requests WHERE id IN (SELECT id FROM (
+	SELECT r.id, sum(coalesce(r.size, 0) + coalesce(b.size, 0)) OVER (ORDER BY r.id DESC) AS total FROM requests r
+	LEFT JOIN (SELECT request_id, sum(size) AS size FROM responses GROUP BY request_id) b ON b.request_id = r.id) t
+	WHERE total > $1);`,
+			[]interface{}{r.MaxBodyBytes}})
+	}
+	return queries
+}
+
+// Janitor prunes the history by a Retention in the background.
+type Janitor struct {
+	store     Storage
+	retention *Retention
+	logger    *log.ServLogger
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+}
+
+// NewJanitor starts pruning every interval, the first run is right away.
+// A non-positive interval leaves the history alone.
+func NewJanitor(store Storage, conf *config.RetentionConfig, logger *log.ServLogger) *Janitor {
+	ctx, cancel := context.WithCancel(context.Background())
+	j := &Janitor{
+		store:     store,
+		retention: NewRetention(conf),
+		logger:    logger,
+		cancel:    cancel,
+	}
+	interval := time.Duration(conf.Interval) * time.Second
+	if interval > 0 {
+		j.wg.Add(1)
+		go j.run(ctx, interval)
+	}
+	return j
+}
+
+func (j *Janitor) run(ctx context.Context, interval time.Duration) {
+	defer j.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := j.store.Prune(j.retention); err != nil {
+			j.logger.Error(0, errors.Wrap(err, "pruning history error").Error())
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Close stops the janitor and waits for a run in progress.
+func (j *Janitor) Close() {
+	j.cancel()
+	j.wg.Wait()
+}
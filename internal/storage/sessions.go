@@ -0,0 +1,46 @@
+package storage
+
+import (
+	"database/sql"
+
+	"github.com/iiivan-lemon/technopark_proxy/internal/repeater"
+)
+
+// Queries on sessions shared by the SQL backends. A single session is
+// active, which a partial unique index makes sure of. SQLite numbers
+// parameters by their first appearance, so they appear in order.
+const (
+	selectSessions     = `SELECT id, name, description, scope, active, created_at, archived_at FROM sessions`
+	SessionsQuery      = selectSessions + ` ORDER BY id;`
+	SessionByIDQuery   = selectSessions + ` WHERE id = $1;`
+	ActiveSessionQuery = selectSessions + ` WHERE active;`
+	// ignores the session itself when renaming it
+	CountSessionNameQuery = `SELECT count(*) FROM sessions WHERE name = $1 AND id <> $2;`
+	InsertSessionQuery    = `INSERT INTO sessions(name, description, scope, created_at) VALUES($1, $2, $3, $4) RETURNING id;`
+	UpdateSessionQuery    = `UPDATE sessions SET name = $1, description = $2, scope = $3 WHERE id = $4;`
+	DeactivateQuery       = `UPDATE sessions SET active = false WHERE active;`
+	ActivateSessionQuery  = `UPDATE sessions SET active = true WHERE id = $1;`
+	ArchiveSessionQuery   = `UPDATE sessions SET archived_at = $1 WHERE id = $2 AND archived_at IS NULL;`
+	CountSessionQuery     = `SELECT count(*) FROM sessions WHERE id = $1;`
+)
+
+// ScanSession scans a row selected by the session queries.
+func ScanSession(row RowScanner) (*repeater.Session, error) {
+	session := &repeater.Session{}
+	var (
+		scope      string
+		archivedAt sql.NullTime
+	)
+	err := row.Scan(&session.ID, &session.Name, &session.Description, &scope, &session.Active, &session.CreatedAt, &archivedAt)
+	if err != nil {
+		return nil, err
+	}
+	if session.Scope, err = repeater.ParseScope(scope); err != nil {
+		return nil, err
+	}
+	if archivedAt.Valid {
+		t := archivedAt.Time
+		session.ArchivedAt = &t
+	}
+	return session, nil
+}
@@ -0,0 +1,364 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/iiivan-lemon/technopark_proxy/internal/repeater"
+)
+
+// Queries shared by the SQL backends, both of them understand $n placeholders.
+const (
+	InsertRequestQuery = `INSERT INTO requests(method, path, get_params, headers, cookies, post_params, raw, is_https, started_at, size,
+	scheme, host, port, url, query, client_addr, proto, session_id, original, source, parent_id)
+	VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21) RETURNING id;`
+	InsertResponseQuery = `INSERT INTO responses(request_id, code, message, headers, body, size, dns_us, connect_us, tls_us, ttfb_us, total_us, remote_ip, body_hash,
+	content_type) VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14);`
+	InsertTunnelQuery = `INSERT INTO tunnels(host, client_addr, bytes_sent, bytes_received, client_payload, server_payload, started_at, duration_ms, session_id)
+	VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9);`
+
+	// every request is joined with its latest response for timing and its annotation
+	fromRequests = ` from requests r
+	LEFT JOIN responses resp ON resp.id = (SELECT max(id) from responses WHERE request_id = r.id)
+	LEFT JOIN annotations a ON a.request_id = r.id`
+	selectRequests = `SELECT r.id, r.session_id, r.method, r.path, r.get_params, r.headers, r.cookies, r.post_params, r.raw, r.is_https, r.started_at, coalesce(r.size, 0),
+	r.scheme, r.host, r.port, r.url, r.query, r.client_addr, r.proto, r.original, r.source, r.parent_id,
+	resp.id, resp.dns_us, resp.connect_us, resp.tls_us, resp.ttfb_us, resp.total_us, resp.size, resp.remote_ip, resp.body_hash,
+	a.note, a.color` + fromRequests
+	GetRequestByIDQuery = selectRequests + ` WHERE r.id = $1;`
+	// lineage selects the repeats made from request $1 and from its repeats
+	lineage = `WITH RECURSIVE lineage(id) AS (
+		SELECT id FROM requests WHERE parent_id = $1
+		UNION ALL SELECT r.id FROM requests r JOIN lineage l ON r.parent_id = l.id)
+	`
+	RepeatsQuery = lineage + selectRequests + ` WHERE r.id IN (SELECT id FROM lineage) ORDER BY r.id;`
+
+	selectResponses = `SELECT resp.id, resp.request_id, r.session_id, resp.code, resp.message, resp.headers, resp.body, resp.body_hash,
+	resp.dns_us, resp.connect_us, resp.tls_us, resp.ttfb_us, resp.total_us, resp.size, resp.remote_ip
+	FROM responses resp JOIN requests r ON r.id = resp.request_id`
+	ResponsesByRequestQuery = selectResponses + ` WHERE resp.request_id = $1 ORDER BY resp.id;`
+	GetResponseByIDQuery    = selectResponses + ` WHERE resp.id = $1;`
+
+	// responses and what else refers to a request go with it by cascade
+	DeleteRequestQuery = `DELETE FROM requests WHERE id = $1;`
+)
+
+// ResponsesOfQuery selects the responses of the requests, by request and
+// oldest first, in one query.
+func ResponsesOfQuery(requestIDs []int64) (string, []interface{}) {
+	params := make([]string, len(requestIDs))
+	args := make([]interface{}, len(requestIDs))
+	for i, id := range requestIDs {
+		params[i] = fmt.Sprintf("$%d", i+1)
+		args[i] = id
+	}
+	return selectResponses + ` WHERE resp.request_id IN (` + strings.Join(params, ", ") + `) ORDER BY resp.request_id, resp.id;`, args
+}
+
+// sortColumns maps repeater.SortKeys to columns.
+var sortColumns = map[string]string{
+	"id":            "r.id",
+	"started_at":    "r.started_at",
+	"size":          "r.size",
+	"duration":      "resp.total_us",
+	"ttfb":          "resp.ttfb_us",
+	"response_size": "resp.size",
+}
+
+// projectedColumns are the columns left out, selected as NULL, when
+// RequestsFilter.Fields does not want the field.
+var projectedColumns = []struct{ field, column string }{
+	{"get_params", "r.get_params"},
+	{"headers", "r.headers"},
+	{"cookies", "r.cookies"},
+	{"post_params", "r.post_params"},
+	{"raw", "r.raw"},
+	// only the repeater needs the original, it goes with raw
+	{"raw", "r.original"},
+}
+
+// requestsWhere returns the WHERE clause matching filter, empty for no filter.
+func requestsWhere(filter *repeater.RequestsFilter) (string, []interface{}) {
+	var (
+		conds []string
+		args  []interface{}
+	)
+	// each %d of cond is the number of the next one of condArgs
+	addCond := func(cond string, condArgs ...interface{}) {
+		nums := make([]interface{}, len(condArgs))
+		for i, arg := range condArgs {
+			args = append(args, arg)
+			nums[i] = len(args)
+		}
+		conds = append(conds, fmt.Sprintf(cond, nums...))
+	}
+	// column IN ($n, ...)
+	addIn := func(column string, values []string) {
+		params := make([]string, len(values))
+		condArgs := make([]interface{}, len(values))
+		for i, v := range values {
+			params[i] = "$%d"
+			condArgs[i] = v
+		}
+		addCond(column+" IN ("+strings.Join(params, ", ")+")", condArgs...)
+	}
+	if filter.SessionID > 0 {
+		addCond("r.session_id = $%d", filter.SessionID)
+	}
+	if filter.MinDuration > 0 {
+		addCond("resp.total_us >= $%d", filter.MinDuration.Microseconds())
+	}
+	if filter.MaxDuration > 0 {
+		addCond("resp.total_us <= $%d", filter.MaxDuration.Microseconds())
+	}
+	if !filter.Since.IsZero() {
+		addCond("r.started_at >= $%d", filter.Since.UTC())
+	}
+	if !filter.Until.IsZero() {
+		addCond("r.started_at <= $%d", filter.Until.UTC())
+	}
+	for _, tag := range filter.Tags {
+		addCond(`EXISTS (SELECT 1 FROM request_tags ft JOIN tags t ON t.id = ft.tag_id
+	WHERE ft.request_id = r.id AND t.name = $%d)`, tag)
+	}
+	if len(filter.Methods) > 0 {
+		addIn("r.method", filter.Methods)
+	}
+	if len(filter.Hosts) > 0 {
+		addIn("r.host", filter.Hosts)
+	}
+	if filter.PathGlob != "" {
+		addCond(`r.path LIKE $%d ESCAPE '\'`, globToLike(filter.PathGlob))
+	}
+	if filter.HTTPS != nil {
+		addCond("r.is_https = $%d", *filter.HTTPS)
+	}
+	if len(filter.Statuses) > 0 {
+		ranges := make([]string, len(filter.Statuses))
+		condArgs := make([]interface{}, 0, 2*len(filter.Statuses))
+		for i, status := range filter.Statuses {
+			ranges[i] = "resp.code BETWEEN $%d AND $%d"
+			condArgs = append(condArgs, status.Min, status.Max)
+		}
+		addCond("("+strings.Join(ranges, " OR ")+")", condArgs...)
+	}
+	if filter.ContentType != "" {
+		if strings.HasSuffix(filter.ContentType, "/*") {
+			addCond(`resp.content_type LIKE $%d ESCAPE '\'`, likeEscaper.Replace(strings.TrimSuffix(filter.ContentType, "*"))+"%")
+		} else {
+			addCond("resp.content_type = $%d", filter.ContentType)
+		}
+	}
+	if filter.MinSize > 0 {
+		addCond("r.size >= $%d", filter.MinSize)
+	}
+	if filter.MaxSize > 0 {
+		addCond("r.size <= $%d", filter.MaxSize)
+	}
+	if c := filter.After; c != nil {
+		// nulls go last in both orders
+		column, op := sortColumn(c.SortBy), ">"
+		if c.Desc {
+			op = "<"
+		}
+		if c.Value == nil {
+			addCond(fmt.Sprintf("%s IS NULL AND r.id %s $%%d", column, op), c.ID)
+		} else {
+			addCond(fmt.Sprintf("((%s, r.id) %s ($%%d, $%%d) OR %s IS NULL)", column, op, column), cursorValue(c), c.ID)
+		}
+	}
+
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// globToLike turns a glob into a LIKE pattern escaped by a backslash.
+func globToLike(glob string) string {
+	return strings.NewReplacer("*", "%", "?", "_").Replace(likeEscaper.Replace(glob))
+}
+
+func sortColumn(key string) string {
+	if column, ok := sortColumns[key]; ok {
+		return column
+	}
+	return sortColumns["id"]
+}
+
+// cursorValue returns the value of the cursor as the sort column holds it.
+func cursorValue(c *repeater.Cursor) interface{} {
+	if c.SortBy == "started_at" {
+		return time.Unix(0, *c.Value).UTC()
+	}
+	return *c.Value
+}
+
+// requestsPage returns the WHERE, ORDER BY and LIMIT clauses of the
+// requests listed for filter.
+func requestsPage(filter *repeater.RequestsFilter) (string, []interface{}) {
+	where, args := requestsWhere(filter)
+
+	order := "ASC"
+	if filter.Desc {
+		order = "DESC"
+	}
+	clauses := where + fmt.Sprintf(" ORDER BY %s %s NULLS LAST, r.id %s", sortColumn(filter.SortBy), order, order)
+	if filter.Limit > 0 {
+		args = append(args, filter.Limit)
+		clauses += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+	return clauses, args
+}
+
+// RequestsQuery builds the query listing requests that match filter.
+func RequestsQuery(filter *repeater.RequestsFilter) (string, []interface{}) {
+	query := selectRequests
+	for _, p := range projectedColumns {
+		if !filter.Wants(p.field) {
+			query = strings.Replace(query, p.column+",", "NULL,", 1)
+		}
+	}
+	page, args := requestsPage(filter)
+	return query + page + ";", args
+}
+
+// DeleteRequestsQuery builds the query deleting requests that match filter,
+// the order of filter does not matter.
+func DeleteRequestsQuery(filter *repeater.RequestsFilter) (string, []interface{}) {
+	where, args := requestsWhere(filter)
+	return `DELETE FROM requests WHERE id IN (SELECT r.id` + fromRequests + where + `);`, args
+}
+
+// RowScanner is satisfied by the rows of both pgx and database/sql.
+type RowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// Rows is satisfied by the result sets of both pgx and database/sql.
+type Rows interface {
+	RowScanner
+	Next() bool
+	Err() error
+}
+
+// ScanRequest scans a row selected by RequestsQuery or GetRequestByIDQuery
+// and opens what c sealed, the tags come separately, see ScanTags.
+func ScanRequest(row RowScanner, c *Cipher) (*repeater.RequestResponse, error) {
+	req := &repeater.RequestResponse{}
+	var (
+		headers, cookies                     []byte
+		raw                                  sql.NullString
+		startedAt                            sql.NullTime
+		respID                               sql.NullInt64
+		dns, connect, tls, ttfb, total, size sql.NullInt64
+		remoteIP, bodyHash                   sql.NullString
+		note, color                          sql.NullString
+		// null in rows recorded before the columns existed
+		scheme, host, url, query, clientAddr, proto, original, source sql.NullString
+		port, parentID                                                sql.NullInt64
+	)
+	err := row.Scan(&req.ID, &req.SessionID, &req.Method, &req.Path, &req.GetParams, &headers, &cookies, &req.PostParams, &raw, &req.IsHTTPS, &startedAt, &req.Size,
+		&scheme, &host, &port, &url, &query, &clientAddr, &proto, &original, &source, &parentID,
+		&respID, &dns, &connect, &tls, &ttfb, &total, &size, &remoteIP, &bodyHash,
+		&note, &color)
+	if err != nil {
+		return nil, err
+	}
+	if err = c.ScanJSON(headers, &req.Headers); err != nil {
+		return nil, err
+	}
+	if err = c.ScanJSON(cookies, &req.Cookies); err != nil {
+		return nil, err
+	}
+	if req.Raw, err = c.Open(raw.String); err != nil {
+		return nil, err
+	}
+	req.Scheme, req.Host, req.Port = scheme.String, host.String, int(port.Int64)
+	req.URL, req.Query, req.ClientAddr, req.Proto = url.String, query.String, clientAddr.String, proto.String
+	req.Original, req.Source = original.String, source.String
+	if parentID.Valid {
+		req.ParentID = &parentID.Int64
+	}
+	if startedAt.Valid {
+		t := startedAt.Time
+		req.StartedAt = &t
+	}
+	if respID.Valid {
+		req.Timing = &repeater.Timing{
+			DNS:          dns.Int64,
+			Connect:      connect.Int64,
+			TLS:          tls.Int64,
+			TTFB:         ttfb.Int64,
+			Total:        total.Int64,
+			ResponseSize: size.Int64,
+			RemoteIP:     remoteIP.String,
+			BodyHash:     bodyHash.String,
+		}
+	}
+	if note.String != "" || color.String != "" {
+		req.Annotation = &repeater.Annotation{Note: note.String, Color: color.String}
+	}
+	return req, nil
+}
+
+// ScanResponse scans a row selected by ResponsesByRequestQuery or
+// GetResponseByIDQuery and opens what c sealed. A body kept in the blob
+// store is left empty, Timing.BodyHash refers to it.
+func ScanResponse(row RowScanner, c *Cipher) (*repeater.Response, error) {
+	resp := &repeater.Response{}
+	var (
+		code                                 sql.NullInt64
+		message, body, bodyHash, remoteIP    sql.NullString
+		headers                              []byte
+		dns, connect, tls, ttfb, total, size sql.NullInt64
+	)
+	err := row.Scan(&resp.ID, &resp.RequestID, &resp.SessionID, &code, &message, &headers, &body, &bodyHash,
+		&dns, &connect, &tls, &ttfb, &total, &size, &remoteIP)
+	if err != nil {
+		return nil, err
+	}
+	if err = c.ScanJSON(headers, &resp.Headers); err != nil {
+		return nil, err
+	}
+	if resp.Body, err = c.Open(body.String); err != nil {
+		return nil, err
+	}
+	resp.Code, resp.Message = int(code.Int64), message.String
+	resp.Timing = repeater.Timing{
+		DNS:          dns.Int64,
+		Connect:      connect.Int64,
+		TLS:          tls.Int64,
+		TTFB:         ttfb.Int64,
+		Total:        total.Int64,
+		ResponseSize: size.Int64,
+		RemoteIP:     remoteIP.String,
+		BodyHash:     bodyHash.String,
+	}
+	return resp, nil
+}
+
+// SearchSQL builds the query behind GET /search out of a select per field
+// of q, newest requests first. fieldSQL gives the expression of a field,
+// match the condition on it, text the expression over the text column of
+// the outer select. The session is bound to $limitParam-1 and the limit
+// to $limitParam: SQLite numbers parameters by their first appearance,
+// so they have to be the last ones.
+func SearchSQL(q *repeater.SearchQuery, fieldSQL map[string]string, match func(expr string) string, text string, limitParam int) string {
+	selects := make([]string, 0, len(q.Fields))
+	for _, field := range q.Fields {
+		from := "requests r"
+		if field == repeater.FieldResponseHeaders || field == repeater.FieldResponseBody {
+			from = "responses resp JOIN requests r ON r.id = resp.request_id"
+		}
+		expr := fieldSQL[field]
+		selects = append(selects, fmt.Sprintf(`SELECT r.id AS id, r.method AS method, coalesce(r.url, r.path, '') AS url, '%s' AS field, %s AS text
+	FROM %s WHERE %s AND r.session_id = $%d`, field, expr, from, match(expr), limitParam-1))
+	}
+	return fmt.Sprintf(`SELECT id, method, url, field, %s FROM (%s
+	ORDER BY id DESC LIMIT $%d) h ORDER BY id DESC;`, text, strings.Join(selects, "\n\tUNION ALL "), limitParam)
+}
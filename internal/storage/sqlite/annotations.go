@@ -0,0 +1,133 @@
+package sqlite
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/iiivan-lemon/technopark_proxy/internal/repeater"
+	"github.com/iiivan-lemon/technopark_proxy/internal/storage"
+	"github.com/pkg/errors"
+)
+
+// scanTags runs a query of storage.ScanTags over requests.
+func (s *Storage) scanTags(requests []repeater.RequestResponse, query string, args ...interface{}) error {
+	if len(requests) == 0 {
+		return nil
+	}
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return errors.Wrap(err, "getting tags error")
+	}
+	defer rows.Close()
+	return errors.Wrap(storage.ScanTags(rows, requests), "getting tags error")
+}
+
+func (s *Storage) SetAnnotation(id int, annotation *repeater.Annotation) (bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, errors.Wrap(err, "annotating error")
+	}
+	defer tx.Rollback()
+
+	if ok, err := requestExists(tx, id); !ok || err != nil {
+		return false, err
+	}
+	if annotation.Note == "" && annotation.Color == "" {
+		_, err = tx.Exec(storage.DeleteAnnotationQuery, id)
+	} else {
+		_, err = tx.Exec(storage.UpsertAnnotationQuery, id, annotation.Note, annotation.Color, time.Now().UTC())
+	}
+	if err != nil {
+		return false, errors.Wrap(err, "annotating error")
+	}
+	if _, err = tx.Exec(storage.ClearTagsQuery, id); err != nil {
+		return false, errors.Wrap(err, "annotating error")
+	}
+	if err = tagRequest(tx, id, annotation.Tags); err != nil {
+		return false, err
+	}
+	return true, errors.Wrap(tx.Commit(), "annotating error")
+}
+
+func (s *Storage) AddTags(id int, tags []string) (bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, errors.Wrap(err, "tagging error")
+	}
+	defer tx.Rollback()
+
+	if ok, err := requestExists(tx, id); !ok || err != nil {
+		return false, err
+	}
+	if err = tagRequest(tx, id, tags); err != nil {
+		return false, err
+	}
+	return true, errors.Wrap(tx.Commit(), "tagging error")
+}
+
+func requestExists(tx *sql.Tx, id int) (bool, error) {
+	var n int
+	if err := tx.QueryRow(storage.CountRequestQuery, id).Scan(&n); err != nil {
+		return false, errors.Wrap(err, "getting request error")
+	}
+	return n > 0, nil
+}
+
+func tagRequest(tx *sql.Tx, id int, tags []string) error {
+	for _, tag := range tags {
+		if _, err := tx.Exec(storage.InsertTagQuery, tag); err != nil {
+			return errors.Wrap(err, "tagging error")
+		}
+		if _, err := tx.Exec(storage.TagRequestQuery, id, tag); err != nil {
+			return errors.Wrap(err, "tagging error")
+		}
+	}
+	return nil
+}
+
+func (s *Storage) RemoveTag(id int, tag string) (bool, error) {
+	res, err := s.db.Exec(storage.UntagRequestQuery, id, tag)
+	if err != nil {
+		return false, errors.Wrap(err, "untagging error")
+	}
+	n, err := res.RowsAffected()
+	return n > 0, errors.Wrap(err, "untagging error")
+}
+
+func (s *Storage) GetTags(sessionID int64) ([]repeater.TagCount, error) {
+	rows, err := s.db.Query(storage.TagsQuery, sessionID)
+	if err != nil {
+		return nil, errors.Wrap(err, "getting tags error")
+	}
+	defer rows.Close()
+
+	tags := make([]repeater.TagCount, 0)
+	for rows.Next() {
+		var tag repeater.TagCount
+		if err = rows.Scan(&tag.Name, &tag.Requests); err != nil {
+			return nil, errors.Wrap(err, "getting tags error")
+		}
+		tags = append(tags, tag)
+	}
+	return tags, errors.Wrap(rows.Err(), "getting tags error")
+}
+
+func (s *Storage) DeleteTag(sessionID int64, tag string) (bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, errors.Wrap(err, "deleting tag error")
+	}
+	defer tx.Rollback()
+
+	var n int
+	if err = tx.QueryRow(storage.CountTagQuery, tag).Scan(&n); err != nil || n == 0 {
+		return false, errors.Wrap(err, "deleting tag error")
+	}
+	if _, err = tx.Exec(storage.UntagSessionQuery, tag, sessionID); err != nil {
+		return false, errors.Wrap(err, "deleting tag error")
+	}
+	if _, err = tx.Exec(storage.DeleteUnusedTagQuery, tag); err != nil {
+		return false, errors.Wrap(err, "deleting tag error")
+	}
+	return true, errors.Wrap(tx.Commit(), "deleting tag error")
+}
@@ -0,0 +1,100 @@
+package sqlite
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/iiivan-lemon/technopark_proxy/internal/repeater"
+	"github.com/iiivan-lemon/technopark_proxy/internal/storage"
+	"github.com/pkg/errors"
+)
+
+func (s *Storage) CreateAttack(attack *repeater.Attack) error {
+	sealed, err := s.cipher.SealAttack(attack)
+	if err != nil {
+		return errors.Wrap(err, "creating attack error")
+	}
+	createdAt := time.Now().UTC()
+	err = s.db.QueryRow(storage.InsertAttackQuery, attack.SessionID, attack.RequestID, attack.Type, sealed.Template,
+		sealed.Payloads, attack.Concurrency, attack.Delay, sealed.Grep, attack.Scheme, attack.Host, attack.Port,
+		attack.Status, attack.Total, createdAt).Scan(&attack.ID)
+	if err != nil {
+		return errors.Wrap(err, "creating attack error")
+	}
+	attack.CreatedAt = createdAt
+	return nil
+}
+
+func (s *Storage) GetAttacks(sessionID int64) ([]repeater.Attack, error) {
+	rows, err := s.db.Query(storage.AttacksQuery, sessionID)
+	if err != nil {
+		return nil, errors.Wrap(err, "getting attacks error")
+	}
+	defer rows.Close()
+
+	attacks := make([]repeater.Attack, 0)
+	for rows.Next() {
+		attack, err := storage.ScanAttack(rows, s.cipher)
+		if err != nil {
+			return nil, errors.Wrap(err, "getting attacks error")
+		}
+		attacks = append(attacks, *attack)
+	}
+	return attacks, errors.Wrap(rows.Err(), "getting attacks error")
+}
+
+func (s *Storage) GetAttack(id int64) (*repeater.Attack, error) {
+	attack, err := storage.ScanAttack(s.db.QueryRow(storage.AttackByIDQuery, id), s.cipher)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return attack, errors.Wrap(err, "getting attack error")
+}
+
+func (s *Storage) UpdateAttack(id int64, status, errText string, finishedAt *time.Time) error {
+	var finished *time.Time
+	if finishedAt != nil {
+		t := finishedAt.UTC()
+		finished = &t
+	}
+	_, err := s.db.Exec(storage.UpdateAttackQuery, status, errText, finished, id)
+	return errors.Wrap(err, "updating attack error")
+}
+
+func (s *Storage) DeleteAttack(id int64) (bool, error) {
+	res, err := s.db.Exec(storage.DeleteAttackQuery, id)
+	if err != nil {
+		return false, errors.Wrap(err, "deleting attack error")
+	}
+	n, err := res.RowsAffected()
+	return n > 0, errors.Wrap(err, "deleting attack error")
+}
+
+func (s *Storage) InsertAttackResult(res *repeater.AttackResult) error {
+	sealed, err := s.cipher.SealAttackResult(res)
+	if err != nil {
+		return errors.Wrap(err, "inserting attack result error")
+	}
+	_, err = s.db.Exec(storage.InsertAttackResultQuery, res.AttackID, res.Index, sealed.Payloads, res.Position,
+		res.RequestID, res.Status, res.Length, res.Duration, sealed.Grep, res.Error, res.StartedAt.UTC())
+	return errors.Wrap(err, "inserting attack result error")
+}
+
+func (s *Storage) GetAttackResults(filter *repeater.AttackResultsFilter) ([]repeater.AttackResult, error) {
+	query, args := storage.AttackResultsQuery(filter)
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "getting attack results error")
+	}
+	defer rows.Close()
+
+	results := make([]repeater.AttackResult, 0)
+	for rows.Next() {
+		res, err := storage.ScanAttackResult(rows, s.cipher)
+		if err != nil {
+			return nil, errors.Wrap(err, "getting attack results error")
+		}
+		results = append(results, *res)
+	}
+	return results, errors.Wrap(rows.Err(), "getting attack results error")
+}
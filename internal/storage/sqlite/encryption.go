@@ -0,0 +1,230 @@
+package sqlite
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/iiivan-lemon/technopark_proxy/internal/storage"
+	"github.com/pkg/errors"
+)
+
+func (s *Storage) EnableEncryption(masterKey []byte) error {
+	c, err := storage.NewCipher(masterKey)
+	if err != nil {
+		return err
+	}
+	if err = s.loadKeys(c); err != nil {
+		return err
+	}
+	if c.Active() == 0 {
+		if err = s.addKey(s.db, c); err != nil {
+			return err
+		}
+	}
+	s.cipher = c
+	s.blobs.SetCipher(c)
+	return nil
+}
+
+func (s *Storage) loadKeys(c *storage.Cipher) error {
+	rows, err := s.db.Query(storage.DataKeysQuery)
+	if err != nil {
+		return errors.Wrap(err, "loading data keys error")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			id      int64
+			wrapped string
+		)
+		if err = rows.Scan(&id, &wrapped); err != nil {
+			return errors.Wrap(err, "loading data keys error")
+		}
+		if err = c.AddKey(id, wrapped); err != nil {
+			return err
+		}
+	}
+	return errors.Wrap(rows.Err(), "loading data keys error")
+}
+
+// querier is either the database or a transaction.
+type querier interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// addKey stores a new data key, which seals from now on.
+func (s *Storage) addKey(q querier, c *storage.Cipher) error {
+	wrapped, err := c.GenerateKey()
+	if err != nil {
+		return err
+	}
+	var id int64
+	if err = q.QueryRow(storage.InsertDataKeyQuery, wrapped, time.Now().UTC()).Scan(&id); err != nil {
+		return errors.Wrap(err, "inserting data key error")
+	}
+	return c.AddKey(id, wrapped)
+}
+
+// RotateKey first wraps the data keys with the new master key, so that
+// from then on only the new one opens the database, then reseals the
+// rows with a new data key and drops the old ones once nothing needs them.
+func (s *Storage) RotateKey(masterKey []byte) (int, error) {
+	if s.cipher == nil {
+		return 0, errors.New("encryption is not enabled")
+	}
+	next, wrapped, err := s.cipher.Rewrap(masterKey)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, errors.Wrap(err, "rewrapping data keys error")
+	}
+	defer tx.Rollback()
+	for id, w := range wrapped {
+		if _, err = tx.Exec(storage.RewrapDataKeyQuery, w, id); err != nil {
+			return 0, errors.Wrap(err, "rewrapping data keys error")
+		}
+	}
+	if err = s.addKey(tx, next); err != nil {
+		return 0, err
+	}
+	if err = tx.Commit(); err != nil {
+		return 0, errors.Wrap(err, "rewrapping data keys error")
+	}
+	s.cipher = next
+	s.blobs.SetCipher(next)
+
+	n, err := s.reseal()
+	return n, errors.Wrap(err, "the data keys are wrapped by the new master key already")
+}
+
+// reseal seals everything with the active data key and drops the others.
+func (s *Storage) reseal() (int, error) {
+	resealed := 0
+	for i := range storage.SealedTables {
+		n, err := s.resealTable(&storage.SealedTables[i])
+		resealed += n
+		if err != nil {
+			return resealed, err
+		}
+	}
+	hashes, err := s.blobHashes()
+	if err != nil {
+		return resealed, err
+	}
+	n, err := s.blobs.Reseal(s.cipher, hashes)
+	resealed += n
+	if err != nil {
+		return resealed, err
+	}
+
+	_, err = s.db.Exec(storage.DeleteDataKeysQuery, s.cipher.Active())
+	return resealed, errors.Wrap(err, "deleting data keys error")
+}
+
+// resealTable reseals the table a page at a time, each in a transaction.
+func (s *Storage) resealTable(t *storage.SealedTable) (int, error) {
+	resealed := 0
+	last := t.FirstKey()
+	for {
+		n, next, err := s.resealPage(t, last)
+		resealed += n
+		if err != nil || next == nil {
+			return resealed, err
+		}
+		last = next
+	}
+}
+
+// resealPage returns the last key of the page, nil after the last page.
+func (s *Storage) resealPage(t *storage.SealedTable, after interface{}) (int, interface{}, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, nil, errors.Wrap(err, "resealing error")
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(t.PageQuery(), after)
+	if err != nil {
+		return 0, nil, errors.Wrapf(err, "resealing %s error", t.Name)
+	}
+	var (
+		keys   []interface{}
+		values [][][]byte
+	)
+	for rows.Next() {
+		key := t.NewKey()
+		row := make([][]byte, len(t.Columns))
+		dest := []interface{}{key}
+		for i := range row {
+			dest = append(dest, &row[i])
+		}
+		if err = rows.Scan(dest...); err != nil {
+			rows.Close()
+			return 0, nil, errors.Wrapf(err, "resealing %s error", t.Name)
+		}
+		keys = append(keys, key)
+		values = append(values, row)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, nil, errors.Wrapf(err, "resealing %s error", t.Name)
+	}
+	if len(keys) == 0 {
+		return 0, nil, nil
+	}
+
+	resealed := 0
+	for i, row := range values {
+		args := make([]interface{}, len(t.Columns)+1)
+		changed := false
+		for j, col := range t.Columns {
+			sealed, ok, err := s.cipher.Reseal(col, row[j])
+			if err != nil {
+				return 0, nil, errors.Wrapf(err, "resealing %s error", t.Name)
+			}
+			if ok {
+				row[j], changed = []byte(sealed), true
+			}
+			switch {
+			case row[j] == nil:
+				args[j] = nil
+			case col.Kind == storage.BytesColumn:
+				args[j] = row[j]
+			default:
+				args[j] = string(row[j])
+			}
+		}
+		if !changed {
+			continue
+		}
+		args[len(t.Columns)] = keys[i]
+		if _, err = tx.Exec(t.UpdateQuery(), args...); err != nil {
+			return 0, nil, errors.Wrapf(err, "resealing %s error", t.Name)
+		}
+		resealed++
+	}
+	if err = tx.Commit(); err != nil {
+		return 0, nil, errors.Wrapf(err, "resealing %s error", t.Name)
+	}
+	return resealed, keys[len(keys)-1], nil
+}
+
+func (s *Storage) blobHashes() ([]string, error) {
+	rows, err := s.db.Query(`SELECT hash FROM blobs;`)
+	if err != nil {
+		return nil, errors.Wrap(err, "listing blobs error")
+	}
+	defer rows.Close()
+	var hashes []string
+	for rows.Next() {
+		var hash string
+		if err = rows.Scan(&hash); err != nil {
+			return nil, errors.Wrap(err, "listing blobs error")
+		}
+		hashes = append(hashes, hash)
+	}
+	return hashes, errors.Wrap(rows.Err(), "listing blobs error")
+}
@@ -0,0 +1,92 @@
+package sqlite
+
+import (
+	"bytes"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	proxyserver "github.com/iiivan-lemon/technopark_proxy/internal/proxyServer"
+	"github.com/iiivan-lemon/technopark_proxy/internal/storage"
+)
+
+func newEncryptedStorage(t *testing.T) *Storage {
+	s, err := NewStorage(filepath.Join(t.TempDir(), "proxy.db"), &storage.Blobs{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	runner, err := s.Migrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = runner.Up(); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.EnableEncryption(bytes.Repeat([]byte{1}, 32)); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// storedTunnels returns the payload columns of the tunnels as they are stored.
+func storedTunnels(t *testing.T, s *Storage) []proxyserver.Tunnel {
+	rows, err := s.db.Query(`SELECT client_payload, server_payload FROM tunnels ORDER BY id;`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var res []proxyserver.Tunnel
+	for rows.Next() {
+		var tunnel proxyserver.Tunnel
+		if err = rows.Scan(&tunnel.ClientPayload, &tunnel.ServerPayload); err != nil {
+			t.Fatal(err)
+		}
+		res = append(res, tunnel)
+	}
+	if err = rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func TestTunnelPayloadsSealed(t *testing.T) {
+	s := newEncryptedStorage(t)
+	tunnel := proxyserver.Tunnel{
+		SessionID:     1,
+		Host:          "example.com:5222",
+		ClientPayload: []byte("<stream:stream to='example.com'>"),
+		ServerPayload: []byte{0, 1, 0xff},
+		StartedAt:     time.Now(),
+	}
+	if err := s.InsertTunnel(&tunnel); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.InsertBatch(nil, []proxyserver.Tunnel{tunnel}); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(stage string) {
+		// sealed by the active data key
+		prefix := "enc:v1:" + strconv.FormatInt(s.cipher.Active(), 10) + ":"
+		for _, stored := range storedTunnels(t, s) {
+			if !strings.HasPrefix(string(stored.ClientPayload), prefix) || !strings.HasPrefix(string(stored.ServerPayload), prefix) {
+				t.Errorf("%s: payloads not sealed by the active data key: %q, %q", stage, stored.ClientPayload, stored.ServerPayload)
+			}
+			if err := s.cipher.OpenTunnel(&stored); err != nil {
+				t.Fatalf("%s: %v", stage, err)
+			}
+			if !bytes.Equal(stored.ClientPayload, tunnel.ClientPayload) || !bytes.Equal(stored.ServerPayload, tunnel.ServerPayload) {
+				t.Errorf("%s: opened payloads = %q, %q", stage, stored.ClientPayload, stored.ServerPayload)
+			}
+		}
+	}
+	check("inserted")
+
+	if _, err := s.RotateKey(bytes.Repeat([]byte{2}, 32)); err != nil {
+		t.Fatal(err)
+	}
+	check("rotated")
+}
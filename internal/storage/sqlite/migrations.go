@@ -0,0 +1,76 @@
+package sqlite
+
+import (
+	"database/sql"
+	"embed"
+
+	"github.com/iiivan-lemon/technopark_proxy/internal/storage/migrate"
+)
+
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+const (
+	createVersionTable = `create table if not exists schema_migrations(
+    version integer primary key,
+    name text not null,
+    applied_at timestamp not null default current_timestamp
+);`
+	selectVersions = `SELECT version FROM schema_migrations ORDER BY version;`
+	insertVersion  = `INSERT INTO schema_migrations(version, name) VALUES($1, $2);`
+	deleteVersion  = `DELETE FROM schema_migrations WHERE version = $1;`
+)
+
+// Migrations returns the runner of the embedded schema migrations.
+func (s *Storage) Migrations() (*migrate.Runner, error) {
+	return migrate.NewRunner(migrationDriver{db: s.db}, migrations, "migrations")
+}
+
+type migrationDriver struct {
+	db *sql.DB
+}
+
+func (d migrationDriver) Applied() ([]int, error) {
+	if _, err := d.db.Exec(createVersionTable); err != nil {
+		return nil, err
+	}
+	rows, err := d.db.Query(selectVersions)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var versions []int
+	for rows.Next() {
+		var version int
+		if err = rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		versions = append(versions, version)
+	}
+	return versions, rows.Err()
+}
+
+func (d migrationDriver) Apply(m *migrate.Migration, up bool) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if up {
+		_, err = tx.Exec(m.Up)
+		if err == nil {
+			_, err = tx.Exec(insertVersion, m.Version, m.Name)
+		}
+	} else {
+		_, err = tx.Exec(m.Down)
+		if err == nil {
+			_, err = tx.Exec(deleteVersion, m.Version)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
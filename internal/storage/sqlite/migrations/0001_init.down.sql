@@ -0,0 +1,3 @@
+drop table if exists tunnels;
+drop table if exists responses;
+drop table if exists requests;
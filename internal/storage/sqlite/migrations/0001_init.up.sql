@@ -0,0 +1,41 @@
+create table if not exists requests(
+    id integer primary key autoincrement,
+    method text,
+    path text,
+    get_params text,
+    headers text,
+    cookies text,
+    post_params text,
+    raw text,
+    is_https boolean default false,
+    started_at timestamp,
+    size integer
+);
+create index if not exists requests_started_at_idx on requests(started_at);
+create table if not exists responses(
+    id integer primary key autoincrement,
+    request_id integer references requests(id) on delete cascade,
+    code integer,
+    message text,
+    headers text,
+    body text,
+    size integer,
+    dns_us integer,
+    connect_us integer,
+    tls_us integer,
+    ttfb_us integer,
+    total_us integer,
+    remote_ip text
+);
+create index if not exists responses_request_id_idx on responses(request_id);
+create table if not exists tunnels(
+    id integer primary key autoincrement,
+    host text,
+    client_addr text,
+    bytes_sent integer,
+    bytes_received integer,
+    client_payload blob,
+    server_payload blob,
+    started_at timestamp,
+    duration_ms integer
+);
@@ -0,0 +1,11 @@
+drop index if exists requests_client_addr_idx;
+drop index if exists requests_url_idx;
+drop index if exists requests_scheme_idx;
+drop index if exists requests_host_port_idx;
+alter table requests drop column proto;
+alter table requests drop column client_addr;
+alter table requests drop column query;
+alter table requests drop column url;
+alter table requests drop column port;
+alter table requests drop column host;
+alter table requests drop column scheme;
@@ -0,0 +1,25 @@
+alter table requests add column scheme text;
+alter table requests add column host text;
+alter table requests add column port integer;
+alter table requests add column url text;
+alter table requests add column query text;
+alter table requests add column client_addr text;
+alter table requests add column proto text;
+
+-- older rows only have the Host header and is_https to go by
+update requests set
+    scheme = case when is_https then 'https' else 'http' end,
+    host = case when instr(json_extract(headers, '$.Host'), ':') > 0
+        then substr(json_extract(headers, '$.Host'), 1, instr(json_extract(headers, '$.Host'), ':') - 1)
+        else json_extract(headers, '$.Host') end,
+    port = case when instr(json_extract(headers, '$.Host'), ':') > 0
+        then cast(substr(json_extract(headers, '$.Host'), instr(json_extract(headers, '$.Host'), ':') + 1) as integer)
+        else case when is_https then 443 else 80 end end
+where scheme is null;
+update requests set url = scheme || '://' || json_extract(headers, '$.Host') || coalesce(path, '')
+where url is null and json_extract(headers, '$.Host') is not null;
+
+create index requests_host_port_idx on requests(host, port);
+create index requests_scheme_idx on requests(scheme);
+create index requests_url_idx on requests(url);
+create index requests_client_addr_idx on requests(client_addr);
@@ -0,0 +1,4 @@
+drop trigger if exists responses_release_blob;
+drop index if exists responses_body_hash_idx;
+alter table responses drop column body_hash;
+drop table if exists blobs;
@@ -0,0 +1,17 @@
+create table blobs(
+    hash text primary key,
+    size integer,
+    refs integer not null default 0,
+    -- null when the data is kept on the filesystem
+    data blob
+);
+create index blobs_refs_idx on blobs(refs) where refs <= 0;
+
+alter table responses add column body_hash text;
+create index responses_body_hash_idx on responses(body_hash);
+
+create trigger responses_release_blob after delete on responses
+when old.body_hash is not null
+begin
+    update blobs set refs = refs - 1 where hash = old.body_hash;
+end;
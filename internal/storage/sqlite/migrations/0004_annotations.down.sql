@@ -0,0 +1,3 @@
+drop table if exists annotations;
+drop table if exists request_tags;
+drop table if exists tags;
@@ -0,0 +1,17 @@
+create table tags(
+    id integer primary key autoincrement,
+    name text not null unique
+);
+create table request_tags(
+    request_id integer not null references requests(id) on delete cascade,
+    tag_id integer not null references tags(id) on delete cascade,
+    primary key (request_id, tag_id)
+);
+create index request_tags_tag_id_idx on request_tags(tag_id);
+
+create table annotations(
+    request_id integer primary key references requests(id) on delete cascade,
+    note text not null default '',
+    color text not null default '',
+    updated_at timestamp not null default current_timestamp
+);
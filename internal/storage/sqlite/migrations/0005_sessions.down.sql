@@ -0,0 +1,5 @@
+drop index if exists tunnels_session_id_idx;
+alter table tunnels drop column session_id;
+drop index if exists requests_session_id_idx;
+alter table requests drop column session_id;
+drop table if exists sessions;
@@ -0,0 +1,23 @@
+create table sessions(
+    id integer primary key autoincrement,
+    name text not null unique,
+    description text not null default '',
+    -- repeater.Scope as json
+    scope text not null default '{}',
+    active boolean not null default false,
+    created_at timestamp not null default current_timestamp,
+    archived_at timestamp
+);
+create unique index sessions_active_idx on sessions(active) where active;
+
+-- the history recorded so far goes to the default session
+insert into sessions(id, name, active) values (1, 'default', true);
+
+-- a column added with a foreign key has to default to null
+alter table requests add column session_id integer references sessions(id);
+update requests set session_id = 1;
+create index requests_session_id_idx on requests(session_id, id);
+
+alter table tunnels add column session_id integer references sessions(id);
+update tunnels set session_id = 1;
+create index tunnels_session_id_idx on tunnels(session_id);
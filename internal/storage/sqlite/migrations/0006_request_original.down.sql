@@ -0,0 +1 @@
+alter table requests drop column original;
@@ -0,0 +1 @@
+alter table requests add column original text;
@@ -0,0 +1,6 @@
+-- data keys sealing the captured traffic, wrapped by the master key
+create table data_keys(
+    id integer primary key autoincrement,
+    wrapped text not null,
+    created_at timestamp not null default current_timestamp
+);
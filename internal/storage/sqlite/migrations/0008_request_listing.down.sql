@@ -0,0 +1,6 @@
+drop index if exists responses_request_id_id_idx;
+drop index if exists requests_session_host_idx;
+drop index if exists requests_session_method_idx;
+drop index if exists requests_session_size_idx;
+drop index if exists requests_session_started_at_idx;
+alter table responses drop column content_type;
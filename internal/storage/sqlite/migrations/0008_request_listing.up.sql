@@ -0,0 +1,22 @@
+-- media type of the response, GET /requests filters on it as headers may be sealed
+alter table responses add column content_type text;
+update responses set content_type = lower(trim(
+        CASE WHEN instr(v, ';') > 0 THEN substr(v, 1, instr(v, ';') - 1) ELSE v END))
+    FROM (SELECT id AS rid, CASE json_type(headers, '$."Content-Type"')
+            WHEN 'text' THEN json_extract(headers, '$."Content-Type"')
+            WHEN 'array' THEN json_extract(headers, '$."Content-Type"[0]')
+        END AS v FROM responses WHERE json_valid(headers))
+    WHERE id = rid AND v IS NOT NULL;
+
+-- rows recorded before the columns existed are listed as 0,
+-- the pages of GET /requests are keyed on the same values
+update requests set size = 0 where size is null;
+update responses set size = coalesce(size, 0), ttfb_us = coalesce(ttfb_us, 0), total_us = coalesce(total_us, 0)
+    where size is null or ttfb_us is null or total_us is null;
+
+create index requests_session_started_at_idx on requests(session_id, started_at, id);
+create index requests_session_size_idx on requests(session_id, size, id);
+create index requests_session_method_idx on requests(session_id, method);
+create index requests_session_host_idx on requests(session_id, host);
+-- the latest response of a request without a table lookup
+create index responses_request_id_id_idx on responses(request_id, id);
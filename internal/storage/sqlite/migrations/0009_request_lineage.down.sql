@@ -0,0 +1,3 @@
+drop index if exists requests_parent_id_idx;
+alter table requests drop column source;
+alter table requests drop column parent_id;
@@ -0,0 +1,5 @@
+-- the request a repeat was made from, a repeat outlives it
+alter table requests add column parent_id integer references requests(id) on delete set null;
+-- what sent the request: proxy, repeater or scanner
+alter table requests add column source text not null default 'proxy' check (source in ('proxy', 'repeater', 'scanner'));
+create index requests_parent_id_idx on requests(parent_id);
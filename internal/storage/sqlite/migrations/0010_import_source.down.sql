@@ -0,0 +1,5 @@
+-- imported requests are kept as recorded by the proxy
+alter table requests add column source_old text not null default 'proxy' check (source_old in ('proxy', 'repeater', 'scanner'));
+update requests set source_old = case when source = 'import' then 'proxy' else source end;
+alter table requests drop column source;
+alter table requests rename column source_old to source;
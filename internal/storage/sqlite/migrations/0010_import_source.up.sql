@@ -0,0 +1,6 @@
+-- requests imported from HAR, curl commands and .http files;
+-- a check constraint cannot be altered, so the column is rebuilt
+alter table requests add column source_new text not null default 'proxy' check (source_new in ('proxy', 'repeater', 'scanner', 'import'));
+update requests set source_new = source;
+alter table requests drop column source;
+alter table requests rename column source_new to source;
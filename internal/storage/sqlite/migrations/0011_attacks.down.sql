@@ -0,0 +1,2 @@
+drop table attack_results;
+drop table attacks;
@@ -0,0 +1,43 @@
+-- repeater.Attack, its template and payloads are sealed when the storage is encrypted
+create table attacks(
+    id integer primary key autoincrement,
+    session_id integer not null references sessions(id),
+    -- the request the attack is built on, an attack outlives it
+    request_id integer references requests(id) on delete set null,
+    type text not null check (type in ('sniper', 'battering-ram', 'pitchfork', 'cluster-bomb')),
+    template text not null,
+    -- the repeater.PayloadSet list as json
+    payloads text not null,
+    concurrency integer not null,
+    delay integer not null default 0,
+    -- regular expressions as a json list
+    grep text not null default '[]',
+    scheme text not null,
+    host text not null,
+    port integer not null,
+    status text not null,
+    total integer not null,
+    error text not null default '',
+    created_at timestamp not null,
+    finished_at timestamp
+);
+create index attacks_session_id_idx on attacks(session_id, id);
+
+-- repeater.AttackResult, the payloads are sealed when the storage is encrypted
+create table attack_results(
+    id integer primary key autoincrement,
+    attack_id integer not null references attacks(id) on delete cascade,
+    idx integer not null,
+    payloads text not null,
+    position integer,
+    request_id integer references requests(id) on delete set null,
+    status integer not null,
+    length integer not null,
+    duration_us integer not null,
+    -- a json list of booleans, one per regular expression
+    grep text not null,
+    error text not null default '',
+    started_at timestamp not null,
+    unique (attack_id, idx)
+);
+create index attack_results_request_id_idx on attack_results(request_id);
@@ -0,0 +1,111 @@
+package sqlite
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/iiivan-lemon/technopark_proxy/internal/repeater"
+	"github.com/iiivan-lemon/technopark_proxy/internal/storage"
+	sqlite3 "github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
+)
+
+// driverName is go-sqlite3 with a regexp function behind the REGEXP operator.
+const driverName = "sqlite3_regexp"
+
+func init() {
+	var (
+		mu   sync.Mutex
+		last *regexp.Regexp
+	)
+	sql.Register(driverName, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			return conn.RegisterFunc("regexp", func(pattern, s string) (bool, error) {
+				// a query calls it with the same pattern for every row
+				mu.Lock()
+				re := last
+				if re == nil || re.String() != pattern {
+					var err error
+					if re, err = regexp.Compile(pattern); err != nil {
+						mu.Unlock()
+						return false, err
+					}
+					last = re
+				}
+				mu.Unlock()
+				return re.MatchString(s), nil
+			}, true)
+		},
+	})
+}
+
+// searchFields are the expressions of repeater.SearchFields.
+var searchFields = map[string]string{
+	repeater.FieldURL:             `coalesce(r.url, r.path, '')`,
+	repeater.FieldRequestHeaders:  `coalesce(r.headers, '') || ' ' || coalesce(r.cookies, '')`,
+	repeater.FieldRequestBody:     `CASE WHEN instr(r.raw, char(13, 10, 13, 10)) > 0 THEN substr(r.raw, instr(r.raw, char(13, 10, 13, 10)) + 4) ELSE '' END`,
+	repeater.FieldResponseHeaders: `coalesce(resp.headers, '')`,
+	repeater.FieldResponseBody:    `coalesce(resp.body, '')`,
+}
+
+// Search has no full-text index to use: words are looked up as
+// substrings, in any case, and snippets are made here.
+// Bodies kept in the blob store are not searched.
+func (s *Storage) Search(q *repeater.SearchQuery) ([]repeater.SearchHit, error) {
+	if s.cipher != nil {
+		rows, err := s.db.Query(storage.SealedSearchQuery, q.SessionID)
+		if err != nil {
+			return nil, errors.Wrap(err, "search error")
+		}
+		defer rows.Close()
+		return storage.SearchSealed(q, rows, s.cipher)
+	}
+
+	var (
+		args  []interface{}
+		match func(expr string) string
+	)
+	if q.Regex != nil {
+		args = append(args, q.Regex.String())
+		match = func(expr string) string {
+			return expr + " REGEXP $1"
+		}
+	} else {
+		terms := q.Terms()
+		for _, term := range terms {
+			args = append(args, term)
+		}
+		match = func(expr string) string {
+			conds := make([]string, len(terms))
+			for i := range terms {
+				conds[i] = fmt.Sprintf("instr(lower(%s), $%d) > 0", expr, i+1)
+			}
+			return strings.Join(conds, " AND ")
+		}
+	}
+	args = append(args, q.SessionID, q.Limit)
+	query := storage.SearchSQL(q, searchFields, match, "text", len(args))
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "search error")
+	}
+	defer rows.Close()
+
+	hits := make([]repeater.SearchHit, 0)
+	for rows.Next() {
+		var (
+			hit  repeater.SearchHit
+			text string
+		)
+		if err = rows.Scan(&hit.RequestID, &hit.Method, &hit.URL, &hit.Field, &text); err != nil {
+			return nil, errors.Wrap(err, "search error")
+		}
+		hit.Snippet, _ = q.Match(text)
+		hits = append(hits, hit)
+	}
+	return hits, errors.Wrap(rows.Err(), "search error")
+}
@@ -0,0 +1,125 @@
+package sqlite
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/iiivan-lemon/technopark_proxy/internal/repeater"
+	"github.com/iiivan-lemon/technopark_proxy/internal/storage"
+	"github.com/pkg/errors"
+)
+
+func (s *Storage) CreateSession(session *repeater.Session) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return errors.Wrap(err, "creating session error")
+	}
+	defer tx.Rollback()
+
+	if err = checkSessionName(tx, session); err != nil {
+		return err
+	}
+	createdAt := time.Now().UTC()
+	err = tx.QueryRow(storage.InsertSessionQuery, session.Name, session.Description, session.Scope.Text(), createdAt).Scan(&session.ID)
+	if err != nil {
+		return errors.Wrap(err, "creating session error")
+	}
+	if err = tx.Commit(); err != nil {
+		return errors.Wrap(err, "creating session error")
+	}
+	session.CreatedAt = createdAt
+	return nil
+}
+
+// checkSessionName returns repeater.ErrSessionExists when another
+// session has the name of session.
+func checkSessionName(tx *sql.Tx, session *repeater.Session) error {
+	var n int
+	if err := tx.QueryRow(storage.CountSessionNameQuery, session.Name, session.ID).Scan(&n); err != nil {
+		return errors.Wrap(err, "checking session name error")
+	}
+	if n > 0 {
+		return repeater.ErrSessionExists
+	}
+	return nil
+}
+
+func (s *Storage) GetSessions() ([]repeater.Session, error) {
+	rows, err := s.db.Query(storage.SessionsQuery)
+	if err != nil {
+		return nil, errors.Wrap(err, "getting sessions error")
+	}
+	defer rows.Close()
+
+	sessions := make([]repeater.Session, 0)
+	for rows.Next() {
+		session, err := storage.ScanSession(rows)
+		if err != nil {
+			return nil, errors.Wrap(err, "getting sessions error")
+		}
+		sessions = append(sessions, *session)
+	}
+	return sessions, errors.Wrap(rows.Err(), "getting sessions error")
+}
+
+func (s *Storage) GetSession(id int64) (*repeater.Session, error) {
+	session, err := storage.ScanSession(s.db.QueryRow(storage.SessionByIDQuery, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return session, errors.Wrap(err, "getting session error")
+}
+
+func (s *Storage) ActiveSession() (*repeater.Session, error) {
+	session, err := storage.ScanSession(s.db.QueryRow(storage.ActiveSessionQuery))
+	return session, errors.Wrap(err, "getting active session error")
+}
+
+func (s *Storage) UpdateSession(session *repeater.Session) (bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, errors.Wrap(err, "updating session error")
+	}
+	defer tx.Rollback()
+
+	if err = checkSessionName(tx, session); err != nil {
+		return false, err
+	}
+	res, err := tx.Exec(storage.UpdateSessionQuery, session.Name, session.Description, session.Scope.Text(), session.ID)
+	if err != nil {
+		return false, errors.Wrap(err, "updating session error")
+	}
+	if n, err := res.RowsAffected(); n == 0 || err != nil {
+		return false, errors.Wrap(err, "updating session error")
+	}
+	return true, errors.Wrap(tx.Commit(), "updating session error")
+}
+
+func (s *Storage) ActivateSession(id int64) (bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, errors.Wrap(err, "activating session error")
+	}
+	defer tx.Rollback()
+
+	var n int
+	if err = tx.QueryRow(storage.CountSessionQuery, id).Scan(&n); err != nil || n == 0 {
+		return false, errors.Wrap(err, "activating session error")
+	}
+	if _, err = tx.Exec(storage.DeactivateQuery); err != nil {
+		return false, errors.Wrap(err, "activating session error")
+	}
+	if _, err = tx.Exec(storage.ActivateSessionQuery, id); err != nil {
+		return false, errors.Wrap(err, "activating session error")
+	}
+	return true, errors.Wrap(tx.Commit(), "activating session error")
+}
+
+func (s *Storage) ArchiveSession(id int64) (bool, error) {
+	res, err := s.db.Exec(storage.ArchiveSessionQuery, time.Now().UTC(), id)
+	if err != nil {
+		return false, errors.Wrap(err, "archiving session error")
+	}
+	n, err := res.RowsAffected()
+	return n > 0, errors.Wrap(err, "archiving session error")
+}
@@ -0,0 +1,401 @@
+// Package sqlite is the storage backend keeping everything in a single
+// SQLite file, no database server is needed.
+package sqlite
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	proxyserver "github.com/iiivan-lemon/technopark_proxy/internal/proxyServer"
+	"github.com/iiivan-lemon/technopark_proxy/internal/repeater"
+	"github.com/iiivan-lemon/technopark_proxy/internal/storage"
+	"github.com/pkg/errors"
+)
+
+type Storage struct {
+	db    *sql.DB
+	blobs *storage.Blobs
+	// nil until EnableEncryption
+	cipher *storage.Cipher
+}
+
+// NewStorage opens (and creates if needed) the database file at path.
+// The schema is created by Migrations.
+func NewStorage(path string, blobs *storage.Blobs) (*Storage, error) {
+	db, err := sql.Open(driverName, "file:"+path+"?_foreign_keys=on&_journal_mode=WAL&_busy_timeout=5000&_case_sensitive_like=on")
+	if err != nil {
+		return nil, errors.Wrap(err, "opening sqlite database")
+	}
+	return &Storage{
+		db:    db,
+		blobs: blobs,
+	}, nil
+}
+
+func (s *Storage) Close() error {
+	return s.db.Close()
+}
+
+func (s *Storage) InsertRequest(req *proxyserver.Request) (uint, error) {
+	var id uint
+	sealed, err := s.cipher.SealRequest(req)
+	if err != nil {
+		return id, err
+	}
+	source, parentID := storage.Lineage(req)
+	err = s.db.QueryRow(storage.InsertRequestQuery, req.Method, req.Path, jsonText(req.GetParams), sealed.Headers, sealed.Cookies,
+		jsonText(req.PostParams), sealed.Raw, req.IsHTTPS, req.StartedAt.UTC(), req.Size,
+		req.Scheme, req.Host, req.Port, req.URL, req.Query, req.ClientAddr, req.Proto, req.SessionID, req.Original, source, parentID).Scan(&id)
+	if err != nil {
+		return id, errors.Wrap(err, "inserting request error")
+	}
+	return id, nil
+}
+
+func (s *Storage) InsertResponse(reqID uint, resp *proxyserver.Response) error {
+	defer s.blobs.Hold()()
+	tx, err := s.db.Begin()
+	if err != nil {
+		return errors.Wrap(err, "inserting response error")
+	}
+	defer tx.Rollback()
+
+	blob, err := s.insertResponse(tx, int64(reqID), resp)
+	if err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return errors.Wrap(err, "inserting response error")
+	}
+	return s.blobs.Write([]*storage.Blob{blob})
+}
+
+// insertResponse moves a large body to the blob store and stores resp.
+// It returns the blob of the body, its file is written after the commit.
+func (s *Storage) insertResponse(tx *sql.Tx, reqID int64, resp *proxyserver.Response) (*storage.Blob, error) {
+	body, blob, err := s.blobs.Split(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	headers, err := s.cipher.SealJSON(resp.Headers)
+	if err != nil {
+		return nil, err
+	}
+	if body, err = s.cipher.Seal(body); err != nil {
+		return nil, err
+	}
+	var bodyHash interface{}
+	if blob != nil {
+		if _, err = tx.Exec(storage.UpsertBlobQuery, blob.Hash, blob.Size, 1, blob.Column()); err != nil {
+			return nil, errors.Wrap(err, "inserting blob error")
+		}
+		bodyHash = blob.Hash
+	}
+
+	t := resp.Timing
+	_, err = tx.Exec(storage.InsertResponseQuery, reqID, resp.Code, resp.Message, headers, body, resp.Size,
+		t.DNS.Microseconds(), t.Connect.Microseconds(), t.TLS.Microseconds(), t.TTFB.Microseconds(), t.Total.Microseconds(), resp.RemoteIP, bodyHash, resp.ContentType())
+	if err != nil {
+		return nil, errors.Wrap(err, "inserting response error")
+	}
+	return blob, nil
+}
+
+func (s *Storage) InsertTunnel(tunnel *proxyserver.Tunnel) error {
+	sealed, err := s.cipher.SealTunnel(tunnel)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(storage.InsertTunnelQuery, tunnel.Host, tunnel.ClientAddr, tunnel.BytesSent, tunnel.BytesReceived,
+		sealed.ClientPayload, sealed.ServerPayload, tunnel.StartedAt.UTC(), tunnel.Duration.Milliseconds(), tunnel.SessionID)
+	if err != nil {
+		return errors.Wrap(err, "inserting tunnel error")
+	}
+	return nil
+}
+
+// InsertBatch runs the inserts through prepared statements in a single
+// transaction, which is what makes SQLite fast at it.
+func (s *Storage) InsertBatch(exchanges []proxyserver.Exchange, tunnels []proxyserver.Tunnel) error {
+	defer s.blobs.Hold()()
+	tx, err := s.db.Begin()
+	if err != nil {
+		return errors.Wrap(err, "begin batch error")
+	}
+	defer tx.Rollback()
+
+	insertRequest, err := tx.Prepare(storage.InsertRequestQuery)
+	if err != nil {
+		return errors.Wrap(err, "prepare batch error")
+	}
+	defer insertRequest.Close()
+	insertTunnel, err := tx.Prepare(storage.InsertTunnelQuery)
+	if err != nil {
+		return errors.Wrap(err, "prepare batch error")
+	}
+	defer insertTunnel.Close()
+
+	var blobs []*storage.Blob
+	for _, ex := range exchanges {
+		req := ex.Request
+		sealed, err := s.cipher.SealRequest(req)
+		if err != nil {
+			return err
+		}
+		var id int64
+		source, parentID := storage.Lineage(req)
+		err = insertRequest.QueryRow(req.Method, req.Path, jsonText(req.GetParams), sealed.Headers, sealed.Cookies,
+			jsonText(req.PostParams), sealed.Raw, req.IsHTTPS, req.StartedAt.UTC(), req.Size,
+			req.Scheme, req.Host, req.Port, req.URL, req.Query, req.ClientAddr, req.Proto, req.SessionID, req.Original, source, parentID).Scan(&id)
+		if err != nil {
+			return errors.Wrap(err, "inserting request error")
+		}
+		if ex.Response != nil {
+			blob, err := s.insertResponse(tx, id, ex.Response)
+			if err != nil {
+				return err
+			}
+			blobs = append(blobs, blob)
+		}
+	}
+	for i := range tunnels {
+		t := &tunnels[i]
+		sealed, err := s.cipher.SealTunnel(t)
+		if err != nil {
+			return err
+		}
+		_, err = insertTunnel.Exec(t.Host, t.ClientAddr, t.BytesSent, t.BytesReceived,
+			sealed.ClientPayload, sealed.ServerPayload, t.StartedAt.UTC(), t.Duration.Milliseconds(), t.SessionID)
+		if err != nil {
+			return errors.Wrap(err, "inserting tunnel error")
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return errors.Wrap(err, "commit batch error")
+	}
+	return s.blobs.Write(blobs)
+}
+
+func (s *Storage) GetAllRequests(filter *repeater.RequestsFilter) ([]repeater.RequestResponse, error) {
+	query, args := storage.RequestsQuery(filter)
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := make([]repeater.RequestResponse, 0)
+	for rows.Next() {
+		req, err := storage.ScanRequest(rows, s.cipher)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, *req)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	if len(res) == 0 || !filter.Wants("annotation") {
+		return res, nil
+	}
+	query, args = storage.RequestTagsQuery(filter)
+	return res, s.scanTags(res, query, args...)
+}
+
+func (s *Storage) GetRequestByID(id int) (*repeater.RequestResponse, error) {
+	req, err := storage.ScanRequest(s.db.QueryRow(storage.GetRequestByIDQuery, id), s.cipher)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	res := []repeater.RequestResponse{*req}
+	return &res[0], s.scanTags(res, storage.RequestTagsByIDQuery, id)
+}
+
+func (s *Storage) GetRepeats(id int) ([]repeater.RequestResponse, error) {
+	rows, err := s.db.Query(storage.RepeatsQuery, id)
+	if err != nil {
+		return nil, errors.Wrap(err, "getting repeats error")
+	}
+	defer rows.Close()
+
+	res := make([]repeater.RequestResponse, 0)
+	for rows.Next() {
+		req, err := storage.ScanRequest(rows, s.cipher)
+		if err != nil {
+			return nil, errors.Wrap(err, "getting repeats error")
+		}
+		res = append(res, *req)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "getting repeats error")
+	}
+	rows.Close()
+	return res, s.scanTags(res, storage.RepeatsTagsQuery, id)
+}
+
+func (s *Storage) GetResponses(requestID int) ([]repeater.Response, error) {
+	return s.queryResponses(storage.ResponsesByRequestQuery, requestID)
+}
+
+func (s *Storage) GetResponsesOf(requestIDs []int64) ([]repeater.Response, error) {
+	if len(requestIDs) == 0 {
+		return []repeater.Response{}, nil
+	}
+	query, args := storage.ResponsesOfQuery(requestIDs)
+	return s.queryResponses(query, args...)
+}
+
+// queryResponses reads the responses selected by query with their bodies.
+func (s *Storage) queryResponses(query string, args ...interface{}) ([]repeater.Response, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "getting responses error")
+	}
+	defer rows.Close()
+
+	res := make([]repeater.Response, 0)
+	for rows.Next() {
+		resp, err := storage.ScanResponse(rows, s.cipher)
+		if err != nil {
+			return nil, errors.Wrap(err, "getting responses error")
+		}
+		res = append(res, *resp)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "getting responses error")
+	}
+	rows.Close()
+
+	for i := range res {
+		if err = s.blobBody(&res[i]); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+func (s *Storage) GetResponse(id int) (*repeater.Response, error) {
+	resp, err := storage.ScanResponse(s.db.QueryRow(storage.GetResponseByIDQuery, id), s.cipher)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "getting response error")
+	}
+	return resp, s.blobBody(resp)
+}
+
+// blobBody reads the body of resp kept in the blob store.
+func (s *Storage) blobBody(resp *repeater.Response) error {
+	if resp.Timing.BodyHash == "" {
+		return nil
+	}
+	data, err := s.GetBlob(resp.Timing.BodyHash)
+	if err != nil {
+		return err
+	}
+	resp.Body = string(data)
+	return nil
+}
+
+func (s *Storage) GetBlob(hash string) ([]byte, error) {
+	var data []byte
+	err := s.db.QueryRow(storage.GetBlobQuery, hash).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "getting blob error")
+	}
+	return s.blobs.Get(hash, data)
+}
+
+func (s *Storage) CollectBlobs() (int, error) {
+	return s.blobs.Collect(s.collectBlobs)
+}
+
+// collectBlobs deletes the rows of the blobs no response refers to.
+func (s *Storage) collectBlobs() ([]string, error) {
+	rows, err := s.db.Query(storage.CollectBlobsQuery)
+	if err != nil {
+		return nil, errors.Wrap(err, "collecting blobs error")
+	}
+	defer rows.Close()
+
+	var hashes []string
+	for rows.Next() {
+		var hash string
+		if err = rows.Scan(&hash); err != nil {
+			return nil, errors.Wrap(err, "collecting blobs error")
+		}
+		hashes = append(hashes, hash)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "collecting blobs error")
+	}
+	return hashes, nil
+}
+
+// jsonText encodes the maps kept in json columns, SQLite has no json type.
+func jsonText(m map[string]interface{}) string {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return "{}"
+	}
+	return string(b)
+}
+
+func (s *Storage) DeleteRequest(id int) (bool, error) {
+	n, err := s.deleteAndCollect(storage.Query{SQL: storage.DeleteRequestQuery, Args: []interface{}{id}})
+	return n > 0, err
+}
+
+func (s *Storage) DeleteRequests(filter *repeater.RequestsFilter) (int, error) {
+	query, args := storage.DeleteRequestsQuery(filter)
+	return s.deleteAndCollect(storage.Query{SQL: query, Args: args})
+}
+
+func (s *Storage) Prune(retention *storage.Retention) (int, error) {
+	return s.deleteAndCollect(retention.Queries(time.Now())...)
+}
+
+// deleteAndCollect runs the deletes in one transaction, then drops the
+// blobs they left without references.
+func (s *Storage) deleteAndCollect(queries ...storage.Query) (int, error) {
+	if len(queries) == 0 {
+		return 0, nil
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, errors.Wrap(err, "deleting error")
+	}
+	defer tx.Rollback()
+
+	deleted := 0
+	for _, q := range queries {
+		res, err := tx.Exec(q.SQL, q.Args...)
+		if err != nil {
+			return 0, errors.Wrap(err, "deleting error")
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return 0, errors.Wrap(err, "deleting error")
+		}
+		deleted += int(n)
+	}
+	if err = tx.Commit(); err != nil {
+		return 0, errors.Wrap(err, "deleting error")
+	}
+
+	if _, err = s.CollectBlobs(); err != nil {
+		return deleted, err
+	}
+	return deleted, nil
+}
@@ -0,0 +1,46 @@
+// Package storage holds what is common to the storage backends
+// living in its subpackages.
+package storage
+
+import (
+	proxyserver "github.com/iiivan-lemon/technopark_proxy/internal/proxyServer"
+	"github.com/iiivan-lemon/technopark_proxy/internal/repeater"
+	"github.com/iiivan-lemon/technopark_proxy/internal/storage/migrate"
+)
+
+const (
+	Postgres = "postgres"
+	SQLite   = "sqlite"
+	Memory   = "memory"
+)
+
+// Storage is implemented by every backend, the proxy writes through it
+// and the repeater reads from it.
+type Storage interface {
+	proxyserver.Repository
+	repeater.Repository
+	// CollectBlobs deletes the blobs no response refers to any more.
+	CollectBlobs() (int, error)
+	// Prune deletes what is beyond the retention limits,
+	// it returns the number of deleted rows.
+	Prune(retention *Retention) (int, error)
+	Close() error
+}
+
+// Migratable is implemented by the backends with a versioned schema.
+type Migratable interface {
+	Migrations() (*migrate.Runner, error)
+}
+
+// Lineage returns the source and the parent of req as they are inserted,
+// the parent is NULL unless req is a repeat.
+func Lineage(req *proxyserver.Request) (string, interface{}) {
+	source := req.Source
+	if source == "" {
+		source = proxyserver.SourceProxy
+	}
+	if req.ParentID == 0 {
+		return source, nil
+	}
+	return source, req.ParentID
+}
@@ -0,0 +1,110 @@
+package forward
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/iiivan-lemon/technopark_proxy/config"
+)
+
+const defaultPseudonym = "technopark-proxy"
+
+// Forwarder adds Via, Forwarded and X-Forwarded-For headers to forwarded
+// messages and detects requests that have already passed through it.
+type Forwarder struct {
+	conf config.ForwardConfig
+	// received-by value of this very process, unique per start so that
+	// several instances with the same config can be chained
+	pseudonym string
+}
+
+func NewForwarder(conf *config.ForwardConfig) (*Forwarder, error) {
+	name := conf.Pseudonym
+	if name == "" {
+		name = defaultPseudonym
+	}
+	id := make([]byte, 4)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	return &Forwarder{
+		conf:      *conf,
+		pseudonym: name + "-" + hex.EncodeToString(id),
+	}, nil
+}
+
+// IsLoop reports whether h carries a Via entry added by this proxy.
+func (f *Forwarder) IsLoop(h http.Header) bool {
+	for _, value := range h.Values("Via") {
+		for _, hop := range strings.Split(value, ",") {
+			fields := strings.Fields(hop)
+			if len(fields) >= 2 && fields[1] == f.pseudonym {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// PrepareRequest strips hop-by-hop headers from r and adds the configured
+// forwarding headers. clientAddr is the remote address of the client connection.
+func (f *Forwarder) PrepareRequest(r *http.Request, clientAddr string, isHTTPS bool) {
+	RemoveHopByHop(r.Header)
+
+	if f.conf.Via {
+		f.addVia(r.Header, r.ProtoMajor, r.ProtoMinor)
+	}
+
+	clientIP, _, err := net.SplitHostPort(clientAddr)
+	if err != nil {
+		clientIP = clientAddr
+	}
+	if f.conf.XForwardedFor && clientIP != "" {
+		if prior := r.Header.Values("X-Forwarded-For"); len(prior) > 0 {
+			clientIP = strings.Join(prior, ", ") + ", " + clientIP
+		}
+		r.Header.Set("X-Forwarded-For", clientIP)
+	}
+	if f.conf.Forwarded {
+		proto := "http"
+		if isHTTPS {
+			proto = "https"
+		}
+		r.Header.Add("Forwarded", fmt.Sprintf("for=%s;host=%q;proto=%s", forwardedNode(clientAddr), r.Host, proto))
+	}
+}
+
+// PrepareResponse strips hop-by-hop headers from resp and adds Via if configured.
+func (f *Forwarder) PrepareResponse(resp *http.Response) {
+	RemoveHopByHop(resp.Header)
+	if f.conf.Via {
+		f.addVia(resp.Header, resp.ProtoMajor, resp.ProtoMinor)
+	}
+}
+
+func (f *Forwarder) addVia(h http.Header, major, minor int) {
+	if major == 0 {
+		major, minor = 1, 1
+	}
+	h.Add("Via", fmt.Sprintf("%d.%d %s", major, minor, f.pseudonym))
+}
+
+// forwardedNode formats the client address as a node of the Forwarded header
+// (RFC 7239, section 6): IPv6 addresses have to be bracketed and quoted.
+func forwardedNode(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	if host == "" {
+		return "unknown"
+	}
+	if strings.Contains(host, ":") {
+		return `"[` + host + `]"`
+	}
+	return host
+}
@@ -0,0 +1,195 @@
+package forward
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/iiivan-lemon/technopark_proxy/config"
+)
+
+func newForwarder(t *testing.T, conf config.ForwardConfig) *Forwarder {
+	f, err := NewForwarder(&conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func newRequest(header http.Header) *http.Request {
+	return &http.Request{Host: "example.com", Header: header, ProtoMajor: 1, ProtoMinor: 1}
+}
+
+var allHeaders = config.ForwardConfig{Via: true, Forwarded: true, XForwardedFor: true, Pseudonym: "edge"}
+
+func TestNewForwarder(t *testing.T) {
+	a, b := newForwarder(t, allHeaders), newForwarder(t, allHeaders)
+	if !strings.HasPrefix(a.pseudonym, "edge-") || a.pseudonym == b.pseudonym {
+		t.Errorf("pseudonyms %q and %q, want distinct ones after edge-", a.pseudonym, b.pseudonym)
+	}
+	if f := newForwarder(t, config.ForwardConfig{}); !strings.HasPrefix(f.pseudonym, defaultPseudonym+"-") {
+		t.Errorf("default pseudonym %q", f.pseudonym)
+	}
+}
+
+func TestPrepareRequest(t *testing.T) {
+	tests := []struct {
+		name       string
+		conf       config.ForwardConfig
+		header     http.Header
+		clientAddr string
+		https      bool
+		proto      [2]int
+		// %s stands for the pseudonym
+		want http.Header
+	}{
+		{
+			name:       "every header",
+			conf:       allHeaders,
+			header:     http.Header{"Connection": {"X-Hop"}, "X-Hop": {"1"}, "Accept": {"*/*"}},
+			clientAddr: "192.0.2.1:5000",
+			proto:      [2]int{1, 1},
+			want: http.Header{
+				"Accept":          {"*/*"},
+				"Via":             {"1.1 %s"},
+				"X-Forwarded-For": {"192.0.2.1"},
+				"Forwarded":       {`for=192.0.2.1;host="example.com";proto=http`},
+			},
+		},
+		{
+			name: "after other proxies",
+			conf: allHeaders,
+			header: http.Header{
+				"Via":             {"1.0 first, 1.1 second"},
+				"X-Forwarded-For": {"198.51.100.1, 198.51.100.2", "198.51.100.3"},
+				"Forwarded":       {"for=198.51.100.1"},
+			},
+			clientAddr: "[2001:db8::1]:443",
+			https:      true,
+			proto:      [2]int{2, 0},
+			want: http.Header{
+				"Via":             {"1.0 first, 1.1 second", "2.0 %s"},
+				"X-Forwarded-For": {"198.51.100.1, 198.51.100.2, 198.51.100.3, 2001:db8::1"},
+				"Forwarded":       {"for=198.51.100.1", `for="[2001:db8::1]";host="example.com";proto=https`},
+			},
+		},
+		{
+			name:       "address without a port",
+			conf:       allHeaders,
+			header:     http.Header{},
+			clientAddr: "192.0.2.1",
+			want: http.Header{
+				"Via":             {"1.1 %s"},
+				"X-Forwarded-For": {"192.0.2.1"},
+				"Forwarded":       {`for=192.0.2.1;host="example.com";proto=http`},
+			},
+		},
+		{
+			name:   "unknown client",
+			conf:   allHeaders,
+			header: http.Header{"X-Forwarded-For": {"198.51.100.1"}},
+			proto:  [2]int{1, 0},
+			want: http.Header{
+				"Via":             {"1.0 %s"},
+				"X-Forwarded-For": {"198.51.100.1"},
+				"Forwarded":       {`for=unknown;host="example.com";proto=http`},
+			},
+		},
+		{
+			name:       "nothing configured",
+			header:     http.Header{"Keep-Alive": {"timeout=5"}, "X-Forwarded-For": {"198.51.100.1"}},
+			clientAddr: "192.0.2.1:5000",
+			want:       http.Header{"X-Forwarded-For": {"198.51.100.1"}},
+		},
+		{
+			name:       "Via only",
+			conf:       config.ForwardConfig{Via: true},
+			header:     http.Header{},
+			clientAddr: "192.0.2.1:5000",
+			proto:      [2]int{1, 1},
+			want:       http.Header{"Via": {"1.1 %s"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newForwarder(t, tt.conf)
+			r := newRequest(tt.header)
+			r.ProtoMajor, r.ProtoMinor = tt.proto[0], tt.proto[1]
+			f.PrepareRequest(r, tt.clientAddr, tt.https)
+			want := withPseudonym(tt.want, f.pseudonym)
+			if !reflect.DeepEqual(r.Header, want) {
+				t.Errorf("PrepareRequest left %v, want %v", r.Header, want)
+			}
+		})
+	}
+}
+
+func TestPrepareResponse(t *testing.T) {
+	f := newForwarder(t, allHeaders)
+	resp := &http.Response{
+		ProtoMajor: 1, ProtoMinor: 1,
+		Header: http.Header{"Via": {"1.1 upstream"}, "Transfer-Encoding": {"chunked"}, "Content-Type": {"text/plain"}},
+	}
+	f.PrepareResponse(resp)
+	want := http.Header{"Via": {"1.1 upstream", "1.1 " + f.pseudonym}, "Content-Type": {"text/plain"}}
+	if !reflect.DeepEqual(resp.Header, want) {
+		t.Errorf("PrepareResponse left %v, want %v", resp.Header, want)
+	}
+}
+
+func TestIsLoop(t *testing.T) {
+	f := newForwarder(t, allHeaders)
+	// another process with the same config, as when two instances are chained
+	twin := newForwarder(t, allHeaders)
+
+	r := newRequest(http.Header{})
+	f.PrepareRequest(r, "192.0.2.1:5000", false)
+	if !f.IsLoop(r.Header) {
+		t.Errorf("request with Via %q is not a loop", r.Header.Values("Via"))
+	}
+	if twin.IsLoop(r.Header) {
+		t.Errorf("request through another instance with Via %q is a loop", r.Header.Values("Via"))
+	}
+	// back through the twin to the first proxy
+	twin.PrepareRequest(r, "192.0.2.2:5000", false)
+	if !f.IsLoop(r.Header) || !twin.IsLoop(r.Header) {
+		t.Errorf("request through both instances with Via %q is not a loop for both", r.Header.Values("Via"))
+	}
+
+	tests := []struct {
+		name string
+		via  []string
+		want bool
+	}{
+		{"no Via", nil, false},
+		{"other proxies", []string{"1.1 other, 1.0 " + defaultPseudonym}, false},
+		{"among others", []string{"1.0 first", "1.1 second,  1.1 %s (comment), 2.0 third"}, true},
+		{"with a protocol name", []string{"HTTP/1.1 %s"}, true},
+		{"pseudonym as a prefix", []string{"1.1 %sx"}, false},
+		{"pseudonym as a comment", []string{"1.1 other (%s)"}, false},
+		{"received-by only", []string{"%s"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := withPseudonym(http.Header{"Via": tt.via}, f.pseudonym)
+			if tt.via == nil {
+				h = http.Header{}
+			}
+			if got := f.IsLoop(h); got != tt.want {
+				t.Errorf("IsLoop(%q) = %v, want %v", h.Values("Via"), got, tt.want)
+			}
+		})
+	}
+}
+
+// withPseudonym replaces %s with pseudonym in the values of h.
+func withPseudonym(h http.Header, pseudonym string) http.Header {
+	res := make(http.Header, len(h))
+	for name, values := range h {
+		for _, v := range values {
+			res[name] = append(res[name], strings.ReplaceAll(v, "%s", pseudonym))
+		}
+	}
+	return res
+}
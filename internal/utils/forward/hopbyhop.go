@@ -0,0 +1,35 @@
+package forward
+
+import (
+	"net/http"
+	"strings"
+)
+
+// hopHeaders are meaningful only for a single transport-level connection
+// and must not be forwarded by proxies (RFC 7230, section 6.1).
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// RemoveHopByHop deletes hop-by-hop headers from h,
+// including the ones listed in its Connection header.
+func RemoveHopByHop(h http.Header) {
+	for _, value := range h.Values("Connection") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				h.Del(name)
+			}
+		}
+	}
+	for _, name := range hopHeaders {
+		h.Del(name)
+	}
+}
@@ -0,0 +1,57 @@
+package forward
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestRemoveHopByHop(t *testing.T) {
+	tests := []struct {
+		name   string
+		header http.Header
+		want   http.Header
+	}{
+		{
+			name: "hop-by-hop headers",
+			header: http.Header{
+				"Connection": {"keep-alive"}, "Proxy-Connection": {"keep-alive"}, "Keep-Alive": {"timeout=5"},
+				"Proxy-Authenticate": {"Basic"}, "Proxy-Authorization": {"Basic abc"}, "Te": {"trailers"},
+				"Trailer": {"Expires"}, "Transfer-Encoding": {"chunked"}, "Upgrade": {"websocket"},
+				"Authorization": {"Bearer abc"}, "Content-Type": {"text/plain"},
+			},
+			want: http.Header{"Authorization": {"Bearer abc"}, "Content-Type": {"text/plain"}},
+		},
+		{
+			name: "headers named in Connection",
+			header: http.Header{
+				"Connection": {"close, x-private ,X-Other", "X-Third"},
+				"X-Private":  {"1"}, "X-Other": {"2"}, "X-Third": {"3"}, "X-Kept": {"4"},
+			},
+			want: http.Header{"X-Kept": {"4"}},
+		},
+		{
+			name:   "empty names in Connection",
+			header: http.Header{"Connection": {", ,"}, "X-Kept": {"1"}},
+			want:   http.Header{"X-Kept": {"1"}},
+		},
+		{
+			name:   "end-to-end headers only",
+			header: http.Header{"Accept": {"a", "b"}, "Via": {"1.1 other"}},
+			want:   http.Header{"Accept": {"a", "b"}, "Via": {"1.1 other"}},
+		},
+		{
+			name:   "no headers",
+			header: http.Header{},
+			want:   http.Header{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			RemoveHopByHop(tt.header)
+			if !reflect.DeepEqual(tt.header, tt.want) {
+				t.Errorf("RemoveHopByHop left %v, want %v", tt.header, tt.want)
+			}
+		})
+	}
+}
@@ -6,4 +6,66 @@ const (
 	UPSTREAM_UNAVAIBLE_ERR = "upstream service unavaible"
 	BAD_REQUEST_ID         = "request id should be positive number"
 	NO_SUCH_REQUEST        = "no such request"
+	LOOP_DETECTED          = "request has already passed through this proxy"
+	BAD_SORT_KEY           = "unknown sort key"
+	BAD_SORT_ORDER         = "order should be asc or desc"
+	BAD_DURATION           = "duration should be a non-negative number of milliseconds"
+	BAD_TIME               = "time should be in RFC 3339 format"
+	BAD_BLOB_HASH          = "blob hash should be a hex-encoded SHA-256"
+	NO_SUCH_BLOB           = "no such blob"
+	NO_DELETE_FILTER       = "set a filter, or all=true to delete every request"
+	NO_SEARCH_QUERY        = "q should not be empty"
+	BAD_SEARCH_MODE        = "mode should be text or regex"
+	BAD_SEARCH_REGEX       = "q is not a valid regular expression"
+	BAD_SEARCH_FIELD       = "unknown search field"
+	BAD_LIMIT              = "limit should be a positive number"
+	BAD_ANNOTATION         = "body should be a JSON object with note, color and tags"
+	BAD_TAG                = "tag should be 1 to 64 characters without commas or line breaks"
+	BAD_COLOR              = "color should be red, orange, yellow, green, cyan, blue, pink, magenta, gray or #rrggbb"
+	NOTE_TOO_LONG          = "note should be at most 64 KiB"
+	NO_SUCH_TAG            = "no such tag"
+	BAD_SESSION_ID         = "session id should be positive number"
+	NO_SUCH_SESSION        = "no such session"
+	BAD_SESSION            = "body should be a JSON object with name, description and scope"
+	BAD_SESSION_NAME       = "session name should be 1 to 128 characters without surrounding spaces"
+	BAD_SCOPE              = "scope patterns should be non-empty host patterns, e.g. *.example.com"
+	SESSION_EXISTS         = "session name is taken"
+	SESSION_ARCHIVED       = "session is archived"
+	SESSION_ACTIVE         = "the active session cannot be archived"
+	BAD_HTTPS              = "https should be true or false"
+	BAD_STATUS             = "status should be codes or classes, e.g. 200,404,5xx"
+	BAD_CONTENT_TYPE       = "content_type should be a media type, e.g. application/json or image/*"
+	BAD_SIZE               = "size should be a non-negative number of bytes"
+	BAD_CURSOR             = "cursor is not valid for this sort and order"
+	BAD_FIELD              = "unknown request field"
+	BAD_RESPONSE_ID        = "response id should be positive number"
+	NO_SUCH_RESPONSE       = "no such response"
+	BAD_INCLUDE            = "include should be response or responses"
+	BAD_REPEAT             = "body should be a JSON object with method, url, query, headers, cookies and body, or raw"
+	BAD_RAW_REQUEST        = "raw is not a valid HTTP request"
+	RAW_WITH_EDITS         = "raw cannot be combined with other edits"
+	BAD_METHOD             = "method should be an HTTP method token"
+	BAD_URL                = "url should be a path or an absolute http or https URL"
+	BAD_EDIT_VALUE         = "query, header and cookie values should be strings, lists of strings or null"
+	BAD_BODY_BASE64        = "body is not valid base64"
+	NO_DIFF_IDS            = "a and b should be the ids of the requests to compare"
+	BAD_DIFF_FORMAT        = "format should be json or text"
+	BAD_MASK               = "mask should be a valid regular expression"
+	BAD_EXPORT_FORMAT      = "format should be curl, httpie, python-requests, go-nethttp, raw or powershell"
+	BAD_HAR                = "body should be a HAR file with log.entries"
+	IMPORT_TOO_LARGE       = "imported file should be at most 64 MiB"
+	BAD_IMPORT_FORMAT      = "import format should be har, curl or http"
+	BAD_IMPORT_VARIABLE    = "var should be name=value"
+	NOTHING_TO_IMPORT      = "imported file has no requests"
+	BAD_IMPORT             = "imported file is not valid"
+	BAD_ATTACK             = "body should be a JSON object with request_id, type, template and payloads"
+	BAD_ATTACK_ID          = "attack id should be positive number"
+	NO_SUCH_ATTACK         = "no such attack"
+	ATTACK_NOT_RUNNING     = "attack is not running"
+	ATTACK_NOT_PAUSED      = "attack is not paused"
+	ATTACK_OVER            = "attack is over"
+	ATTACK_RUNNING         = "a running attack should be cancelled before it is deleted"
+	BAD_ATTACK_SORT_KEY    = "sort should be index, status, length or duration"
+	BAD_MATCHED            = "matched should be true or false"
+	BAD_OFFSET             = "offset should be a non-negative number"
 )
@@ -0,0 +1,162 @@
+package redact
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// step is one segment of a JSON path: a key, an index, a wildcard,
+// or a descent into every level when deep is set.
+type step struct {
+	key     string
+	index   int
+	isIndex bool
+	any     bool
+	deep    bool
+}
+
+// jsonPath is a small subset of JSONPath: $.a.b, $.a[0], $.a[*], $..a.
+type jsonPath []step
+
+func parseJSONPath(path string) (jsonPath, error) {
+	bad := errors.Errorf("bad redaction JSON path %q", path)
+	if !strings.HasPrefix(path, "$") {
+		return nil, bad
+	}
+	var p jsonPath
+	rest := path[1:]
+	for rest != "" {
+		deep := false
+		switch {
+		case strings.HasPrefix(rest, ".."):
+			deep = true
+			rest = rest[2:]
+		case rest[0] == '.':
+			rest = rest[1:]
+		case rest[0] == '[':
+		default:
+			return nil, bad
+		}
+		if strings.HasPrefix(rest, "[") {
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, bad
+			}
+			s, err := parseBracket(rest[1:end])
+			if err != nil {
+				return nil, bad
+			}
+			s.deep = deep
+			p = append(p, s)
+			rest = rest[end+1:]
+			continue
+		}
+		end := strings.IndexAny(rest, ".[")
+		if end < 0 {
+			end = len(rest)
+		}
+		key := rest[:end]
+		if key == "" {
+			return nil, bad
+		}
+		p = append(p, step{key: key, any: key == "*", deep: deep})
+		rest = rest[end:]
+	}
+	if len(p) == 0 {
+		return nil, bad
+	}
+	return p, nil
+}
+
+func parseBracket(text string) (step, error) {
+	if text == "*" {
+		return step{any: true}, nil
+	}
+	if len(text) >= 2 && (text[0] == '\'' || text[0] == '"') && text[len(text)-1] == text[0] {
+		return step{key: text[1 : len(text)-1]}, nil
+	}
+	index, err := strconv.Atoi(text)
+	if err != nil || index < 0 {
+		return step{}, errors.New("bad index")
+	}
+	return step{index: index, isIndex: true}, nil
+}
+
+// json masks the values at the paths of a JSON body, a body that is not
+// JSON or has nothing to mask is returned as it is.
+func (r *Redactor) json(body string) string {
+	if len(r.paths) == 0 {
+		return body
+	}
+	dec := json.NewDecoder(strings.NewReader(body))
+	dec.UseNumber()
+	var doc interface{}
+	if err := dec.Decode(&doc); err != nil {
+		return body
+	}
+	changed := false
+	for _, p := range r.paths {
+		doc = r.maskPath(doc, p, &changed)
+	}
+	if !changed {
+		return body
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(doc); err != nil {
+		return body
+	}
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// maskPath replaces the values of v at p with the mask.
+func (r *Redactor) maskPath(v interface{}, p jsonPath, changed *bool) interface{} {
+	if len(p) == 0 {
+		*changed = true
+		return r.mask
+	}
+	s := p[0]
+	if s.deep {
+		here := s
+		here.deep = false
+		v = r.maskPath(v, append(jsonPath{here}, p[1:]...), changed)
+		return r.children(v, func(child interface{}) interface{} {
+			return r.maskPath(child, p, changed)
+		})
+	}
+	switch node := v.(type) {
+	case map[string]interface{}:
+		for key, child := range node {
+			if s.any || !s.isIndex && key == s.key {
+				node[key] = r.maskPath(child, p[1:], changed)
+			}
+		}
+	case []interface{}:
+		for i, child := range node {
+			if s.any || s.isIndex && i == s.index {
+				node[i] = r.maskPath(child, p[1:], changed)
+			}
+		}
+	}
+	return v
+}
+
+// children applies f to every child of an object or an array.
+func (r *Redactor) children(v interface{}, f func(interface{}) interface{}) interface{} {
+	switch node := v.(type) {
+	case map[string]interface{}:
+		for key, child := range node {
+			node[key] = f(child)
+		}
+	case []interface{}:
+		for i, child := range node {
+			node[i] = f(child)
+		}
+	}
+	return v
+}
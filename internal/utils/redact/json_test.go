@@ -0,0 +1,73 @@
+package redact
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/iiivan-lemon/technopark_proxy/config"
+)
+
+func TestParseJSONPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want jsonPath
+	}{
+		{"$.a", jsonPath{{key: "a"}}},
+		{"$.a.b", jsonPath{{key: "a"}, {key: "b"}}},
+		{"$.a[0]", jsonPath{{key: "a"}, {index: 0, isIndex: true}}},
+		{"$.a[*].b", jsonPath{{key: "a"}, {any: true}, {key: "b"}}},
+		{"$.*", jsonPath{{key: "*", any: true}}},
+		{"$..a", jsonPath{{key: "a", deep: true}}},
+		{"$..[1]", jsonPath{{index: 1, isIndex: true, deep: true}}},
+		{`$['a.b']["c d"]`, jsonPath{{key: "a.b"}, {key: "c d"}}},
+	}
+	for _, tt := range tests {
+		got, err := parseJSONPath(tt.path)
+		if err != nil {
+			t.Errorf("parseJSONPath(%q): %v", tt.path, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseJSONPath(%q) = %+v, want %+v", tt.path, got, tt.want)
+		}
+	}
+
+	for _, path := range []string{"", "$", "a.b", "$a", "$.", "$.a.", "$[", "$[-1]", "$[x]", "$['a]", "$.a..", "$.a[0"} {
+		if _, err := parseJSONPath(path); err == nil {
+			t.Errorf("parseJSONPath(%q) returned no error", path)
+		}
+	}
+}
+
+func TestJSON(t *testing.T) {
+	tests := []struct {
+		name  string
+		paths []string
+		body  string
+		want  string
+	}{
+		{"nested key", []string{"$.user.password"}, `{"user": {"password": "p", "name": "ann"}, "password": "kept"}`,
+			`{"password":"kept","user":{"name":"ann","password":"[REDACTED]"}}`},
+		{"index", []string{"$.items[1]"}, `{"items": [1, 2, 3]}`, `{"items":[1,"[REDACTED]",3]}`},
+		{"wildcard", []string{"$.items[*].token"}, `{"items": [{"token": "a"}, {"id": 2}, {"token": {"deep": 1}}]}`,
+			`{"items":[{"token":"[REDACTED]"},{"id":2},{"token":"[REDACTED]"}]}`},
+		{"every level", []string{"$..secret"}, `{"secret": 1, "a": [{"secret": 2}, {"b": {"secret": [3]}}]}`,
+			`{"a":[{"secret":"[REDACTED]"},{"b":{"secret":"[REDACTED]"}}],"secret":"[REDACTED]"}`},
+		{"quoted key", []string{`$["a.b"]`}, `{"a.b": 1, "a": {"b": 2}}`, `{"a":{"b":2},"a.b":"[REDACTED]"}`},
+		{"array at the top", []string{"$[0].k"}, `[{"k": 1}, {"k": 2}]`, `[{"k":"[REDACTED]"},{"k":2}]`},
+		// numbers and HTML characters are written back as they were
+		{"kept values", []string{"$.k"}, `{"k": 1, "big": 12345678901234567890, "html": "<a&b>"}`,
+			`{"big":12345678901234567890,"html":"<a&b>","k":"[REDACTED]"}`},
+		{"path not found", []string{"$.missing", "$.a[5]", "$.a.b"}, `{"a": [1]}`, `{"a": [1]}`},
+		{"not json", []string{"$.a"}, `{"a": `, `{"a": `},
+		{"no paths", nil, `{"a": 1}`, `{"a": 1}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newRedactor(t, config.RedactConfig{JSONPaths: tt.paths})
+			if got := r.json(tt.body); got != tt.want {
+				t.Errorf("json = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,324 @@
+// Package redact replaces secrets in recorded traffic before it is stored.
+package redact
+
+import (
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/iiivan-lemon/technopark_proxy/config"
+	"github.com/iiivan-lemon/technopark_proxy/internal/utils/seal"
+	"github.com/pkg/errors"
+)
+
+const defaultMask = "[REDACTED]"
+
+// Redactor applies the rules of a config.RedactConfig. A nil Redactor
+// leaves everything as it is.
+type Redactor struct {
+	// lower-cased
+	headers map[string]bool
+	cookies map[string]bool
+	fields  map[string]bool
+	paths   []jsonPath
+	regexes []*regexp.Regexp
+	mask    string
+	// set when the originals are kept
+	sealer *seal.Sealer
+}
+
+func NewRedactor(conf *config.RedactConfig) (*Redactor, error) {
+	r := &Redactor{
+		headers: make(map[string]bool),
+		cookies: make(map[string]bool),
+		fields:  make(map[string]bool),
+		mask:    conf.Mask,
+	}
+	if r.mask == "" {
+		r.mask = defaultMask
+	}
+	for _, name := range conf.Headers {
+		r.headers[strings.ToLower(name)] = true
+	}
+	for _, name := range conf.Cookies {
+		r.cookies[name] = true
+	}
+	for _, name := range conf.Fields {
+		r.fields[name] = true
+	}
+	for _, path := range conf.JSONPaths {
+		p, err := parseJSONPath(path)
+		if err != nil {
+			return nil, err
+		}
+		r.paths = append(r.paths, p)
+	}
+	for _, expr := range conf.Regexes {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, errors.Wrapf(err, "redaction regex %q", expr)
+		}
+		r.regexes = append(r.regexes, re)
+	}
+	if conf.KeepOriginals {
+		key, err := seal.LoadKey(conf.KeyFile, conf.KeyEnv)
+		if err != nil {
+			return nil, errors.Wrap(err, "loading redaction key")
+		}
+		if r.sealer, err = seal.NewSealer(key); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// Text replaces the matches of the regexes in s.
+func (r *Redactor) Text(s string) string {
+	if r == nil {
+		return s
+	}
+	for _, re := range r.regexes {
+		if re.NumSubexp() == 0 {
+			s = re.ReplaceAllLiteralString(s, r.mask)
+			continue
+		}
+		matches := re.FindAllStringSubmatchIndex(s, -1)
+		if len(matches) == 0 {
+			continue
+		}
+		var b strings.Builder
+		pos := 0
+		for _, m := range matches {
+			if m[2] < 0 {
+				continue
+			}
+			b.WriteString(s[pos:m[2]])
+			b.WriteString(r.mask)
+			pos = m[3]
+		}
+		b.WriteString(s[pos:])
+		s = b.String()
+	}
+	return s
+}
+
+// Headers masks the values of the secret headers and the secret cookies
+// set by Set-Cookie in m, a map of header names to a value or a list.
+func (r *Redactor) Headers(m map[string]interface{}) {
+	if r == nil {
+		return
+	}
+	for name, value := range m {
+		switch {
+		case r.headers[strings.ToLower(name)]:
+			m[name] = r.mask
+		case strings.EqualFold(name, "Set-Cookie"):
+			m[name] = mapValue(value, func(v string) string { return r.Text(r.setCookie(v)) })
+		default:
+			m[name] = mapValue(value, r.Text)
+		}
+	}
+}
+
+// Cookies masks the values of the secret cookies in m.
+func (r *Redactor) Cookies(m map[string]interface{}) {
+	if r != nil {
+		r.names(m, r.cookies)
+	}
+}
+
+// Fields masks the values of the secret query parameters or form fields in m.
+func (r *Redactor) Fields(m map[string]interface{}) {
+	if r != nil {
+		r.names(m, r.fields)
+	}
+}
+
+func (r *Redactor) names(m map[string]interface{}, secret map[string]bool) {
+	for name, value := range m {
+		if secret[name] {
+			m[name] = r.mask
+			continue
+		}
+		m[name] = mapValue(value, r.Text)
+	}
+}
+
+// mapValue applies f to a value of a header or parameter map.
+func mapValue(value interface{}, f func(string) string) interface{} {
+	switch v := value.(type) {
+	case string:
+		return f(v)
+	case []string:
+		res := make([]string, len(v))
+		for i := range v {
+			res[i] = f(v[i])
+		}
+		return res
+	case []interface{}:
+		res := make([]interface{}, len(v))
+		for i := range v {
+			res[i] = mapValue(v[i], f)
+		}
+		return res
+	}
+	return value
+}
+
+// Query masks the secret fields of a query or a form-urlencoded body,
+// keeping the order of the fields.
+func (r *Redactor) Query(query string) string {
+	if r == nil || query == "" {
+		return query
+	}
+	if len(r.fields) > 0 {
+		pairs := strings.Split(query, "&")
+		for i, pair := range pairs {
+			key := pair
+			if j := strings.IndexByte(pair, '='); j >= 0 {
+				key = pair[:j]
+			}
+			if name, err := url.QueryUnescape(key); err == nil && r.fields[name] {
+				pairs[i] = key + "=" + url.QueryEscape(r.mask)
+			}
+		}
+		query = strings.Join(pairs, "&")
+	}
+	return r.Text(query)
+}
+
+// URL masks the secret fields of the query of u.
+func (r *Redactor) URL(u string) string {
+	if r == nil {
+		return u
+	}
+	if i := strings.IndexByte(u, '?'); i >= 0 {
+		return r.Text(u[:i]) + "?" + r.Query(u[i+1:])
+	}
+	return r.Text(u)
+}
+
+// Body masks the secret JSON paths of a JSON body and the secret fields
+// of a form, contentType tells which one body is.
+func (r *Redactor) Body(body, contentType string) string {
+	if r == nil || body == "" {
+		return body
+	}
+	contentType = strings.ToLower(contentType)
+	switch {
+	case strings.Contains(contentType, "json"):
+		body = r.json(body)
+	case strings.HasPrefix(contentType, "application/x-www-form-urlencoded"):
+		return r.Query(body)
+	}
+	return r.Text(body)
+}
+
+// cookie masks the values of the secret cookies in a Cookie header.
+func (r *Redactor) cookie(value string) string {
+	if len(r.cookies) == 0 {
+		return value
+	}
+	pairs := strings.Split(value, ";")
+	for i, pair := range pairs {
+		name := strings.TrimSpace(pair)
+		if j := strings.IndexByte(name, '='); j >= 0 {
+			name = name[:j]
+		}
+		if r.cookies[name] {
+			lead := pair[:len(pair)-len(strings.TrimLeft(pair, " "))]
+			pairs[i] = lead + name + "=" + r.mask
+		}
+	}
+	return strings.Join(pairs, ";")
+}
+
+// setCookie masks the value of a Set-Cookie header setting a secret cookie.
+func (r *Redactor) setCookie(value string) string {
+	pair, attrs := value, ""
+	if i := strings.IndexByte(value, ';'); i >= 0 {
+		pair, attrs = value[:i], value[i:]
+	}
+	name := strings.TrimSpace(pair)
+	if j := strings.IndexByte(name, '='); j >= 0 {
+		name = name[:j]
+	}
+	if !r.cookies[name] {
+		return value
+	}
+	return name + "=" + r.mask + attrs
+}
+
+// Raw masks the secrets of a request dump: the query of the request
+// line, the headers, the cookies and the body. Content-Length follows
+// the body, a dump without secrets comes back unchanged.
+func (r *Redactor) Raw(raw string) string {
+	if r == nil {
+		return raw
+	}
+	head, body, hasBody := raw, "", false
+	if i := strings.Index(raw, "\r\n\r\n"); i >= 0 {
+		head, body, hasBody = raw[:i], raw[i+4:], true
+	}
+	lines := strings.Split(head, "\r\n")
+	if parts := strings.SplitN(lines[0], " ", 3); len(parts) == 3 {
+		lines[0] = parts[0] + " " + r.URL(parts[1]) + " " + parts[2]
+	}
+	contentType, contentLength := "", -1
+	for i := 1; i < len(lines); i++ {
+		colon := strings.IndexByte(lines[i], ':')
+		if colon < 0 {
+			continue
+		}
+		name, value := lines[i][:colon], strings.TrimSpace(lines[i][colon+1:])
+		masked := value
+		switch {
+		case r.headers[strings.ToLower(name)]:
+			masked = r.mask
+		case strings.EqualFold(name, "Cookie"):
+			masked = r.Text(r.cookie(value))
+		case strings.EqualFold(name, "Content-Length"):
+			contentLength = i
+		case strings.EqualFold(name, "Content-Type"):
+			contentType = value
+			masked = r.Text(value)
+		default:
+			masked = r.Text(value)
+		}
+		// a line without secrets is kept as it was written
+		if masked != value {
+			lines[i] = name + ": " + masked
+		}
+	}
+	if !hasBody {
+		return strings.Join(lines, "\r\n")
+	}
+	redacted := r.Body(body, contentType)
+	if redacted != body && contentLength > 0 {
+		lines[contentLength] = lines[contentLength][:strings.IndexByte(lines[contentLength], ':')] + ": " + strconv.Itoa(len(redacted))
+	}
+	return strings.Join(lines, "\r\n") + "\r\n\r\n" + redacted
+}
+
+// KeepsOriginals reports whether Seal should be called.
+func (r *Redactor) KeepsOriginals() bool {
+	return r != nil && r.sealer != nil
+}
+
+// Seal encrypts an original for the repeater.
+func (r *Redactor) Seal(original string) (string, error) {
+	if !r.KeepsOriginals() {
+		return "", errors.New("originals are not kept")
+	}
+	return r.sealer.Seal([]byte(original))
+}
+
+// Open decrypts an original sealed by Seal.
+func (r *Redactor) Open(sealed string) (string, error) {
+	if !r.KeepsOriginals() {
+		return "", errors.New("no key to open originals with")
+	}
+	original, err := r.sealer.Open(sealed)
+	return string(original), err
+}
@@ -0,0 +1,221 @@
+package redact
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/iiivan-lemon/technopark_proxy/config"
+)
+
+func newRedactor(t *testing.T, conf config.RedactConfig) *Redactor {
+	r, err := NewRedactor(&conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+var testConfig = config.RedactConfig{
+	Headers:   []string{"authorization", "X-API-KEY"},
+	Cookies:   []string{"sid"},
+	Fields:    []string{"password", "api key"},
+	JSONPaths: []string{"$.password", "$.user.tokens[*]", "$..secret"},
+	Regexes:   []string{`\bssn=(\d+)`, `sk_live_\w+`},
+}
+
+func TestText(t *testing.T) {
+	r := newRedactor(t, testConfig)
+	tests := []struct {
+		in, want string
+	}{
+		{"nothing here", "nothing here"},
+		// a group is masked, not the rest of the match
+		{"ssn=123&ssn=456", "ssn=[REDACTED]&ssn=[REDACTED]"},
+		{"key sk_live_abc1 and sk_live_def2", "key [REDACTED] and [REDACTED]"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := r.Text(tt.in); got != tt.want {
+			t.Errorf("Text(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+
+	// an optional group that does not take part in a match leaves it alone
+	r = newRedactor(t, config.RedactConfig{Regexes: []string{`token(=\w+)?`}, Mask: "*"})
+	if got := r.Text("token token=abc"); got != "token token*" {
+		t.Errorf("Text with an optional group = %q", got)
+	}
+}
+
+func TestHeaders(t *testing.T) {
+	r := newRedactor(t, testConfig)
+	headers := map[string]interface{}{
+		"Authorization": "Bearer abc",
+		"x-api-key":     []string{"1", "2"},
+		"Set-Cookie":    []interface{}{"sid=abc; Path=/; HttpOnly", "theme=dark", "SID=upper"},
+		"X-Note":        []string{"ssn=123", "plain"},
+		"Accept":        "text/html",
+	}
+	r.Headers(headers)
+	want := map[string]interface{}{
+		"Authorization": "[REDACTED]",
+		"x-api-key":     "[REDACTED]",
+		"Set-Cookie":    []interface{}{"sid=[REDACTED]; Path=/; HttpOnly", "theme=dark", "SID=upper"},
+		"X-Note":        []string{"ssn=[REDACTED]", "plain"},
+		"Accept":        "text/html",
+	}
+	if !reflect.DeepEqual(headers, want) {
+		t.Errorf("Headers = %v, want %v", headers, want)
+	}
+}
+
+func TestCookiesAndFields(t *testing.T) {
+	r := newRedactor(t, testConfig)
+	cookies := map[string]interface{}{"sid": "abc", "Sid": "other", "theme": "ssn=1"}
+	r.Cookies(cookies)
+	// cookie names are case-sensitive
+	if want := map[string]interface{}{"sid": "[REDACTED]", "Sid": "other", "theme": "ssn=[REDACTED]"}; !reflect.DeepEqual(cookies, want) {
+		t.Errorf("Cookies = %v, want %v", cookies, want)
+	}
+	fields := map[string]interface{}{"password": []string{"a", "b"}, "api key": "k", "user": "ann"}
+	r.Fields(fields)
+	if want := map[string]interface{}{"password": "[REDACTED]", "api key": "[REDACTED]", "user": "ann"}; !reflect.DeepEqual(fields, want) {
+		t.Errorf("Fields = %v, want %v", fields, want)
+	}
+}
+
+func TestQuery(t *testing.T) {
+	r := newRedactor(t, testConfig)
+	tests := []struct {
+		in, want string
+	}{
+		{"", ""},
+		{"user=ann&page=2", "user=ann&page=2"},
+		// the order, the other fields and their encoding are kept
+		{"b=1&password=p%40ss&a=%20&password", "b=1&password=%5BREDACTED%5D&a=%20&password=%5BREDACTED%5D"},
+		{"api+key=k&api%20key=k", "api+key=%5BREDACTED%5D&api%20key=%5BREDACTED%5D"},
+		{"q=ssn=123", "q=ssn=[REDACTED]"},
+		{"bad=%zz&password=1", "bad=%zz&password=%5BREDACTED%5D"},
+	}
+	for _, tt := range tests {
+		if got := r.Query(tt.in); got != tt.want {
+			t.Errorf("Query(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+	if got := r.URL("https://example.com/ssn=1?password=2"); got != "https://example.com/ssn=[REDACTED]?password=%5BREDACTED%5D" {
+		t.Errorf("URL = %q", got)
+	}
+}
+
+func TestBody(t *testing.T) {
+	r := newRedactor(t, testConfig)
+	tests := []struct {
+		name, body, contentType, want string
+	}{
+		{"json", `{"password": "p", "user": {"name": "ann", "tokens": ["a", "b"]}, "n": 1.50}`, "application/json; charset=utf-8",
+			`{"n":1.50,"password":"[REDACTED]","user":{"name":"ann","tokens":["[REDACTED]","[REDACTED]"]}}`},
+		{"json of another type", `{"a": {"b": [{"secret": 1}]}}`, "application/vnd.api+JSON", `{"a":{"b":[{"secret":"[REDACTED]"}]}}`},
+		{"json without secrets", `{ "user": "ann" }`, "application/json", `{ "user": "ann" }`},
+		// not JSON after all: left as it is, the regexes still apply
+		{"not json", `{"password": "p", ssn=123`, "application/json", `{"password": "p", ssn=[REDACTED]`},
+		{"form", "user=ann&password=p", "application/x-www-form-urlencoded", "user=ann&password=%5BREDACTED%5D"},
+		{"text", "password=p ssn=123", "text/plain", "password=p ssn=[REDACTED]"},
+		{"empty", "", "application/json", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := r.Body(tt.body, tt.contentType); got != tt.want {
+				t.Errorf("Body = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRaw(t *testing.T) {
+	r := newRedactor(t, testConfig)
+	raw := "POST /login?password=1&next=%2F HTTP/1.1\r\n" +
+		"Host: example.com\r\n" +
+		"authorization:Bearer abc\r\n" +
+		"Cookie: theme=dark; sid=abc;sid=def\r\n" +
+		"Content-Type: application/json\r\n" +
+		"Content-Length: 31\r\n" +
+		"X-Odd:   spaced\r\n" +
+		"\r\n" +
+		`{"password": "hunt", "user": 1}`
+	want := "POST /login?password=%5BREDACTED%5D&next=%2F HTTP/1.1\r\n" +
+		"Host: example.com\r\n" +
+		"authorization: [REDACTED]\r\n" +
+		"Cookie: theme=dark; sid=[REDACTED];sid=[REDACTED]\r\n" +
+		"Content-Type: application/json\r\n" +
+		"Content-Length: 34\r\n" +
+		"X-Odd:   spaced\r\n" +
+		"\r\n" +
+		`{"password":"[REDACTED]","user":1}`
+	if got := r.Raw(raw); got != want {
+		t.Errorf("Raw =\n%q\nwant\n%q", got, want)
+	}
+
+	// a dump without secrets is not rewritten
+	for _, raw := range []string{
+		"GET /path?page=2 HTTP/1.1\r\nHost:example.com\r\nX-Odd:   spaced \r\nCookie: theme=dark",
+		"POST / HTTP/1.1\r\nContent-Type: application/json\r\nContent-Length: 14\r\n\r\n{ \"user\": 1 }\n",
+		"not a request",
+	} {
+		if got := r.Raw(raw); got != raw {
+			t.Errorf("Raw(%q) = %q", raw, got)
+		}
+	}
+}
+
+func TestNilRedactor(t *testing.T) {
+	var r *Redactor
+	headers := map[string]interface{}{"Authorization": "a"}
+	r.Headers(headers)
+	r.Cookies(headers)
+	r.Fields(headers)
+	if headers["Authorization"] != "a" || r.Text("ssn=1") != "ssn=1" || r.Query("password=1") != "password=1" ||
+		r.Body(`{"password": 1}`, "application/json") != `{"password": 1}` || r.Raw("GET /?password=1 HTTP/1.1") != "GET /?password=1 HTTP/1.1" {
+		t.Error("a nil Redactor changed something")
+	}
+	if r.KeepsOriginals() {
+		t.Error("a nil Redactor keeps originals")
+	}
+	if _, err := r.Seal("x"); err == nil {
+		t.Error("a nil Redactor sealed an original")
+	}
+}
+
+func TestOriginals(t *testing.T) {
+	conf := testConfig
+	conf.KeepOriginals, conf.KeyEnv = true, "REDACT_TEST_KEY"
+	if _, err := NewRedactor(&conf); err == nil {
+		t.Fatal("NewRedactor kept originals without a key")
+	}
+	t.Setenv("REDACT_TEST_KEY", strings.Repeat("ab", 32))
+	r := newRedactor(t, conf)
+	sealed, err := r.Seal("Authorization: Bearer abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(sealed, "Bearer") {
+		t.Errorf("sealed original %q shows the secret", sealed)
+	}
+	if original, err := r.Open(sealed); err != nil || original != "Authorization: Bearer abc" {
+		t.Errorf("Open = %q, %v", original, err)
+	}
+	if _, err = newRedactor(t, testConfig).Open(sealed); err == nil {
+		t.Error("Open without a key returned no error")
+	}
+}
+
+func TestNewRedactorErrors(t *testing.T) {
+	for _, conf := range []config.RedactConfig{
+		{Regexes: []string{"("}},
+		{JSONPaths: []string{"password"}},
+	} {
+		if _, err := NewRedactor(&conf); err == nil {
+			t.Errorf("NewRedactor(%+v) returned no error", conf)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package resolver
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/http"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	dohContentType = "application/dns-message"
+	// RFC 8484 allows at most 65535 bytes in a DNS message
+	maxDNSMessageSize = 65535
+)
+
+// dohClient resolves names with DNS-over-HTTPS (RFC 8484) POST requests.
+type dohClient struct {
+	url    string
+	client *http.Client
+}
+
+func newDoHClient(url string) *dohClient {
+	return &dohClient{
+		url:    url,
+		client: &http.Client{Timeout: dialTimeout},
+	}
+}
+
+func (c *dohClient) lookup(ctx context.Context, host string) ([]net.IPAddr, error) {
+	fqdn := host
+	if fqdn == "" || fqdn[len(fqdn)-1] != '.' {
+		fqdn += "."
+	}
+	qname, err := dnsmessage.NewName(fqdn)
+	if err != nil {
+		return nil, errors.Wrap(err, "bad host name")
+	}
+
+	var addrs []net.IPAddr
+	for _, qtype := range []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA} {
+		answers, err := c.query(ctx, qname, qtype)
+		if err != nil {
+			return nil, err
+		}
+		addrs = append(addrs, answers...)
+	}
+	if len(addrs) == 0 {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	return addrs, nil
+}
+
+// untraced keeps the deadline and the cancellation of a context but
+// none of its values, the httptrace.ClientTrace among them.
+type untraced struct {
+	context.Context
+}
+
+func (untraced) Value(key interface{}) interface{} {
+	return nil
+}
+
+func (c *dohClient) query(ctx context.Context, qname dnsmessage.Name, qtype dnsmessage.Type) ([]net.IPAddr, error) {
+	// the ID should be 0 to keep responses cacheable
+	msg := dnsmessage.Message{
+		Header: dnsmessage.Header{RecursionDesired: true},
+		Questions: []dnsmessage.Question{{
+			Name:  qname,
+			Type:  qtype,
+			Class: dnsmessage.ClassINET,
+		}},
+	}
+	packed, err := msg.Pack()
+	if err != nil {
+		return nil, errors.Wrap(err, "packing dns query")
+	}
+
+	// the trace of the exchange resolving the name is not the DoH request's
+	req, err := http.NewRequestWithContext(untraced{ctx}, http.MethodPost, c.url, bytes.NewReader(packed))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", dohContentType)
+	req.Header.Set("Accept", dohContentType)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "doh request")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("doh server answered %s", resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxDNSMessageSize))
+	if err != nil {
+		return nil, errors.Wrap(err, "reading doh response")
+	}
+
+	var answer dnsmessage.Message
+	if err = answer.Unpack(body); err != nil {
+		return nil, errors.Wrap(err, "unpacking doh response")
+	}
+	if answer.RCode != dnsmessage.RCodeSuccess && answer.RCode != dnsmessage.RCodeNameError {
+		return nil, errors.Errorf("doh server answered %s", answer.RCode)
+	}
+
+	var addrs []net.IPAddr
+	for _, rr := range answer.Answers {
+		switch body := rr.Body.(type) {
+		case *dnsmessage.AResource:
+			addrs = append(addrs, net.IPAddr{IP: net.IP(body.A[:])})
+		case *dnsmessage.AAAAResource:
+			addrs = append(addrs, net.IPAddr{IP: net.IP(body.AAAA[:])})
+		}
+	}
+	return addrs, nil
+}
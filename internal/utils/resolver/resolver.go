@@ -0,0 +1,163 @@
+package resolver
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http/httptrace"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/iiivan-lemon/technopark_proxy/config"
+	"github.com/pkg/errors"
+)
+
+const dialTimeout = 10 * time.Second
+
+// Resolver resolves upstream host names for every dial of the proxy and the repeater.
+// Lookups go to the static overrides first, then to DNS-over-HTTPS if it is
+// configured, and finally to the configured (or system) DNS server.
+type Resolver struct {
+	hosts map[string][]net.IPAddr
+	doh   *dohClient
+	dns   *net.Resolver
+}
+
+func NewResolver(conf *config.ResolverConfig) (*Resolver, error) {
+	r := &Resolver{
+		hosts: make(map[string][]net.IPAddr),
+		dns:   net.DefaultResolver,
+	}
+
+	if conf.HostsFile != "" {
+		f, err := os.Open(conf.HostsFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "opening hosts file")
+		}
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			if err = addHostsLine(r.hosts, scanner.Text()); err != nil {
+				return nil, errors.Wrap(err, conf.HostsFile)
+			}
+		}
+		if err = scanner.Err(); err != nil {
+			return nil, errors.Wrap(err, "reading hosts file")
+		}
+	}
+	// the names of the config lines override the ones of the file
+	overrides := make(map[string][]net.IPAddr)
+	for _, line := range conf.Hosts {
+		if err := addHostsLine(overrides, line); err != nil {
+			return nil, err
+		}
+	}
+	for name, addrs := range overrides {
+		r.hosts[name] = addrs
+	}
+
+	if conf.Server != "" {
+		server := conf.Server
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(server, "53")
+		}
+		r.dns = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				d := net.Dialer{Timeout: dialTimeout}
+				return d.DialContext(ctx, network, server)
+			},
+		}
+	}
+
+	if conf.DoH != "" {
+		r.doh = newDoHClient(conf.DoH)
+	}
+	return r, nil
+}
+
+// addHostsLine adds to hosts a line in /etc/hosts format: an address
+// followed by host names.
+func addHostsLine(hosts map[string][]net.IPAddr, line string) error {
+	if i := strings.IndexByte(line, '#'); i >= 0 {
+		line = line[:i]
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return nil
+	}
+	ip := net.ParseIP(fields[0])
+	if ip == nil || len(fields) < 2 {
+		return errors.Errorf("bad hosts line %q", line)
+	}
+	for _, name := range fields[1:] {
+		name = normalize(name)
+		hosts[name] = append(hosts[name], net.IPAddr{IP: ip})
+	}
+	return nil
+}
+
+// LookupIPAddr resolves host. IP literals are returned as is.
+func (r *Resolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IPAddr{{IP: ip}}, nil
+	}
+	if addrs, ok := r.hosts[normalize(host)]; ok {
+		return addrs, nil
+	}
+	if r.doh != nil {
+		trace := httptrace.ContextClientTrace(ctx)
+		if trace != nil && trace.DNSStart != nil {
+			trace.DNSStart(httptrace.DNSStartInfo{Host: host})
+		}
+		addrs, err := r.doh.lookup(ctx, host)
+		if trace != nil && trace.DNSDone != nil {
+			trace.DNSDone(httptrace.DNSDoneInfo{Addrs: addrs, Err: err})
+		}
+		return addrs, err
+	}
+	return r.dns.LookupIPAddr(ctx, host)
+}
+
+// DialContext has the signature of net.Dialer.DialContext and is meant
+// for http.Transport. The addresses of host are tried in order.
+func (r *Resolver) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := r.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	if len(addrs) == 0 {
+		return nil, errors.Errorf("no addresses for %s", host)
+	}
+
+	d := net.Dialer{Timeout: dialTimeout}
+	for _, ip := range addrs {
+		var conn net.Conn
+		conn, err = d.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
+
+func normalize(host string) string {
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+// RemoteIP returns the IP part of the remote address of conn.
+func RemoteIP(conn net.Conn) string {
+	if conn == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return conn.RemoteAddr().String()
+	}
+	return host
+}
@@ -0,0 +1,239 @@
+package resolver
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/iiivan-lemon/technopark_proxy/config"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// records are the addresses the stand-in servers know, by fully qualified name.
+var records = map[string][]net.IP{
+	"dns.example.": {net.ParseIP("10.0.0.3")},
+	"doh.example.": {net.ParseIP("10.0.0.4"), net.ParseIP("fd00::4")},
+}
+
+// answer builds the response to the packed DNS query from records.
+func answer(t *testing.T, query []byte) []byte {
+	var msg dnsmessage.Message
+	if err := msg.Unpack(query); err != nil {
+		t.Errorf("unpacking query: %v", err)
+		return nil
+	}
+	msg.Header.Response = true
+	msg.Header.RecursionAvailable = true
+	if len(msg.Questions) != 1 {
+		msg.Header.RCode = dnsmessage.RCodeFormatError
+	} else if ips, ok := records[msg.Questions[0].Name.String()]; !ok {
+		msg.Header.RCode = dnsmessage.RCodeNameError
+	} else {
+		q := msg.Questions[0]
+		head := dnsmessage.ResourceHeader{Name: q.Name, Class: dnsmessage.ClassINET, TTL: 60}
+		for _, ip := range ips {
+			if ip4 := ip.To4(); ip4 != nil && q.Type == dnsmessage.TypeA {
+				var a dnsmessage.AResource
+				copy(a.A[:], ip4)
+				msg.Answers = append(msg.Answers, dnsmessage.Resource{Header: head, Body: &a})
+			} else if ip4 == nil && q.Type == dnsmessage.TypeAAAA {
+				var aaaa dnsmessage.AAAAResource
+				copy(aaaa.AAAA[:], ip)
+				msg.Answers = append(msg.Answers, dnsmessage.Resource{Header: head, Body: &aaaa})
+			}
+		}
+	}
+	packed, err := msg.Pack()
+	if err != nil {
+		t.Errorf("packing answer: %v", err)
+	}
+	return packed
+}
+
+func ips(addrs []net.IPAddr) []string {
+	res := make([]string, len(addrs))
+	for i, addr := range addrs {
+		res[i] = addr.IP.String()
+	}
+	return res
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestHosts(t *testing.T) {
+	hostsFile := filepath.Join(t.TempDir(), "hosts")
+	err := os.WriteFile(hostsFile, []byte("# overrides\n10.0.0.1 a.example\n10.0.0.2 b.example c.example # both\n\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewResolver(&config.ResolverConfig{
+		HostsFile: hostsFile,
+		Hosts:     []string{"10.0.0.9 B.example.", "fd00::9 b.example"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		host string
+		want []string
+	}{
+		{"a.example", []string{"10.0.0.1"}},
+		{"A.EXAMPLE.", []string{"10.0.0.1"}},
+		{"b.example", []string{"10.0.0.9", "fd00::9"}},
+		{"c.example", []string{"10.0.0.2"}},
+		{"192.0.2.1", []string{"192.0.2.1"}},
+	}
+	for _, tt := range tests {
+		addrs, err := r.LookupIPAddr(context.Background(), tt.host)
+		if err != nil {
+			t.Errorf("LookupIPAddr(%q): %v", tt.host, err)
+			continue
+		}
+		if got := ips(addrs); !equal(got, tt.want) {
+			t.Errorf("LookupIPAddr(%q) = %v, want %v", tt.host, got, tt.want)
+		}
+	}
+
+	if _, err = NewResolver(&config.ResolverConfig{Hosts: []string{"not-an-ip a.example"}}); err == nil {
+		t.Error("NewResolver accepted a bad hosts line")
+	}
+}
+
+func TestServer(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go func() {
+		buf := make([]byte, maxDNSMessageSize)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if _, err = conn.WriteTo(answer(t, buf[:n]), addr); err != nil {
+				return
+			}
+		}
+	}()
+
+	r, err := NewResolver(&config.ResolverConfig{Server: conn.LocalAddr().String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addrs, err := r.LookupIPAddr(context.Background(), "dns.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := ips(addrs), []string{"10.0.0.3"}; !equal(got, want) {
+		t.Errorf("LookupIPAddr = %v, want %v", got, want)
+	}
+
+	_, err = r.LookupIPAddr(context.Background(), "missing.example")
+	if dnsErr, ok := err.(*net.DNSError); !ok || !dnsErr.IsNotFound {
+		t.Errorf("LookupIPAddr of a missing name: %v, want a not found error", err)
+	}
+}
+
+func TestDoH(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost || req.Header.Get("Content-Type") != dohContentType {
+			http.Error(w, "bad dns request", http.StatusBadRequest)
+			return
+		}
+		query, err := io.ReadAll(req.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", dohContentType)
+		w.Write(answer(t, query))
+	}))
+	defer srv.Close()
+
+	r, err := NewResolver(&config.ResolverConfig{DoH: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.doh.client = srv.Client()
+
+	var dnsStarted, dnsDone, connected bool
+	ctx := httptrace.WithClientTrace(context.Background(), &httptrace.ClientTrace{
+		DNSStart:          func(httptrace.DNSStartInfo) { dnsStarted = true },
+		DNSDone:           func(httptrace.DNSDoneInfo) { dnsDone = true },
+		ConnectStart:      func(string, string) { connected = true },
+		TLSHandshakeStart: func() { connected = true },
+	})
+	addrs, err := r.LookupIPAddr(ctx, "doh.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := ips(addrs), []string{"10.0.0.4", "fd00::4"}; !equal(got, want) {
+		t.Errorf("LookupIPAddr = %v, want %v", got, want)
+	}
+	if !dnsStarted || !dnsDone {
+		t.Error("the DNS hooks of the trace were not called")
+	}
+	if connected {
+		t.Error("the DoH request reported its connection to the trace of the lookup")
+	}
+
+	_, err = r.LookupIPAddr(context.Background(), "missing.example")
+	if dnsErr, ok := err.(*net.DNSError); !ok || !dnsErr.IsNotFound {
+		t.Errorf("LookupIPAddr of a missing name: %v, want a not found error", err)
+	}
+}
+
+func TestRemoteIP(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	defer srv.Close()
+	_, port, err := net.SplitHostPort(srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewResolver(&config.ResolverConfig{Hosts: []string{"127.0.0.1 upstream.example"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: &http.Transport{DialContext: r.DialContext}}
+
+	var remoteIP string
+	ctx := httptrace.WithClientTrace(context.Background(), &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) { remoteIP = RemoteIP(info.Conn) },
+	})
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://upstream.example:"+port+"/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if remoteIP != "127.0.0.1" {
+		t.Errorf("RemoteIP = %q, want 127.0.0.1", remoteIP)
+	}
+
+	if ip := RemoteIP(nil); ip != "" {
+		t.Errorf("RemoteIP(nil) = %q, want empty", ip)
+	}
+}
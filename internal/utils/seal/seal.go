@@ -0,0 +1,94 @@
+// Package seal encrypts what is stored with AES-256-GCM.
+package seal
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const KeySize = 32
+
+// LoadKey reads a key from file or, when file is empty, from the env
+// variable. The key is 32 bytes written as hex or base64.
+func LoadKey(file, env string) ([]byte, error) {
+	var text string
+	switch {
+	case file != "":
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return nil, errors.Wrap(err, "reading key file")
+		}
+		text = string(b)
+	case env != "":
+		text = os.Getenv(env)
+		if text == "" {
+			return nil, errors.Errorf("key variable %s is not set", env)
+		}
+	default:
+		return nil, errors.New("neither key file nor key variable is set")
+	}
+	return ParseKey(text)
+}
+
+// ParseKey decodes a key written as hex or base64.
+func ParseKey(text string) ([]byte, error) {
+	text = strings.TrimSpace(text)
+	key, err := hex.DecodeString(text)
+	if err != nil {
+		key, err = base64.StdEncoding.DecodeString(text)
+	}
+	if err != nil || len(key) != KeySize {
+		return nil, errors.Errorf("key should be %d bytes in hex or base64", KeySize)
+	}
+	return key, nil
+}
+
+// Sealer encrypts and authenticates data with a key.
+type Sealer struct {
+	aead cipher.AEAD
+}
+
+func NewSealer(key []byte) (*Sealer, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating cipher")
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating cipher")
+	}
+	return &Sealer{aead: aead}, nil
+}
+
+// Seal returns the nonce and the ciphertext of data in base64.
+func (s *Sealer) Seal(data []byte) (string, error) {
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", errors.Wrap(err, "generating nonce")
+	}
+	return base64.StdEncoding.EncodeToString(s.aead.Seal(nonce, nonce, data, nil)), nil
+}
+
+// Open returns the data sealed by Seal with the same key.
+func (s *Sealer) Open(sealed string) ([]byte, error) {
+	b, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding sealed data")
+	}
+	if len(b) < s.aead.NonceSize() {
+		return nil, errors.New("sealed data is too short")
+	}
+	nonce, ciphertext := b[:s.aead.NonceSize()], b[s.aead.NonceSize():]
+	data, err := s.aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "opening sealed data")
+	}
+	return data, nil
+}